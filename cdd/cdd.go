@@ -13,6 +13,7 @@ https://developers.google.com/open-source/licenses/bsd
 package cdd
 
 import (
+	"reflect"
 	"strconv"
 	"strings"
 )
@@ -121,6 +122,213 @@ func (a *PrinterDescriptionSection) Absorb(b *PrinterDescriptionSection) {
 	}
 }
 
+// Intersect removes the capabilities and options that are not also in the
+// passed-in description, so that a job described by a fits both printers.
+//
+// Fields of a are replaced, never modified, so a may be a shallow copy.
+func (a *PrinterDescriptionSection) Intersect(b *PrinterDescriptionSection) {
+	if a.SupportedContentType != nil && b.SupportedContentType != nil {
+		o := intersectOptions(*a.SupportedContentType, *b.SupportedContentType).([]SupportedContentType)
+		a.SupportedContentType = &o
+	} else {
+		a.SupportedContentType = nil
+	}
+	if !reflect.DeepEqual(a.PrintingSpeed, b.PrintingSpeed) {
+		a.PrintingSpeed = nil
+	}
+	if !reflect.DeepEqual(a.PWGRasterConfig, b.PWGRasterConfig) {
+		a.PWGRasterConfig = nil
+	}
+	if !reflect.DeepEqual(a.InputTrayUnit, b.InputTrayUnit) {
+		a.InputTrayUnit = nil
+	}
+	if !reflect.DeepEqual(a.OutputBinUnit, b.OutputBinUnit) {
+		a.OutputBinUnit = nil
+	}
+	if !reflect.DeepEqual(a.Marker, b.Marker) {
+		a.Marker = nil
+	}
+	if !reflect.DeepEqual(a.Cover, b.Cover) {
+		a.Cover = nil
+	}
+	if !reflect.DeepEqual(a.MediaPath, b.MediaPath) {
+		a.MediaPath = nil
+	}
+	if a.VendorCapability != nil && b.VendorCapability != nil {
+		bCaps := make(map[string]VendorCapability, len(*b.VendorCapability))
+		for _, v := range *b.VendorCapability {
+			bCaps[v.ID] = v
+		}
+		vcs := make([]VendorCapability, 0, len(*a.VendorCapability))
+		for _, v := range *a.VendorCapability {
+			bv, exists := bCaps[v.ID]
+			if !exists {
+				continue
+			}
+			if v.Type == VendorCapabilitySelect && bv.Type == VendorCapabilitySelect &&
+				v.SelectCap != nil && bv.SelectCap != nil {
+				o := intersectOptions(v.SelectCap.Option, bv.SelectCap.Option).([]SelectCapabilityOption)
+				if len(o) == 0 {
+					continue
+				}
+				v.SelectCap = &SelectCapability{Option: o}
+				vcs = append(vcs, v)
+			} else if reflect.DeepEqual(v, bv) {
+				vcs = append(vcs, v)
+			}
+		}
+		a.VendorCapability = &vcs
+	} else {
+		a.VendorCapability = nil
+	}
+	if a.Color != nil && b.Color != nil {
+		a.Color = &Color{Option: intersectOptions(a.Color.Option, b.Color.Option).([]ColorOption)}
+		if len(a.Color.Option) == 0 {
+			a.Color = nil
+		}
+	} else {
+		a.Color = nil
+	}
+	if a.Duplex != nil && b.Duplex != nil {
+		a.Duplex = &Duplex{Option: intersectOptions(a.Duplex.Option, b.Duplex.Option).([]DuplexOption)}
+		if len(a.Duplex.Option) == 0 {
+			a.Duplex = nil
+		}
+	} else {
+		a.Duplex = nil
+	}
+	if a.PageOrientation != nil && b.PageOrientation != nil {
+		a.PageOrientation = &PageOrientation{Option: intersectOptions(a.PageOrientation.Option, b.PageOrientation.Option).([]PageOrientationOption)}
+		if len(a.PageOrientation.Option) == 0 {
+			a.PageOrientation = nil
+		}
+	} else {
+		a.PageOrientation = nil
+	}
+	if a.Copies != nil && b.Copies != nil {
+		copies := *a.Copies
+		if b.Copies.Max < copies.Max {
+			copies.Max = b.Copies.Max
+		}
+		if copies.Default > copies.Max {
+			copies.Default = copies.Max
+		}
+		a.Copies = &copies
+	} else {
+		a.Copies = nil
+	}
+	if a.Margins != nil && b.Margins != nil {
+		a.Margins = &Margins{Option: intersectOptions(a.Margins.Option, b.Margins.Option).([]MarginsOption)}
+		if len(a.Margins.Option) == 0 {
+			a.Margins = nil
+		}
+	} else {
+		a.Margins = nil
+	}
+	if a.DPI != nil && b.DPI != nil {
+		dpi := *a.DPI
+		dpi.Option = intersectOptions(a.DPI.Option, b.DPI.Option).([]DPIOption)
+		if dpi.MinHorizontalDPI < b.DPI.MinHorizontalDPI {
+			dpi.MinHorizontalDPI = b.DPI.MinHorizontalDPI
+		}
+		if dpi.MaxHorizontalDPI > b.DPI.MaxHorizontalDPI {
+			dpi.MaxHorizontalDPI = b.DPI.MaxHorizontalDPI
+		}
+		if dpi.MinVerticalDPI < b.DPI.MinVerticalDPI {
+			dpi.MinVerticalDPI = b.DPI.MinVerticalDPI
+		}
+		if dpi.MaxVerticalDPI > b.DPI.MaxVerticalDPI {
+			dpi.MaxVerticalDPI = b.DPI.MaxVerticalDPI
+		}
+		a.DPI = &dpi
+		if len(a.DPI.Option) == 0 {
+			a.DPI = nil
+		}
+	} else {
+		a.DPI = nil
+	}
+	if a.FitToPage != nil && b.FitToPage != nil {
+		a.FitToPage = &FitToPage{Option: intersectOptions(a.FitToPage.Option, b.FitToPage.Option).([]FitToPageOption)}
+		if len(a.FitToPage.Option) == 0 {
+			a.FitToPage = nil
+		}
+	} else {
+		a.FitToPage = nil
+	}
+	if !reflect.DeepEqual(a.PageRange, b.PageRange) {
+		a.PageRange = nil
+	}
+	if a.MediaSize != nil && b.MediaSize != nil {
+		mediaSize := *a.MediaSize
+		mediaSize.Option = intersectOptions(a.MediaSize.Option, b.MediaSize.Option).([]MediaSizeOption)
+		if mediaSize.MaxWidthMicrons > b.MediaSize.MaxWidthMicrons {
+			mediaSize.MaxWidthMicrons = b.MediaSize.MaxWidthMicrons
+		}
+		if mediaSize.MaxHeightMicrons > b.MediaSize.MaxHeightMicrons {
+			mediaSize.MaxHeightMicrons = b.MediaSize.MaxHeightMicrons
+		}
+		if mediaSize.MinWidthMicrons < b.MediaSize.MinWidthMicrons {
+			mediaSize.MinWidthMicrons = b.MediaSize.MinWidthMicrons
+		}
+		if mediaSize.MinHeightMicrons < b.MediaSize.MinHeightMicrons {
+			mediaSize.MinHeightMicrons = b.MediaSize.MinHeightMicrons
+		}
+		a.MediaSize = &mediaSize
+		if len(a.MediaSize.Option) == 0 {
+			a.MediaSize = nil
+		}
+	} else {
+		a.MediaSize = nil
+	}
+	if !reflect.DeepEqual(a.Collate, b.Collate) {
+		a.Collate = nil
+	}
+	if !reflect.DeepEqual(a.ReverseOrder, b.ReverseOrder) {
+		a.ReverseOrder = nil
+	}
+}
+
+// intersectOptions returns a new slice with the elements of a that are also
+// in b. a and b must be slices of the same struct type. Elements are compared
+// without their IsDefault field, if any.
+//
+// If a default option is lost, the first remaining option becomes default.
+func intersectOptions(a, b interface{}) interface{} {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	result := reflect.MakeSlice(va.Type(), 0, va.Len())
+
+	withoutDefault := func(v reflect.Value) interface{} {
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		if f := c.FieldByName("IsDefault"); f.IsValid() {
+			f.SetBool(false)
+		}
+		return c.Interface()
+	}
+
+	hadDefault, hasDefault := false, false
+	for i := 0; i < va.Len(); i++ {
+		isDefault := false
+		if f := va.Index(i).FieldByName("IsDefault"); f.IsValid() && f.Bool() {
+			isDefault, hadDefault = true, true
+		}
+		option := withoutDefault(va.Index(i))
+		for j := 0; j < vb.Len(); j++ {
+			if reflect.DeepEqual(option, withoutDefault(vb.Index(j))) {
+				result = reflect.Append(result, va.Index(i))
+				hasDefault = hasDefault || isDefault
+				break
+			}
+		}
+	}
+
+	if hadDefault && !hasDefault && result.Len() > 0 {
+		result.Index(0).FieldByName("IsDefault").SetBool(true)
+	}
+
+	return result.Interface()
+}
+
 type SupportedContentType struct {
 	ContentType string `json:"content_type"`
 	MinVersion  string `json:"min_version,omitempty"`
@@ -0,0 +1,226 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package cdd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIntersectOptions(t *testing.T) {
+	cases := []struct {
+		name     string
+		a, b     interface{}
+		expected interface{}
+	}{
+		{
+			name: "common options in the order of a",
+			a: []DuplexOption{
+				DuplexOption{Type: DuplexNoDuplex},
+				DuplexOption{Type: DuplexLongEdge},
+				DuplexOption{Type: DuplexShortEdge},
+			},
+			b: []DuplexOption{
+				DuplexOption{Type: DuplexShortEdge},
+				DuplexOption{Type: DuplexNoDuplex},
+			},
+			expected: []DuplexOption{
+				DuplexOption{Type: DuplexNoDuplex},
+				DuplexOption{Type: DuplexShortEdge},
+			},
+		},
+		{
+			name: "default of a is kept",
+			a: []DuplexOption{
+				DuplexOption{Type: DuplexNoDuplex},
+				DuplexOption{Type: DuplexLongEdge, IsDefault: true},
+			},
+			b: []DuplexOption{
+				DuplexOption{Type: DuplexNoDuplex, IsDefault: true},
+				DuplexOption{Type: DuplexLongEdge},
+			},
+			expected: []DuplexOption{
+				DuplexOption{Type: DuplexNoDuplex},
+				DuplexOption{Type: DuplexLongEdge, IsDefault: true},
+			},
+		},
+		{
+			name: "lost default moves to the first option",
+			a: []ColorOption{
+				ColorOption{VendorID: "color", Type: ColorTypeStandardColor, IsDefault: true},
+				ColorOption{VendorID: "gray", Type: ColorTypeStandardMonochrome},
+				ColorOption{VendorID: "black", Type: ColorTypeStandardMonochrome},
+			},
+			b: []ColorOption{
+				ColorOption{VendorID: "black", Type: ColorTypeStandardMonochrome},
+				ColorOption{VendorID: "gray", Type: ColorTypeStandardMonochrome, IsDefault: true},
+			},
+			expected: []ColorOption{
+				ColorOption{VendorID: "gray", Type: ColorTypeStandardMonochrome, IsDefault: true},
+				ColorOption{VendorID: "black", Type: ColorTypeStandardMonochrome},
+			},
+		},
+		{
+			name: "options differ in other fields",
+			a: []DPIOption{
+				DPIOption{HorizontalDPI: 300, VerticalDPI: 300},
+				DPIOption{HorizontalDPI: 600, VerticalDPI: 600, IsDefault: true},
+			},
+			b: []DPIOption{
+				DPIOption{HorizontalDPI: 600, VerticalDPI: 300},
+			},
+			expected: []DPIOption{},
+		},
+		{
+			name: "options without default",
+			a: []SupportedContentType{
+				SupportedContentType{ContentType: "application/pdf", MinVersion: "1.5"},
+				SupportedContentType{ContentType: "image/pwg-raster"},
+			},
+			b: []SupportedContentType{
+				SupportedContentType{ContentType: "application/pdf", MinVersion: "1.5"},
+			},
+			expected: []SupportedContentType{
+				SupportedContentType{ContentType: "application/pdf", MinVersion: "1.5"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		if got := intersectOptions(c.a, c.b); !reflect.DeepEqual(c.expected, got) {
+			t.Logf("%s: expected %+v, got %+v", c.name, c.expected, got)
+			t.Fail()
+		}
+	}
+}
+
+func TestIntersect(t *testing.T) {
+	cases := []struct {
+		name     string
+		a, b     PrinterDescriptionSection
+		expected PrinterDescriptionSection
+	}{
+		{
+			name: "capability missing from b",
+			a: PrinterDescriptionSection{
+				Color:  &Color{Option: []ColorOption{ColorOption{Type: ColorTypeStandardColor, IsDefault: true}}},
+				Duplex: &Duplex{Option: []DuplexOption{DuplexOption{Type: DuplexNoDuplex, IsDefault: true}}},
+			},
+			b: PrinterDescriptionSection{
+				Duplex: &Duplex{Option: []DuplexOption{DuplexOption{Type: DuplexNoDuplex}}},
+			},
+			expected: PrinterDescriptionSection{
+				Duplex: &Duplex{Option: []DuplexOption{DuplexOption{Type: DuplexNoDuplex, IsDefault: true}}},
+			},
+		},
+		{
+			name: "no common options",
+			a: PrinterDescriptionSection{
+				Duplex: &Duplex{Option: []DuplexOption{DuplexOption{Type: DuplexLongEdge}}},
+			},
+			b: PrinterDescriptionSection{
+				Duplex: &Duplex{Option: []DuplexOption{DuplexOption{Type: DuplexNoDuplex}}},
+			},
+			expected: PrinterDescriptionSection{},
+		},
+		{
+			name: "ranges narrow",
+			a: PrinterDescriptionSection{
+				Copies: &Copies{Default: 50, Max: 99},
+				DPI: &DPI{
+					Option:           []DPIOption{DPIOption{HorizontalDPI: 300, VerticalDPI: 300}},
+					MinHorizontalDPI: 150, MaxHorizontalDPI: 1200,
+					MinVerticalDPI: 150, MaxVerticalDPI: 1200,
+				},
+			},
+			b: PrinterDescriptionSection{
+				Copies: &Copies{Default: 1, Max: 10},
+				DPI: &DPI{
+					Option:           []DPIOption{DPIOption{HorizontalDPI: 300, VerticalDPI: 300}},
+					MinHorizontalDPI: 300, MaxHorizontalDPI: 600,
+					MinVerticalDPI: 100, MaxVerticalDPI: 600,
+				},
+			},
+			expected: PrinterDescriptionSection{
+				Copies: &Copies{Default: 10, Max: 10},
+				DPI: &DPI{
+					Option:           []DPIOption{DPIOption{HorizontalDPI: 300, VerticalDPI: 300}},
+					MinHorizontalDPI: 300, MaxHorizontalDPI: 600,
+					MinVerticalDPI: 150, MaxVerticalDPI: 600,
+				},
+			},
+		},
+		{
+			name: "vendor capabilities",
+			a: PrinterDescriptionSection{
+				VendorCapability: &[]VendorCapability{
+					VendorCapability{
+						ID:   "tray",
+						Type: VendorCapabilitySelect,
+						SelectCap: &SelectCapability{Option: []SelectCapabilityOption{
+							SelectCapabilityOption{Value: "1", IsDefault: true},
+							SelectCapabilityOption{Value: "2"},
+						}},
+					},
+					VendorCapability{
+						ID:   "staple",
+						Type: VendorCapabilitySelect,
+						SelectCap: &SelectCapability{Option: []SelectCapabilityOption{
+							SelectCapabilityOption{Value: "none"},
+						}},
+					},
+					VendorCapability{ID: "punch", Type: VendorCapabilityTypedValue},
+					VendorCapability{ID: "fold", Type: VendorCapabilityTypedValue},
+				},
+			},
+			b: PrinterDescriptionSection{
+				VendorCapability: &[]VendorCapability{
+					VendorCapability{
+						ID:   "tray",
+						Type: VendorCapabilitySelect,
+						SelectCap: &SelectCapability{Option: []SelectCapabilityOption{
+							SelectCapabilityOption{Value: "2"},
+							SelectCapabilityOption{Value: "3"},
+						}},
+					},
+					VendorCapability{
+						ID:   "staple",
+						Type: VendorCapabilitySelect,
+						SelectCap: &SelectCapability{Option: []SelectCapabilityOption{
+							SelectCapabilityOption{Value: "corner"},
+						}},
+					},
+					VendorCapability{ID: "punch", Type: VendorCapabilityTypedValue},
+					VendorCapability{ID: "fold", Type: VendorCapabilityTypedValue, DisplayName: "Fold"},
+				},
+			},
+			expected: PrinterDescriptionSection{
+				VendorCapability: &[]VendorCapability{
+					VendorCapability{
+						ID:   "tray",
+						Type: VendorCapabilitySelect,
+						SelectCap: &SelectCapability{Option: []SelectCapabilityOption{
+							SelectCapabilityOption{Value: "2", IsDefault: true},
+						}},
+					},
+					VendorCapability{ID: "punch", Type: VendorCapabilityTypedValue},
+				},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		a := c.a
+		a.Intersect(&c.b)
+		if !reflect.DeepEqual(c.expected, a) {
+			t.Logf("%s: expected %+v, got %+v", c.name, c.expected, a)
+			t.Fail()
+		}
+	}
+}
@@ -32,6 +32,10 @@ const (
 	// to do things like query the state of a job.
 	jobURIFormat = "/jobs/%d"
 
+	// printerURIFormat is the string format required by the CUPS API
+	// to do things like subscribe to the jobs of a printer.
+	printerURIFormat = "/printers/%s"
+
 	// filePathMaxLength varies by operating system and file system.
 	// This value should be large enough to be useful and small enough
 	// to work on any platform.
@@ -230,16 +234,162 @@ func (cc *cupsCore) getJobAttributes(jobID C.int, attributes **C.char) (*C.ipp_t
 	return response, nil
 }
 
+// cancelJob cancels a job by calling C.doRequest (IPP_OP_CANCEL_JOB).
+func (cc *cupsCore) cancelJob(jobID C.int) error {
+	uri, err := createJobURI(jobID)
+	if err != nil {
+		return err
+	}
+	defer C.free(unsafe.Pointer(uri))
+
+	// ippNewRequest() returns ipp_t pointer does not need explicit free.
+	request := C.ippNewRequest(C.IPP_OP_CANCEL_JOB)
+
+	C.ippAddString(request, C.IPP_TAG_OPERATION, C.IPP_TAG_URI, C.JOB_URI_ATTRIBUTE, nil, uri)
+
+	response, err := cc.doRequest(request, []C.ipp_status_t{C.IPP_STATUS_OK})
+	if err != nil {
+		return fmt.Errorf("Failed to call cupsDoRequest() [IPP_OP_CANCEL_JOB]: %s", err)
+	}
+	C.ippDelete(response)
+
+	return nil
+}
+
+// releaseJob releases a held job by calling C.doRequest (IPP_OP_RELEASE_JOB).
+func (cc *cupsCore) releaseJob(jobID C.int) error {
+	uri, err := createJobURI(jobID)
+	if err != nil {
+		return err
+	}
+	defer C.free(unsafe.Pointer(uri))
+
+	// ippNewRequest() returns ipp_t pointer does not need explicit free.
+	request := C.ippNewRequest(C.IPP_OP_RELEASE_JOB)
+
+	C.ippAddString(request, C.IPP_TAG_OPERATION, C.IPP_TAG_URI, C.JOB_URI_ATTRIBUTE, nil, uri)
+
+	response, err := cc.doRequest(request, []C.ipp_status_t{C.IPP_STATUS_OK})
+	if err != nil {
+		return fmt.Errorf("Failed to call cupsDoRequest() [IPP_OP_RELEASE_JOB]: %s", err)
+	}
+	C.ippDelete(response)
+
+	return nil
+}
+
+// createJobSubscription subscribes to the state changes of a job by calling
+// C.doRequest (IPP_OP_CREATE_JOB_SUBSCRIPTIONS). The events are pulled
+// later by getNotifications.
+//
+// Returns the subscription ID.
+func (cc *cupsCore) createJobSubscription(printername string, jobID C.int) (C.int, error) {
+	uri, err := createURI(fmt.Sprintf(printerURIFormat, printername))
+	if err != nil {
+		return 0, err
+	}
+	defer C.free(unsafe.Pointer(uri))
+
+	// ippNewRequest() returns ipp_t pointer does not need explicit free.
+	request := C.ippNewRequest(C.IPP_OP_CREATE_JOB_SUBSCRIPTIONS)
+
+	C.ippAddString(request, C.IPP_TAG_OPERATION, C.IPP_TAG_URI, C.PRINTER_URI_ATTRIBUTE, nil, uri)
+	C.ippAddString(request, C.IPP_TAG_SUBSCRIPTION, C.IPP_TAG_KEYWORD, C.NOTIFY_PULL_METHOD, nil, C.IPPGET)
+	C.ippAddString(request, C.IPP_TAG_SUBSCRIPTION, C.IPP_TAG_KEYWORD, C.NOTIFY_EVENTS, nil, C.JOB_STATE_CHANGED)
+	C.ippAddInteger(request, C.IPP_TAG_SUBSCRIPTION, C.IPP_TAG_INTEGER, C.NOTIFY_JOB_ID, jobID)
+
+	response, err := cc.doRequest(request,
+		[]C.ipp_status_t{C.IPP_STATUS_OK, C.IPP_STATUS_OK_IGNORED_OR_SUBSTITUTED})
+	if err != nil {
+		err = fmt.Errorf("Failed to call cupsDoRequest() [IPP_OP_CREATE_JOB_SUBSCRIPTIONS]: %s", err)
+		return 0, err
+	}
+
+	// cupsDoRequest() returned ipp_t pointer needs explicit free.
+	defer C.ippDelete(response)
+
+	a := C.ippFindAttribute(response, C.NOTIFY_SUBSCRIPTION_ID, C.IPP_TAG_INTEGER)
+	if a == nil {
+		return 0, errors.New("CUPS did not return a subscription ID")
+	}
+
+	return C.getAttributeIntegerValue(a, C.int(0)), nil
+}
+
+// getNotifications gets the pending events of many subscriptions by calling
+// C.doRequest (IPP_OP_GET_NOTIFICATIONS). sequenceNumbers holds the lowest
+// event sequence number wanted for each subscription.
+//
+// The caller is responsible to C.ippDelete the returned *C.ipp_t response.
+func (cc *cupsCore) getNotifications(subscriptionIDs, sequenceNumbers []C.int) (*C.ipp_t, error) {
+	uri, err := createURI("/")
+	if err != nil {
+		return nil, err
+	}
+	defer C.free(unsafe.Pointer(uri))
+
+	// ippNewRequest() returns ipp_t pointer does not need explicit free.
+	request := C.ippNewRequest(C.IPP_OP_GET_NOTIFICATIONS)
+
+	C.ippAddString(request, C.IPP_TAG_OPERATION, C.IPP_TAG_URI, C.PRINTER_URI_ATTRIBUTE, nil, uri)
+	C.ippAddIntegers(request, C.IPP_TAG_OPERATION, C.IPP_TAG_INTEGER, C.NOTIFY_SUBSCRIPTION_IDS,
+		C.int(len(subscriptionIDs)), &subscriptionIDs[0])
+	C.ippAddIntegers(request, C.IPP_TAG_OPERATION, C.IPP_TAG_INTEGER, C.NOTIFY_SEQUENCE_NUMBERS,
+		C.int(len(sequenceNumbers)), &sequenceNumbers[0])
+
+	response, err := cc.doRequest(request,
+		[]C.ipp_status_t{C.IPP_STATUS_OK, C.IPP_STATUS_OK_EVENTS_COMPLETE})
+	if err != nil {
+		err = fmt.Errorf("Failed to call cupsDoRequest() [IPP_OP_GET_NOTIFICATIONS]: %s", err)
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// cancelSubscription cancels a subscription by calling
+// C.doRequest (IPP_OP_CANCEL_SUBSCRIPTION).
+func (cc *cupsCore) cancelSubscription(subscriptionID C.int) error {
+	uri, err := createURI("/")
+	if err != nil {
+		return err
+	}
+	defer C.free(unsafe.Pointer(uri))
+
+	// ippNewRequest() returns ipp_t pointer does not need explicit free.
+	request := C.ippNewRequest(C.IPP_OP_CANCEL_SUBSCRIPTION)
+
+	C.ippAddString(request, C.IPP_TAG_OPERATION, C.IPP_TAG_URI, C.PRINTER_URI_ATTRIBUTE, nil, uri)
+	C.ippAddInteger(request, C.IPP_TAG_OPERATION, C.IPP_TAG_INTEGER, C.NOTIFY_SUBSCRIPTION_ID, subscriptionID)
+
+	// The subscription might have ended with its job already.
+	response, err := cc.doRequest(request,
+		[]C.ipp_status_t{C.IPP_STATUS_OK, C.IPP_STATUS_ERROR_NOT_FOUND})
+	if err != nil {
+		return fmt.Errorf("Failed to call cupsDoRequest() [IPP_OP_CANCEL_SUBSCRIPTION]: %s", err)
+	}
+	C.ippDelete(response)
+
+	return nil
+}
+
 // createJobURI creates a uri string for the job-uri attribute, used to get the
 // state of a CUPS job.
 func createJobURI(jobID C.int) (*C.char, error) {
+	return createURI(fmt.Sprintf(jobURIFormat, uint32(jobID)))
+}
+
+// createURI creates an ipp uri string for a resource on the CUPS server.
+//
+// The caller is responsible to C.free the returned uri.
+func createURI(r string) (*C.char, error) {
 	length := C.size_t(urlMaxLength)
 	uri := (*C.char)(C.malloc(length))
 	if uri == nil {
 		return nil, errors.New("Failed to malloc; out of memory?")
 	}
 
-	resource := C.CString(fmt.Sprintf(jobURIFormat, uint32(jobID)))
+	resource := C.CString(r)
 	defer C.free(unsafe.Pointer(resource))
 	C.httpAssembleURI(C.HTTP_URI_CODING_ALL,
 		uri, C.int(length), C.IPP, nil, C.cupsServer(), C.ippPort(), resource)
@@ -333,3 +483,20 @@ func (cc *cupsCore) connQtyOpen() uint {
 func (cc *cupsCore) connQtyMax() uint {
 	return cc.connectionSemaphore.Size()
 }
+
+// ping opens a new connection to the CUPS server, then closes it, to check
+// that the server is reachable. The connection pool is bypassed, because a
+// pooled connection can outlive the server, and because a health check
+// should not wait for a busy pool.
+func (cc *cupsCore) ping() error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	http := C.httpConnect2(cc.host, cc.port, nil, C.AF_UNSPEC, cc.encryption, 1, cc.connectTimeout, nil)
+	if http == nil {
+		return fmt.Errorf("Failed to connect to CUPS server %s:%d because %d %s",
+			C.GoString(cc.host), int(cc.port), int(C.cupsLastError()), C.GoString(C.cupsLastErrorString()))
+	}
+	C.httpClose(http)
+	return nil
+}
@@ -10,10 +10,20 @@ https://developers.google.com/open-source/licenses/bsd
 
 const char
 	*JOB_STATE                  = "job-state",
+	*JOB_IMPRESSIONS_COMPLETED  = "job-impressions-completed",
 	*JOB_MEDIA_SHEETS_COMPLETED = "job-media-sheets-completed",
 	*POST_RESOURCE              = "/",
 	*REQUESTED_ATTRIBUTES       = "requested-attributes",
 	*JOB_URI_ATTRIBUTE          = "job-uri",
+	*PRINTER_URI_ATTRIBUTE      = "printer-uri",
+	*NOTIFY_PULL_METHOD         = "notify-pull-method",
+	*NOTIFY_EVENTS              = "notify-events",
+	*NOTIFY_JOB_ID              = "notify-job-id",
+	*NOTIFY_SUBSCRIPTION_ID     = "notify-subscription-id",
+	*NOTIFY_SUBSCRIPTION_IDS    = "notify-subscription-ids",
+	*NOTIFY_SEQUENCE_NUMBERS    = "notify-sequence-numbers",
+	*IPPGET                     = "ippget",
+	*JOB_STATE_CHANGED          = "job-state-changed",
 	*IPP                        = "ipp";
 
 // Allocates a new char**, initializes the values to NULL.
@@ -27,13 +27,16 @@ import (
 	"unsafe"
 
 	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/ipp"
 	"github.com/google/cloud-print-connector/lib"
 	"github.com/google/cloud-print-connector/log"
 )
 
 const (
 	// CUPS "URL" length are always less than 40. For example: /job/1234567
-	urlMaxLength = 100
+	// Printer URLs are longer, because printer names are up to 127
+	// characters before escaping. For example: /printers/Office_Printer
+	urlMaxLength = 512
 
 	// Attributes that CUPS uses to describe printers.
 	attrCUPSVersion                   = "cups-version"
@@ -62,6 +65,7 @@ const (
 	attrCollate              = "collate"
 	attrFalse                = "false"
 	attrFitToPage            = "fit-to-page"
+	attrJobHoldUntil         = "job-hold-until"
 	attrMediaBottomMargin    = "media-bottom-margin"
 	attrMediaLeftMargin      = "media-left-margin"
 	attrMediaRightMargin     = "media-right-margin"
@@ -75,8 +79,13 @@ const (
 	attrTrue                 = "true"
 
 	// Attributes that CUPS uses to describe job state.
+	attrJobImpressionsCompleted = "job-impressions-completed"
 	attrJobMediaSheetsCompleted = "job-media-sheets-completed"
 	attrJobState                = "job-state"
+
+	// Attributes that CUPS uses to describe job events.
+	attrNotifySequenceNumber = "notify-sequence-number"
+	attrNotifySubscriptionID = "notify-subscription-id"
 )
 
 var (
@@ -104,6 +113,7 @@ var (
 
 	jobAttributes []string = []string{
 		attrJobState,
+		attrJobImpressionsCompleted,
 		attrJobMediaSheetsCompleted,
 	}
 
@@ -130,15 +140,19 @@ var (
 type CUPS struct {
 	cc                    *cupsCore
 	pc                    *ppdCache
+	je                    *jobEvents
 	infoToDisplayName     bool
 	prefixJobIDToJobTitle bool
-	displayNamePrefix     string
 	printerAttributes     []string
 	systemTags            map[string]string
-	printerBlacklist      map[string]interface{}
-	printerWhitelist      map[string]interface{}
 	ignoreRawPrinters     bool
 	ignoreClassPrinters   bool
+
+	// The filters can be changed while running.
+	filterMutex       sync.RWMutex
+	displayNamePrefix string
+	printerBlacklist  map[string]interface{}
+	printerWhitelist  map[string]interface{}
 }
 
 func NewCUPS(infoToDisplayName, prefixJobIDToJobTitle bool, displayNamePrefix string,
@@ -160,34 +174,45 @@ func NewCUPS(infoToDisplayName, prefixJobIDToJobTitle bool, displayNamePrefix st
 		return nil, err
 	}
 
-	pb := map[string]interface{}{}
-	for _, p := range printerBlacklist {
-		pb[p] = struct{}{}
-	}
-
-	pw := map[string]interface{}{}
-	for _, p := range printerWhitelist {
-		pw[p] = struct{}{}
-	}
-
 	c := &CUPS{
 		cc:                  cc,
 		pc:                  pc,
+		je:                  newJobEvents(cc),
 		infoToDisplayName:   infoToDisplayName,
-		displayNamePrefix:   displayNamePrefix,
 		printerAttributes:   printerAttributes,
 		systemTags:          systemTags,
-		printerBlacklist:    pb,
-		printerWhitelist:    pw,
 		ignoreRawPrinters:   ignoreRawPrinters,
 		ignoreClassPrinters: ignoreClassPrinters,
 	}
+	c.SetPrinterFilters(displayNamePrefix, printerBlacklist, printerWhitelist)
 
 	return c, nil
 }
 
+// SetPrinterFilters replaces the display name prefix, and the printer
+// blacklist and whitelist. The new filters apply from the next GetPrinters.
+func (c *CUPS) SetPrinterFilters(displayNamePrefix string, printerBlacklist, printerWhitelist []string) {
+	pb := map[string]interface{}{}
+	for _, p := range printerBlacklist {
+		pb[p] = struct{}{}
+	}
+
+	pw := map[string]interface{}{}
+	for _, p := range printerWhitelist {
+		pw[p] = struct{}{}
+	}
+
+	c.filterMutex.Lock()
+	defer c.filterMutex.Unlock()
+
+	c.displayNamePrefix = displayNamePrefix
+	c.printerBlacklist = pb
+	c.printerWhitelist = pw
+}
+
 func (c *CUPS) Quit() {
 	c.pc.quit()
+	c.je.stop()
 }
 
 // ConnQtyOpen gets the current quantity of open CUPS connections.
@@ -200,6 +225,14 @@ func (c *CUPS) ConnQtyMax() uint {
 	return c.cc.connQtyMax()
 }
 
+// CheckConnection is a health check of the connection to the CUPS server.
+func (c *CUPS) CheckConnection() (string, error) {
+	if err := c.cc.ping(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("CUPS server is reachable; %d of %d connections are open", c.ConnQtyOpen(), c.ConnQtyMax()), nil
+}
+
 // GetPrinters gets all CUPS printers found on the CUPS server.
 func (c *CUPS) GetPrinters() ([]lib.Printer, error) {
 	pa := C.newArrayOfStrings(C.int(len(c.printerAttributes)))
@@ -240,6 +273,9 @@ func (c *CUPS) GetPrinters() ([]lib.Printer, error) {
 func (c *CUPS) responseToPrinters(response *C.ipp_t) []lib.Printer {
 	printers := make([]lib.Printer, 0, 1)
 
+	c.filterMutex.RLock()
+	defer c.filterMutex.RUnlock()
+
 	for a := response.attrs; a != nil; a = a.next {
 		if a.group_tag != C.IPP_TAG_PRINTER {
 			continue
@@ -250,7 +286,7 @@ func (c *CUPS) responseToPrinters(response *C.ipp_t) []lib.Printer {
 			attributes = append(attributes, a)
 		}
 		mAttributes := attributesToMap(attributes)
-		pds, pss, name, defaultDisplayName, uuid, tags := translateAttrs(mAttributes)
+		pds, pss, name, defaultDisplayName, uuid, tags := ipp.TranslateAttrs(mAttributes)
 
 		// Check whitelist/blacklist in loop once we have printer name.
 		// Avoids unnecessary processing of excluded printers.
@@ -427,6 +463,22 @@ func (c *CUPS) RemoveCachedPPD(printername string) {
 
 // GetJobState gets the current state of the job indicated by jobID.
 func (c *CUPS) GetJobState(_ string, jobID uint32) (*cdd.PrintJobStateDiff, error) {
+	state, pagesPrinted, err := c.getJobState(jobID)
+	if err != nil {
+		return nil, err
+	}
+	jobState := ipp.ConvertJobState(state)
+	jobState.PagesPrinted = pagesPrinted
+	return jobState, nil
+}
+
+// getJobState gets the CUPS state of the job indicated by jobID, and the
+// quantity of pages printed, which is nil when CUPS does not know.
+//
+// Pages are impressions, ie sides of sheets. When CUPS does not count
+// impressions, sheets are counted instead, which undercounts duplex and
+// N-up jobs.
+func (c *CUPS) getJobState(jobID uint32) (int32, *int32, error) {
 	ja := C.newArrayOfStrings(C.int(len(jobAttributes)))
 	defer C.freeStringArrayAndStrings(ja, C.int(len(jobAttributes)))
 	for i, attribute := range jobAttributes {
@@ -435,7 +487,7 @@ func (c *CUPS) GetJobState(_ string, jobID uint32) (*cdd.PrintJobStateDiff, erro
 
 	response, err := c.cc.getJobAttributes(C.int(jobID), ja)
 	if err != nil {
-		return nil, err
+		return 0, nil, err
 	}
 
 	// cupsDoRequest() returned ipp_t pointer needs explicit free.
@@ -444,36 +496,45 @@ func (c *CUPS) GetJobState(_ string, jobID uint32) (*cdd.PrintJobStateDiff, erro
 	s := C.ippFindAttribute(response, C.JOB_STATE, C.IPP_TAG_ENUM)
 	state := int32(C.getAttributeIntegerValue(s, C.int(0)))
 
-	return convertJobState(state), nil
+	var pagesPrinted *int32
+	if m := C.ippFindAttribute(response, C.JOB_IMPRESSIONS_COMPLETED, C.IPP_TAG_INTEGER); m != nil {
+		impressions := int32(C.getAttributeIntegerValue(m, C.int(0)))
+		pagesPrinted = &impressions
+	} else if m := C.ippFindAttribute(response, C.JOB_MEDIA_SHEETS_COMPLETED, C.IPP_TAG_INTEGER); m != nil {
+		sheets := int32(C.getAttributeIntegerValue(m, C.int(0)))
+		pagesPrinted = &sheets
+	}
+
+	return state, pagesPrinted, nil
 }
 
-// convertJobState converts CUPS job state to cdd.PrintJobStateDiff.
-func convertJobState(cupsState int32) *cdd.PrintJobStateDiff {
-	var state cdd.PrintJobStateDiff
-
-	switch cupsState {
-	case 3, 4, 5: // PENDING, HELD, PROCESSING
-		state.State = &cdd.JobState{Type: cdd.JobStateInProgress}
-	case 6: // STOPPED
-		state.State = &cdd.JobState{
-			Type:              cdd.JobStateStopped,
-			DeviceActionCause: &cdd.DeviceActionCause{ErrorCode: cdd.DeviceActionCauseOther},
-		}
-	case 7: // CANCELED
-		state.State = &cdd.JobState{
-			Type:            cdd.JobStateAborted,
-			UserActionCause: &cdd.UserActionCause{ActionCode: cdd.UserActionCauseCanceled},
-		}
-	case 8: // ABORTED
-		state.State = &cdd.JobState{
-			Type:              cdd.JobStateAborted,
-			DeviceActionCause: &cdd.DeviceActionCause{ErrorCode: cdd.DeviceActionCausePrintFailure},
-		}
-	case 9: // COMPLETED
-		state.State = &cdd.JobState{Type: cdd.JobStateDone}
+// CancelJob cancels the job indicated by jobID.
+func (c *CUPS) CancelJob(_ string, jobID uint32) error {
+	return c.cc.cancelJob(C.int(jobID))
+}
+
+// ReleaseHeldJob releases the job indicated by jobID, if the job is held.
+func (c *CUPS) ReleaseHeldJob(_ string, jobID uint32) error {
+	state, _, err := c.getJobState(jobID)
+	if err != nil {
+		return err
+	}
+	// Only release if the job is held (otherwise we get an error)
+	if state != ipp.JobStateHeld {
+		return nil
 	}
+	return c.cc.releaseJob(C.int(jobID))
+}
 
-	return &state
+// SubscribeJobState subscribes to the state changes of the job indicated by
+// jobID, so that the job state need not be polled.
+func (c *CUPS) SubscribeJobState(printerName string, jobID uint32) (<-chan *cdd.PrintJobStateDiff, error) {
+	return c.je.subscribe(printerName, jobID)
+}
+
+// UnsubscribeJobState cancels a subscription made by SubscribeJobState.
+func (c *CUPS) UnsubscribeJobState(_ string, jobID uint32) {
+	c.je.unsubscribe(jobID)
 }
 
 // Print sends a new print job to the specified printer. The job ID
@@ -502,6 +563,9 @@ func (c *CUPS) Print(printer *lib.Printer, filename, title, user, gcpJobID strin
 	if err != nil {
 		return 0, err
 	}
+	if printer.HoldJobs {
+		options[attrJobHoldUntil] = "indefinite"
+	}
 	numOptions := C.int(0)
 	var o *C.cups_option_t = nil
 	for key, value := range options {
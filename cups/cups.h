@@ -21,10 +21,20 @@ https://developers.google.com/open-source/licenses/bsd
 
 extern const char
 	*JOB_STATE,
+	*JOB_IMPRESSIONS_COMPLETED,
 	*JOB_MEDIA_SHEETS_COMPLETED,
 	*POST_RESOURCE,
 	*REQUESTED_ATTRIBUTES,
 	*JOB_URI_ATTRIBUTE,
+	*PRINTER_URI_ATTRIBUTE,
+	*NOTIFY_PULL_METHOD,
+	*NOTIFY_EVENTS,
+	*NOTIFY_JOB_ID,
+	*NOTIFY_SUBSCRIPTION_ID,
+	*NOTIFY_SUBSCRIPTION_IDS,
+	*NOTIFY_SEQUENCE_NUMBERS,
+	*IPPGET,
+	*JOB_STATE_CHANGED,
 	*IPP;
 
 char **newArrayOfStrings(int size);
@@ -51,6 +61,13 @@ http_t *httpConnect2(const char *host, int port, http_addrlist_t *addrlist, int
 # define HTTP_STATUS_NOT_MODIFIED     HTTP_NOT_MODIFIED
 # define IPP_OP_CUPS_GET_PRINTERS     CUPS_GET_PRINTERS
 # define IPP_OP_GET_JOB_ATTRIBUTES    IPP_GET_JOB_ATTRIBUTES
+# define IPP_OP_CANCEL_JOB            IPP_CANCEL_JOB
+# define IPP_OP_RELEASE_JOB           IPP_RELEASE_JOB
 # define IPP_STATUS_OK                IPP_OK
 # define IPP_STATUS_ERROR_NOT_FOUND   IPP_NOT_FOUND
+# define IPP_STATUS_OK_IGNORED_OR_SUBSTITUTED IPP_OK_SUBST
+# define IPP_STATUS_OK_EVENTS_COMPLETE        IPP_OK_EVENTS_COMPLETE
+# define IPP_OP_CREATE_JOB_SUBSCRIPTIONS      IPP_CREATE_JOB_SUBSCRIPTION
+# define IPP_OP_GET_NOTIFICATIONS             IPP_GET_NOTIFICATIONS
+# define IPP_OP_CANCEL_SUBSCRIPTION           IPP_CANCEL_SUBSCRIPTION
 #endif
@@ -0,0 +1,304 @@
+// Copyright 2016 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+/*
+#include "cups.h"
+*/
+import "C"
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/ipp"
+	"github.com/google/cloud-print-connector/log"
+)
+
+const (
+	// jobEventsPollInterval is the time between Get-Notifications requests.
+	// One request collects the events of every subscribed job.
+	jobEventsPollInterval = time.Second
+	// jobEventsMaxPollInterval is the longest time between Get-Notifications
+	// requests, while they fail.
+	jobEventsMaxPollInterval = 30 * time.Second
+	// jobEventsMaxFailures is the count of Get-Notifications requests in a
+	// row that may fail for one subscription before it is dropped.
+	jobEventsMaxFailures = 5
+)
+
+// jobSubscription is the subscription to the state changes of one CUPS job.
+type jobSubscription struct {
+	jobID          uint32
+	sequenceNumber C.int
+	states         chan *cdd.PrintJobStateDiff
+	// failures counts the failed requests for this subscription since the
+	// last one that succeeded.
+	failures uint
+}
+
+// jobEvents pulls job state changes from CUPS for all subscribed jobs, and
+// hands them to the subscribers.
+type jobEvents struct {
+	cc *cupsCore
+
+	// Key is CUPS subscription ID.
+	subscriptions map[C.int]*jobSubscription
+	mutex         sync.Mutex
+
+	quit chan struct{}
+}
+
+func newJobEvents(cc *cupsCore) *jobEvents {
+	je := jobEvents{
+		cc:            cc,
+		subscriptions: make(map[C.int]*jobSubscription),
+		quit:          make(chan struct{}),
+	}
+	go je.pollPeriodically()
+	return &je
+}
+
+func (je *jobEvents) stop() {
+	close(je.quit)
+}
+
+// subscribe subscribes to the state changes of a CUPS job.
+//
+// The returned channel holds the most recent job state not yet received.
+// The channel is closed when the subscription fails jobEventsMaxFailures
+// times in a row.
+func (je *jobEvents) subscribe(printerName string, jobID uint32) (<-chan *cdd.PrintJobStateDiff, error) {
+	subscriptionID, err := je.cc.createJobSubscription(printerName, C.int(jobID))
+	if err != nil {
+		return nil, err
+	}
+
+	js := jobSubscription{
+		jobID:          jobID,
+		sequenceNumber: 1,
+		states:         make(chan *cdd.PrintJobStateDiff, 1),
+	}
+
+	je.mutex.Lock()
+	defer je.mutex.Unlock()
+	je.subscriptions[subscriptionID] = &js
+
+	return js.states, nil
+}
+
+// unsubscribe cancels the subscription to the state changes of a CUPS job.
+func (je *jobEvents) unsubscribe(jobID uint32) {
+	je.mutex.Lock()
+	var subscriptionID C.int
+	var found bool
+	for id, js := range je.subscriptions {
+		if js.jobID == jobID {
+			subscriptionID, found = id, true
+			delete(je.subscriptions, id)
+			close(js.states)
+			break
+		}
+	}
+	je.mutex.Unlock()
+
+	if !found {
+		return
+	}
+	if err := je.cc.cancelSubscription(subscriptionID); err != nil {
+		log.Warningf("Failed to cancel CUPS subscription to job %d: %s", jobID, err)
+	}
+}
+
+// pollPeriodically polls every jobEventsPollInterval, backing off while the
+// polls fail.
+func (je *jobEvents) pollPeriodically() {
+	interval := jobEventsPollInterval
+	for {
+		select {
+		case <-time.After(interval):
+			if je.poll() {
+				interval = jobEventsPollInterval
+				continue
+			}
+			interval *= 2
+			if interval > jobEventsMaxPollInterval {
+				interval = jobEventsMaxPollInterval
+			}
+		case <-je.quit:
+			return
+		}
+	}
+}
+
+// poll gets the pending events of all subscriptions with one request. It
+// returns false when no events could be had from CUPS.
+func (je *jobEvents) poll() bool {
+	je.mutex.Lock()
+	subscriptionIDs := make([]C.int, 0, len(je.subscriptions))
+	sequenceNumbers := make([]C.int, 0, len(je.subscriptions))
+	for id, js := range je.subscriptions {
+		subscriptionIDs = append(subscriptionIDs, id)
+		sequenceNumbers = append(sequenceNumbers, js.sequenceNumber)
+	}
+	je.mutex.Unlock()
+
+	if len(subscriptionIDs) == 0 {
+		return true
+	}
+
+	response, err := je.cc.getNotifications(subscriptionIDs, sequenceNumbers)
+	if err == nil {
+		je.handleNotifications(subscriptionIDs, response)
+		return true
+	}
+
+	// CUPS fails the whole request when one subscription is gone, so
+	// there is no telling which subscriptions are still good. Ask for each
+	// one on its own; those that keep failing are dropped, and their
+	// subscribers fall back to polling the job state.
+	log.Warningf("Failed to get CUPS job events: %s", err)
+	var ok bool
+	for i, id := range subscriptionIDs {
+		response, err := je.cc.getNotifications(subscriptionIDs[i:i+1], sequenceNumbers[i:i+1])
+		if err != nil {
+			je.handleFailure(id, err)
+			continue
+		}
+		je.handleNotifications(subscriptionIDs[i:i+1], response)
+		ok = true
+	}
+	return ok
+}
+
+// handleFailure drops the subscription with the given ID when it has failed
+// jobEventsMaxFailures times in a row.
+func (je *jobEvents) handleFailure(subscriptionID C.int, err error) {
+	je.mutex.Lock()
+	defer je.mutex.Unlock()
+
+	js, exists := je.subscriptions[subscriptionID]
+	if !exists {
+		return
+	}
+	js.failures++
+	if js.failures < jobEventsMaxFailures {
+		return
+	}
+	log.Warningf("Dropping CUPS subscription to job %d after %d failures: %s", js.jobID, js.failures, err)
+	delete(je.subscriptions, subscriptionID)
+	close(js.states)
+}
+
+// handleNotifications hands the events in response to the subscribers, and
+// deletes response. subscriptionIDs are the subscriptions that response
+// answers for.
+func (je *jobEvents) handleNotifications(subscriptionIDs []C.int, response *C.ipp_t) {
+	events := responseToEvents(response)
+	C.ippDelete(response)
+
+	je.mutex.Lock()
+	defer je.mutex.Unlock()
+
+	for _, id := range subscriptionIDs {
+		if js, exists := je.subscriptions[id]; exists {
+			js.failures = 0
+		}
+	}
+
+	for _, event := range events {
+		subscriptionID, sequenceNumber, state, ok := parseJobEvent(event)
+		if !ok {
+			continue
+		}
+		js, exists := je.subscriptions[subscriptionID]
+		if !exists || sequenceNumber < js.sequenceNumber {
+			continue
+		}
+		js.sequenceNumber = sequenceNumber + 1
+
+		// Replace any state that the subscriber has not received yet;
+		// only the most recent state matters.
+		select {
+		case js.states <- state:
+		default:
+			select {
+			case <-js.states:
+			default:
+			}
+			js.states <- state
+		}
+	}
+}
+
+// responseToEvents converts the event notification groups of a C.ipp_t to a
+// slice of string:string "tag" maps.
+func responseToEvents(response *C.ipp_t) []map[string][]string {
+	events := make([]map[string][]string, 0, 1)
+
+	for a := response.attrs; a != nil; a = a.next {
+		if a.group_tag != C.IPP_TAG_EVENT_NOTIFICATION {
+			continue
+		}
+
+		attributes := make([]*C.ipp_attribute_t, 0, 8)
+		for ; a != nil && a.group_tag == C.IPP_TAG_EVENT_NOTIFICATION; a = a.next {
+			attributes = append(attributes, a)
+		}
+		events = append(events, attributesToMap(attributes))
+
+		if a == nil {
+			break
+		}
+	}
+
+	return events
+}
+
+// parseJobEvent gets the subscription ID, sequence number and job state from
+// one event, with the sheets printed when the event has them. ok is false
+// when the event is not a job state event.
+func parseJobEvent(event map[string][]string) (subscriptionID, sequenceNumber C.int, state *cdd.PrintJobStateDiff, ok bool) {
+	getInt := func(key string) (int64, bool) {
+		values, exists := event[key]
+		if !exists || len(values) != 1 {
+			return 0, false
+		}
+		i, err := strconv.ParseInt(values[0], 10, 32)
+		if err != nil {
+			return 0, false
+		}
+		return i, true
+	}
+
+	id, ok := getInt(attrNotifySubscriptionID)
+	if !ok {
+		return
+	}
+	sn, ok := getInt(attrNotifySequenceNumber)
+	if !ok {
+		return
+	}
+	js, ok := getInt(attrJobState)
+	if !ok {
+		return
+	}
+
+	state = ipp.ConvertJobState(int32(js))
+	// Pages are impressions, or sheets when CUPS does not count impressions.
+	if impressions, exists := getInt(attrJobImpressionsCompleted); exists {
+		pagesPrinted := int32(impressions)
+		state.PagesPrinted = &pagesPrinted
+	} else if sheets, exists := getInt(attrJobMediaSheetsCompleted); exists {
+		pagesPrinted := int32(sheets)
+		state.PagesPrinted = &pagesPrinted
+	}
+	return C.int(id), C.int(sn), state, true
+}
@@ -0,0 +1,65 @@
+// Copyright 2016 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package cups
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+func TestParseJobEvent(t *testing.T) {
+	event := map[string][]string{
+		"notify-subscription-id":     []string{"42"},
+		"notify-sequence-number":     []string{"3"},
+		"notify-subscribed-event":    []string{"job-completed"},
+		"job-id":                     []string{"17"},
+		"job-state":                  []string{"9"},
+		"job-impressions-completed":  []string{"10"},
+		"job-media-sheets-completed": []string{"5"},
+	}
+	subscriptionID, sequenceNumber, state, ok := parseJobEvent(event)
+	if !ok {
+		t.Fatal("expected job event to parse")
+	}
+	if int(subscriptionID) != 42 {
+		t.Logf("expected subscription ID 42, got %d", int(subscriptionID))
+		t.Fail()
+	}
+	if int(sequenceNumber) != 3 {
+		t.Logf("expected sequence number 3, got %d", int(sequenceNumber))
+		t.Fail()
+	}
+	pagesPrinted := int32(10)
+	expected := &cdd.PrintJobStateDiff{State: &cdd.JobState{Type: cdd.JobStateDone}, PagesPrinted: &pagesPrinted}
+	if !reflect.DeepEqual(state, expected) {
+		t.Logf("expected %+v, got %+v", expected, state)
+		t.Fail()
+	}
+
+	// Sheets are counted when impressions are not.
+	delete(event, "job-impressions-completed")
+	_, _, state, _ = parseJobEvent(event)
+	if state.PagesPrinted == nil || *state.PagesPrinted != 5 {
+		t.Logf("expected 5 pages from sheets, got %+v", state.PagesPrinted)
+		t.Fail()
+	}
+
+	// Printer events carry no job state.
+	event = map[string][]string{
+		"notify-subscription-id":  []string{"42"},
+		"notify-sequence-number":  []string{"4"},
+		"notify-subscribed-event": []string{"printer-state-changed"},
+	}
+	if _, _, _, ok = parseJobEvent(event); ok {
+		t.Log("expected event without job state to be ignored")
+		t.Fail()
+	}
+}
@@ -22,6 +22,11 @@ import (
 var rVendorIDKeyValue = regexp.MustCompile(
 	`^([^\` + internalKeySeparator + `]+)(?:` + internalKeySeparator + `(.+))?$`)
 
+var orientationValueByType = map[cdd.PageOrientationType]string{
+	cdd.PageOrientationPortrait:  "3",
+	cdd.PageOrientationLandscape: "4",
+}
+
 // translateTicket converts a CloudJobTicket to a map of options, suitable for a new CUPS print job.
 func translateTicket(printer *lib.Printer, ticket *cdd.CloudJobTicket) (map[string]string, error) {
 	if printer == nil || ticket == nil {
@@ -3,17 +3,22 @@ package fcm
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 )
 
 import (
 	"github.com/google/cloud-print-connector/log"
+	"github.com/google/cloud-print-connector/metrics"
 	"github.com/google/cloud-print-connector/notification"
 )
 
+var connected = metrics.NewGauge("fcm_connected", "Whether the FCM connection is up.")
+
 const (
 	gcpFcmSubscribePath = "fcm/subscribe"
 )
@@ -32,6 +37,11 @@ type FCM struct {
 
 	quit chan struct{}
 	backoff backoff
+
+	// connectedSince is when the current connection was made, or zero when
+	// there is none.
+	connectedMutex sync.Mutex
+	connectedSince time.Time
 }
 
 type FCMMessage []struct {
@@ -59,6 +69,8 @@ func NewFCM(clientID string, proxyName string, fcmServerBindURL string, FcmSubsc
 		make(chan struct{}),
 		make(chan struct{}),
 		backoff{0, time.Second * 5, time.Minute * 5},
+		sync.Mutex{},
+		time.Time{},
 	}
 	return &f, nil
 }
@@ -97,6 +109,7 @@ func (f *FCM) ConnectToFcm(fcmNotifications chan<- notification.PrinterNotificat
 		return err
 	}
 	if resp.StatusCode == 200 {
+		f.setConnected(true)
 		reader := bufio.NewReader(resp.Body)
 		go func() {
 			for {
@@ -107,6 +120,7 @@ func (f *FCM) ConnectToFcm(fcmNotifications chan<- notification.PrinterNotificat
 				}
 				if err != nil {
 						log.Info("DRAIN message received, client reconnecting.")
+						f.setConnected(false)
 						dead <- struct{}{}
 						break
 					}
@@ -116,6 +130,29 @@ func (f *FCM) ConnectToFcm(fcmNotifications chan<- notification.PrinterNotificat
 	return nil
 }
 
+func (f *FCM) setConnected(c bool) {
+	f.connectedMutex.Lock()
+	defer f.connectedMutex.Unlock()
+
+	if c {
+		f.connectedSince = time.Now()
+	} else {
+		f.connectedSince = time.Time{}
+	}
+	connected.SetBool(c)
+}
+
+// CheckConnection is a health check of the FCM connection.
+func (f *FCM) CheckConnection() (string, error) {
+	f.connectedMutex.Lock()
+	defer f.connectedMutex.Unlock()
+
+	if f.connectedSince.IsZero() {
+		return "", errors.New("FCM connection is down")
+	}
+	return fmt.Sprintf("FCM connection has been up since %s", f.connectedSince.Format(time.RFC3339)), nil
+}
+
 func GetPrinterID(reader *bufio.Reader) (string, error) {
 	raw_input, err := reader.ReadBytes('\n')
 	if err == nil {
@@ -198,6 +235,7 @@ func (f *FCM) KeepFcmAlive() {
 
 		case <-f.quit:
 			log.Info("Fcm client Quitting ...")
+			f.setConnected(false)
 			// quitting keeping alive
 			return
 		}
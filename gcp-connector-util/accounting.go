@@ -0,0 +1,209 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/urfave/cli"
+)
+
+// accountingDateFormat is the format of the --since and --until dates.
+const accountingDateFormat = "2006-01-02"
+
+// rolledAccountingFilePattern matches the suffix of rolled accounting files.
+var rolledAccountingFilePattern = regexp.MustCompile(`^\.([0-9]+)$`)
+
+// accountingSummary is the jobs and pages of one user or printer.
+type accountingSummary struct {
+	name    string
+	jobs    uint
+	done    uint
+	aborted uint
+	pages   int64
+}
+
+// accountingFileNames returns the accounting file, and the files rolled from
+// it, oldest first.
+func accountingFileNames(fileName string) ([]string, error) {
+	rolled, err := filepath.Glob(fileName + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	numbers := make(map[string]uint64, len(rolled))
+	fileNames := make([]string, 0, len(rolled)+1)
+	for _, name := range rolled {
+		match := rolledAccountingFilePattern.FindStringSubmatch(name[len(fileName):])
+		if match == nil {
+			continue
+		}
+		n, err := strconv.ParseUint(match[1], 10, 16)
+		if err != nil {
+			continue
+		}
+		numbers[name] = n
+		fileNames = append(fileNames, name)
+	}
+	// Higher numbers are older.
+	sort.Slice(fileNames, func(i, j int) bool { return numbers[fileNames[i]] > numbers[fileNames[j]] })
+
+	if _, err := os.Stat(fileName); err == nil {
+		fileNames = append(fileNames, fileName)
+	}
+	return fileNames, nil
+}
+
+// parseAccountingDate parses a --since or --until date, in local time.
+func parseAccountingDate(context *cli.Context, flag string) (time.Time, error) {
+	if !context.IsSet(flag) {
+		return time.Time{}, nil
+	}
+	t, err := time.ParseInLocation(accountingDateFormat, context.String(flag), time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Failed to parse --%s: %s", flag, err)
+	}
+	return t, nil
+}
+
+// summarizeAccountingRecords adds up the jobs and pages of records, by user
+// and by printer, sorted by name.
+func summarizeAccountingRecords(records []lib.AccountingRecord) ([]accountingSummary, []accountingSummary) {
+	byUser := make(map[string]*accountingSummary)
+	byPrinter := make(map[string]*accountingSummary)
+	add := func(summaries map[string]*accountingSummary, name string, record *lib.AccountingRecord) {
+		s, exists := summaries[name]
+		if !exists {
+			s = &accountingSummary{name: name}
+			summaries[name] = s
+		}
+		s.jobs++
+		if record.State == string(cdd.JobStateDone) {
+			s.done++
+		} else {
+			s.aborted++
+		}
+		s.pages += int64(record.PagesPrinted)
+	}
+
+	for i := range records {
+		add(byUser, records[i].User, &records[i])
+		add(byPrinter, records[i].PrinterName, &records[i])
+	}
+
+	sorted := func(summaries map[string]*accountingSummary) []accountingSummary {
+		s := make([]accountingSummary, 0, len(summaries))
+		for _, summary := range summaries {
+			s = append(s, *summary)
+		}
+		sort.Slice(s, func(i, j int) bool { return s[i].name < s[j].name })
+		return s
+	}
+	return sorted(byUser), sorted(byPrinter)
+}
+
+// writeAccountingSummaries writes summaries as an aligned table, or as CSV.
+func writeAccountingSummaries(w io.Writer, kind string, summaries []accountingSummary, asCSV bool) error {
+	if asCSV {
+		cw := csv.NewWriter(w)
+		cw.Write([]string{kind, "jobs", "done", "aborted", "pages"})
+		for _, s := range summaries {
+			cw.Write([]string{
+				s.name,
+				strconv.FormatUint(uint64(s.jobs), 10),
+				strconv.FormatUint(uint64(s.done), 10),
+				strconv.FormatUint(uint64(s.aborted), 10),
+				strconv.FormatInt(s.pages, 10),
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tjobs\tdone\taborted\tpages\n", kind)
+	for _, s := range summaries {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\n", s.name, s.jobs, s.done, s.aborted, s.pages)
+	}
+	return tw.Flush()
+}
+
+// summarizeAccountingFile prints the jobs and pages printed per user and per
+// printer, from the accounting files.
+func summarizeAccountingFile(context *cli.Context) error {
+	fileName := context.String("accounting-file")
+	if fileName == "" {
+		config, err := getConfig(context)
+		if err != nil {
+			return err
+		}
+		if config.AccountingLog == nil || config.AccountingLog.FileName == "" {
+			return errors.New("Accounting is not configured; set accounting_log in the config file, or use --accounting-file")
+		}
+		fileName = config.AccountingLog.FileName
+	}
+
+	since, err := parseAccountingDate(context, "since")
+	if err != nil {
+		return err
+	}
+	until, err := parseAccountingDate(context, "until")
+	if err != nil {
+		return err
+	}
+
+	fileNames, err := accountingFileNames(fileName)
+	if err != nil {
+		return err
+	}
+	if len(fileNames) == 0 {
+		return fmt.Errorf("Found no accounting files at %s", fileName)
+	}
+
+	var records []lib.AccountingRecord
+	for _, name := range fileNames {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		fileRecords, err := lib.ReadAccountingRecords(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("Failed to read accounting file %s: %s", name, err)
+		}
+		for _, record := range fileRecords {
+			if !since.IsZero() && record.FinishedAt.Before(since) {
+				continue
+			}
+			if !until.IsZero() && !record.FinishedAt.Before(until) {
+				continue
+			}
+			records = append(records, record)
+		}
+	}
+
+	byUser, byPrinter := summarizeAccountingRecords(records)
+	if err = writeAccountingSummaries(os.Stdout, "user", byUser, context.Bool("csv")); err != nil {
+		return err
+	}
+	fmt.Println()
+	return writeAccountingSummaries(os.Stdout, "printer", byPrinter, context.Bool("csv"))
+}
@@ -159,6 +159,29 @@ var commonCommands = []*cli.Command{
 			},
 		},
 	},
+	&cli.Command{
+		Name:   "summarize-accounting-file",
+		Usage:  "Shows the jobs and pages printed per user and per printer",
+		Action: summarizeAccountingFile,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "accounting-file",
+				Usage: "Accounting file to read, instead of the one in the config file",
+			},
+			&cli.StringFlag{
+				Name:  "since",
+				Usage: "Count jobs finished on or after this date (YYYY-MM-DD)",
+			},
+			&cli.StringFlag{
+				Name:  "until",
+				Usage: "Count jobs finished before this date (YYYY-MM-DD)",
+			},
+			&cli.BoolFlag{
+				Name:  "csv",
+				Usage: "Write the summary as CSV",
+			},
+		},
+	},
 }
 
 // getConfig returns a config object
@@ -171,11 +194,11 @@ func getConfig(context *cli.Context) (*lib.Config, error) {
 }
 
 // getGCP returns a GoogleCloudPrint object
-func getGCP(config *lib.Config) (*gcp.GoogleCloudPrint, error) {
+func getGCP(config *lib.Config) (gcp.CloudPrint, error) {
 	return gcp.NewGoogleCloudPrint(config.GCPBaseURL, config.RobotRefreshToken,
 		config.UserRefreshToken, config.ProxyName, config.GCPOAuthClientID,
 		config.GCPOAuthClientSecret, config.GCPOAuthAuthURL, config.GCPOAuthTokenURL,
-		0, nil, false)
+		0, 0, nil, false)
 }
 
 // backfillConfigFile opens the config file, adds all missing keys
@@ -107,6 +107,11 @@ var commonInitFlags = []cli.Flag{
 		Usage: "Maximum quantity of PDFs to download concurrently from GCP cloud service",
 		Value: int(lib.DefaultConfig.GCPMaxConcurrentDownloads),
 	},
+	&cli.IntFlag{
+		Name:  "gcp-download-max-retries",
+		Usage: "Maximum quantity of times to resume an interrupted PDF download from GCP cloud service",
+		Value: int(lib.DefaultConfig.GCPDownloadMaxRetries),
+	},
 	&cli.IntFlag{
 		Name:  "native-job-queue-size",
 		Usage: "Native job queue size",
@@ -88,13 +88,87 @@ var unixCommands = []*cli.Command{
 		Flags: []cli.Flag{
 			&cli.DurationFlag{
 				Name:  "monitor-timeout",
-				Usage: "wait for a monitor response no more than this long",
+				Usage: "wait for a monitor response no more than this long; sync-now, pause-printer and resume-printer wait at least 10m",
 				Value: 10 * time.Second,
 			},
+			monitorJSONFlag,
+		},
+		Subcommands: []*cli.Command{
+			&cli.Command{
+				Name:   "stats",
+				Usage:  "Shows printer, job and sync stats",
+				Action: monitorConnector,
+				Flags:  []cli.Flag{monitorJSONFlag},
+			},
+			&cli.Command{
+				Name:   "list-printers",
+				Usage:  "Lists printers, with their state and GCP ID",
+				Action: monitorListPrinters,
+				Flags:  []cli.Flag{monitorJSONFlag},
+			},
+			&cli.Command{
+				Name:   "list-jobs",
+				Usage:  "Lists jobs in flight, with their native job IDs",
+				Action: monitorListJobs,
+				Flags:  []cli.Flag{monitorJSONFlag},
+			},
+			&cli.Command{
+				Name:   "sync-now",
+				Usage:  "Synchronizes printers now",
+				Action: monitorSyncNow,
+				Flags:  []cli.Flag{monitorJSONFlag},
+			},
+			&cli.Command{
+				Name:   "pause-printer",
+				Usage:  "Stops a printer, and holds its new jobs until it is resumed",
+				Action: monitorPausePrinter,
+				Flags:  []cli.Flag{monitorPrinterFlag, monitorJSONFlag},
+			},
+			&cli.Command{
+				Name:   "resume-printer",
+				Usage:  "Resumes a paused printer",
+				Action: monitorResumePrinter,
+				Flags:  []cli.Flag{monitorPrinterFlag, monitorJSONFlag},
+			},
+			&cli.Command{
+				Name:   "cancel-job",
+				Usage:  "Cancels a job in flight",
+				Action: monitorCancelJob,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "job-id",
+						Usage: "Job ID, as shown by list-jobs",
+					},
+					monitorJSONFlag,
+				},
+			},
+			&cli.Command{
+				Name:   "set-log-level",
+				Usage:  "Changes the log level until the connector restarts",
+				Action: monitorSetLogLevel,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "log-level",
+						Usage: "Minimum event severity to log: FATAL, ERROR, WARNING, INFO, DEBUG",
+					},
+					monitorJSONFlag,
+				},
+			},
 		},
 	},
 }
 
+var (
+	monitorJSONFlag = &cli.BoolFlag{
+		Name:  "json",
+		Usage: "Write the response as JSON",
+	}
+	monitorPrinterFlag = &cli.StringFlag{
+		Name:  "printer",
+		Usage: "Native printer name, as shown by list-printers",
+	}
+)
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "gcp-connector-util"
@@ -130,6 +204,7 @@ func createCloudConfig(context *cli.Context, xmppJID, robotRefreshToken, userRef
 		GCPOAuthAuthURL:           context.String("gcp-oauth-auth-url"),
 		GCPOAuthTokenURL:          context.String("gcp-oauth-token-url"),
 		GCPMaxConcurrentDownloads: uint(context.Int("gcp-max-concurrent-downloads")),
+		GCPDownloadMaxRetries:     uint(context.Int("gcp-download-max-retries")),
 
 		NativeJobQueueSize:        uint(context.Int("native-job-queue-size")),
 		NativePrinterPollInterval: context.String("native-printer-poll-interval"),
@@ -207,6 +207,7 @@ func createCloudConfig(context *cli.Context, xmppJID, robotRefreshToken, userRef
 		GCPOAuthAuthURL:           lib.DefaultConfig.GCPOAuthAuthURL,
 		GCPOAuthTokenURL:          lib.DefaultConfig.GCPOAuthTokenURL,
 		GCPMaxConcurrentDownloads: uint(context.Int("gcp-max-concurrent-downloads")),
+		GCPDownloadMaxRetries:     uint(context.Int("gcp-download-max-retries")),
 
 		NativeJobQueueSize:        uint(context.Int("native-job-queue-size")),
 		NativePrinterPollInterval: context.String("native-printer-poll-interval"),
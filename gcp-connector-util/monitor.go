@@ -9,22 +9,33 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"net"
 	"os"
+	"text/tabwriter"
 	"time"
 
 	"github.com/google/cloud-print-connector/lib"
 	"github.com/urfave/cli"
 )
 
-func monitorConnector(context *cli.Context) error {
+// monitorSyncTimeout is the least time to wait for the response to the
+// commands that sync printers, which can take longer than the default
+// monitor-timeout.
+const monitorSyncTimeout = 10 * time.Minute
+
+// sendMonitorRequest sends a request to the monitor socket of the running
+// connector, and decodes the result of the response into result, which may
+// be nil.
+func sendMonitorRequest(context *cli.Context, request lib.MonitorRequest, result interface{}) error {
 	config, filename, err := lib.GetConfig(context)
 	if err != nil {
 		return fmt.Errorf("Failed to read config file: %s", err)
 	}
-	if filename == "" {
+	if filename == "" && !context.Bool("json") {
 		fmt.Println("No config file was found, so using defaults")
 	}
 
@@ -37,10 +48,18 @@ func monitorConnector(context *cli.Context) error {
 			config.MonitorSocketFilename)
 	}
 
-	timer := time.AfterFunc(context.Duration("monitor-timeout"), func() {
-		fmt.Fprintf(os.Stderr, "Monitor check timed out after %s", context.Duration("monitor-timeout").String())
+	timeout := context.Duration("monitor-timeout")
+	switch request.Command {
+	case lib.MonitorCommandSyncNow, lib.MonitorCommandPausePrinter, lib.MonitorCommandResumePrinter:
+		if timeout < monitorSyncTimeout {
+			timeout = monitorSyncTimeout
+		}
+	}
+	timer := time.AfterFunc(timeout, func() {
+		fmt.Fprintf(os.Stderr, "Monitor check timed out after %s", timeout.String())
 		os.Exit(1)
 	})
+	defer timer.Stop()
 
 	conn, err := net.DialTimeout("unix", config.MonitorSocketFilename, time.Second)
 	if err != nil {
@@ -50,13 +69,146 @@ func monitorConnector(context *cli.Context) error {
 	}
 	defer conn.Close()
 
-	buf, err := ioutil.ReadAll(conn)
+	request.Version = lib.MonitorProtocolVersion
+	b, err := json.Marshal(&request)
+	if err != nil {
+		return err
+	}
+	if _, err = conn.Write(append(b, '\n')); err != nil {
+		return err
+	}
+
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("Failed to read monitor response: %s", err)
+	}
+	var response lib.MonitorResponse
+	if err = json.Unmarshal(line, &response); err != nil {
+		return fmt.Errorf("Failed to parse monitor response; the connector may be too old: %s", err)
+	}
+	if response.Error != "" {
+		return errors.New(response.Error)
+	}
+	if result != nil && response.Result != nil {
+		return json.Unmarshal(response.Result, result)
+	}
+	return nil
+}
+
+// printJSON writes v as indented JSON.
+func printJSON(v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
 	if err != nil {
 		return err
 	}
+	fmt.Println(string(b))
+	return nil
+}
+
+func monitorConnector(context *cli.Context) error {
+	var stats lib.MonitorStats
+	if err := sendMonitorRequest(context, lib.MonitorRequest{Command: lib.MonitorCommandStats}, &stats); err != nil {
+		return err
+	}
+	if context.Bool("json") {
+		return printJSON(&stats)
+	}
+
+	fmt.Print(stats.String())
+	return nil
+}
+
+func monitorListPrinters(context *cli.Context) error {
+	var printers []lib.MonitorPrinter
+	if err := sendMonitorRequest(context, lib.MonitorRequest{Command: lib.MonitorCommandListPrinters}, &printers); err != nil {
+		return err
+	}
+	if context.Bool("json") {
+		return printJSON(printers)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "name\tgcp id\tstate\tpaused")
+	for _, p := range printers {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%t\n", p.Name, p.GCPID, p.State, p.Paused)
+	}
+	return tw.Flush()
+}
 
-	timer.Stop()
+func monitorListJobs(context *cli.Context) error {
+	var jobs []lib.MonitorJob
+	if err := sendMonitorRequest(context, lib.MonitorRequest{Command: lib.MonitorCommandListJobs}, &jobs); err != nil {
+		return err
+	}
+	if context.Bool("json") {
+		return printJSON(jobs)
+	}
 
-	fmt.Printf(string(buf))
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "job id\torigin\tprinter\tnative printer\tnative job id\tstate\tsubmitted")
+	for _, j := range jobs {
+		var nativeJobID, submittedAt string
+		if j.NativeJobID != 0 {
+			nativeJobID = fmt.Sprintf("%d", j.NativeJobID)
+		}
+		if j.SubmittedAt != nil {
+			submittedAt = j.SubmittedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			j.JobID, j.Origin, j.PrinterName, j.NativePrinterName, nativeJobID, j.State, submittedAt)
+	}
+	return tw.Flush()
+}
+
+// monitorAction sends a command that has no result, then reports success.
+func monitorAction(context *cli.Context, request lib.MonitorRequest, done string) error {
+	if err := sendMonitorRequest(context, request, nil); err != nil {
+		return err
+	}
+	if context.Bool("json") {
+		return printJSON(map[string]bool{"ok": true})
+	}
+	fmt.Println(done)
 	return nil
 }
+
+func monitorSyncNow(context *cli.Context) error {
+	return monitorAction(context, lib.MonitorRequest{Command: lib.MonitorCommandSyncNow},
+		"Printers synchronized")
+}
+
+func monitorPausePrinter(context *cli.Context) error {
+	if !context.IsSet("printer") {
+		return errors.New("--printer is required")
+	}
+	printer := context.String("printer")
+	return monitorAction(context, lib.MonitorRequest{Command: lib.MonitorCommandPausePrinter, Printer: printer},
+		fmt.Sprintf("Printer %s paused", printer))
+}
+
+func monitorResumePrinter(context *cli.Context) error {
+	if !context.IsSet("printer") {
+		return errors.New("--printer is required")
+	}
+	printer := context.String("printer")
+	return monitorAction(context, lib.MonitorRequest{Command: lib.MonitorCommandResumePrinter, Printer: printer},
+		fmt.Sprintf("Printer %s resumed", printer))
+}
+
+func monitorCancelJob(context *cli.Context) error {
+	if !context.IsSet("job-id") {
+		return errors.New("--job-id is required")
+	}
+	jobID := context.String("job-id")
+	return monitorAction(context, lib.MonitorRequest{Command: lib.MonitorCommandCancelJob, JobID: jobID},
+		fmt.Sprintf("Job %s canceled", jobID))
+}
+
+func monitorSetLogLevel(context *cli.Context) error {
+	if !context.IsSet("log-level") {
+		return errors.New("--log-level is required")
+	}
+	level := context.String("log-level")
+	return monitorAction(context, lib.MonitorRequest{Command: lib.MonitorCommandSetLogLevel, LogLevel: level},
+		fmt.Sprintf("Log level set to %s", level))
+}
@@ -18,20 +18,32 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/coreos/go-systemd/daemon"
 	"github.com/coreos/go-systemd/journal"
 	"github.com/google/cloud-print-connector/cups"
 	"github.com/google/cloud-print-connector/fcm"
 	"github.com/google/cloud-print-connector/gcp"
+	"github.com/google/cloud-print-connector/health"
+	"github.com/google/cloud-print-connector/ipp"
 	"github.com/google/cloud-print-connector/lib"
 	"github.com/google/cloud-print-connector/log"
 	"github.com/google/cloud-print-connector/manager"
+	"github.com/google/cloud-print-connector/metrics"
 	"github.com/google/cloud-print-connector/monitor"
 	"github.com/google/cloud-print-connector/notification"
 	"github.com/google/cloud-print-connector/privet"
+	"github.com/google/cloud-print-connector/status"
 	"github.com/google/cloud-print-connector/xmpp"
 	"github.com/urfave/cli"
 )
 
+// nativePrintSystem is the native print system that the connector prints
+// to: CUPS, or IPP printers.
+type nativePrintSystem interface {
+	manager.NativePrintSystem
+	SetPrinterFilters(displayNamePrefix string, printerBlacklist, printerWhitelist []string)
+}
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "gcp-cups-connector"
@@ -119,6 +131,9 @@ func connector(context *cli.Context) error {
 	notifications := make(chan notification.PrinterNotification, 5)
 
 	var g *gcp.GoogleCloudPrint
+	// cloud is the cloud service that printers are registered with; nil,
+	// rather than a nil *GoogleCloudPrint, when cloud printing is disabled.
+	var cloud gcp.CloudPrint
 	var x *xmpp.XMPP
 	var f *fcm.FCM
 	if config.CloudPrintingEnable {
@@ -138,11 +153,12 @@ func connector(context *cli.Context) error {
 		g, err = gcp.NewGoogleCloudPrint(config.GCPBaseURL, config.RobotRefreshToken,
 			config.UserRefreshToken, config.ProxyName, config.GCPOAuthClientID,
 			config.GCPOAuthClientSecret, config.GCPOAuthAuthURL, config.GCPOAuthTokenURL,
-			config.GCPMaxConcurrentDownloads, jobs, useFcm)
+			config.GCPMaxConcurrentDownloads, config.GCPDownloadMaxRetries, jobs, useFcm)
 		if err != nil {
 			log.Fatal(err)
 			return cli.NewExitError(err.Error(), 1)
 		}
+		cloud = g
 		if useFcm {
 			f, err = fcm.NewFCM(config.GCPOAuthClientID, config.ProxyName, config.FcmServerBindUrl, g.FcmSubscribe, notifications)
 			if err != nil {
@@ -161,21 +177,55 @@ func connector(context *cli.Context) error {
 		}
 	}
 
-	cupsConnectTimeout, err := time.ParseDuration(config.CUPSConnectTimeout)
-	if err != nil {
-		errStr := fmt.Sprintf("Failed to parse CUPS connect timeout: %s", err)
-		log.Fatalf(errStr)
+	var native nativePrintSystem
+	// checkNative checks the connection to the native print system, if it
+	// has one.
+	var checkNative health.Check
+	switch config.NativePrintSystem {
+	case "cups":
+		cupsConnectTimeout, err := time.ParseDuration(config.CUPSConnectTimeout)
+		if err != nil {
+			errStr := fmt.Sprintf("Failed to parse CUPS connect timeout: %s", err)
+			log.Fatalf(errStr)
+			return cli.NewExitError(errStr, 1)
+		}
+		c, err := cups.NewCUPS(*config.CUPSCopyPrinterInfoToDisplayName, *config.PrefixJobIDToJobTitle,
+			config.DisplayNamePrefix, config.CUPSPrinterAttributes, config.CUPSVendorPPDOptions, config.CUPSMaxConnections,
+			cupsConnectTimeout, config.PrinterBlacklist, config.PrinterWhitelist, *config.CUPSIgnoreRawPrinters,
+			*config.CUPSIgnoreClassPrinters, useFcm)
+		if err != nil {
+			log.Fatal(err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+		defer c.Quit()
+		native = c
+		checkNative = c.CheckConnection
+		metrics.NewGaugeFunc("cups_connections_open", "Open CUPS connections.",
+			func() float64 { return float64(c.ConnQtyOpen()) })
+		metrics.NewGaugeFunc("cups_connections_max", "Maximum open CUPS connections.",
+			func() float64 { return float64(c.ConnQtyMax()) })
+
+	case "ipp":
+		ippRequestTimeout, err := time.ParseDuration(config.IPPRequestTimeout)
+		if err != nil {
+			errStr := fmt.Sprintf("Failed to parse IPP request timeout: %s", err)
+			log.Fatal(errStr)
+			return cli.NewExitError(errStr, 1)
+		}
+		i, err := ipp.NewIPP(config.IPPPrinters, *config.PrefixJobIDToJobTitle, config.DisplayNamePrefix,
+			config.PrinterBlacklist, config.PrinterWhitelist, ippRequestTimeout, config.IPPCAFile,
+			config.IPPInsecureSkipVerify, useFcm)
+		if err != nil {
+			log.Fatal(err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+		native = i
+
+	default:
+		errStr := fmt.Sprintf("Native print system %s is not recognized; use cups or ipp", config.NativePrintSystem)
+		log.Fatal(errStr)
 		return cli.NewExitError(errStr, 1)
 	}
-	c, err := cups.NewCUPS(*config.CUPSCopyPrinterInfoToDisplayName, *config.PrefixJobIDToJobTitle,
-		config.DisplayNamePrefix, config.CUPSPrinterAttributes, config.CUPSVendorPPDOptions, config.CUPSMaxConnections,
-		cupsConnectTimeout, config.PrinterBlacklist, config.PrinterWhitelist, *config.CUPSIgnoreRawPrinters,
-		*config.CUPSIgnoreClassPrinters, useFcm)
-	if err != nil {
-		log.Fatal(err)
-		return cli.NewExitError(err.Error(), 1)
-	}
-	defer c.Quit()
 
 	var priv *privet.Privet
 	if config.LocalPrintingEnable {
@@ -197,9 +247,43 @@ func connector(context *cli.Context) error {
 		log.Fatal(errStr)
 		return cli.NewExitError(errStr, 1)
 	}
-	pm, err := manager.NewPrinterManager(c, g, priv, nativePrinterPollInterval,
-		config.NativeJobQueueSize, *config.CUPSJobFullUsername, config.ShareScope,
-		jobs, notifications, useFcm)
+	secureReleaseTimeout, err := time.ParseDuration(config.CUPSSecureReleaseTimeout)
+	if err != nil {
+		errStr := fmt.Sprintf("Failed to parse CUPS secure release timeout: %s", err)
+		log.Fatal(errStr)
+		return cli.NewExitError(errStr, 1)
+	}
+	shutdownDrainTimeout, err := time.ParseDuration(config.ShutdownDrainTimeout)
+	if err != nil {
+		errStr := fmt.Sprintf("Failed to parse shutdown drain timeout: %s", err)
+		log.Fatal(errStr)
+		return cli.NewExitError(errStr, 1)
+	}
+	pm, err := manager.NewPrinterManager(native, cloud, priv, jobs, notifications, manager.PrinterManagerConfig{
+		PrinterPollInterval:   nativePrinterPollInterval,
+		SyncMaxConcurrency:    config.PrinterSyncMaxConcurrency,
+		SyncRateLimit:         config.PrinterSyncRateLimit,
+		SyncRateBurst:         config.PrinterSyncRateBurst,
+		NativeJobQueueSize:    config.NativeJobQueueSize,
+		JobFullUsername:       *config.CUPSJobFullUsername,
+		ShareScope:            config.ShareScope,
+		JobJournalFilename:    config.JobJournalFilename,
+		QuotaLedgerFilename:   config.QuotaLedgerFilename,
+		AccountingLog:         config.AccountingLog,
+		RetryPolicy:           config.NativeJobRetryPolicy,
+		PrinterRetryPolicies:  config.PrinterJobRetryPolicies,
+		NativeJobTimeouts:     config.NativeJobTimeouts,
+		PrinterJobTimeouts:    config.PrinterJobTimeouts,
+		PrinterPools:          config.PrinterPools,
+		RoutingRules:          config.RoutingRules,
+		JobHooks:              config.JobHooks,
+		JobPriorities:         config.JobPriorities,
+		NativeJobPriority:     *config.NativeJobPriority,
+		MaintenanceWindows:    config.MaintenanceWindows,
+		SecureReleasePrinters: config.CUPSSecureReleasePrinters,
+		SecureReleaseTimeout:  secureReleaseTimeout,
+		UseFcm:                useFcm,
+	})
 	if err != nil {
 		log.Fatal(err)
 		return cli.NewExitError(err.Error(), 1)
@@ -210,13 +294,54 @@ func connector(context *cli.Context) error {
 	if useFcm && config.CloudPrintingEnable {
 		f.Init()
 	}
-	m, err := monitor.NewMonitor(c, g, priv, pm, config.MonitorSocketFilename)
+	m, err := monitor.NewMonitor(native, cloud, priv, pm, config.MonitorSocketFilename)
 	if err != nil {
 		log.Fatal(err)
 		return cli.NewExitError(err.Error(), 1)
 	}
 	defer m.Quit()
 
+	if config.PrometheusListenAddress != "" {
+		if err = metrics.Serve(config.PrometheusListenAddress); err != nil {
+			log.Fatal(err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+
+	h := health.NewHealth()
+	h.AddCheck("printer_sync_loop", true, pm.CheckSyncLoop)
+	h.AddCheck("printer_sync", false, pm.CheckSync)
+	if checkNative != nil {
+		h.AddCheck(config.NativePrintSystem, false, checkNative)
+	}
+	if g != nil {
+		h.AddCheck("oauth", false, g.CheckRobotToken)
+	}
+	if x != nil {
+		h.AddCheck("xmpp", false, x.CheckConnection)
+	}
+	if f != nil {
+		h.AddCheck("fcm", false, f.CheckConnection)
+	}
+	if config.HealthListenAddress != "" {
+		if err = h.Serve(config.HealthListenAddress); err != nil {
+			log.Fatal(err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+	go notifySystemdWatchdog(h)
+
+	if config.StatusPageListenAddress != "" {
+		var privetPort func(string) (uint16, bool)
+		if priv != nil {
+			privetPort = priv.Port
+		}
+		if err = status.NewPage(pm, privetPort).Serve(config.StatusPageListenAddress); err != nil {
+			log.Fatal(err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+
 	if config.CloudPrintingEnable {
 		if config.LocalPrintingEnable {
 			log.Infof("Ready to rock as proxy '%s' and in local mode", config.ProxyName)
@@ -230,19 +355,110 @@ func connector(context *cli.Context) error {
 		fmt.Println("Ready to rock in local-only mode")
 	}
 
-	waitIndefinitely()
+	// The config as it is applied now; GetConfig may return DefaultConfig,
+	// which must not change.
+	current := *config
+	waitIndefinitely(func() { reloadConfig(context, &current, native, pm) })
 
 	log.Info("Shutting down")
 	fmt.Println("")
 	fmt.Println("Shutting down")
 
+	pm.Drain(shutdownDrainTimeout)
+
 	return nil
 }
 
-// Blocks until Ctrl-C or SIGTERM.
-func waitIndefinitely() {
-	ch := make(chan os.Signal)
+// reloadableConfigKeys are the config keys that reloadConfig applies without
+// a restart.
+var reloadableConfigKeys = map[string]struct{}{
+	"printer_blacklist":          struct{}{},
+	"printer_whitelist":          struct{}{},
+	"display_name_prefix":        struct{}{},
+	"cups_printer_poll_interval": struct{}{},
+	"log_level":                  struct{}{},
+}
+
+// reloadConfig reads the config file again, and applies the changes that do
+// not need a restart. Changes that need a restart are logged and ignored.
+//
+// current is the config as it is applied now, and is updated with the
+// changes applied.
+func reloadConfig(context *cli.Context, current *lib.Config, native nativePrintSystem, pm *manager.PrinterManager) {
+	log.Info("Reloading config")
+
+	config, configFilename, err := lib.GetConfig(context)
+	if err != nil {
+		log.Errorf("Failed to reload config file: %s", err)
+		return
+	}
+	logLevel, ok := log.LevelFromString(config.LogLevel)
+	if !ok {
+		log.Errorf("Failed to reload config file %s: log level %s is not recognized", configFilename, config.LogLevel)
+		return
+	}
+	nativePrinterPollInterval, err := time.ParseDuration(config.NativePrinterPollInterval)
+	if err != nil {
+		log.Errorf("Failed to reload config file %s: failed to parse CUPS printer poll interval: %s", configFilename, err)
+		return
+	}
+
+	changed := current.ChangedKeys(config)
+	if len(changed) == 0 {
+		log.Info("Config has not changed")
+		return
+	}
+	for _, key := range changed {
+		if _, exists := reloadableConfigKeys[key]; exists {
+			log.Infof("Config %s changed", key)
+		} else {
+			log.Warningf("Config %s changed, but needs a restart to take effect", key)
+		}
+	}
+
+	log.SetLevel(logLevel)
+	native.SetPrinterFilters(config.DisplayNamePrefix, config.PrinterBlacklist, config.PrinterWhitelist)
+	if config.NativePrinterPollInterval != current.NativePrinterPollInterval {
+		pm.SetPrinterPollInterval(nativePrinterPollInterval)
+	}
+
+	current.LogLevel = config.LogLevel
+	current.DisplayNamePrefix = config.DisplayNamePrefix
+	current.PrinterBlacklist = config.PrinterBlacklist
+	current.PrinterWhitelist = config.PrinterWhitelist
+	current.NativePrinterPollInterval = config.NativePrinterPollInterval
+
+	if err = pm.SyncPrinters(false); err != nil {
+		log.Error(err)
+	}
+}
+
+// Blocks until Ctrl-C or SIGTERM. Calls reload on SIGHUP, in the background,
+// since a reload syncs printers, which can take minutes.
+func waitIndefinitely(reload func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	// Buffered, so that a termination request is not dropped while
+	// nobody is receiving.
+	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		// One reload at a time; SIGHUPs during a reload are coalesced.
+		for {
+			select {
+			case <-hup:
+				reload()
+			case <-done:
+				return
+			}
+		}
+	}()
+
 	<-ch
 
 	go func() {
@@ -252,3 +468,27 @@ func waitIndefinitely() {
 		os.Exit(1)
 	}()
 }
+
+// notifySystemdWatchdog notifies the systemd watchdog, when the service has
+// one, for as long as the live health checks pass.
+func notifySystemdWatchdog(h *health.Health) {
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		log.Warningf("Failed to read systemd watchdog settings: %s", err)
+		return
+	}
+	if interval == 0 {
+		return
+	}
+
+	log.Infof("Notifying the systemd watchdog every %s while healthy", interval/2)
+	for _ = range time.Tick(interval / 2) {
+		if status := h.Liveness(); !status.OK() {
+			log.Warning("Not notifying the systemd watchdog, because a liveness check failed")
+			continue
+		}
+		if _, err := daemon.SdNotify(false, daemon.SdNotifyWatchdog); err != nil {
+			log.Warningf("Failed to notify the systemd watchdog: %s", err)
+		}
+	}
+}
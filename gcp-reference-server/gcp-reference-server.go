@@ -0,0 +1,60 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+// gcp-reference-server serves a local, in-memory stand-in for the Google
+// Cloud Print API, that the connector can be run against.
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/google/cloud-print-connector/gcpserver"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/urfave/cli"
+)
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "gcp-reference-server"
+	app.Usage = "Reference Cloud Print server for the " + lib.ConnectorName
+	app.Version = lib.BuildDate
+	app.Flags = []cli.Flag{
+		&cli.StringFlag{
+			Name:  "address",
+			Usage: "Address to listen on",
+			Value: "localhost:8080",
+		},
+	}
+	app.Action = serve
+	app.Run(os.Args)
+}
+
+func serve(context *cli.Context) error {
+	listener, err := net.Listen("tcp", context.String("address"))
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Failed to listen on %s: %s", context.String("address"), err), 1)
+	}
+
+	baseURL := fmt.Sprintf("http://%s/", listener.Addr())
+	fmt.Printf("Serving the Cloud Print API at %s\n", baseURL)
+	fmt.Println("To run the connector against this server, set these in its config file:")
+	fmt.Printf("  \"gcp_base_url\": %q,\n", baseURL)
+	fmt.Printf("  \"gcp_oauth_token_url\": %q,\n", baseURL+"token")
+	fmt.Println(`  "robot_refresh_token": "any",`)
+	fmt.Println(`  "fcm_notifications_enable": true,`)
+	fmt.Printf("  \"fcm_server_bind_url\": %q,\n", baseURL+"fcm/bind")
+	fmt.Println("Submit jobs by POSTing printerid, title and content to " + baseURL + "submit")
+
+	if err = http.Serve(listener, gcpserver.NewServer().Handler()); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	return nil
+}
@@ -16,10 +16,13 @@ import (
 
 	"github.com/google/cloud-print-connector/fcm"
 	"github.com/google/cloud-print-connector/gcp"
+	"github.com/google/cloud-print-connector/health"
 	"github.com/google/cloud-print-connector/lib"
 	"github.com/google/cloud-print-connector/log"
 	"github.com/google/cloud-print-connector/manager"
+	"github.com/google/cloud-print-connector/metrics"
 	"github.com/google/cloud-print-connector/notification"
+	"github.com/google/cloud-print-connector/status"
 	"github.com/google/cloud-print-connector/winspool"
 	"github.com/google/cloud-print-connector/xmpp"
 	"github.com/urfave/cli"
@@ -123,6 +126,8 @@ func (service *service) Execute(args []string, r <-chan svc.ChangeRequest, s cha
 	notifications := make(chan notification.PrinterNotification, 5)
 
 	var g *gcp.GoogleCloudPrint
+	// cloud is g, but stays a nil interface when cloud printing is disabled.
+	var cloud gcp.CloudPrint
 	var x *xmpp.XMPP
 	var f *fcm.FCM
 	if config.CloudPrintingEnable {
@@ -140,11 +145,12 @@ func (service *service) Execute(args []string, r <-chan svc.ChangeRequest, s cha
 		g, err = gcp.NewGoogleCloudPrint(config.GCPBaseURL, config.RobotRefreshToken,
 			config.UserRefreshToken, config.ProxyName, config.GCPOAuthClientID,
 			config.GCPOAuthClientSecret, config.GCPOAuthAuthURL, config.GCPOAuthTokenURL,
-			config.GCPMaxConcurrentDownloads, jobs, config.FcmNotificationsEnable)
+			config.GCPMaxConcurrentDownloads, config.GCPDownloadMaxRetries, jobs, config.FcmNotificationsEnable)
 		if err != nil {
 			log.Fatal(err)
 			return false, 1
 		}
+		cloud = g
 		if config.FcmNotificationsEnable {
 			f, err = fcm.NewFCM(config.GCPOAuthClientID, config.ProxyName, config.FcmServerBindUrl, g.FcmSubscribe, notifications)
 			if err != nil {
@@ -174,15 +180,72 @@ func (service *service) Execute(args []string, r <-chan svc.ChangeRequest, s cha
 		log.Fatalf("Failed to parse printer poll interval: %s", err)
 		return false, 1
 	}
-	pm, err := manager.NewPrinterManager(ws, g, nil, nativePrinterPollInterval,
-		config.NativeJobQueueSize, *config.CUPSJobFullUsername, config.ShareScope, jobs, notifications,
-		config.FcmNotificationsEnable)
+	shutdownDrainTimeout, err := time.ParseDuration(config.ShutdownDrainTimeout)
+	if err != nil {
+		log.Fatalf("Failed to parse shutdown drain timeout: %s", err)
+		return false, 1
+	}
+	pm, err := manager.NewPrinterManager(ws, cloud, nil, jobs, notifications, manager.PrinterManagerConfig{
+		PrinterPollInterval:  nativePrinterPollInterval,
+		SyncMaxConcurrency:   config.PrinterSyncMaxConcurrency,
+		SyncRateLimit:        config.PrinterSyncRateLimit,
+		SyncRateBurst:        config.PrinterSyncRateBurst,
+		NativeJobQueueSize:   config.NativeJobQueueSize,
+		JobFullUsername:      *config.CUPSJobFullUsername,
+		ShareScope:           config.ShareScope,
+		JobJournalFilename:   config.JobJournalFilename,
+		QuotaLedgerFilename:  config.QuotaLedgerFilename,
+		AccountingLog:        config.AccountingLog,
+		RetryPolicy:          config.NativeJobRetryPolicy,
+		PrinterRetryPolicies: config.PrinterJobRetryPolicies,
+		NativeJobTimeouts:    config.NativeJobTimeouts,
+		PrinterJobTimeouts:   config.PrinterJobTimeouts,
+		PrinterPools:         config.PrinterPools,
+		RoutingRules:         config.RoutingRules,
+		JobHooks:             config.JobHooks,
+		JobPriorities:        config.JobPriorities,
+		MaintenanceWindows:   config.MaintenanceWindows,
+		UseFcm:               config.FcmNotificationsEnable,
+	})
 	if err != nil {
 		log.Fatal(err)
 		return false, 1
 	}
 	defer pm.Quit()
 
+	if config.PrometheusListenAddress != "" {
+		if err = metrics.Serve(config.PrometheusListenAddress); err != nil {
+			log.Fatal(err)
+			return false, 1
+		}
+	}
+
+	h := health.NewHealth()
+	h.AddCheck("printer_sync_loop", true, pm.CheckSyncLoop)
+	h.AddCheck("printer_sync", false, pm.CheckSync)
+	if g != nil {
+		h.AddCheck("oauth", false, g.CheckRobotToken)
+	}
+	if x != nil {
+		h.AddCheck("xmpp", false, x.CheckConnection)
+	}
+	if f != nil {
+		h.AddCheck("fcm", false, f.CheckConnection)
+	}
+	if config.HealthListenAddress != "" {
+		if err = h.Serve(config.HealthListenAddress); err != nil {
+			log.Fatal(err)
+			return false, 1
+		}
+	}
+
+	if config.StatusPageListenAddress != "" {
+		if err = status.NewPage(pm, nil).Serve(config.StatusPageListenAddress); err != nil {
+			log.Fatal(err)
+			return false, 1
+		}
+	}
+
 	// Init FCM client after printers are registered
 	if config.FcmNotificationsEnable && config.CloudPrintingEnable {
 		f.Init()
@@ -215,8 +278,12 @@ func (service *service) Execute(args []string, r <-chan svc.ChangeRequest, s cha
 			s <- runningStatus
 
 		case svc.Stop:
-			s <- stoppingStatus
+			// Ask the service control manager to wait while jobs drain.
+			status := stoppingStatus
+			status.WaitHint = uint32((shutdownDrainTimeout + 30*time.Second) / time.Millisecond)
+			s <- status
 			log.Info("Shutting down")
+			pm.Drain(shutdownDrainTimeout)
 			time.AfterFunc(time.Second*30, func() {
 				log.Fatal("Failed to stop quickly; stopping forcefully")
 				os.Exit(1)
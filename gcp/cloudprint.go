@@ -0,0 +1,61 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcp
+
+import (
+	"io"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+// CloudPrint is a cloud print-queue service that the connector registers
+// printers with, and receives jobs from. GoogleCloudPrint implements it for
+// Google Cloud Print, and for any server that implements the same HTTP API,
+// like the reference server in package gcpserver.
+type CloudPrint interface {
+	// List gets the printers of this connector, as a map of GCPID to name.
+	List() (map[string]string, error)
+	// ListPrinters gets the printers of this connector, with details, and
+	// a map of GCPID to queued job quantity.
+	ListPrinters() ([]lib.Printer, map[string]uint, error)
+	// ListQuotas gets the quotas of the printers of this connector, as a
+	// map of GCPID to quota.
+	ListQuotas() (map[string]Quota, error)
+	// Printer gets one printer, and its queued job quantity.
+	Printer(gcpID string) (*lib.Printer, uint, error)
+	// Register registers a printer, and sets its GCPID.
+	Register(printer *lib.Printer) error
+	Update(diff *lib.PrinterDiff) error
+	Delete(gcpID string) error
+
+	// CanShare checks whether printers can be shared.
+	CanShare() bool
+	Share(gcpID, shareScope string, role Role, skipNotification bool, public bool) error
+	Unshare(gcpID, shareScope string, public bool) error
+
+	// Fetch gets the queued jobs of a printer.
+	Fetch(gcpID string) ([]Job, error)
+	// Jobs gets the jobs of a printer, with their states.
+	Jobs(gcpID string) ([]Job, error)
+	// Ticket gets the print options of a job.
+	Ticket(gcpJobID string) (*cdd.CloudJobTicket, error)
+	// Download writes the data of a job, from its FileURL, to dst.
+	Download(dst io.Writer, url string) error
+	// Control sets the state of a job.
+	Control(jobID string, state *cdd.PrintJobStateDiff) error
+	DeleteJob(gcpJobID string) error
+	// HandleJobs fetches the queued jobs of a printer, assembles them, and
+	// sends them to the jobs channel that the service was created with.
+	HandleJobs(printer *lib.Printer, reportJobFailed func())
+
+	// ProximityToken gets a token for Privet users to access a printer
+	// through the cloud, as raw JSON, with the HTTP status.
+	ProximityToken(gcpID, user string) ([]byte, int, error)
+}
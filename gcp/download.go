@@ -0,0 +1,217 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcp
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+)
+
+// downloadStats describes a download.
+type downloadStats struct {
+	// bytes is the quantity of bytes written.
+	bytes int64
+	// retries is the quantity of times the download was resumed.
+	retries uint
+}
+
+// temporaryError is a download failure that is worth resuming from.
+type temporaryError struct {
+	error
+}
+
+// Download downloads a URL (a print job data file) directly to a Writer.
+func (gcp *GoogleCloudPrint) Download(dst io.Writer, url string) error {
+	_, err := gcp.download(dst, url, "")
+	return err
+}
+
+// download downloads a URL directly to a Writer. Interrupted downloads are
+// resumed with Range requests, up to gcp.downloadMaxRetries times. When the
+// download ends, the data is checked against the length and the checksums
+// sent by the server.
+//
+// jobID is for logging; it may be empty.
+func (gcp *GoogleCloudPrint) download(dst io.Writer, url, jobID string) (downloadStats, error) {
+	stats, err := gcp.resumeDownload(dst, url, jobID)
+	countCall("download", err)
+	return stats, err
+}
+
+func (gcp *GoogleCloudPrint) resumeDownload(dst io.Writer, url, jobID string) (downloadStats, error) {
+	var stats downloadStats
+	d := download{
+		length:    -1,
+		checksums: map[string]string{},
+		hashes: map[string]hash.Hash{
+			"md5":    md5.New(),
+			"crc32c": crc32.New(crc32.MakeTable(crc32.Castagnoli)),
+		},
+	}
+	writers := []io.Writer{dst}
+	for _, h := range d.hashes {
+		writers = append(writers, h)
+	}
+	d.dst = io.MultiWriter(writers...)
+
+	backoff := lib.Backoff{}
+	for {
+		n, err := d.get(gcp.robotClient, url, stats.bytes)
+		stats.bytes += n
+		if err == nil {
+			break
+		}
+		if _, ok := err.(temporaryError); !ok {
+			return stats, err
+		}
+		if stats.retries >= gcp.downloadMaxRetries {
+			return stats, fmt.Errorf("Gave up after %d retries: %s", stats.retries, err)
+		}
+		p, retryAgain := backoff.Pause()
+		if !retryAgain {
+			return stats, fmt.Errorf("Gave up after %d retries, retry timeout hit: %s", stats.retries, err)
+		}
+		stats.retries++
+		downloadRetries.Inc()
+		log.WarningJobf(jobID, "Download interrupted after %d bytes, resuming after %s: %s", stats.bytes, p, err)
+		time.Sleep(p)
+	}
+
+	if d.length >= 0 && stats.bytes != d.length {
+		return stats, fmt.Errorf("Downloaded %d bytes, expected %d", stats.bytes, d.length)
+	}
+	if err := d.verify(); err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+// download is the state of a download across its attempts.
+type download struct {
+	// dst receives the data, and feeds hashes.
+	dst io.Writer
+	// length is the size of the data, or -1 if unknown.
+	length int64
+	// checksums are the checksums sent by the server, base64-encoded. Key
+	// is algorithm, eg md5.
+	checksums map[string]string
+	// hashes hash the data. Key is algorithm.
+	hashes map[string]hash.Hash
+}
+
+// get downloads the data from offset on. Returns the quantity of bytes
+// written to d.dst. Errors are of type temporaryError when worth resuming.
+func (d *download) get(hc *http.Client, url string, offset int64) (int64, error) {
+	response, err := getFrom(hc, url, offset)
+	if err != nil {
+		if response == nil || (response.StatusCode >= 500 && response.StatusCode <= 599) {
+			err = temporaryError{err}
+		}
+		if response != nil {
+			response.Body.Close()
+		}
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	length := response.ContentLength
+	if response.StatusCode == http.StatusPartialContent {
+		start, total, err := parseContentRange(response.Header.Get("Content-Range"))
+		if err != nil {
+			return 0, err
+		}
+		if start != offset {
+			return 0, fmt.Errorf("Asked for data from byte %d, got data from byte %d", offset, start)
+		}
+		length = total
+	} else {
+		if md5Sum := response.Header.Get("Content-MD5"); md5Sum != "" {
+			d.checksums["md5"] = md5Sum
+		}
+		if offset > 0 {
+			// The server ignored the Range; skip the data we have.
+			if _, err = io.CopyN(ioutil.Discard, response.Body, offset); err != nil {
+				return 0, temporaryError{err}
+			}
+		}
+	}
+	if length >= 0 {
+		if d.length >= 0 && length != d.length {
+			return 0, fmt.Errorf("Data length changed from %d to %d bytes", d.length, length)
+		}
+		d.length = length
+	}
+	for _, value := range response.Header["X-Goog-Hash"] {
+		for _, checksum := range strings.Split(value, ",") {
+			if kv := strings.SplitN(strings.TrimSpace(checksum), "=", 2); len(kv) == 2 {
+				d.checksums[kv[0]] = kv[1]
+			}
+		}
+	}
+
+	n, err := io.Copy(d.dst, response.Body)
+	if err != nil {
+		return n, temporaryError{err}
+	}
+	if d.length >= 0 && offset+n < d.length {
+		return n, temporaryError{fmt.Errorf("Body ended after %d of %d bytes", offset+n, d.length)}
+	}
+	return n, nil
+}
+
+// verify checks the data against the checksums sent by the server, when
+// their algorithms are known.
+func (d *download) verify() error {
+	for algorithm, checksum := range d.checksums {
+		h, exists := d.hashes[algorithm]
+		if !exists {
+			continue
+		}
+		if sum := base64.StdEncoding.EncodeToString(h.Sum(nil)); sum != checksum {
+			return fmt.Errorf("Downloaded data failed %s check: got %s, expected %s", algorithm, sum, checksum)
+		}
+	}
+	return nil
+}
+
+// parseContentRange parses a Content-Range header, eg bytes 100-199/1000.
+// Returns the first byte position, and the total length, which is -1 when
+// unknown.
+func parseContentRange(contentRange string) (int64, int64, error) {
+	r := strings.TrimPrefix(contentRange, "bytes ")
+	slash := strings.Index(r, "/")
+	dash := strings.Index(r, "-")
+	if r == contentRange || slash < 0 || dash < 0 || dash > slash {
+		return 0, 0, fmt.Errorf("Failed to parse Content-Range %q", contentRange)
+	}
+	start, err := strconv.ParseInt(r[:dash], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Failed to parse Content-Range %q: %s", contentRange, err)
+	}
+	if r[slash+1:] == "*" {
+		return start, -1, nil
+	}
+	total, err := strconv.ParseInt(r[slash+1:], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Failed to parse Content-Range %q: %s", contentRange, err)
+	}
+	return start, total, nil
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/cloud-print-connector/gcptest"
+)
+
+// submitTestData submits a job with data, and returns the URL of the data.
+func submitTestData(t *testing.T, g *GoogleCloudPrint, s *gcptest.Server, data []byte) string {
+	printer := registerTestPrinter(t, g, "a")
+	if _, err := s.SubmitJob(printer.GCPID, "job", "", data); err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := g.Fetch(printer.GCPID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jobs[0].FileURL
+}
+
+func TestDownloadResume(t *testing.T) {
+	g, s := newTestGCP(t, nil)
+	defer s.Close()
+
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	url := submitTestData(t, g, s, data)
+
+	s.InjectFaults("download", gcptest.FaultReset, gcptest.FaultReset)
+	var b bytes.Buffer
+	stats, err := g.download(&b, url, "job")
+	if err != nil {
+		t.Fatalf("expected download to resume, got %s", err)
+	}
+	if !bytes.Equal(b.Bytes(), data) {
+		t.Logf("expected %d bytes of data, got %d bytes", len(data), b.Len())
+		t.Fail()
+	}
+	if stats.bytes != int64(len(data)) || stats.retries != 2 || s.Calls("download") != 3 {
+		t.Logf("expected %d bytes after 2 retries and 3 calls, got %+v after %d calls",
+			len(data), stats, s.Calls("download"))
+		t.Fail()
+	}
+}
+
+func TestDownloadFailure(t *testing.T) {
+	g, s := newTestGCP(t, nil)
+	defer s.Close()
+
+	url := submitTestData(t, g, s, []byte("data"))
+
+	cases := []struct {
+		name   string
+		faults []gcptest.Fault
+		calls  int
+	}{
+		{"corrupt", []gcptest.Fault{gcptest.FaultCorrupt}, 1},
+		{"retry budget", []gcptest.Fault{gcptest.FaultReset, gcptest.FaultReset, gcptest.FaultReset}, 3},
+	}
+	for _, c := range cases {
+		before := s.Calls("download")
+		s.InjectFaults("download", c.faults...)
+		var b bytes.Buffer
+		if err := g.Download(&b, url); err == nil {
+			t.Logf("%s: expected download to fail, got %q", c.name, b.String())
+			t.Fail()
+		}
+		if calls := s.Calls("download") - before; calls != c.calls {
+			t.Logf("%s: expected %d calls to download, got %d", c.name, c.calls, calls)
+			t.Fail()
+		}
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	cases := []struct {
+		contentRange string
+		start, total int64
+		ok           bool
+	}{
+		{"bytes 0-99/100", 0, 100, true},
+		{"bytes 50-99/*", 50, -1, true},
+		{"bytes */100", 0, 0, false},
+		{"50-99/100", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, c := range cases {
+		start, total, err := parseContentRange(c.contentRange)
+		if (err == nil) != c.ok || start != c.start || total != c.total {
+			t.Logf("parseContentRange(%q): expected %d %d %t, got %d %d %v",
+				c.contentRange, c.start, c.total, c.ok, start, total, err)
+			t.Fail()
+		}
+	}
+}
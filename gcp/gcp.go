@@ -14,7 +14,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -44,45 +43,49 @@ const (
 	AccessType      = "offline"
 
 	// Printer Notification channel constants.
-	FCP_CHANNEL     = "FCM_CHANNEL"
-	XMPP_CHANNEL    = "XMPP_CHANNEL"
+	FCP_CHANNEL  = "FCM_CHANNEL"
+	XMPP_CHANNEL = "XMPP_CHANNEL"
 )
 
 // GoogleCloudPrint is the interface between Go and the Google Cloud Print API.
 type GoogleCloudPrint struct {
 	baseURL     string
 	robotClient *http.Client
+	robotTokens *trackedTokenSource
 	userClient  *http.Client
 	proxyName   string
 	useFcm      bool
 
-	jobs              chan<- *lib.Job
-	downloadSemaphore *lib.Semaphore
+	jobs               chan<- *lib.Job
+	downloadSemaphore  *lib.Semaphore
+	downloadMaxRetries uint
 }
 
 // NewGoogleCloudPrint establishes a connection with GCP, returns a new GoogleCloudPrint object.
-func NewGoogleCloudPrint(baseURL, robotRefreshToken, userRefreshToken, proxyName, oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL string, maxConcurrentDownload uint, jobs chan<- *lib.Job, useFcm bool) (*GoogleCloudPrint, error) {
-	robotClient, err := newClient(oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL, robotRefreshToken, ScopeCloudPrint, ScopeGoogleTalk)
+func NewGoogleCloudPrint(baseURL, robotRefreshToken, userRefreshToken, proxyName, oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL string, maxConcurrentDownload, downloadMaxRetries uint, jobs chan<- *lib.Job, useFcm bool) (*GoogleCloudPrint, error) {
+	robotClient, robotTokens, err := newClient(oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL, robotRefreshToken, ScopeCloudPrint, ScopeGoogleTalk)
 	if err != nil {
 		return nil, err
 	}
 
 	var userClient *http.Client
 	if userRefreshToken != "" {
-		userClient, err = newClient(oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL, userRefreshToken, ScopeCloudPrint)
+		userClient, _, err = newClient(oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL, userRefreshToken, ScopeCloudPrint)
 		if err != nil {
 			return nil, err
 		}
 	}
 
 	gcp := &GoogleCloudPrint{
-		baseURL:           baseURL,
-		robotClient:       robotClient,
-		userClient:        userClient,
-		proxyName:         proxyName,
-		useFcm:            useFcm,
-		jobs:              jobs,
-		downloadSemaphore: lib.NewSemaphore(maxConcurrentDownload),
+		baseURL:            baseURL,
+		robotClient:        robotClient,
+		robotTokens:        robotTokens,
+		userClient:         userClient,
+		proxyName:          proxyName,
+		useFcm:             useFcm,
+		jobs:               jobs,
+		downloadSemaphore:  lib.NewSemaphore(maxConcurrentDownload),
+		downloadMaxRetries: downloadMaxRetries,
 	}
 
 	return gcp, nil
@@ -96,6 +99,19 @@ func (gcp *GoogleCloudPrint) GetRobotAccessToken() (string, error) {
 	return token.AccessToken, nil
 }
 
+// CheckRobotToken is a health check of the OAuth robot access token. It
+// fails when the latest refresh of the token failed.
+func (gcp *GoogleCloudPrint) CheckRobotToken() (string, error) {
+	refreshedAt, err := gcp.robotTokens.status()
+	if err != nil {
+		return "", fmt.Errorf("Failed to refresh the robot access token: %s", err)
+	}
+	if refreshedAt.IsZero() {
+		return "Robot access token has not been refreshed yet", nil
+	}
+	return fmt.Sprintf("Robot access token was last refreshed at %s", refreshedAt.Format(time.RFC3339)), nil
+}
+
 // CanShare answers the question "can we share printers when they are registered?"
 func (gcp *GoogleCloudPrint) CanShare() bool {
 	return gcp.userClient != nil
@@ -162,10 +178,12 @@ func (gcp *GoogleCloudPrint) Fetch(gcpID string) ([]Job, error) {
 
 	var jobsData struct {
 		Jobs []struct {
-			ID      string
-			Title   string
-			FileURL string
-			OwnerID string
+			ID            string
+			Title         string
+			FileURL       string
+			OwnerID       string
+			ContentType   string
+			NumberOfPages int
 		}
 	}
 	if err = json.Unmarshal(responseBody, &jobsData); err != nil {
@@ -176,11 +194,13 @@ func (gcp *GoogleCloudPrint) Fetch(gcpID string) ([]Job, error) {
 
 	for i, jobData := range jobsData.Jobs {
 		jobs[i] = Job{
-			GCPPrinterID: gcpID,
-			GCPJobID:     jobData.ID,
-			FileURL:      jobData.FileURL,
-			OwnerID:      jobData.OwnerID,
-			Title:        jobData.Title,
+			GCPPrinterID:  gcpID,
+			GCPJobID:      jobData.ID,
+			FileURL:       jobData.FileURL,
+			OwnerID:       jobData.OwnerID,
+			Title:         jobData.Title,
+			ContentType:   jobData.ContentType,
+			NumberOfPages: jobData.NumberOfPages,
 		}
 	}
 
@@ -232,6 +252,50 @@ func (gcp *GoogleCloudPrint) Jobs(gcpID string) ([]Job, error) {
 // Returns map of GCPID => printer name. GCPID is unique to GCP; printer name
 // should be unique to CUPS. Use Printer to get details about each printer.
 func (gcp *GoogleCloudPrint) List() (map[string]string, error) {
+	listPrinters, err := gcp.list()
+	if err != nil {
+		return nil, err
+	}
+
+	printers := make(map[string]string, len(listPrinters))
+	for _, p := range listPrinters {
+		printers[p.ID] = p.Name
+	}
+
+	return printers, nil
+}
+
+// Quota is the daily page quota of a printer, which is set in the cloud.
+type Quota struct {
+	Enabled    bool
+	DailyQuota int
+}
+
+// ListQuotas calls google.com/cloudprint/list to get the quotas of the
+// printers of this connector, with one call. Key is GCPID.
+func (gcp *GoogleCloudPrint) ListQuotas() (map[string]Quota, error) {
+	listPrinters, err := gcp.list()
+	if err != nil {
+		return nil, err
+	}
+
+	quotas := make(map[string]Quota, len(listPrinters))
+	for _, p := range listPrinters {
+		quotas[p.ID] = Quota{p.QuotaEnabled, p.DailyQuota}
+	}
+
+	return quotas, nil
+}
+
+// listPrinter is a printer as listed by google.com/cloudprint/list.
+type listPrinter struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	QuotaEnabled bool   `json:"quotaEnabled"`
+	DailyQuota   int    `json:"dailyQuota"`
+}
+
+func (gcp *GoogleCloudPrint) list() ([]listPrinter, error) {
 	form := url.Values{}
 	form.Set("proxy", gcp.proxyName)
 	form.Set("extra_fields", "-tags")
@@ -242,21 +306,13 @@ func (gcp *GoogleCloudPrint) List() (map[string]string, error) {
 	}
 
 	var listData struct {
-		Printers []struct {
-			ID   string `json:"id"`
-			Name string `json:"name"`
-		}
+		Printers []listPrinter
 	}
 	if err = json.Unmarshal(responseBody, &listData); err != nil {
 		return nil, err
 	}
 
-	printers := make(map[string]string, len(listData.Printers))
-	for _, p := range listData.Printers {
-		printers[p.ID] = p.Name
-	}
-
-	return printers, nil
+	return listData.Printers, nil
 }
 
 // Register calls google.com/cloudprint/register to register a GCP printer.
@@ -440,6 +496,8 @@ func (gcp *GoogleCloudPrint) Printer(gcpID string) (*lib.Printer, uint, error) {
 			QueuedJobsCount     uint                       `json:"queuedJobsCount"`
 			SemanticState       cdd.CloudDeviceState       `json:"semanticState"`
 			NotificationChannel string                     `json:"notificationChannel"`
+			QuotaEnabled        bool                       `json:"quotaEnabled"`
+			DailyQuota          int                        `json:"dailyQuota"`
 		}
 	}
 	if err = json.Unmarshal(responseBody, &printersData); err != nil {
@@ -475,6 +533,8 @@ func (gcp *GoogleCloudPrint) Printer(gcpID string) (*lib.Printer, uint, error) {
 		Description:         p.Capabilities.Printer,
 		CapsHash:            p.CapsHash,
 		Tags:                tags,
+		QuotaEnabled:        p.QuotaEnabled,
+		DailyQuota:          p.DailyQuota,
 		NotificationChannel: p.NotificationChannel,
 	}
 
@@ -547,25 +607,10 @@ func (gcp *GoogleCloudPrint) Unshare(gcpID, shareScope string, public bool) erro
 	return nil
 }
 
-// Download downloads a URL (a print job data file) directly to a Writer.
-func (gcp *GoogleCloudPrint) Download(dst io.Writer, url string) error {
-	response, err := getWithRetry(gcp.robotClient, url)
-	if err != nil {
-		return err
-	}
-	defer response.Body.Close()
-
-	_, err = io.Copy(dst, response.Body)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
 // FCM Subscribe.
 func (gcp *GoogleCloudPrint) FcmSubscribe(subscribeUrl string) (interface{}, error) {
 	response, err := getWithRetry(gcp.robotClient, fmt.Sprintf("%s%s", gcp.baseURL, subscribeUrl))
+	countCall("fcm/subscribe", err)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get Fcm Token: %s", err)
 	}
@@ -715,6 +760,9 @@ func (gcp *GoogleCloudPrint) processJob(job *Job, printer *lib.Printer, reportJo
 		Title:             job.Title,
 		User:              job.OwnerID,
 		JobID:             job.GCPJobID,
+		Origin:            lib.JobOriginCloud,
+		ContentType:       job.ContentType,
+		PageCount:         job.NumberOfPages,
 		Ticket:            ticket,
 		UpdateJob:         gcp.Control,
 	}
@@ -759,7 +807,7 @@ func (gcp *GoogleCloudPrint) assembleJob(job *Job) (*cdd.CloudJobTicket, string,
 		downloadUrl = "http://" + job.FileURL
 	}
 	// Do not check err until semaphore is released and timer is stopped.
-	err = gcp.Download(file, downloadUrl)
+	stats, err := gcp.download(file, downloadUrl, job.GCPJobID)
 	dt := time.Since(t)
 	gcp.downloadSemaphore.Release()
 	if err != nil {
@@ -775,7 +823,9 @@ func (gcp *GoogleCloudPrint) assembleJob(job *Job) (*cdd.CloudJobTicket, string,
 			}
 	}
 
-	log.InfoJobf(job.GCPJobID, "Downloaded in %s", dt.String())
+	jobDownloadSeconds.Observe(dt.Seconds())
+	log.InfoJobf(job.GCPJobID, "Downloaded %d bytes in %s (%.1f KiB/s) after %d retries",
+		stats.bytes, dt.String(), float64(stats.bytes)/1024/dt.Seconds(), stats.retries)
 	defer file.Close()
 
 	log.DebugJobf(job.GCPJobID, "Assembled with file %s: %+v", file.Name(), ticket.Print.Color)
@@ -0,0 +1,275 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcp
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/gcptest"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+// newTestGCP makes a client of a new fake server. The caller must close the
+// server.
+func newTestGCP(t *testing.T, jobs chan<- *lib.Job) (*GoogleCloudPrint, *gcptest.Server) {
+	s := gcptest.New()
+	g, err := NewGoogleCloudPrint(s.BaseURL(), "robot", "user", "proxy", "id", "secret",
+		s.AuthURL(), s.TokenURL(), 2, 2, jobs, true)
+	if err != nil {
+		s.Close()
+		t.Fatal(err)
+	}
+	return g, s
+}
+
+func registerTestPrinter(t *testing.T, g *GoogleCloudPrint, name string) lib.Printer {
+	printer := lib.Printer{
+		Name:               name,
+		DefaultDisplayName: name,
+		State:              &cdd.PrinterStateSection{State: cdd.CloudDeviceStateIdle},
+		Description:        &cdd.PrinterDescriptionSection{},
+		Tags:               map[string]string{"printer-location": "here"},
+	}
+	if err := g.Register(&printer); err != nil {
+		t.Fatal(err)
+	}
+	return printer
+}
+
+func TestListPrinters(t *testing.T) {
+	g, s := newTestGCP(t, nil)
+	defer s.Close()
+
+	a := registerTestPrinter(t, g, "a")
+	b := registerTestPrinter(t, g, "b")
+	if _, err := s.SubmitJob(b.GCPID, "job", "", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	printers, queuedJobsCount, err := g.ListPrinters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(printers) != 2 {
+		t.Fatalf("expected 2 printers, got %+v", printers)
+	}
+	names := map[string]string{}
+	for _, p := range printers {
+		names[p.GCPID] = p.Name
+		if p.Tags["printer-location"] != "here" || p.State == nil || p.Description == nil {
+			t.Logf("expected tags, state and description of printer %s, got %+v", p.Name, p)
+			t.Fail()
+		}
+	}
+	if names[a.GCPID] != "a" || names[b.GCPID] != "b" {
+		t.Logf("expected printers a and b, got %v", names)
+		t.Fail()
+	}
+	if len(queuedJobsCount) != 1 || queuedJobsCount[b.GCPID] != 1 {
+		t.Logf("expected 1 job queued on b, got %v", queuedJobsCount)
+		t.Fail()
+	}
+
+	s.InjectFaults("printer", gcptest.FaultMalformedJSON)
+	if _, _, err = g.ListPrinters(); err == nil {
+		t.Log("expected error from malformed printer")
+		t.Fail()
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	g, s := newTestGCP(t, nil)
+	defer s.Close()
+
+	printer := registerTestPrinter(t, g, "a")
+	printer.DefaultDisplayName = "A"
+	printer.Tags = map[string]string{"printer-location": "there", "printer-info": "info"}
+	printer.DailyQuota = 10
+	err := g.Update(&lib.PrinterDiff{
+		Operation:                 lib.UpdatePrinter,
+		Printer:                   printer,
+		DefaultDisplayNameChanged: true,
+		TagsChanged:               true,
+		DailyQuotaChanged:         true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form := s.LastForm("update")
+	if form.Get("printerid") != printer.GCPID || form.Get("proxy") != "proxy" ||
+		form.Get("remove_tag") != gcpTagPrefix+".*" || form.Get("daily_quota") != "10" {
+		t.Logf("unexpected update parameters %v", form)
+		t.Fail()
+	}
+	if _, exists := form["model"]; exists {
+		t.Log("expected unchanged model not to be updated")
+		t.Fail()
+	}
+	if _, exists := form["semantic_state"]; exists {
+		t.Log("expected unchanged state not to be updated")
+		t.Fail()
+	}
+
+	p, _, err := g.Printer(printer.GCPID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.DefaultDisplayName != "A" || len(p.Tags) != 2 || p.Tags["printer-location"] != "there" ||
+		p.DailyQuota != 10 {
+		t.Logf("unexpected printer after update %+v", p)
+		t.Fail()
+	}
+	quotas, err := g.ListQuotas()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quota := quotas[printer.GCPID]; quota.DailyQuota != 10 {
+		t.Logf("expected daily quota of 10 from list, got %+v", quotas)
+		t.Fail()
+	}
+
+	s.InjectFaults("update", gcptest.FaultTimeout)
+	if err = g.Update(&lib.PrinterDiff{Operation: lib.UpdatePrinter, Printer: printer}); err == nil {
+		t.Log("expected error from timed out update")
+		t.Fail()
+	}
+}
+
+func TestHandleJobs(t *testing.T) {
+	jobs := make(chan *lib.Job, 2)
+	g, s := newTestGCP(t, jobs)
+	defer s.Close()
+
+	printer := registerTestPrinter(t, g, "a")
+	jobID, err := s.SubmitJob(printer.GCPID, "good", `{"version":"1.0","print":{"copies":{"copies":3}}}`, []byte("good"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	badJobID, err := s.SubmitJob(printer.GCPID, "bad", "", []byte("bad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The jobs are assembled concurrently, so either may get the fault.
+	s.InjectFaults("ticket", gcptest.FaultMalformedJSON)
+
+	failed := make(chan struct{}, 2)
+	g.HandleJobs(&printer, func() { failed <- struct{}{} })
+
+	var job *lib.Job
+	select {
+	case job = <-jobs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for job")
+	}
+	defer os.Remove(job.Filename)
+	select {
+	case <-failed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for failed job")
+	}
+
+	data, err := ioutil.ReadFile(job.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.NativePrinterName != "a" || job.Origin != lib.JobOriginCloud || job.Title != string(data) ||
+		job.Ticket == nil || job.UpdateJob == nil {
+		t.Logf("unexpected job %+v", job)
+		t.Fail()
+	}
+	if job.JobID == jobID && (job.Ticket.Print.Copies == nil || job.Ticket.Print.Copies.Copies != 3) {
+		t.Logf("expected 3 copies, got %+v", job.Ticket.Print.Copies)
+		t.Fail()
+	}
+
+	failedJobID := badJobID
+	if job.JobID == badJobID {
+		failedJobID = jobID
+	}
+	waitForControl(t, s, failedJobID)
+}
+
+// waitForControl waits until the latest call to control is about jobID.
+func waitForControl(t *testing.T, s *gcptest.Server, jobID string) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if s.LastForm("control").Get("jobid") == jobID {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for control of job %s", jobID)
+}
+
+func TestAssembleJob(t *testing.T) {
+	g, s := newTestGCP(t, nil)
+	defer s.Close()
+
+	printer := registerTestPrinter(t, g, "a")
+	if _, err := s.SubmitJob(printer.GCPID, "job", "", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := g.Fetch(printer.GCPID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 {
+		t.Fatalf("expected 1 job, got %+v", jobs)
+	}
+	job := jobs[0]
+
+	ticket, filename, message, _ := g.assembleJob(&job)
+	if message != "" {
+		t.Fatal(message)
+	}
+	data, err := ioutil.ReadFile(filename)
+	os.Remove(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticket == nil || string(data) != "data" {
+		t.Logf("expected ticket and data, got %+v and %q", ticket, data)
+		t.Fail()
+	}
+
+	cases := []struct {
+		name     string
+		endpoint string
+		faults   []gcptest.Fault
+		cause    cdd.DeviceActionCauseCode
+	}{
+		{"malformed ticket", "ticket", []gcptest.Fault{gcptest.FaultMalformedJSON}, cdd.DeviceActionCauseInvalidTicket},
+		// One more timeout than the retry budget of newTestGCP.
+		{"download timeout", "download", []gcptest.Fault{gcptest.FaultTimeout, gcptest.FaultTimeout, gcptest.FaultTimeout}, cdd.DeviceActionCauseDownloadFailure},
+		{"corrupt download", "download", []gcptest.Fault{gcptest.FaultCorrupt}, cdd.DeviceActionCauseDownloadFailure},
+	}
+	for _, c := range cases {
+		s.InjectFaults(c.endpoint, c.faults...)
+		_, filename, message, state := g.assembleJob(&job)
+		if message == "" || filename != "" {
+			t.Logf("%s: expected failure without file, got %q %q", c.name, message, filename)
+			t.Fail()
+			continue
+		}
+		if state.State.Type != cdd.JobStateAborted || state.State.DeviceActionCause == nil ||
+			state.State.DeviceActionCause.ErrorCode != c.cause {
+			t.Logf("%s: expected job ABORTED by %s, got %+v", c.name, c.cause, state.State)
+			t.Fail()
+		}
+	}
+
+	job.FileURL = s.URL + "/download?jobid=missing"
+	if _, _, message, _ = g.assembleJob(&job); message == "" {
+		t.Log("expected failure to download missing data")
+		t.Fail()
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/cloud-print-connector/lib"
@@ -42,7 +43,9 @@ https://github.com/golang/go/issues/6336
 var lock *lib.Semaphore = lib.NewSemaphore(100)
 
 // newClient creates an instance of http.Client, wrapped with OAuth credentials.
-func newClient(oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL, refreshToken string, scopes ...string) (*http.Client, error) {
+// Also returns the source of the access tokens, to check how they are being
+// refreshed.
+func newClient(oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL, refreshToken string, scopes ...string) (*http.Client, *trackedTokenSource, error) {
 	config := oauth2.Config{
 		ClientID:     oauthClientID,
 		ClientSecret: oauthClientSecret,
@@ -55,9 +58,47 @@ func newClient(oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL, re
 	}
 
 	token := oauth2.Token{RefreshToken: refreshToken}
-	client := config.Client(oauth2.NoContext, &token)
+	source := &trackedTokenSource{source: config.TokenSource(oauth2.NoContext, &token)}
+	client := oauth2.NewClient(oauth2.NoContext, source)
 
-	return client, nil
+	return client, source, nil
+}
+
+// trackedTokenSource records when access tokens are refreshed, and whether
+// the latest refresh failed.
+type trackedTokenSource struct {
+	source oauth2.TokenSource
+
+	mutex       sync.Mutex
+	accessToken string
+	refreshedAt time.Time
+	err         error
+}
+
+func (s *trackedTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.source.Token()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.err = err
+	if err != nil {
+		return nil, err
+	}
+	if token.AccessToken != s.accessToken {
+		s.accessToken = token.AccessToken
+		s.refreshedAt = time.Now()
+	}
+	return token, nil
+}
+
+// status returns when the access token was last refreshed, which is zero
+// if it never was, and the error of the latest refresh.
+func (s *trackedTokenSource) status() (time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.refreshedAt, s.err
 }
 
 // getWithRetry calls get() and retries on HTTP temp failure
@@ -88,11 +129,23 @@ func getWithRetry(hc *http.Client, url string) (*http.Response, error) {
 //
 // The caller must close the returned Response.Body object if err == nil.
 func get(hc *http.Client, url string) (*http.Response, error) {
+	return getFrom(hc, url, 0)
+}
+
+// getFrom GETs a URL, skipping the first offset bytes with a Range request.
+// The response is either partial (206) or, when the server ignores the Range,
+// the whole body (200).
+//
+// The caller must close the returned Response.Body object if err == nil.
+func getFrom(hc *http.Client, url string, offset int64) (*http.Response, error) {
 	request, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 	request.Header.Set("X-CloudPrint-Proxy", lib.ShortName)
+	if offset > 0 {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
 
 	lock.Acquire()
 	response, err := hc.Do(request)
@@ -100,7 +153,8 @@ func get(hc *http.Client, url string) (*http.Response, error) {
 	if err != nil {
 		return response, fmt.Errorf("GET failure: %s", err)
 	}
-	if response.StatusCode != http.StatusOK {
+	if response.StatusCode != http.StatusOK &&
+		(offset == 0 || response.StatusCode != http.StatusPartialContent) {
 		return response, fmt.Errorf("GET HTTP-level failure: %s %s", url, response.Status)
 	}
 
@@ -110,6 +164,12 @@ func get(hc *http.Client, url string) (*http.Response, error) {
 // postWithRetry calls post() and retries on HTTP temp failure
 // (response code 500-599).
 func postWithRetry(hc *http.Client, url string, form url.Values) ([]byte, uint, int, error) {
+	responseBody, gcpErrorCode, httpStatusCode, err := retryPost(hc, url, form)
+	countCall(endpointOf(url), err)
+	return responseBody, gcpErrorCode, httpStatusCode, err
+}
+
+func retryPost(hc *http.Client, url string, form url.Values) ([]byte, uint, int, error) {
 	backoff := lib.Backoff{}
 	for {
 		responseBody, gcpErrorCode, httpStatusCode, err := post(hc, url, form)
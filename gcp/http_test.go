@@ -0,0 +1,102 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/cloud-print-connector/gcptest"
+)
+
+func TestPostWithRetry(t *testing.T) {
+	g, s := newTestGCP(t, nil)
+	defer s.Close()
+
+	s.InjectFaults("list", gcptest.FaultServerError, gcptest.FaultServerError)
+	if _, err := g.List(); err != nil {
+		t.Fatalf("expected list to succeed after retries, got %s", err)
+	}
+	if calls := s.Calls("list"); calls != 3 {
+		t.Logf("expected 3 calls to list, got %d", calls)
+		t.Fail()
+	}
+
+	cases := []struct {
+		name  string
+		fault gcptest.Fault
+	}{
+		{"malformed JSON", gcptest.FaultMalformedJSON},
+		{"timeout", gcptest.FaultTimeout},
+	}
+	for _, c := range cases {
+		before := s.Calls("list")
+		s.InjectFaults("list", c.fault)
+		if _, err := g.List(); err == nil {
+			t.Logf("%s: expected list to fail", c.name)
+			t.Fail()
+		}
+		if calls := s.Calls("list") - before; calls != 1 {
+			t.Logf("%s: expected 1 call to list without retry, got %d", c.name, calls)
+			t.Fail()
+		}
+	}
+}
+
+func TestGetWithRetry(t *testing.T) {
+	g, s := newTestGCP(t, nil)
+	defer s.Close()
+
+	printer := registerTestPrinter(t, g, "a")
+	if _, err := s.SubmitJob(printer.GCPID, "job", "", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := g.Fetch(printer.GCPID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.InjectFaults("download", gcptest.FaultServerError)
+	var b bytes.Buffer
+	if err = g.Download(&b, jobs[0].FileURL); err != nil {
+		t.Fatalf("expected download to succeed after retry, got %s", err)
+	}
+	if b.String() != "data" || s.Calls("download") != 2 {
+		t.Logf("expected data after 2 calls, got %q after %d", b.String(), s.Calls("download"))
+		t.Fail()
+	}
+
+	b.Reset()
+	if err = g.Download(&b, s.URL+"/download?jobid=missing"); err == nil {
+		t.Log("expected error downloading missing data")
+		t.Fail()
+	}
+	if s.Calls("download") != 3 {
+		t.Logf("expected not found not to be retried, got %d calls", s.Calls("download"))
+		t.Fail()
+	}
+}
+
+func TestRobotToken(t *testing.T) {
+	g, s := newTestGCP(t, nil)
+	defer s.Close()
+
+	if _, err := g.CheckRobotToken(); err != nil {
+		t.Fatal(err)
+	}
+	token, err := g.GetRobotAccessToken()
+	if err != nil || token == "" {
+		t.Fatalf("expected access token, got %q %v", token, err)
+	}
+
+	if _, err = g.CheckRobotToken(); err != nil {
+		t.Logf("expected robot token check to pass after refresh, got %s", err)
+		t.Fail()
+	}
+}
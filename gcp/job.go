@@ -16,5 +16,7 @@ type Job struct {
 	FileURL       string
 	OwnerID       string
 	Title         string
+	ContentType   string
+	NumberOfPages int
 	SemanticState *cdd.PrintJobState
 }
@@ -0,0 +1,40 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcp
+
+import (
+	"strings"
+
+	"github.com/google/cloud-print-connector/metrics"
+)
+
+var (
+	apiCalls = metrics.NewCounter("gcp_api_calls_total",
+		"Calls to the Google Cloud Print API, including retries of temporary failures as one call.", "endpoint")
+	apiErrors = metrics.NewCounter("gcp_api_errors_total",
+		"Calls to the Google Cloud Print API that failed.", "endpoint")
+	downloadRetries = metrics.NewCounter("gcp_download_retries_total",
+		"Times that interrupted downloads of print job data were resumed.")
+	jobDownloadSeconds = metrics.NewHistogram("job_download_seconds",
+		"Time to download the data of print jobs.", metrics.LatencyBuckets)
+)
+
+// countCall counts a call to an API endpoint, eg fetch, and whether it
+// failed.
+func countCall(endpoint string, err error) {
+	apiCalls.Inc(endpoint)
+	if err != nil {
+		apiErrors.Inc(endpoint)
+	}
+}
+
+// endpointOf returns the endpoint of an API URL, eg fetch.
+func endpointOf(url string) string {
+	return url[strings.LastIndex(url, "/")+1:]
+}
@@ -0,0 +1,725 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+// Package gcpserver is a small, in-memory reference implementation of the
+// HTTP API of Google Cloud Print, as used by the connector. It lets the
+// connector run against a local stand-in, by pointing gcp_base_url at the
+// server, gcp_oauth_token_url at its token endpoint, and
+// fcm_server_bind_url at its FCM bind endpoint, with FCM notifications
+// enabled. XMPP notifications are not implemented.
+//
+// The server trusts every client: any refresh token gets an access token,
+// and access tokens are not checked.
+package gcpserver
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+// Error codes returned with success=false, as GCP does.
+const (
+	errorCodeBadRequest     = 3
+	errorCodeNoPrinter      = 8
+	errorCodeNoJob          = 111
+	errorCodeNoJobsToFetch  = 413
+	errorCodeNoUserIdentity = 7
+)
+
+// fcmTTLSeconds is how long FCM tokens are good for.
+const fcmTTLSeconds = 24 * 60 * 60
+
+type printer struct {
+	ID                  string          `json:"id"`
+	Proxy               string          `json:"proxy"`
+	Name                string          `json:"name"`
+	DefaultDisplayName  string          `json:"defaultDisplayName"`
+	UUID                string          `json:"uuid"`
+	Manufacturer        string          `json:"manufacturer"`
+	Model               string          `json:"model"`
+	GCPVersion          string          `json:"gcpVersion"`
+	SetupURL            string          `json:"setupUrl"`
+	SupportURL          string          `json:"supportUrl"`
+	UpdateURL           string          `json:"updateUrl"`
+	Firmware            string          `json:"firmware"`
+	Capabilities        json.RawMessage `json:"capabilities,omitempty"`
+	CapsHash            string          `json:"capsHash"`
+	Tags                []string        `json:"tags"`
+	QueuedJobsCount     uint            `json:"queuedJobsCount"`
+	SemanticState       json.RawMessage `json:"semanticState,omitempty"`
+	NotificationChannel string          `json:"notificationChannel"`
+	QuotaEnabled        bool            `json:"quotaEnabled"`
+	DailyQuota          int             `json:"dailyQuota"`
+
+	// Shares maps a scope, eg someone@example.com, to a role.
+	Shares map[string]string `json:"-"`
+	Public bool              `json:"-"`
+}
+
+type job struct {
+	ID            string            `json:"id"`
+	PrinterID     string            `json:"printerid"`
+	Title         string            `json:"title"`
+	OwnerID       string            `json:"ownerId"`
+	ContentType   string            `json:"contentType"`
+	NumberOfPages int               `json:"numberOfPages"`
+	FileURL       string            `json:"fileUrl,omitempty"`
+	SemanticState cdd.PrintJobState `json:"semanticState"`
+	Ticket        json.RawMessage   `json:"-"`
+	Data          []byte            `json:"-"`
+}
+
+// Server serves the Cloud Print API from memory.
+type Server struct {
+	mutex     sync.Mutex
+	lastID    uint64
+	printers  map[string]*printer
+	jobs      map[string]*job
+	fcmTokens map[string]string
+	fcmBinds  map[chan string]string
+}
+
+// NewServer creates a server without printers or jobs.
+func NewServer() *Server {
+	return &Server{
+		printers:  make(map[string]*printer),
+		jobs:      make(map[string]*job),
+		fcmTokens: make(map[string]string),
+		fcmBinds:  make(map[chan string]string),
+	}
+}
+
+// Handler serves the API. The connector's gcp_base_url is the URL of the
+// handler, with a trailing slash.
+func (s *Server) Handler() http.Handler {
+	sm := http.NewServeMux()
+	sm.HandleFunc("/list", s.list)
+	sm.HandleFunc("/printer", s.printer)
+	sm.HandleFunc("/register", s.register)
+	sm.HandleFunc("/update", s.update)
+	sm.HandleFunc("/delete", s.delete)
+	sm.HandleFunc("/share", s.share)
+	sm.HandleFunc("/unshare", s.unshare)
+	sm.HandleFunc("/submit", s.submit)
+	sm.HandleFunc("/fetch", s.fetch)
+	sm.HandleFunc("/jobs", s.jobsList)
+	sm.HandleFunc("/ticket", s.ticket)
+	sm.HandleFunc("/control", s.control)
+	sm.HandleFunc("/deletejob", s.deleteJob)
+	sm.HandleFunc("/download", s.download)
+	sm.HandleFunc("/proximitytoken", s.proximityToken)
+	sm.HandleFunc("/token", s.token)
+	sm.HandleFunc("/fcm/subscribe", s.fcmSubscribe)
+	sm.HandleFunc("/fcm/bind", s.fcmBind)
+	return sm
+}
+
+// newID returns a new printer or job ID. The server must be locked.
+func (s *Server) newID() string {
+	s.lastID++
+	return strconv.FormatUint(s.lastID, 10)
+}
+
+// writeJSON writes v, which is a response of the API, plus success=true.
+func writeJSON(w http.ResponseWriter, v map[string]interface{}) {
+	v["success"] = true
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// writeFailure writes a response with success=false. GCP reports failures
+// of calls with HTTP status 200.
+func writeFailure(w http.ResponseWriter, errorCode int, format string, args ...interface{}) {
+	b, _ := json.Marshal(map[string]interface{}{
+		"success":   false,
+		"errorCode": errorCode,
+		"message":   fmt.Sprintf(format, args...),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// getPrinter gets the printer identified by the printerid parameter. The
+// server must be locked.
+func (s *Server) getPrinter(w http.ResponseWriter, r *http.Request) (*printer, bool) {
+	p, exists := s.printers[r.FormValue("printerid")]
+	if !exists {
+		writeFailure(w, errorCodeNoPrinter, "Printer %q not found", r.FormValue("printerid"))
+	}
+	return p, exists
+}
+
+// getJob gets the job identified by the jobid parameter. The server must
+// be locked.
+func (s *Server) getJob(w http.ResponseWriter, r *http.Request) (*job, bool) {
+	j, exists := s.jobs[r.FormValue("jobid")]
+	if !exists {
+		writeFailure(w, errorCodeNoJob, "Job %q not found", r.FormValue("jobid"))
+	}
+	return j, exists
+}
+
+// sortedPrinters returns the printers of proxy, or all printers when proxy
+// is empty, sorted by ID. The server must be locked.
+func (s *Server) sortedPrinters(proxy string) []*printer {
+	printers := make([]*printer, 0, len(s.printers))
+	for _, p := range s.printers {
+		if proxy == "" || p.Proxy == proxy {
+			printers = append(printers, p)
+		}
+	}
+	sort.Slice(printers, func(i, j int) bool { return lessID(printers[i].ID, printers[j].ID) })
+	return printers
+}
+
+// lessID orders IDs numerically.
+func lessID(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	type listPrinter struct {
+		ID                 string `json:"id"`
+		Name               string `json:"name"`
+		DefaultDisplayName string `json:"defaultDisplayName"`
+		Proxy              string `json:"proxy"`
+		QuotaEnabled       bool   `json:"quotaEnabled"`
+		DailyQuota         int    `json:"dailyQuota"`
+	}
+	printers := []listPrinter{}
+	for _, p := range s.sortedPrinters(r.FormValue("proxy")) {
+		printers = append(printers, listPrinter{p.ID, p.Name, p.DefaultDisplayName, p.Proxy, p.QuotaEnabled, p.DailyQuota})
+	}
+	writeJSON(w, map[string]interface{}{"printers": printers})
+}
+
+func (s *Server) printer(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p, ok := s.getPrinter(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, map[string]interface{}{"printers": []*printer{p}})
+}
+
+func (s *Server) register(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if r.FormValue("name") == "" || r.FormValue("proxy") == "" {
+		writeFailure(w, errorCodeBadRequest, "name and proxy are required")
+		return
+	}
+
+	p := &printer{
+		ID:                  s.newID(),
+		Proxy:               r.FormValue("proxy"),
+		Name:                r.FormValue("name"),
+		DefaultDisplayName:  r.FormValue("default_display_name"),
+		UUID:                r.FormValue("uuid"),
+		Manufacturer:        r.FormValue("manufacturer"),
+		Model:               r.FormValue("model"),
+		GCPVersion:          r.FormValue("gcp_version"),
+		SetupURL:            r.FormValue("setup_url"),
+		SupportURL:          r.FormValue("support_url"),
+		UpdateURL:           r.FormValue("update_url"),
+		Firmware:            r.FormValue("firmware"),
+		CapsHash:            r.FormValue("capsHash"),
+		Tags:                r.Form["tag"],
+		NotificationChannel: r.FormValue("notification_channel"),
+		Shares:              make(map[string]string),
+	}
+	if err := setRaw(&p.Capabilities, r.FormValue("capabilities")); err != nil {
+		writeFailure(w, errorCodeBadRequest, "Bad capabilities: %s", err)
+		return
+	}
+	if err := setRaw(&p.SemanticState, r.FormValue("semantic_state")); err != nil {
+		writeFailure(w, errorCodeBadRequest, "Bad semantic_state: %s", err)
+		return
+	}
+	s.printers[p.ID] = p
+
+	writeJSON(w, map[string]interface{}{"printers": []*printer{p}})
+}
+
+// setRaw sets dst to the JSON value v, if v is not empty.
+func setRaw(dst *json.RawMessage, v string) error {
+	if v == "" {
+		return nil
+	}
+	if !json.Valid([]byte(v)) {
+		return fmt.Errorf("invalid JSON %q", v)
+	}
+	*dst = json.RawMessage(v)
+	return nil
+}
+
+func (s *Server) update(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p, ok := s.getPrinter(w, r)
+	if !ok {
+		return
+	}
+
+	fields := map[string]*string{
+		"default_display_name": &p.DefaultDisplayName,
+		"manufacturer":         &p.Manufacturer,
+		"model":                &p.Model,
+		"gcp_version":          &p.GCPVersion,
+		"setup_url":            &p.SetupURL,
+		"support_url":          &p.SupportURL,
+		"update_url":           &p.UpdateURL,
+		"firmware":             &p.Firmware,
+		"capsHash":             &p.CapsHash,
+		"notification_channel": &p.NotificationChannel,
+	}
+	for name, field := range fields {
+		if _, exists := r.Form[name]; exists {
+			*field = r.FormValue(name)
+		}
+	}
+	if err := setRaw(&p.Capabilities, r.FormValue("capabilities")); err != nil {
+		writeFailure(w, errorCodeBadRequest, "Bad capabilities: %s", err)
+		return
+	}
+	if err := setRaw(&p.SemanticState, r.FormValue("semantic_state")); err != nil {
+		writeFailure(w, errorCodeBadRequest, "Bad semantic_state: %s", err)
+		return
+	}
+	if v := r.FormValue("quota_enabled"); v != "" {
+		p.QuotaEnabled = v == "true"
+	}
+	if v := r.FormValue("daily_quota"); v != "" {
+		dailyQuota, err := strconv.Atoi(v)
+		if err != nil {
+			writeFailure(w, errorCodeBadRequest, "Bad daily_quota: %s", err)
+			return
+		}
+		p.DailyQuota = dailyQuota
+	}
+
+	if removeTag := r.FormValue("remove_tag"); removeTag != "" {
+		re, err := regexp.Compile("^(?:" + removeTag + ")$")
+		if err != nil {
+			writeFailure(w, errorCodeBadRequest, "Bad remove_tag: %s", err)
+			return
+		}
+		tags := make([]string, 0, len(p.Tags))
+		for _, tag := range p.Tags {
+			if !re.MatchString(tag) {
+				tags = append(tags, tag)
+			}
+		}
+		p.Tags = tags
+	}
+	p.Tags = append(p.Tags, r.Form["tag"]...)
+
+	writeJSON(w, map[string]interface{}{})
+}
+
+func (s *Server) delete(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p, ok := s.getPrinter(w, r)
+	if !ok {
+		return
+	}
+	delete(s.printers, p.ID)
+	for id, j := range s.jobs {
+		if j.PrinterID == p.ID {
+			delete(s.jobs, id)
+		}
+	}
+	writeJSON(w, map[string]interface{}{})
+}
+
+func (s *Server) share(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p, ok := s.getPrinter(w, r)
+	if !ok {
+		return
+	}
+	if r.FormValue("public") == "true" {
+		p.Public = true
+	} else if scope := r.FormValue("scope"); scope != "" {
+		p.Shares[scope] = r.FormValue("role")
+	} else {
+		writeFailure(w, errorCodeBadRequest, "scope or public is required")
+		return
+	}
+	writeJSON(w, map[string]interface{}{})
+}
+
+func (s *Server) unshare(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p, ok := s.getPrinter(w, r)
+	if !ok {
+		return
+	}
+	if r.FormValue("public") == "true" {
+		p.Public = false
+	} else {
+		delete(p.Shares, r.FormValue("scope"))
+	}
+	writeJSON(w, map[string]interface{}{})
+}
+
+// submit adds a job, with the data in the content parameter, which may be
+// a file of a multipart form.
+func (s *Server) submit(w http.ResponseWriter, r *http.Request) {
+	var data []byte
+	if file, _, err := r.FormFile("content"); err == nil {
+		data, err = ioutil.ReadAll(file)
+		file.Close()
+		if err != nil {
+			writeFailure(w, errorCodeBadRequest, "Failed to read content: %s", err)
+			return
+		}
+	} else {
+		data = []byte(r.FormValue("content"))
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p, ok := s.getPrinter(w, r)
+	if !ok {
+		return
+	}
+
+	ticket := r.FormValue("ticket")
+	if ticket == "" {
+		ticket = `{"version":"1.0","print":{}}`
+	}
+	numberOfPages, _ := strconv.Atoi(r.FormValue("number_of_pages"))
+	j := &job{
+		ID:            s.newID(),
+		PrinterID:     p.ID,
+		Title:         r.FormValue("title"),
+		OwnerID:       r.FormValue("owner"),
+		ContentType:   r.FormValue("contentType"),
+		NumberOfPages: numberOfPages,
+		SemanticState: cdd.PrintJobState{
+			Version: "1.0",
+			State:   cdd.JobState{Type: cdd.JobStateQueued},
+		},
+		Data: data,
+	}
+	if err := setRaw(&j.Ticket, ticket); err != nil {
+		writeFailure(w, errorCodeBadRequest, "Bad ticket: %s", err)
+		return
+	}
+	if j.OwnerID == "" {
+		j.OwnerID = "nobody@example.com"
+	}
+	if j.ContentType == "" {
+		j.ContentType = "application/pdf"
+	}
+	s.jobs[j.ID] = j
+	p.QueuedJobsCount++
+
+	s.notify(p)
+	writeJSON(w, map[string]interface{}{"job": j})
+}
+
+// printerJobs returns the jobs of a printer, sorted by ID, with file URLs
+// relative to the host of r. The server must be locked.
+func (s *Server) printerJobs(p *printer, r *http.Request) []job {
+	jobs := []job{}
+	for _, j := range s.jobs {
+		if j.PrinterID == p.ID {
+			jc := *j
+			jc.FileURL = fmt.Sprintf("http://%s%sdownload?jobid=%s", r.Host, basePath(r), j.ID)
+			jobs = append(jobs, jc)
+		}
+	}
+	sort.Slice(jobs, func(i, k int) bool { return lessID(jobs[i].ID, jobs[k].ID) })
+	return jobs
+}
+
+// basePath gets the path that the handler is served at, with a trailing
+// slash, from the path of r.
+func basePath(r *http.Request) string {
+	return r.URL.Path[:strings.LastIndex(r.URL.Path, "/")+1]
+}
+
+func (s *Server) fetch(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p, ok := s.getPrinter(w, r)
+	if !ok {
+		return
+	}
+	jobs := []job{}
+	for _, j := range s.printerJobs(p, r) {
+		if j.SemanticState.State.Type == cdd.JobStateQueued {
+			jobs = append(jobs, j)
+		}
+	}
+	if len(jobs) == 0 {
+		writeFailure(w, errorCodeNoJobsToFetch, "No print job available on specified printer.")
+		return
+	}
+	writeJSON(w, map[string]interface{}{"jobs": jobs})
+}
+
+func (s *Server) jobsList(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p, ok := s.getPrinter(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, map[string]interface{}{"jobs": s.printerJobs(p, r)})
+}
+
+// ticket writes the ticket alone, like GCP does on success.
+func (s *Server) ticket(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	j, ok := s.getJob(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(j.Ticket)
+}
+
+func (s *Server) control(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	j, ok := s.getJob(w, r)
+	if !ok {
+		return
+	}
+	var diff cdd.PrintJobStateDiff
+	if err := json.Unmarshal([]byte(r.FormValue("semantic_state_diff")), &diff); err != nil {
+		writeFailure(w, errorCodeBadRequest, "Bad semantic_state_diff: %s", err)
+		return
+	}
+
+	wasQueued := j.SemanticState.State.Type == cdd.JobStateQueued
+	if diff.State != nil {
+		j.SemanticState.State = *diff.State
+	}
+	if diff.PagesPrinted != nil {
+		j.SemanticState.PagesPrinted = diff.PagesPrinted
+	}
+	if p, exists := s.printers[j.PrinterID]; exists && wasQueued &&
+		j.SemanticState.State.Type != cdd.JobStateQueued && p.QueuedJobsCount > 0 {
+		p.QueuedJobsCount--
+	}
+
+	writeJSON(w, map[string]interface{}{})
+}
+
+func (s *Server) deleteJob(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	j, ok := s.getJob(w, r)
+	if !ok {
+		return
+	}
+	if p, exists := s.printers[j.PrinterID]; exists &&
+		j.SemanticState.State.Type == cdd.JobStateQueued && p.QueuedJobsCount > 0 {
+		p.QueuedJobsCount--
+	}
+	delete(s.jobs, j.ID)
+	writeJSON(w, map[string]interface{}{})
+}
+
+func (s *Server) download(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	j, exists := s.jobs[r.FormValue("jobid")]
+	var data []byte
+	var contentType string
+	if exists {
+		data, contentType = j.Data, j.ContentType
+	}
+	s.mutex.Unlock()
+
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Like Google Cloud Storage, send the MD5 of the whole data, which
+	// lets clients verify downloads that were resumed with Range requests.
+	sum := md5.Sum(data)
+	w.Header().Set("X-Goog-Hash", "md5="+base64.StdEncoding.EncodeToString(sum[:]))
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+func (s *Server) proximityToken(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p, ok := s.getPrinter(w, r)
+	if !ok {
+		return
+	}
+	user := r.FormValue("user")
+	if user == "" {
+		writeFailure(w, errorCodeNoUserIdentity, "user is required")
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"proximity_token": map[string]interface{}{
+			"user":       user,
+			"token":      fmt.Sprintf("proximity-%s-%s", p.ID, s.newID()),
+			"expires_in": 600,
+		},
+	})
+}
+
+// token is an OAuth 2.0 token endpoint that grants an access token for any
+// refresh token.
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	s.mutex.Lock()
+	accessToken := "access-" + s.newID()
+	s.mutex.Unlock()
+
+	b, _ := json.Marshal(map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// fcmSubscribe gives a proxy a token to bind to FCM with.
+func (s *Server) fcmSubscribe(w http.ResponseWriter, r *http.Request) {
+	proxy := r.FormValue("proxy")
+	if proxy == "" {
+		writeFailure(w, errorCodeBadRequest, "proxy is required")
+		return
+	}
+
+	s.mutex.Lock()
+	token := "fcm-" + s.newID()
+	s.fcmTokens[token] = proxy
+	s.mutex.Unlock()
+
+	writeJSON(w, map[string]interface{}{
+		"token":  token,
+		"fcmttl": strconv.Itoa(fcmTTLSeconds),
+	})
+}
+
+// fcmBind streams notifications of new jobs for the printers of the proxy
+// that the token was given to, in the framing that package fcm reads: the
+// length of a message on its own line, then the message.
+func (s *Server) fcmBind(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	s.mutex.Lock()
+	proxy, exists := s.fcmTokens[r.FormValue("token")]
+	if !exists {
+		s.mutex.Unlock()
+		http.Error(w, "Unknown token", http.StatusUnauthorized)
+		return
+	}
+	ch := make(chan string, 10)
+	s.fcmBinds[ch] = proxy
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.fcmBinds, ch)
+		s.mutex.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var messageID int
+	for {
+		select {
+		case printerID := <-ch:
+			messageID++
+			message, _ := json.Marshal([]interface{}{
+				[]interface{}{messageID, []interface{}{
+					map[string]interface{}{
+						"data": map[string]string{"notification": printerID},
+					},
+				}},
+			})
+			fmt.Fprintf(w, "%d\n%s", len(message), message)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// notify tells the FCM binds of the proxy of p that p has new jobs. The
+// server must be locked.
+func (s *Server) notify(p *printer) {
+	for ch, proxy := range s.fcmBinds {
+		if proxy != p.Proxy {
+			continue
+		}
+		select {
+		case ch <- p.ID:
+		default:
+			// The bind is not keeping up; it will see the jobs at its next fetch.
+		}
+	}
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcpserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/fcm"
+	"github.com/google/cloud-print-connector/gcp"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+func newTestClient(t *testing.T, ts *httptest.Server, jobs chan<- *lib.Job) *gcp.GoogleCloudPrint {
+	g, err := gcp.NewGoogleCloudPrint(ts.URL+"/", "robot", "user", "proxy", "id", "secret",
+		ts.URL+"/auth", ts.URL+"/token", 1, 0, jobs, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func submit(t *testing.T, ts *httptest.Server, gcpID, content string) {
+	response, err := http.PostForm(ts.URL+"/submit", url.Values{
+		"printerid": {gcpID},
+		"title":     {"title"},
+		"content":   {content},
+		"ticket":    {`{"version":"1.0","print":{"copies":{"copies":2}}}`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	var result struct{ Success bool }
+	if err = json.NewDecoder(response.Body).Decode(&result); err != nil || !result.Success {
+		t.Fatalf("failed to submit job: %v", err)
+	}
+}
+
+func TestPrinters(t *testing.T) {
+	ts := httptest.NewServer(NewServer().Handler())
+	defer ts.Close()
+	g := newTestClient(t, ts, nil)
+
+	printer := lib.Printer{
+		Name:               "a",
+		DefaultDisplayName: "A",
+		State:              &cdd.PrinterStateSection{State: cdd.CloudDeviceStateIdle},
+		Description:        &cdd.PrinterDescriptionSection{},
+		Tags:               map[string]string{"printer-location": "here"},
+	}
+	if err := g.Register(&printer); err != nil {
+		t.Fatal(err)
+	}
+	if printer.GCPID == "" {
+		t.Fatal("expected GCPID to be set by Register")
+	}
+
+	printer.DefaultDisplayName = "B"
+	printer.Tags = map[string]string{"printer-location": "there"}
+	err := g.Update(&lib.PrinterDiff{
+		Operation:                 lib.UpdatePrinter,
+		Printer:                   printer,
+		DefaultDisplayNameChanged: true,
+		TagsChanged:               true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	printers, queuedJobsCount, err := g.ListPrinters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(printers) != 1 || len(queuedJobsCount) != 0 {
+		t.Fatalf("expected 1 printer without queued jobs, got %+v and %v", printers, queuedJobsCount)
+	}
+	p := printers[0]
+	if p.Name != "a" || p.DefaultDisplayName != "B" || p.Tags["printer-location"] != "there" ||
+		len(p.Tags) != 1 || p.State == nil || p.State.State != cdd.CloudDeviceStateIdle {
+		t.Logf("unexpected printer %+v", p)
+		t.Fail()
+	}
+
+	if err = g.Share(printer.GCPID, "someone@example.com", gcp.User, true, false); err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+	if err = g.Unshare(printer.GCPID, "someone@example.com", false); err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+
+	body, status, err := g.ProximityToken(printer.GCPID, "someone@example.com")
+	var token struct {
+		ProximityToken map[string]interface{} `json:"proximity_token"`
+	}
+	if err != nil || status != http.StatusOK || json.Unmarshal(body, &token) != nil || token.ProximityToken["token"] == nil {
+		t.Logf("expected proximity token, got %d %s %v", status, body, err)
+		t.Fail()
+	}
+
+	if err = g.Delete(printer.GCPID); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := g.List(); err != nil || len(ids) != 0 {
+		t.Logf("expected no printers after delete, got %v %v", ids, err)
+		t.Fail()
+	}
+	if _, _, err = g.Printer(printer.GCPID); err == nil {
+		t.Log("expected error getting deleted printer")
+		t.Fail()
+	}
+}
+
+func TestJobs(t *testing.T) {
+	ts := httptest.NewServer(NewServer().Handler())
+	defer ts.Close()
+	jobs := make(chan *lib.Job, 1)
+	g := newTestClient(t, ts, jobs)
+
+	printer := lib.Printer{Name: "a", Description: &cdd.PrinterDescriptionSection{}}
+	if err := g.Register(&printer); err != nil {
+		t.Fatal(err)
+	}
+	if fetched, err := g.Fetch(printer.GCPID); err != nil || len(fetched) != 0 {
+		t.Fatalf("expected no jobs to fetch, got %+v %v", fetched, err)
+	}
+
+	submit(t, ts, printer.GCPID, "hello")
+	if _, queued, err := g.Printer(printer.GCPID); err != nil || queued != 1 {
+		t.Fatalf("expected 1 queued job, got %d %v", queued, err)
+	}
+
+	g.HandleJobs(&printer, func() { t.Error("job failed") })
+	var job *lib.Job
+	select {
+	case job = <-jobs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for job")
+	}
+	defer os.Remove(job.Filename)
+
+	data, err := ioutil.ReadFile(job.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" || job.NativePrinterName != "a" || job.Title != "title" ||
+		job.Ticket == nil || job.Ticket.Print.Copies == nil || job.Ticket.Print.Copies.Copies != 2 {
+		t.Logf("unexpected job %+v with data %q", job, data)
+		t.Fail()
+	}
+
+	err = g.Control(job.JobID, &cdd.PrintJobStateDiff{State: &cdd.JobState{Type: cdd.JobStateDone}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	printerJobs, err := g.Jobs(printer.GCPID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(printerJobs) != 1 || printerJobs[0].SemanticState == nil ||
+		printerJobs[0].SemanticState.State.Type != cdd.JobStateDone {
+		t.Logf("expected 1 DONE job, got %+v", printerJobs)
+		t.Fail()
+	}
+	if fetched, err := g.Fetch(printer.GCPID); err != nil || len(fetched) != 0 {
+		t.Logf("expected no jobs to fetch after DONE, got %+v %v", fetched, err)
+		t.Fail()
+	}
+
+	if err = g.DeleteJob(job.JobID); err != nil {
+		t.Fatal(err)
+	}
+	if printerJobs, err = g.Jobs(printer.GCPID); err != nil || len(printerJobs) != 0 {
+		t.Logf("expected no jobs after delete, got %+v %v", printerJobs, err)
+		t.Fail()
+	}
+}
+
+func TestFCM(t *testing.T) {
+	ts := httptest.NewServer(NewServer().Handler())
+	defer ts.Close()
+	g := newTestClient(t, ts, nil)
+
+	printer := lib.Printer{Name: "a", Description: &cdd.PrinterDescriptionSection{}}
+	if err := g.Register(&printer); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := g.FcmSubscribe("fcm/subscribe?client=id&proxy=proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _ := result.(map[string]interface{})["token"].(string)
+	if token == "" {
+		t.Fatalf("expected FCM token, got %v", result)
+	}
+
+	response, err := http.Get(ts.URL + "/fcm/bind?token=" + url.QueryEscape(token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 from bind, got %s", response.Status)
+	}
+
+	submit(t, ts, printer.GCPID, "hello")
+	printerID, err := fcm.GetPrinterID(bufio.NewReader(response.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if printerID != printer.GCPID {
+		t.Logf("expected notification for printer %s, got %q", printer.GCPID, printerID)
+		t.Fail()
+	}
+}
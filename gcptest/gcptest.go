@@ -0,0 +1,268 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+// Package gcptest is a fake Google Cloud Print server on a local port, for
+// testing code that calls Google Cloud Print, like the gcp package.
+//
+// The fake serves the API of package gcpserver, including its OAuth token
+// endpoint. Tests script the fake: they submit jobs, make the next calls to
+// an endpoint fail, and check how many calls each endpoint got.
+package gcptest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/cloud-print-connector/gcpserver"
+)
+
+// Fault is a way for a call to fail.
+type Fault int
+
+const (
+	// FaultServerError answers with HTTP status 503, which the client
+	// should retry.
+	FaultServerError Fault = iota
+	// FaultTimeout answers with the headers of a successful response, then
+	// stalls, and closes the connection after Server.TimeoutDelay without
+	// sending the body, as if a proxy gave up on the call.
+	FaultTimeout
+	// FaultMalformedJSON answers with HTTP status 200, and a body that is
+	// not JSON.
+	FaultMalformedJSON
+	// FaultReset answers with the headers and the first half of the body
+	// of the real response, then closes the connection.
+	FaultReset
+	// FaultCorrupt answers with the real response, with the last byte of
+	// the body changed.
+	FaultCorrupt
+)
+
+// defaultTimeoutDelay is how long FaultTimeout calls hang by default.
+const defaultTimeoutDelay = 100 * time.Millisecond
+
+// Server is a fake Google Cloud Print server. The zero value is not usable;
+// use New, and Close when done.
+type Server struct {
+	*httptest.Server
+
+	// TimeoutDelay is how long FaultTimeout calls hang.
+	TimeoutDelay time.Duration
+
+	mutex sync.Mutex
+	// faults are the faults of the next calls. Key is endpoint, eg list.
+	faults map[string][]Fault
+	// calls counts calls, including failed calls. Key is endpoint.
+	calls map[string]int
+	// forms are the parameters of the latest call. Key is endpoint.
+	forms map[string]url.Values
+}
+
+// New starts a fake server without printers or jobs.
+func New() *Server {
+	s := &Server{
+		TimeoutDelay: defaultTimeoutDelay,
+		faults:       make(map[string][]Fault),
+		calls:        make(map[string]int),
+		forms:        make(map[string]url.Values),
+	}
+	api := gcpserver.NewServer().Handler()
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.serve(api, w, r)
+	}))
+	return s
+}
+
+// BaseURL is the URL to use as gcp_base_url.
+func (s *Server) BaseURL() string {
+	return s.URL + "/"
+}
+
+// TokenURL is the URL to use as gcp_oauth_token_url.
+func (s *Server) TokenURL() string {
+	return s.URL + "/token"
+}
+
+// AuthURL is the URL to use as gcp_oauth_auth_url. It is never called.
+func (s *Server) AuthURL() string {
+	return s.URL + "/auth"
+}
+
+// endpointOf gets the endpoint of a request, eg fcm/subscribe.
+func endpointOf(r *http.Request) string {
+	return strings.TrimPrefix(r.URL.Path, "/")
+}
+
+func (s *Server) serve(api http.Handler, w http.ResponseWriter, r *http.Request) {
+	endpoint := endpointOf(r)
+	// Multipart forms are parsed by the API handler.
+	r.ParseForm()
+
+	s.mutex.Lock()
+	s.calls[endpoint]++
+	s.forms[endpoint] = r.Form
+	var fault *Fault
+	if faults := s.faults[endpoint]; len(faults) > 0 {
+		fault = &faults[0]
+		s.faults[endpoint] = faults[1:]
+	}
+	timeoutDelay := s.TimeoutDelay
+	s.mutex.Unlock()
+
+	if fault == nil {
+		api.ServeHTTP(w, r)
+		return
+	}
+
+	switch *fault {
+	case FaultServerError:
+		http.Error(w, "Injected server error", http.StatusServiceUnavailable)
+	case FaultTimeout:
+		conn, buf, ok := hijack(w)
+		if !ok {
+			return
+		}
+		buf.WriteString("HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: 1024\r\n\r\n")
+		buf.Flush()
+		time.Sleep(timeoutDelay)
+		conn.Close()
+	case FaultMalformedJSON:
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"success": tru`))
+	case FaultReset:
+		recorder := httptest.NewRecorder()
+		api.ServeHTTP(recorder, r)
+		response := recorder.Result()
+		body := recorder.Body.Bytes()
+
+		conn, buf, ok := hijack(w)
+		if !ok {
+			return
+		}
+		fmt.Fprintf(buf, "HTTP/1.1 %s\r\n", response.Status)
+		response.Header.Write(buf)
+		buf.WriteString("\r\n")
+		buf.Write(body[:len(body)/2])
+		buf.Flush()
+		conn.Close()
+	case FaultCorrupt:
+		recorder := httptest.NewRecorder()
+		api.ServeHTTP(recorder, r)
+		body := recorder.Body.Bytes()
+		if len(body) > 0 {
+			body[len(body)-1] ^= 0xff
+		}
+		for key, values := range recorder.Result().Header {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(recorder.Code)
+		w.Write(body)
+	}
+}
+
+// hijack takes over the connection of a call, to answer without HTTP.
+func hijack(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, bool) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Hijacking is not supported", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, false
+	}
+	return conn, buf, true
+}
+
+// InjectFaults makes the next calls to endpoint, eg list, fail, one fault
+// per call.
+func (s *Server) InjectFaults(endpoint string, faults ...Fault) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.faults[endpoint] = append(s.faults[endpoint], faults...)
+}
+
+// Calls counts the calls to endpoint, including failed calls.
+func (s *Server) Calls(endpoint string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.calls[endpoint]
+}
+
+// LastForm gets the parameters of the latest call to endpoint, or nil if
+// it was never called.
+func (s *Server) LastForm(endpoint string) url.Values {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.forms[endpoint]
+}
+
+// SubmitJob queues a job on a printer, and returns the job ID. An empty
+// ticket is a ticket without options.
+func (s *Server) SubmitJob(gcpID, title, ticket string, data []byte) (string, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fields := map[string]string{"printerid": gcpID, "title": title, "ticket": ticket}
+	for name, value := range fields {
+		if value == "" {
+			continue
+		}
+		if err := mw.WriteField(name, value); err != nil {
+			return "", err
+		}
+	}
+	content, err := mw.CreateFormFile("content", "content")
+	if err != nil {
+		return "", err
+	}
+	if _, err = content.Write(data); err != nil {
+		return "", err
+	}
+	if err = mw.Close(); err != nil {
+		return "", err
+	}
+
+	response, err := http.Post(s.URL+"/submit", mw.FormDataContentType(), &body)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	responseBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		Success bool
+		Message string
+		Job     struct {
+			ID string
+		}
+	}
+	if err = json.Unmarshal(responseBody, &result); err != nil {
+		return "", fmt.Errorf("Failed to parse submit response %q: %s", responseBody, err)
+	}
+	if !result.Success {
+		return "", fmt.Errorf("Failed to submit job: %s", result.Message)
+	}
+	return result.Job.ID, nil
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+// Package health reports whether the subsystems of the connector are
+// working, for load balancers, orchestrators and watchdogs.
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/google/cloud-print-connector/log"
+)
+
+// Statuses of checks.
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check checks a subsystem. It returns details of the state of the
+// subsystem, or an error when the subsystem is not working.
+type Check func() (string, error)
+
+type check struct {
+	name  string
+	live  bool
+	check Check
+}
+
+// Health runs checks of subsystems.
+type Health struct {
+	mutex  sync.Mutex
+	checks []check
+}
+
+func NewHealth() *Health {
+	return &Health{}
+}
+
+// AddCheck adds a check of a subsystem, named eg xmpp. All checks must pass
+// for the connector to be ready. Live checks must also pass for the
+// connector to be alive; they should fail only when restarting the connector
+// is the remedy.
+func (h *Health) AddCheck(name string, live bool, c Check) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.checks = append(h.checks, check{name, live, c})
+}
+
+// CheckStatus is the result of one check.
+type CheckStatus struct {
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Status is the result of several checks. Status is StatusOK when all of the
+// checks pass.
+type Status struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckStatus `json:"checks"`
+}
+
+// OK checks whether all of the checks passed.
+func (s *Status) OK() bool {
+	return s.Status == StatusOK
+}
+
+// run runs the checks, or only the live checks when liveOnly is true.
+func (h *Health) run(liveOnly bool) Status {
+	h.mutex.Lock()
+	checks := h.checks
+	h.mutex.Unlock()
+
+	status := Status{
+		Status: StatusOK,
+		Checks: make(map[string]CheckStatus, len(checks)),
+	}
+	for _, c := range checks {
+		if liveOnly && !c.live {
+			continue
+		}
+		detail, err := c.check()
+		if err != nil {
+			status.Status = StatusFail
+			status.Checks[c.name] = CheckStatus{Status: StatusFail, Detail: detail, Error: err.Error()}
+		} else {
+			status.Checks[c.name] = CheckStatus{Status: StatusOK, Detail: detail}
+		}
+	}
+	return status
+}
+
+// Liveness runs the live checks.
+func (h *Health) Liveness() Status {
+	return h.run(true)
+}
+
+// Readiness runs all of the checks.
+func (h *Health) Readiness() Status {
+	return h.run(false)
+}
+
+// handler writes a status as JSON, with HTTP status 200 when it is OK, and
+// 503 otherwise.
+func handler(getStatus func() Status) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := getStatus()
+		body, err := json.MarshalIndent(&status, "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		if status.OK() {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write(body)
+	})
+}
+
+// Serve listens on address, eg localhost:9101, and serves liveness at
+// /livez and readiness at /readyz until the process exits.
+func (h *Health) Serve(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("Failed to listen for health requests on %s: %s", address, err)
+	}
+
+	sm := http.NewServeMux()
+	sm.Handle("/livez", handler(h.Liveness))
+	sm.Handle("/readyz", handler(h.Readiness))
+	go func() {
+		if err := http.Serve(listener, sm); err != nil {
+			log.Errorf("Health HTTP server failed: %s", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	sync := func() (string, error) { return "synchronized", nil }
+	xmppDown := func() (string, error) { return "", errors.New("XMPP conversation is down") }
+
+	h := NewHealth()
+	h.AddCheck("printer_sync", true, sync)
+	h.AddCheck("xmpp", false, xmppDown)
+
+	cases := []struct {
+		name      string
+		getStatus func() Status
+		code      int
+		status    Status
+	}{
+		{"liveness", h.Liveness, http.StatusOK, Status{
+			Status: StatusOK,
+			Checks: map[string]CheckStatus{
+				"printer_sync": CheckStatus{Status: StatusOK, Detail: "synchronized"},
+			},
+		}},
+		{"readiness", h.Readiness, http.StatusServiceUnavailable, Status{
+			Status: StatusFail,
+			Checks: map[string]CheckStatus{
+				"printer_sync": CheckStatus{Status: StatusOK, Detail: "synchronized"},
+				"xmpp":         CheckStatus{Status: StatusFail, Error: "XMPP conversation is down"},
+			},
+		}},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		handler(c.getStatus).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != c.code {
+			t.Logf("%s: expected HTTP status %d, got %d", c.name, c.code, w.Code)
+			t.Fail()
+		}
+		var status Status
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if !reflect.DeepEqual(c.status, status) {
+			t.Logf("%s: expected %+v, got %+v", c.name, c.status, status)
+			t.Fail()
+		}
+	}
+}
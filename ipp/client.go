@@ -0,0 +1,153 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package ipp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// defaultPort is the port of ipp and ipps URIs that have none.
+const defaultPort = "631"
+
+// client sends IPP requests to printers over HTTP.
+type client struct {
+	httpClient *http.Client
+	// requestID is the last request ID used; accessed atomically.
+	requestID uint32
+}
+
+func newClient(timeout time.Duration, tlsConfig *tls.Config) *client {
+	return &client{
+		httpClient: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+	}
+}
+
+// newTLSConfig returns the TLS config for ipps printers. Certificates are
+// verified against the system CAs, and the CAs in the PEM file caFile when
+// it is not empty. Printers often have self-signed certificates, which are
+// accepted without verification when insecureSkipVerify is true.
+func newTLSConfig(caFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if caFile == "" {
+		return tlsConfig, nil
+	}
+
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificates found in %s", caFile)
+	}
+	tlsConfig.RootCAs = pool
+
+	return tlsConfig, nil
+}
+
+// nextRequestID returns a request ID that is unique to this client.
+func (c *client) nextRequestID() uint32 {
+	return atomic.AddUint32(&c.requestID, 1)
+}
+
+// httpURL converts an ipp or ipps URI to the http or https URL that the
+// printer listens at.
+func httpURL(printerURI string) (string, error) {
+	u, err := url.Parse(printerURI)
+	if err != nil {
+		return "", err
+	}
+
+	switch u.Scheme {
+	case "ipp":
+		u.Scheme = "http"
+	case "ipps":
+		u.Scheme = "https"
+	case "http", "https":
+	default:
+		return "", fmt.Errorf("Printer URI %s is not ipp, ipps, http or https", printerURI)
+	}
+	if u.Port() == "" {
+		u.Host = u.Host + ":" + defaultPort
+	}
+
+	return u.String(), nil
+}
+
+// do sends a request, followed by document if it is not nil, and returns
+// the response.
+//
+// Responses with a status that is not successful are returned as errors.
+func (c *client) do(printerURI string, request *message, document io.Reader) (*message, error) {
+	u, err := httpURL(printerURI)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	if err = request.encode(&b); err != nil {
+		return nil, err
+	}
+	var body io.Reader = &b
+	if document != nil {
+		body = io.MultiReader(&b, document)
+	}
+
+	hr, err := c.httpClient.Post(u, "application/ipp", body)
+	if err != nil {
+		return nil, err
+	}
+	defer hr.Body.Close()
+
+	if hr.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("IPP request to %s failed: HTTP status %s", printerURI, hr.Status)
+	}
+
+	response, err := decodeMessage(hr.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read IPP response from %s: %s", printerURI, err)
+	}
+	if response.code > 0x00ff {
+		return response, &statusError{response.code, response.statusMessage()}
+	}
+
+	return response, nil
+}
+
+// statusError is returned for IPP responses with a status that is not
+// successful.
+type statusError struct {
+	status  uint16
+	message string
+}
+
+func (e *statusError) Error() string {
+	if e.message == "" {
+		return fmt.Sprintf("IPP status 0x%04x", e.status)
+	}
+	return fmt.Sprintf("IPP status 0x%04x: %s", e.status, e.message)
+}
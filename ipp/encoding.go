@@ -0,0 +1,416 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package ipp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IPP operations, RFC 8011 section 5.4.15.
+const (
+	opPrintJob             uint16 = 0x0002
+	opCancelJob            uint16 = 0x0008
+	opGetJobAttributes     uint16 = 0x0009
+	opGetPrinterAttributes uint16 = 0x000b
+	opReleaseJob           uint16 = 0x000d
+)
+
+// IPP status codes, RFC 8011 section 5.4.15.
+const (
+	statusOK               uint16 = 0x0000
+	statusErrorNotFound    uint16 = 0x0406
+	statusErrorNotPossible uint16 = 0x0404
+)
+
+// Delimiter tags, RFC 8010 section 3.5.1.
+const (
+	tagOperationGroup   byte = 0x01
+	tagJobGroup         byte = 0x02
+	tagEndOfAttributes  byte = 0x03
+	tagPrinterGroup     byte = 0x04
+	tagUnsupportedGroup byte = 0x05
+)
+
+// Value tags, RFC 8010 section 3.5.2.
+const (
+	tagUnsupported   byte = 0x10
+	tagUnknown       byte = 0x12
+	tagNoValue       byte = 0x13
+	tagInteger       byte = 0x21
+	tagBoolean       byte = 0x22
+	tagEnum          byte = 0x23
+	tagOctetString   byte = 0x30
+	tagDateTime      byte = 0x31
+	tagResolution    byte = 0x32
+	tagRange         byte = 0x33
+	tagBegCollection byte = 0x34
+	tagTextLang      byte = 0x35
+	tagNameLang      byte = 0x36
+	tagEndCollection byte = 0x37
+	tagText          byte = 0x41
+	tagName          byte = 0x42
+	tagKeyword       byte = 0x44
+	tagURI           byte = 0x45
+	tagURIScheme     byte = 0x46
+	tagCharset       byte = 0x47
+	tagLanguage      byte = 0x48
+	tagMimeType      byte = 0x49
+	tagMemberName    byte = 0x4a
+)
+
+// ippVersion is the IPP version of requests, 2.0.
+const ippVersion uint16 = 0x0200
+
+// attribute is an IPP attribute. Values are strings in the same format that
+// the cups package uses for CUPS attributes, so that both can be translated
+// by TranslateAttrs.
+type attribute struct {
+	name   string
+	tag    byte
+	values []string
+}
+
+// group is a group of attributes, eg the operation attributes.
+type group struct {
+	tag        byte
+	attributes []attribute
+}
+
+// message is an IPP request or response. code is the operation of a
+// request, or the status of a response.
+type message struct {
+	version   uint16
+	code      uint16
+	requestID uint32
+	groups    []group
+}
+
+// newRequest makes a request with the operation attributes that every
+// request needs.
+func newRequest(op uint16, requestID uint32, printerURI string) *message {
+	return &message{
+		version:   ippVersion,
+		code:      op,
+		requestID: requestID,
+		groups: []group{
+			group{
+				tag: tagOperationGroup,
+				attributes: []attribute{
+					attribute{"attributes-charset", tagCharset, []string{"utf-8"}},
+					attribute{"attributes-natural-language", tagLanguage, []string{"en"}},
+					attribute{"printer-uri", tagURI, []string{printerURI}},
+				},
+			},
+		},
+	}
+}
+
+// addAttribute adds an attribute to the last group with tag, adding the
+// group if needed.
+func (m *message) addAttribute(groupTag byte, a attribute) {
+	if len(m.groups) == 0 || m.groups[len(m.groups)-1].tag != groupTag {
+		m.groups = append(m.groups, group{tag: groupTag})
+	}
+	g := &m.groups[len(m.groups)-1]
+	g.attributes = append(g.attributes, a)
+}
+
+// attributes returns the attributes of every group with tag, as a map of
+// name to values.
+func (m *message) attributes(groupTag byte) map[string][]string {
+	attributes := make(map[string][]string)
+	for _, g := range m.groups {
+		if g.tag != groupTag {
+			continue
+		}
+		for _, a := range g.attributes {
+			values := a.values
+			if len(values) == 1 && (values[0] == "none" || len(values[0]) == 0) {
+				values = []string{}
+			}
+			attributes[a.name] = values
+		}
+	}
+	return attributes
+}
+
+// statusMessage returns the status-message operation attribute, if any.
+func (m *message) statusMessage() string {
+	if sm := m.attributes(tagOperationGroup)["status-message"]; len(sm) > 0 {
+		return sm[0]
+	}
+	return ""
+}
+
+// encode writes the message in the IPP wire format.
+func (m *message) encode(w io.Writer) error {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, m.version)
+	binary.Write(&b, binary.BigEndian, m.code)
+	binary.Write(&b, binary.BigEndian, m.requestID)
+
+	for _, g := range m.groups {
+		b.WriteByte(g.tag)
+		for _, a := range g.attributes {
+			for i, value := range a.values {
+				v, err := encodeValue(a.tag, value)
+				if err != nil {
+					return fmt.Errorf("Failed to encode IPP attribute %s: %s", a.name, err)
+				}
+				b.WriteByte(a.tag)
+				name := a.name
+				if i > 0 {
+					// Additional values have no name.
+					name = ""
+				}
+				binary.Write(&b, binary.BigEndian, uint16(len(name)))
+				b.WriteString(name)
+				binary.Write(&b, binary.BigEndian, uint16(len(v)))
+				b.Write(v)
+			}
+		}
+	}
+	b.WriteByte(tagEndOfAttributes)
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// encodeValue converts a string value to the IPP wire format of tag.
+func encodeValue(tag byte, value string) ([]byte, error) {
+	var b bytes.Buffer
+	switch tag {
+	case tagInteger, tagEnum:
+		i, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		binary.Write(&b, binary.BigEndian, int32(i))
+	case tagBoolean:
+		if value == "true" {
+			b.WriteByte(1)
+		} else {
+			b.WriteByte(0)
+		}
+	case tagRange:
+		var lower, upper int32
+		if _, err := fmt.Sscanf(value, "%d~%d", &lower, &upper); err != nil {
+			return nil, err
+		}
+		binary.Write(&b, binary.BigEndian, lower)
+		binary.Write(&b, binary.BigEndian, upper)
+	case tagNoValue, tagUnknown, tagUnsupported:
+		// Out-of-band values have no value.
+	case tagText, tagName, tagKeyword, tagURI, tagURIScheme, tagCharset, tagLanguage, tagMimeType, tagOctetString:
+		b.WriteString(value)
+	default:
+		return nil, fmt.Errorf("value tag 0x%02x is not supported", tag)
+	}
+	return b.Bytes(), nil
+}
+
+// decodeMessage reads a message in the IPP wire format. Document data that
+// follows the attributes is not read.
+func decodeMessage(r io.Reader) (*message, error) {
+	d := decoder{r: r}
+	var m message
+	m.version = d.uint16()
+	m.code = d.uint16()
+	m.requestID = d.uint32()
+
+	var g *group
+	for d.err == nil {
+		tag := d.byte()
+		if d.err != nil {
+			break
+		}
+
+		if tag == tagEndOfAttributes {
+			return &m, nil
+		}
+		if tag < 0x10 {
+			// Delimiter tag starts a new group.
+			m.groups = append(m.groups, group{tag: tag})
+			g = &m.groups[len(m.groups)-1]
+			continue
+		}
+		if g == nil {
+			return nil, errors.New("IPP attribute outside of a group")
+		}
+
+		name, value := d.attribute(tag)
+		if d.err != nil {
+			break
+		}
+		if name == "" {
+			// Additional value of the previous attribute.
+			if len(g.attributes) == 0 {
+				return nil, errors.New("IPP additional value without attribute")
+			}
+			a := &g.attributes[len(g.attributes)-1]
+			a.values = append(a.values, value)
+		} else {
+			g.attributes = append(g.attributes, attribute{name, tag, []string{value}})
+		}
+	}
+
+	if d.err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return nil, d.err
+}
+
+// decoder reads IPP wire format, remembering the first error.
+type decoder struct {
+	r   io.Reader
+	err error
+}
+
+func (d *decoder) read(v interface{}) {
+	if d.err == nil {
+		d.err = binary.Read(d.r, binary.BigEndian, v)
+	}
+}
+
+func (d *decoder) byte() byte {
+	var b byte
+	d.read(&b)
+	return b
+}
+
+func (d *decoder) uint16() uint16 {
+	var u uint16
+	d.read(&u)
+	return u
+}
+
+func (d *decoder) uint32() uint32 {
+	var u uint32
+	d.read(&u)
+	return u
+}
+
+func (d *decoder) bytes(n uint16) []byte {
+	b := make([]byte, n)
+	if d.err == nil {
+		_, d.err = io.ReadFull(d.r, b)
+	}
+	return b
+}
+
+// attribute reads the name and value of an attribute whose value tag has
+// already been read.
+func (d *decoder) attribute(tag byte) (string, string) {
+	name := string(d.bytes(d.uint16()))
+	v := d.bytes(d.uint16())
+	if d.err != nil {
+		return "", ""
+	}
+
+	if tag == tagBegCollection {
+		return name, d.collection()
+	}
+	return name, decodeValue(tag, v)
+}
+
+// collection reads the members of a collection, up to and including the
+// end of the collection, and formats them as {name=value ...}.
+func (d *decoder) collection() string {
+	var members []string
+	for d.err == nil {
+		tag := d.byte()
+		_, v := d.attribute(tag)
+		switch tag {
+		case tagEndCollection:
+			return "{" + strings.Join(members, " ") + "}"
+		case tagMemberName:
+			members = append(members, v+"=")
+		default:
+			if len(members) == 0 {
+				d.err = errors.New("IPP collection value without member name")
+				break
+			}
+			if last := members[len(members)-1]; strings.HasSuffix(last, "=") {
+				members[len(members)-1] = last + v
+			} else {
+				// Additional value of the previous member.
+				members[len(members)-1] = last + "," + v
+			}
+		}
+	}
+	return ""
+}
+
+// decodeValue converts a value in the IPP wire format to a string in the
+// format that the cups package uses for CUPS attributes.
+func decodeValue(tag byte, v []byte) string {
+	switch tag {
+	case tagInteger, tagEnum:
+		if len(v) == 4 {
+			return strconv.FormatInt(int64(int32(binary.BigEndian.Uint32(v))), 10)
+		}
+	case tagBoolean:
+		if len(v) == 1 {
+			return strconv.FormatBool(v[0] != 0)
+		}
+	case tagRange:
+		if len(v) == 8 {
+			return fmt.Sprintf("%d~%d", int32(binary.BigEndian.Uint32(v)), int32(binary.BigEndian.Uint32(v[4:])))
+		}
+	case tagResolution:
+		if len(v) == 9 {
+			return fmt.Sprintf("%dx%dppi", int32(binary.BigEndian.Uint32(v)), int32(binary.BigEndian.Uint32(v[4:])))
+		}
+	case tagDateTime:
+		if len(v) == 11 {
+			return strconv.FormatInt(decodeDateTime(v).Unix(), 10)
+		}
+	case tagTextLang, tagNameLang:
+		// Language length, language, text length, text.
+		if len(v) >= 2 {
+			n := int(binary.BigEndian.Uint16(v))
+			if len(v) >= n+4 {
+				return string(v[n+4:])
+			}
+		}
+	case tagNoValue, tagUnknown, tagUnsupported:
+		return ""
+	default:
+		return string(v)
+	}
+	return ""
+}
+
+// decodeDateTime converts an RFC 2579 date to a time.Time object.
+func decodeDateTime(v []byte) time.Time {
+	year := int(binary.BigEndian.Uint16(v))
+	month, day, hour, min, sec, dsec := v[2], v[3], v[4], v[5], v[6], v[7]
+	utcDirection, utcHour, utcMin := v[8], v[9], v[10]
+
+	var utcOffset time.Duration
+	utcOffset += time.Duration(utcHour) * time.Hour
+	utcOffset += time.Duration(utcMin) * time.Minute
+	var loc *time.Location
+	if utcDirection == '-' {
+		loc = time.FixedZone("", -int(utcOffset.Seconds()))
+	} else {
+		loc = time.FixedZone("", int(utcOffset.Seconds()))
+	}
+
+	nsec := int(dsec) * 100 * int(time.Millisecond)
+
+	return time.Date(year, time.Month(month), int(day), int(hour), int(min), int(sec), nsec, loc)
+}
@@ -0,0 +1,598 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+// Package ipp is a native print system that sends jobs straight to printers
+// that speak IPP Everywhere, without CUPS.
+package ipp
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+)
+
+// Job states, the values of job-state. CUPS uses the same values.
+const (
+	JobStatePending    = 3
+	JobStateHeld       = 4
+	JobStateProcessing = 5
+	JobStateStopped    = 6
+	JobStateCanceled   = 7
+	JobStateAborted    = 8
+	JobStateCompleted  = 9
+)
+
+const (
+	// internalKeySeparator separates the IPP attribute name from the value
+	// in vendor IDs, eg print-color-mode:color.
+	internalKeySeparator = ":"
+
+	// maxTitleLength is the most bytes of a job title sent to printers.
+	maxTitleLength = 255
+
+	// Attributes that printers use to describe themselves.
+	attrCopiesDefault                 = "copies-default"
+	attrCopiesSupported               = "copies-supported"
+	attrDocumentFormatSupported       = "document-format-supported"
+	attrMarkerLevels                  = "marker-levels"
+	attrMarkerNames                   = "marker-names"
+	attrMarkerTypes                   = "marker-types"
+	attrNumberUpDefault               = "number-up-default"
+	attrNumberUpSupported             = "number-up-supported"
+	attrOrientationRequestedDefault   = "orientation-requested-default"
+	attrOrientationRequestedSupported = "orientation-requested-supported"
+	attrPDFVersionsSupported          = "pdf-versions-supported"
+	attrPrintColorModeDefault         = "print-color-mode-default"
+	attrPrintColorModeSupported       = "print-color-mode-supported"
+	attrPrinterInfo                   = "printer-info"
+	attrPrinterMakeAndModel           = "printer-make-and-model"
+	attrPrinterName                   = "printer-name"
+	attrPrinterState                  = "printer-state"
+	attrPrinterStateReasons           = "printer-state-reasons"
+	attrPrinterUUID                   = "printer-uuid"
+	attrSidesDefault                  = "sides-default"
+	attrSidesSupported                = "sides-supported"
+
+	// Attributes that the connector uses to describe print jobs.
+	attrCopies               = "copies"
+	attrDocumentFormat       = "document-format"
+	attrJobHoldUntil         = "job-hold-until"
+	attrJobName              = "job-name"
+	attrJobPriority          = "job-priority"
+	attrNumberUp             = "number-up"
+	attrOrientationRequested = "orientation-requested"
+	attrPrintColorMode       = "print-color-mode"
+	attrRequestingUserName   = "requesting-user-name"
+	attrSides                = "sides"
+
+	// Attributes that printers use to describe print jobs.
+	attrJobID                   = "job-id"
+	attrJobImpressionsCompleted = "job-impressions-completed"
+	attrJobMediaSheetsCompleted = "job-media-sheets-completed"
+	attrJobState                = "job-state"
+)
+
+var (
+	printerAttributes = []string{
+		attrCopiesDefault,
+		attrCopiesSupported,
+		attrDocumentFormatSupported,
+		attrMarkerLevels,
+		attrMarkerNames,
+		attrMarkerTypes,
+		attrNumberUpDefault,
+		attrNumberUpSupported,
+		attrOrientationRequestedDefault,
+		attrOrientationRequestedSupported,
+		attrPDFVersionsSupported,
+		attrPrintColorModeDefault,
+		attrPrintColorModeSupported,
+		attrPrinterInfo,
+		attrPrinterMakeAndModel,
+		attrPrinterName,
+		attrPrinterState,
+		attrPrinterStateReasons,
+		attrPrinterUUID,
+		attrSidesDefault,
+		attrSidesSupported,
+	}
+
+	jobAttributes = []string{
+		attrJobState,
+		attrJobImpressionsCompleted,
+		attrJobMediaSheetsCompleted,
+	}
+
+	duplexBySides = map[string]cdd.DuplexType{
+		"one-sided":            cdd.DuplexNoDuplex,
+		"two-sided-long-edge":  cdd.DuplexLongEdge,
+		"two-sided-short-edge": cdd.DuplexShortEdge,
+	}
+)
+
+// Interface between Go and printers that speak IPP.
+type IPP struct {
+	client *client
+	// Printer URIs by printer name.
+	printerURIs           map[string]string
+	prefixJobIDToJobTitle bool
+	systemTags            map[string]string
+
+	// Last printers that answered, by printer name.
+	lastPrintersMutex sync.Mutex
+	lastPrinters      map[string]lib.Printer
+
+	// The filters can be changed while running.
+	filterMutex       sync.RWMutex
+	displayNamePrefix string
+	printerBlacklist  map[string]interface{}
+	printerWhitelist  map[string]interface{}
+}
+
+// NewIPP constructs an IPP native print system for the printers at
+// printerURIs, which are keyed by printer name.
+func NewIPP(printerURIs map[string]string, prefixJobIDToJobTitle bool, displayNamePrefix string,
+	printerBlacklist, printerWhitelist []string, requestTimeout time.Duration, caFile string,
+	insecureSkipVerify bool, fcmNotificationsEnable bool) (*IPP, error) {
+	for name, uri := range printerURIs {
+		if _, err := httpURL(uri); err != nil {
+			return nil, fmt.Errorf("Bad URI for IPP printer %s: %s", name, err)
+		}
+	}
+	tlsConfig, err := newTLSConfig(caFile, insecureSkipVerify)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read IPP CA file: %s", err)
+	}
+
+	i := &IPP{
+		client:                newClient(requestTimeout, tlsConfig),
+		printerURIs:           printerURIs,
+		prefixJobIDToJobTitle: prefixJobIDToJobTitle,
+		systemTags:            getSystemTags(fcmNotificationsEnable),
+		lastPrinters:          make(map[string]lib.Printer),
+	}
+	i.SetPrinterFilters(displayNamePrefix, printerBlacklist, printerWhitelist)
+
+	return i, nil
+}
+
+// SetPrinterFilters replaces the display name prefix, and the printer
+// blacklist and whitelist. The new filters apply from the next GetPrinters.
+func (i *IPP) SetPrinterFilters(displayNamePrefix string, printerBlacklist, printerWhitelist []string) {
+	pb := map[string]interface{}{}
+	for _, p := range printerBlacklist {
+		pb[p] = struct{}{}
+	}
+
+	pw := map[string]interface{}{}
+	for _, p := range printerWhitelist {
+		pw[p] = struct{}{}
+	}
+
+	i.filterMutex.Lock()
+	defer i.filterMutex.Unlock()
+
+	i.displayNamePrefix = displayNamePrefix
+	i.printerBlacklist = pb
+	i.printerWhitelist = pw
+}
+
+// isFiltered returns true if the printer is blacklisted, or is not
+// whitelisted.
+//
+// The caller must hold filterMutex.
+func (i *IPP) isFiltered(name string) bool {
+	if _, exists := i.printerBlacklist[name]; exists {
+		return true
+	}
+	if len(i.printerWhitelist) != 0 {
+		if _, exists := i.printerWhitelist[name]; !exists {
+			return true
+		}
+	}
+	return false
+}
+
+func getSystemTags(fcmNotificationsEnable bool) map[string]string {
+	tags := make(map[string]string)
+
+	tags["connector-version"] = lib.BuildDate
+	hostname, err := os.Hostname()
+	if err == nil {
+		tags["system-hostname"] = hostname
+	}
+	tags["system-arch"] = runtime.GOARCH
+	tags["system-golang-version"] = runtime.Version()
+	if fcmNotificationsEnable {
+		tags["system-notifications-channel"] = "fcm"
+	} else {
+		tags["system-notifications-channel"] = "xmpp"
+	}
+	tags["connector-native-print-system"] = "ipp"
+
+	return tags
+}
+
+// GetPrinters gets the printers that are configured, asking each printer
+// for its attributes concurrently.
+//
+// A printer that fails to answer is reported as it last answered, but
+// STOPPED, so that a short outage does not delete it from the cloud. A
+// printer that never answered is left out.
+func (i *IPP) GetPrinters() ([]lib.Printer, error) {
+	i.filterMutex.RLock()
+	defer i.filterMutex.RUnlock()
+
+	var wg sync.WaitGroup
+	ch := make(chan lib.Printer, len(i.printerURIs))
+
+	for name, uri := range i.printerURIs {
+		if i.isFiltered(name) {
+			log.Debugf("Ignoring filtered printer %s", name)
+			continue
+		}
+
+		wg.Add(1)
+		go func(name, uri string) {
+			defer wg.Done()
+			p, err := i.getPrinter(name, uri)
+			if err != nil {
+				log.ErrorPrinterf(name, "Failed to get IPP printer attributes: %s", err)
+				if p, exists := i.getLastPrinter(name, err); exists {
+					ch <- p
+				}
+				return
+			}
+			i.lastPrintersMutex.Lock()
+			i.lastPrinters[name] = p
+			i.lastPrintersMutex.Unlock()
+			ch <- p
+		}(name, uri)
+	}
+
+	wg.Wait()
+	close(ch)
+
+	printers := make([]lib.Printer, 0, len(ch))
+	for p := range ch {
+		printers = append(printers, p)
+	}
+
+	return printers, nil
+}
+
+// getLastPrinter returns the printer as it last answered, STOPPED because
+// of err.
+func (i *IPP) getLastPrinter(name string, err error) (lib.Printer, bool) {
+	i.lastPrintersMutex.Lock()
+	defer i.lastPrintersMutex.Unlock()
+
+	p, exists := i.lastPrinters[name]
+	if !exists {
+		return lib.Printer{}, false
+	}
+	p.State = &cdd.PrinterStateSection{
+		State: cdd.CloudDeviceStateStopped,
+		VendorState: &cdd.VendorState{
+			Item: []cdd.VendorStateItem{
+				cdd.VendorStateItem{
+					State:                cdd.VendorStateError,
+					DescriptionLocalized: cdd.NewLocalizedString(fmt.Sprintf("Printer is not answering: %s", err)),
+				},
+			},
+		},
+	}
+	return p, true
+}
+
+// getPrinter asks one printer for its attributes, and translates them.
+//
+// The caller must hold filterMutex.
+func (i *IPP) getPrinter(name, uri string) (lib.Printer, error) {
+	request := newRequest(opGetPrinterAttributes, i.client.nextRequestID(), uri)
+	request.addAttribute(tagOperationGroup, attribute{"requested-attributes", tagKeyword, printerAttributes})
+
+	response, err := i.client.do(uri, request, nil)
+	if err != nil {
+		return lib.Printer{}, err
+	}
+
+	attributes := response.attributes(tagPrinterGroup)
+	pds, pss, _, info, uuid, tags := TranslateAttrs(attributes)
+	// Printers support only the document formats they say.
+	pds.SupportedContentType = filterContentTypes(pds.SupportedContentType, attributes[attrDocumentFormatSupported])
+	var duplexMap lib.DuplexVendorMap
+	pds.Duplex, duplexMap = convertSides(attributes)
+
+	if info == "" {
+		info = name
+	}
+	if uuid == "" {
+		uuid = uri
+	}
+	var manufacturer, model string
+	if mm := attributes[attrPrinterMakeAndModel]; len(mm) > 0 {
+		model = mm[0]
+		manufacturer = strings.SplitN(model, " ", 2)[0]
+	}
+	for k, v := range i.systemTags {
+		tags[k] = v
+	}
+	tags["printer-uri"] = uri
+
+	p := lib.Printer{
+		Name:               name,
+		DefaultDisplayName: i.displayNamePrefix + info,
+		UUID:               uuid,
+		Manufacturer:       manufacturer,
+		Model:              model,
+		State:              pss,
+		Description:        pds,
+		Tags:               tags,
+		DuplexMap:          duplexMap,
+		GCPVersion:         lib.GCPAPIVersion,
+		SetupURL:           lib.ConnectorHomeURL,
+		SupportURL:         lib.ConnectorHomeURL,
+		UpdateURL:          lib.ConnectorHomeURL,
+		ConnectorVersion:   lib.ShortName,
+	}
+
+	return p, nil
+}
+
+// filterContentTypes removes the content types that TranslateAttrs adds
+// because CUPS converts them, but that the printer does not support.
+func filterContentTypes(sct *[]cdd.SupportedContentType, supported []string) *[]cdd.SupportedContentType {
+	if sct == nil {
+		return nil
+	}
+
+	result := make([]cdd.SupportedContentType, 0, len(*sct))
+	for _, ct := range *sct {
+		for _, s := range supported {
+			if ct.ContentType == s {
+				result = append(result, ct)
+				break
+			}
+		}
+	}
+	return &result
+}
+
+// convertSides converts sides-(default|supported) to *cdd.Duplex, and the
+// sides values by duplex type.
+func convertSides(printerTags map[string][]string) (*cdd.Duplex, lib.DuplexVendorMap) {
+	sidesSupported, exists := printerTags[attrSidesSupported]
+	if !exists || len(sidesSupported) < 2 {
+		return nil, nil
+	}
+
+	sidesDefault, exists := printerTags[attrSidesDefault]
+	if !exists || len(sidesDefault) != 1 {
+		sidesDefault = []string{"one-sided"}
+	}
+
+	var d cdd.Duplex
+	duplexMap := lib.DuplexVendorMap{}
+	for _, sides := range sidesSupported {
+		if t, exists := duplexBySides[sides]; exists {
+			d.Option = append(d.Option, cdd.DuplexOption{
+				Type:      t,
+				IsDefault: sides == sidesDefault[0],
+			})
+			duplexMap[t] = attrSides + internalKeySeparator + sides
+		}
+	}
+
+	return &d, duplexMap
+}
+
+// RemoveCachedPPD does nothing; IPP printers have no PPD.
+func (i *IPP) RemoveCachedPPD(printerName string) {}
+
+// getPrinterURI gets the URI of the printer named printerName.
+func (i *IPP) getPrinterURI(printerName string) (string, error) {
+	uri, exists := i.printerURIs[printerName]
+	if !exists {
+		return "", fmt.Errorf("IPP printer %s is not configured", printerName)
+	}
+	return uri, nil
+}
+
+// GetJobState gets the current state of the job indicated by jobID.
+//
+// Pages printed are impressions, ie sides of sheets, or sheets when the
+// printer does not count impressions.
+func (i *IPP) GetJobState(printerName string, jobID uint32) (*cdd.PrintJobStateDiff, error) {
+	attributes, err := i.getJobAttributes(printerName, jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	var state int64
+	if js := attributes[attrJobState]; len(js) > 0 {
+		state, _ = strconv.ParseInt(js[0], 10, 32)
+	}
+	s := ConvertJobState(int32(state))
+	for _, attr := range []string{attrJobImpressionsCompleted, attrJobMediaSheetsCompleted} {
+		if v := attributes[attr]; len(v) > 0 {
+			if pages, err := strconv.ParseInt(v[0], 10, 32); err == nil {
+				pagesPrinted := int32(pages)
+				s.PagesPrinted = &pagesPrinted
+				break
+			}
+		}
+	}
+	return s, nil
+}
+
+// getJobAttributes gets the attributes of the job indicated by jobID.
+func (i *IPP) getJobAttributes(printerName string, jobID uint32) (map[string][]string, error) {
+	uri, err := i.getPrinterURI(printerName)
+	if err != nil {
+		return nil, err
+	}
+
+	request := newRequest(opGetJobAttributes, i.client.nextRequestID(), uri)
+	request.addAttribute(tagOperationGroup, attribute{attrJobID, tagInteger, []string{strconv.FormatUint(uint64(jobID), 10)}})
+	request.addAttribute(tagOperationGroup, attribute{"requested-attributes", tagKeyword, jobAttributes})
+
+	response, err := i.client.do(uri, request, nil)
+	if err != nil {
+		return nil, err
+	}
+	return response.attributes(tagJobGroup), nil
+}
+
+// ConvertJobState converts IPP job state, which CUPS also uses, to
+// cdd.PrintJobStateDiff.
+func ConvertJobState(ippState int32) *cdd.PrintJobStateDiff {
+	var state cdd.PrintJobStateDiff
+
+	switch ippState {
+	case JobStatePending, JobStateHeld, JobStateProcessing:
+		state.State = &cdd.JobState{Type: cdd.JobStateInProgress}
+	case JobStateStopped:
+		state.State = &cdd.JobState{
+			Type:              cdd.JobStateStopped,
+			DeviceActionCause: &cdd.DeviceActionCause{ErrorCode: cdd.DeviceActionCauseOther},
+		}
+	case JobStateCanceled:
+		state.State = &cdd.JobState{
+			Type:            cdd.JobStateAborted,
+			UserActionCause: &cdd.UserActionCause{ActionCode: cdd.UserActionCauseCanceled},
+		}
+	case JobStateAborted:
+		state.State = &cdd.JobState{
+			Type:              cdd.JobStateAborted,
+			DeviceActionCause: &cdd.DeviceActionCause{ErrorCode: cdd.DeviceActionCausePrintFailure},
+		}
+	case JobStateCompleted:
+		state.State = &cdd.JobState{Type: cdd.JobStateDone}
+	}
+
+	return &state
+}
+
+// jobRequest sends a request that needs only the job ID.
+func (i *IPP) jobRequest(op uint16, printerName string, jobID uint32) error {
+	uri, err := i.getPrinterURI(printerName)
+	if err != nil {
+		return err
+	}
+
+	request := newRequest(op, i.client.nextRequestID(), uri)
+	request.addAttribute(tagOperationGroup, attribute{attrJobID, tagInteger, []string{strconv.FormatUint(uint64(jobID), 10)}})
+
+	_, err = i.client.do(uri, request, nil)
+	return err
+}
+
+// CancelJob cancels the job indicated by jobID.
+func (i *IPP) CancelJob(printerName string, jobID uint32) error {
+	return i.jobRequest(opCancelJob, printerName, jobID)
+}
+
+// ReleaseJob is not relevant to IPP printing, but is required by the
+// NativePrintSystem interface.
+func (i *IPP) ReleaseJob(printerName string, jobID uint32) error {
+	return nil
+}
+
+// ReleaseHeldJob releases the job indicated by jobID, if the job is held.
+func (i *IPP) ReleaseHeldJob(printerName string, jobID uint32) error {
+	attributes, err := i.getJobAttributes(printerName, jobID)
+	if err != nil {
+		return err
+	}
+	// Only release if the job is held (otherwise we get an error)
+	if js := attributes[attrJobState]; len(js) == 0 || js[0] != strconv.Itoa(JobStateHeld) {
+		return nil
+	}
+	return i.jobRequest(opReleaseJob, printerName, jobID)
+}
+
+// truncateTitle shortens title to at most n bytes, without splitting a
+// UTF-8 character.
+func truncateTitle(title string, n int) string {
+	if len(title) <= n {
+		return title
+	}
+	for n > 0 && !utf8.RuneStart(title[n]) {
+		n--
+	}
+	return title[:n]
+}
+
+// Print sends a new print job to the specified printer. The job ID
+// is returned.
+func (i *IPP) Print(printer *lib.Printer, filename, title, user, gcpJobID string, ticket *cdd.CloudJobTicket) (uint32, error) {
+	printer.NativeJobSemaphore.Acquire()
+	defer printer.NativeJobSemaphore.Release()
+
+	uri, err := i.getPrinterURI(printer.Name)
+	if err != nil {
+		return 0, err
+	}
+
+	if i.prefixJobIDToJobTitle {
+		title = fmt.Sprintf("gcp:%s %s", gcpJobID, title)
+	}
+	title = truncateTitle(title, maxTitleLength)
+
+	attributes, err := translateTicket(printer, ticket)
+	if err != nil {
+		return 0, err
+	}
+	if printer.HoldJobs {
+		attributes = append(attributes, attribute{attrJobHoldUntil, tagKeyword, []string{"indefinite"}})
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	request := newRequest(opPrintJob, i.client.nextRequestID(), uri)
+	request.addAttribute(tagOperationGroup, attribute{attrRequestingUserName, tagName, []string{user}})
+	request.addAttribute(tagOperationGroup, attribute{attrJobName, tagName, []string{title}})
+	// The file type is not known here, so let the printer detect it, if it
+	// can. Otherwise the printer assumes its default document format.
+	if printer.Tags != nil && strings.Contains(","+printer.Tags[attrDocumentFormatSupported]+",", ",application/octet-stream,") {
+		request.addAttribute(tagOperationGroup, attribute{attrDocumentFormat, tagMimeType, []string{"application/octet-stream"}})
+	}
+	for _, a := range attributes {
+		request.addAttribute(tagJobGroup, a)
+	}
+
+	response, err := i.client.do(uri, request, f)
+	if err != nil {
+		return 0, err
+	}
+
+	jobID := response.attributes(tagJobGroup)[attrJobID]
+	if len(jobID) == 0 {
+		return 0, fmt.Errorf("IPP printer %s did not return a job ID", printer.Name)
+	}
+	id, err := strconv.ParseUint(jobID[0], 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("IPP printer %s returned bad job ID %s", printer.Name, jobID[0])
+	}
+
+	return uint32(id), nil
+}
@@ -0,0 +1,394 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package ipp
+
+import (
+	"bytes"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+)
+
+// fakePrinter is an IPP printer that answers requests with handle.
+type fakePrinter struct {
+	server *httptest.Server
+	handle func(request *message, document []byte) *message
+}
+
+func newFakePrinter(t *testing.T, handle func(request *message, document []byte) *message) *fakePrinter {
+	p := &fakePrinter{handle: handle}
+	p.server = httptest.NewServer(p.handler(t))
+	return p
+}
+
+// newFakeTLSPrinter is like newFakePrinter, with an ipps URI.
+func newFakeTLSPrinter(t *testing.T, handle func(request *message, document []byte) *message) *fakePrinter {
+	p := &fakePrinter{handle: handle}
+	p.server = httptest.NewTLSServer(p.handler(t))
+	return p
+}
+
+func (p *fakePrinter) handler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/ipp" {
+			t.Logf("expected Content-Type application/ipp, got %s", r.Header.Get("Content-Type"))
+			t.Fail()
+		}
+		request, err := decodeMessage(r.Body)
+		if err != nil {
+			t.Logf("failed to decode request: %s", err)
+			t.Fail()
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		document, _ := ioutil.ReadAll(r.Body)
+
+		response := p.handle(request, document)
+		response.version = ippVersion
+		response.requestID = request.requestID
+		w.Header().Set("Content-Type", "application/ipp")
+		response.encode(w)
+	})
+}
+
+func (p *fakePrinter) uri() string {
+	// http becomes ipp, and https becomes ipps.
+	return strings.Replace(p.server.URL, "http", "ipp", 1) + "/ipp/print"
+}
+
+func newResponse(status uint16, groups ...group) *message {
+	return &message{
+		code: status,
+		groups: append([]group{group{
+			tag: tagOperationGroup,
+			attributes: []attribute{
+				attribute{"attributes-charset", tagCharset, []string{"utf-8"}},
+				attribute{"attributes-natural-language", tagLanguage, []string{"en"}},
+			},
+		}}, groups...),
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	m := newRequest(opPrintJob, 7, "ipp://printer/ipp/print")
+	m.addAttribute(tagJobGroup, attribute{"copies", tagInteger, []string{"2"}})
+	m.addAttribute(tagJobGroup, attribute{"finishings", tagEnum, []string{"3", "4"}})
+	m.addAttribute(tagJobGroup, attribute{"copies-supported", tagRange, []string{"1~99"}})
+	m.addAttribute(tagJobGroup, attribute{"page-ranges-supported", tagBoolean, []string{"true"}})
+
+	var b bytes.Buffer
+	if err := m.encode(&b); err != nil {
+		t.Fatal(err)
+	}
+	d, err := decodeMessage(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, d) {
+		t.Logf("expected %+v, got %+v", m, d)
+		t.Fail()
+	}
+}
+
+func TestDecodeCollection(t *testing.T) {
+	var b bytes.Buffer
+	b.Write([]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, tagPrinterGroup})
+	write := func(tag byte, name, value string) {
+		b.WriteByte(tag)
+		b.Write([]byte{0, byte(len(name))})
+		b.WriteString(name)
+		b.Write([]byte{0, byte(len(value))})
+		b.WriteString(value)
+	}
+	write(tagBegCollection, "media-col-default", "")
+	write(tagMemberName, "", "media-type")
+	write(tagKeyword, "", "stationery")
+	write(tagEndCollection, "", "")
+	write(tagKeyword, "sides-default", "one-sided")
+	b.WriteByte(tagEndOfAttributes)
+
+	m, err := decodeMessage(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]string{
+		"media-col-default": []string{"{media-type=stationery}"},
+		"sides-default":     []string{"one-sided"},
+	}
+	if attributes := m.attributes(tagPrinterGroup); !reflect.DeepEqual(expected, attributes) {
+		t.Logf("expected %v, got %v", expected, attributes)
+		t.Fail()
+	}
+}
+
+func TestGetPrinters(t *testing.T) {
+	log.SetLevel(log.ERROR)
+
+	p := newFakePrinter(t, func(request *message, _ []byte) *message {
+		if request.code != opGetPrinterAttributes {
+			t.Logf("expected Get-Printer-Attributes, got 0x%04x", request.code)
+			t.Fail()
+		}
+		return newResponse(statusOK, group{
+			tag: tagPrinterGroup,
+			attributes: []attribute{
+				attribute{attrPrinterName, tagName, []string{"device"}},
+				attribute{attrPrinterInfo, tagText, []string{"Front desk"}},
+				attribute{attrPrinterUUID, tagURI, []string{"urn:uuid:1234"}},
+				attribute{attrPrinterState, tagEnum, []string{"3"}},
+				attribute{attrPrinterMakeAndModel, tagText, []string{"Acme LaserJet"}},
+				attribute{attrDocumentFormatSupported, tagMimeType, []string{"application/pdf", "image/pwg-raster"}},
+				attribute{attrSidesSupported, tagKeyword, []string{"one-sided", "two-sided-long-edge"}},
+				attribute{attrSidesDefault, tagKeyword, []string{"one-sided"}},
+			},
+		})
+	})
+	defer p.server.Close()
+
+	i, err := NewIPP(map[string]string{"front": p.uri()}, false, "IPP ", nil, nil, time.Second, "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	printers, err := i.GetPrinters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(printers) != 1 {
+		t.Fatalf("expected 1 printer, got %d", len(printers))
+	}
+
+	printer := printers[0]
+	if printer.Name != "front" || printer.DefaultDisplayName != "IPP Front desk" || printer.UUID != "1234" {
+		t.Logf("unexpected name, display name or UUID: %s, %s, %s", printer.Name, printer.DefaultDisplayName, printer.UUID)
+		t.Fail()
+	}
+	if printer.Manufacturer != "Acme" || printer.Model != "Acme LaserJet" {
+		t.Logf("unexpected manufacturer or model: %s, %s", printer.Manufacturer, printer.Model)
+		t.Fail()
+	}
+	expectedSCT := []cdd.SupportedContentType{cdd.SupportedContentType{ContentType: "application/pdf"}}
+	if !reflect.DeepEqual(expectedSCT, *printer.Description.SupportedContentType) {
+		t.Logf("expected content types %+v, got %+v", expectedSCT, *printer.Description.SupportedContentType)
+		t.Fail()
+	}
+	if printer.Description.Duplex == nil || len(printer.Description.Duplex.Option) != 2 ||
+		printer.DuplexMap[cdd.DuplexLongEdge] != "sides:two-sided-long-edge" {
+		t.Logf("unexpected duplex %+v, %+v", printer.Description.Duplex, printer.DuplexMap)
+		t.Fail()
+	}
+
+	i.SetPrinterFilters("", []string{"front"}, nil)
+	if printers, _ = i.GetPrinters(); len(printers) != 0 {
+		t.Logf("expected blacklisted printer to be filtered, got %d printers", len(printers))
+		t.Fail()
+	}
+}
+
+func TestGetPrintersOutage(t *testing.T) {
+	log.SetLevel(log.FATAL)
+
+	p := newFakePrinter(t, func(request *message, _ []byte) *message {
+		return newResponse(statusOK, group{
+			tag:        tagPrinterGroup,
+			attributes: []attribute{attribute{attrPrinterState, tagEnum, []string{"3"}}},
+		})
+	})
+	i, err := NewIPP(map[string]string{"front": p.uri(), "never": "ipp://127.0.0.1:1/ipp/print"}, false, "", nil, nil,
+		time.Second, "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	printers, err := i.GetPrinters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(printers) != 1 || printers[0].State.State != cdd.CloudDeviceStateIdle {
+		t.Fatalf("expected 1 IDLE printer, got %+v", printers)
+	}
+
+	p.server.Close()
+	printers, err = i.GetPrinters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(printers) != 1 || printers[0].Name != "front" || printers[0].State.State != cdd.CloudDeviceStateStopped ||
+		printers[0].State.VendorState == nil || len(printers[0].State.VendorState.Item) != 1 {
+		t.Logf("expected printer that stopped answering to be STOPPED with a vendor state, got %+v", printers)
+		t.Fail()
+	}
+}
+
+func TestPrint(t *testing.T) {
+	var received map[string][]string
+	var receivedDocument []byte
+	p := newFakePrinter(t, func(request *message, document []byte) *message {
+		switch request.code {
+		case opPrintJob:
+			received = request.attributes(tagJobGroup)
+			for k, v := range request.attributes(tagOperationGroup) {
+				received[k] = v
+			}
+			receivedDocument = document
+			return newResponse(statusOK, group{
+				tag:        tagJobGroup,
+				attributes: []attribute{attribute{attrJobID, tagInteger, []string{"42"}}},
+			})
+		case opGetJobAttributes:
+			return newResponse(statusOK, group{
+				tag: tagJobGroup,
+				attributes: []attribute{
+					attribute{attrJobState, tagEnum, []string{"9"}},
+					attribute{attrJobImpressionsCompleted, tagInteger, []string{"6"}},
+					attribute{attrJobMediaSheetsCompleted, tagInteger, []string{"3"}},
+				},
+			})
+		}
+		return newResponse(statusErrorNotPossible)
+	})
+	defer p.server.Close()
+
+	i, err := NewIPP(map[string]string{"front": p.uri()}, true, "", nil, nil, time.Second, "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ioutil.TempFile("", "ipp-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("%PDF-1.4")
+	f.Close()
+
+	printer := lib.Printer{
+		Name: "front",
+		Description: &cdd.PrinterDescriptionSection{
+			Copies: &cdd.Copies{Default: 1, Max: 99},
+		},
+		NativeJobSemaphore: lib.NewSemaphore(1),
+		HoldJobs:           true,
+	}
+	ticket := cdd.CloudJobTicket{
+		Print: cdd.PrintTicketSection{Copies: &cdd.CopiesTicketItem{Copies: 2}},
+	}
+
+	jobID, err := i.Print(&printer, f.Name(), "title", "joe", "gcp1", &ticket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobID != 42 {
+		t.Logf("expected job ID 42, got %d", jobID)
+		t.Fail()
+	}
+	expected := map[string]string{
+		attrJobName:            "gcp:gcp1 title",
+		attrRequestingUserName: "joe",
+		attrCopies:             "2",
+		attrJobHoldUntil:       "indefinite",
+	}
+	for k, v := range expected {
+		if len(received[k]) != 1 || received[k][0] != v {
+			t.Logf("expected %s=%s, got %v", k, v, received[k])
+			t.Fail()
+		}
+	}
+	if string(receivedDocument) != "%PDF-1.4" {
+		t.Logf("expected document to follow request, got %q", receivedDocument)
+		t.Fail()
+	}
+
+	state, err := i.GetJobState("front", jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.State.Type != cdd.JobStateDone || state.PagesPrinted == nil || *state.PagesPrinted != 6 {
+		t.Logf("unexpected job state %+v", state)
+		t.Fail()
+	}
+
+	if err = i.CancelJob("front", jobID); err == nil {
+		t.Log("expected error status to be returned as an error")
+		t.Fail()
+	}
+}
+
+func TestTLS(t *testing.T) {
+	log.SetLevel(log.ERROR)
+
+	p := newFakeTLSPrinter(t, func(request *message, _ []byte) *message {
+		return newResponse(statusOK, group{
+			tag:        tagPrinterGroup,
+			attributes: []attribute{attribute{attrPrinterState, tagEnum, []string{"3"}}},
+		})
+	})
+	defer p.server.Close()
+
+	f, err := ioutil.TempFile("", "ipp-test-ca-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: p.server.Certificate().Raw})
+	f.Close()
+
+	cases := []struct {
+		name               string
+		caFile             string
+		insecureSkipVerify bool
+		ok                 bool
+	}{
+		{name: "self-signed", ok: false},
+		{name: "CA file", caFile: f.Name(), ok: true},
+		{name: "skip verify", insecureSkipVerify: true, ok: true},
+	}
+	for _, c := range cases {
+		i, err := NewIPP(map[string]string{"front": p.uri()}, false, "", nil, nil, time.Second,
+			c.caFile, c.insecureSkipVerify, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		printers, err := i.GetPrinters()
+		if ok := err == nil && len(printers) == 1; ok != c.ok {
+			t.Logf("%s: expected success %t, got %d printers, %v", c.name, c.ok, len(printers), err)
+			t.Fail()
+		}
+	}
+
+	if _, err := NewIPP(nil, false, "", nil, nil, time.Second, os.DevNull, false, false); err == nil {
+		t.Log("expected error from CA file without certificates")
+		t.Fail()
+	}
+}
+
+func TestTruncateTitle(t *testing.T) {
+	cases := []struct {
+		title, expected string
+	}{
+		{"title", "title"},
+		{"titles", "title"},
+		{"titl\u00e9", "titl"},
+		{"tit\u00e9", "tit\u00e9"},
+	}
+	for _, c := range cases {
+		if title := truncateTitle(c.title, 5); title != c.expected {
+			t.Logf("truncateTitle(%q, 5): expected %q, got %q", c.title, c.expected, title)
+			t.Fail()
+		}
+	}
+}
@@ -4,9 +4,7 @@
 // license that can be found in the LICENSE file or at
 // https://developers.google.com/open-source/licenses/bsd
 
-// +build linux darwin freebsd
-
-package cups
+package ipp
 
 import (
 	"fmt"
@@ -19,8 +17,8 @@ import (
 	"github.com/google/cloud-print-connector/log"
 )
 
-// translateAttrs extracts a PrinterDescriptionSection, PrinterStateSection, name, default diplay name, UUID, and tags from maps of tags (CUPS attributes)
-func translateAttrs(printerTags map[string][]string) (*cdd.PrinterDescriptionSection, *cdd.PrinterStateSection, string, string, string, map[string]string) {
+// TranslateAttrs extracts a PrinterDescriptionSection, PrinterStateSection, name, default diplay name, UUID, and tags from maps of tags (CUPS attributes)
+func TranslateAttrs(printerTags map[string][]string) (*cdd.PrinterDescriptionSection, *cdd.PrinterStateSection, string, string, string, map[string]string) {
 	var name, info string
 	if n, ok := printerTags[attrPrinterName]; ok && len(n) > 0 {
 		name = n[0]
@@ -251,7 +249,7 @@ func convertMarkers(printerTags map[string][]string) (*[]cdd.Marker, *cdd.Marker
 	}
 
 	markers := make([]cdd.Marker, 0, len(names))
-	states := cdd.MarkerState{make([]cdd.MarkerStateItem, 0, len(names))}
+	states := cdd.MarkerState{Item: make([]cdd.MarkerStateItem, 0, len(names))}
 	for i := 0; i < len(names); i++ {
 		if len(names[i]) == 0 {
 			return nil, nil
@@ -333,7 +331,7 @@ func convertMarkers(printerTags map[string][]string) (*[]cdd.Marker, *cdd.Marker
 	return &markers, &states
 }
 
-//fixMarkers corrects some drivers' marker names/types where CUPS detects names/types with a comma
+// fixMarkers corrects some drivers' marker names/types where CUPS detects names/types with a comma
 // as two separate values. The second value of these pairs contain a space, so it's easy to detect.
 func fixMarkers(values []string) []string {
 	var newValues []string
@@ -445,18 +443,18 @@ func convertCopies(printerTags map[string][]string) *cdd.Copies {
 
 var colorByKeyword = map[string]cdd.ColorOption{
 	"auto": cdd.ColorOption{
-		VendorID: attrPrintColorMode + internalKeySeparator + "auto",
-		Type:     cdd.ColorTypeAuto,
+		VendorID:                   attrPrintColorMode + internalKeySeparator + "auto",
+		Type:                       cdd.ColorTypeAuto,
 		CustomDisplayNameLocalized: cdd.NewLocalizedString("Auto"),
 	},
 	"color": cdd.ColorOption{
-		VendorID: attrPrintColorMode + internalKeySeparator + "color",
-		Type:     cdd.ColorTypeStandardColor,
+		VendorID:                   attrPrintColorMode + internalKeySeparator + "color",
+		Type:                       cdd.ColorTypeStandardColor,
 		CustomDisplayNameLocalized: cdd.NewLocalizedString("Color"),
 	},
 	"monochrome": cdd.ColorOption{
-		VendorID: attrPrintColorMode + internalKeySeparator + "monochrome",
-		Type:     cdd.ColorTypeStandardMonochrome,
+		VendorID:                   attrPrintColorMode + internalKeySeparator + "monochrome",
+		Type:                       cdd.ColorTypeStandardMonochrome,
 		CustomDisplayNameLocalized: cdd.NewLocalizedString("Monochrome"),
 	},
 }
@@ -479,8 +477,8 @@ func convertColorAttrs(printerTags map[string][]string) *cdd.Color {
 		var exists bool
 		if co, exists = colorByKeyword[color]; !exists {
 			co = cdd.ColorOption{
-				VendorID: attrPrintColorMode + internalKeySeparator + color,
-				Type:     cdd.ColorTypeCustomColor,
+				VendorID:                   attrPrintColorMode + internalKeySeparator + color,
+				Type:                       cdd.ColorTypeCustomColor,
 				CustomDisplayNameLocalized: cdd.NewLocalizedString(color),
 			}
 		}
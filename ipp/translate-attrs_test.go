@@ -4,9 +4,7 @@
 // license that can be found in the LICENSE file or at
 // https://developers.google.com/open-source/licenses/bsd
 
-// +build linux darwin freebsd
-
-package cups
+package ipp
 
 import (
 	"encoding/json"
@@ -294,7 +292,7 @@ func TestConvertMarkers(t *testing.T) {
 			VendorID: "rainbow",
 			Type:     cdd.MarkerInk,
 			Color: &cdd.MarkerColor{
-				Type: cdd.MarkerColorCustom,
+				Type:                       cdd.MarkerColorCustom,
 				CustomDisplayNameLocalized: cdd.NewLocalizedString("rainbow"),
 			},
 		},
@@ -361,7 +359,7 @@ func TestConvertMarkers(t *testing.T) {
 			VendorID: "rainbow",
 			Type:     cdd.MarkerInk,
 			Color: &cdd.MarkerColor{
-				Type: cdd.MarkerColorCustom,
+				Type:                       cdd.MarkerColorCustom,
 				CustomDisplayNameLocalized: cdd.NewLocalizedString("rainbow"),
 			},
 		},
@@ -585,10 +583,10 @@ func TestConvertColorAttrs(t *testing.T) {
 	}
 	expected := &cdd.Color{
 		Option: []cdd.ColorOption{
-			cdd.ColorOption{"print-color-mode:color", cdd.ColorTypeStandardColor, "", false, cdd.NewLocalizedString("Color")},
-			cdd.ColorOption{"print-color-mode:monochrome", cdd.ColorTypeStandardMonochrome, "", false, cdd.NewLocalizedString("Monochrome")},
-			cdd.ColorOption{"print-color-mode:auto", cdd.ColorTypeAuto, "", true, cdd.NewLocalizedString("Auto")},
-			cdd.ColorOption{"print-color-mode:zebra", cdd.ColorTypeCustomColor, "", false, cdd.NewLocalizedString("zebra")},
+			cdd.ColorOption{VendorID: "print-color-mode:color", Type: cdd.ColorTypeStandardColor, CustomDisplayNameLocalized: cdd.NewLocalizedString("Color")},
+			cdd.ColorOption{VendorID: "print-color-mode:monochrome", Type: cdd.ColorTypeStandardMonochrome, CustomDisplayNameLocalized: cdd.NewLocalizedString("Monochrome")},
+			cdd.ColorOption{VendorID: "print-color-mode:auto", Type: cdd.ColorTypeAuto, IsDefault: true, CustomDisplayNameLocalized: cdd.NewLocalizedString("Auto")},
+			cdd.ColorOption{VendorID: "print-color-mode:zebra", Type: cdd.ColorTypeCustomColor, CustomDisplayNameLocalized: cdd.NewLocalizedString("zebra")},
 		},
 	}
 	c = convertColorAttrs(pt)
@@ -0,0 +1,83 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package ipp
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+// jobAttributeTags are the value tags of the job attributes, among those
+// that tickets can set, whose values are not keywords.
+var jobAttributeTags = map[string]byte{
+	attrCopies:               tagInteger,
+	attrJobPriority:          tagInteger,
+	attrNumberUp:             tagInteger,
+	attrOrientationRequested: tagEnum,
+}
+
+// translateTicket converts a CloudJobTicket to IPP job attributes, suitable
+// for a new IPP print job.
+func translateTicket(printer *lib.Printer, ticket *cdd.CloudJobTicket) ([]attribute, error) {
+	if printer == nil || ticket == nil {
+		return nil, nil
+	}
+
+	m := map[string]string{}
+	for _, vti := range ticket.Print.VendorTicketItem {
+		m[vti.ID] = vti.Value
+	}
+	if ticket.Print.Color != nil && printer.Description.Color != nil {
+		var colorString string
+		if ticket.Print.Color.VendorID != "" {
+			colorString = ticket.Print.Color.VendorID
+		} else {
+			// The ticket doesn't provide the VendorID. Let's find it by Type.
+			for _, colorOption := range printer.Description.Color.Option {
+				if ticket.Print.Color.Type == colorOption.Type {
+					colorString = colorOption.VendorID
+					break
+				}
+			}
+		}
+		if parts := strings.SplitN(colorString, internalKeySeparator, 2); len(parts) == 2 {
+			m[parts[0]] = parts[1]
+		}
+	}
+	if ticket.Print.Duplex != nil && printer.Description.Duplex != nil {
+		duplexString := printer.DuplexMap[ticket.Print.Duplex.Type]
+		if parts := strings.SplitN(duplexString, internalKeySeparator, 2); len(parts) == 2 {
+			m[parts[0]] = parts[1]
+		}
+	}
+	if ticket.Print.PageOrientation != nil && printer.Description.PageOrientation != nil {
+		if orientation, exists := orientationValueByType[ticket.Print.PageOrientation.Type]; exists {
+			m[attrOrientationRequested] = orientation
+		}
+	}
+	if ticket.Print.Copies != nil && printer.Description.Copies != nil {
+		m[attrCopies] = strconv.FormatInt(int64(ticket.Print.Copies.Copies), 10)
+	}
+
+	attributes := make([]attribute, 0, len(m))
+	for name, value := range m {
+		tag, exists := jobAttributeTags[name]
+		if !exists {
+			tag = tagKeyword
+		} else if _, err := strconv.ParseInt(value, 10, 32); err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, attribute{name, tag, []string{value}})
+	}
+
+	return attributes, nil
+}
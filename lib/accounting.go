@@ -0,0 +1,176 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Accounting file formats.
+const (
+	AccountingFormatJSON = "json"
+	AccountingFormatCSV  = "csv"
+)
+
+// AccountingRecord describes a finished job, for accounting.
+type AccountingRecord struct {
+	JobID  string    `json:"job_id"`
+	Origin JobOrigin `json:"origin"`
+	User   string    `json:"user"`
+
+	// PrinterName is the printer that the job was routed to. It differs
+	// from NativePrinterName when it is a pool, and the job was sent to a
+	// member of the pool.
+	PrinterName       string `json:"printer_name"`
+	NativePrinterName string `json:"native_printer_name,omitempty"`
+	NativeJobID       uint32 `json:"native_job_id,omitempty"`
+
+	// PagesPrinted is the count of impressions (printed sides) that the
+	// native print system reported, or of sheets when the printer does not
+	// report impressions.
+	PagesPrinted int32  `json:"pages_printed"`
+	Color        string `json:"color,omitempty"`
+	Duplex       string `json:"duplex,omitempty"`
+
+	ReceivedAt time.Time `json:"received_at"`
+	// SubmittedAt is nil for jobs that never reached the native print
+	// system.
+	SubmittedAt *time.Time `json:"submitted_at,omitempty"`
+	FinishedAt  time.Time  `json:"finished_at"`
+
+	// State is DONE or ABORTED. Cause is the cause of ABORTED, eg
+	// USER_ACTION:CANCELLED.
+	State string `json:"state"`
+	Cause string `json:"cause,omitempty"`
+}
+
+// AccountingCSVHeader is the first row of accounting files in CSV format.
+var AccountingCSVHeader = []string{
+	"job_id", "origin", "user", "printer_name", "native_printer_name", "native_job_id",
+	"pages_printed", "color", "duplex", "received_at", "submitted_at", "finished_at",
+	"state", "cause",
+}
+
+// CSV converts the record to a CSV row, in the order of AccountingCSVHeader.
+func (r *AccountingRecord) CSV() []string {
+	var nativeJobID, submittedAt string
+	if r.NativeJobID != 0 {
+		nativeJobID = strconv.FormatUint(uint64(r.NativeJobID), 10)
+	}
+	if r.SubmittedAt != nil {
+		submittedAt = r.SubmittedAt.Format(time.RFC3339)
+	}
+	return []string{
+		r.JobID, string(r.Origin), r.User, r.PrinterName, r.NativePrinterName, nativeJobID,
+		strconv.FormatInt(int64(r.PagesPrinted), 10), r.Color, r.Duplex,
+		r.ReceivedAt.Format(time.RFC3339), submittedAt, r.FinishedAt.Format(time.RFC3339),
+		r.State, r.Cause,
+	}
+}
+
+// parseAccountingCSV converts a CSV row, in the order of AccountingCSVHeader,
+// to a record.
+func parseAccountingCSV(row []string) (AccountingRecord, error) {
+	if len(row) != len(AccountingCSVHeader) {
+		return AccountingRecord{}, fmt.Errorf("Accounting record has %d fields, expected %d", len(row), len(AccountingCSVHeader))
+	}
+
+	r := AccountingRecord{
+		JobID:             row[0],
+		Origin:            JobOrigin(row[1]),
+		User:              row[2],
+		PrinterName:       row[3],
+		NativePrinterName: row[4],
+		Color:             row[7],
+		Duplex:            row[8],
+		State:             row[12],
+		Cause:             row[13],
+	}
+	if row[5] != "" {
+		nativeJobID, err := strconv.ParseUint(row[5], 10, 32)
+		if err != nil {
+			return AccountingRecord{}, fmt.Errorf("Failed to parse native job ID of accounting record: %s", err)
+		}
+		r.NativeJobID = uint32(nativeJobID)
+	}
+	pagesPrinted, err := strconv.ParseInt(row[6], 10, 32)
+	if err != nil {
+		return AccountingRecord{}, fmt.Errorf("Failed to parse pages printed of accounting record: %s", err)
+	}
+	r.PagesPrinted = int32(pagesPrinted)
+
+	if r.ReceivedAt, err = time.Parse(time.RFC3339, row[9]); err != nil {
+		return AccountingRecord{}, fmt.Errorf("Failed to parse received time of accounting record: %s", err)
+	}
+	if row[10] != "" {
+		submittedAt, err := time.Parse(time.RFC3339, row[10])
+		if err != nil {
+			return AccountingRecord{}, fmt.Errorf("Failed to parse submitted time of accounting record: %s", err)
+		}
+		r.SubmittedAt = &submittedAt
+	}
+	if r.FinishedAt, err = time.Parse(time.RFC3339, row[11]); err != nil {
+		return AccountingRecord{}, fmt.Errorf("Failed to parse finished time of accounting record: %s", err)
+	}
+
+	return r, nil
+}
+
+// ReadAccountingRecords reads an accounting file in either format. The
+// format is told apart by the first character of the file.
+func ReadAccountingRecords(r io.Reader) ([]AccountingRecord, error) {
+	br := bufio.NewReader(r)
+	first, err := br.Peek(1)
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var records []AccountingRecord
+
+	if bytes.Equal(first, []byte("{")) {
+		d := json.NewDecoder(br)
+		for {
+			var record AccountingRecord
+			if err := d.Decode(&record); err == io.EOF {
+				return records, nil
+			} else if err != nil {
+				return nil, err
+			}
+			records = append(records, record)
+		}
+	}
+
+	cr := csv.NewReader(br)
+	cr.FieldsPerRecord = len(AccountingCSVHeader)
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if row[0] == AccountingCSVHeader[0] {
+			continue
+		}
+		record, err := parseAccountingCSV(row)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+}
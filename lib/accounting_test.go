@@ -0,0 +1,84 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadAccountingRecords(t *testing.T) {
+	received := time.Date(2016, 9, 1, 10, 0, 0, 0, time.UTC)
+	submitted := received.Add(time.Second)
+	records := []AccountingRecord{
+		AccountingRecord{
+			JobID:             "job1",
+			Origin:            JobOriginCloud,
+			User:              "joe@example.com",
+			PrinterName:       "pool",
+			NativePrinterName: "member",
+			NativeJobID:       7,
+			PagesPrinted:      3,
+			Color:             "STANDARD_COLOR",
+			Duplex:            "LONG_EDGE",
+			ReceivedAt:        received,
+			SubmittedAt:       &submitted,
+			FinishedAt:        received.Add(time.Minute),
+			State:             "DONE",
+		},
+		AccountingRecord{
+			JobID:       "job2",
+			Origin:      JobOriginPrivet,
+			User:        "jane, the boss",
+			PrinterName: "gone",
+			ReceivedAt:  received,
+			FinishedAt:  received,
+			State:       "ABORTED",
+			Cause:       "SERVICE_ACTION:PRINTER_DELETED",
+		},
+	}
+
+	var j bytes.Buffer
+	for _, record := range records {
+		b, err := json.Marshal(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		j.Write(b)
+		j.WriteByte('\n')
+	}
+
+	var c bytes.Buffer
+	w := csv.NewWriter(&c)
+	w.Write(AccountingCSVHeader)
+	for _, record := range records {
+		w.Write(record.CSV())
+	}
+	w.Flush()
+
+	for format, b := range map[string]*bytes.Buffer{"json": &j, "csv": &c} {
+		read, err := ReadAccountingRecords(b)
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		if !reflect.DeepEqual(records, read) {
+			t.Logf("%s: expected %+v, got %+v", format, records, read)
+			t.Fail()
+		}
+	}
+
+	if read, err := ReadAccountingRecords(&bytes.Buffer{}); err != nil || len(read) != 0 {
+		t.Logf("expected no records from empty file, got %+v, %v", read, err)
+		t.Fail()
+	}
+}
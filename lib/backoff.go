@@ -23,7 +23,13 @@ const (
 
 // Backoff provides a mechanism for determining a good amount of time before
 // retrying an operation.
+//
+// The exported fields are optional; zero values mean the defaults.
 type Backoff struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	MaxElapsedTime  time.Duration
+
 	interval    time.Duration
 	elapsedTime time.Duration
 }
@@ -33,7 +39,10 @@ type Backoff struct {
 func (b *Backoff) Pause() (time.Duration, bool) {
 	if b.interval == 0 {
 		// first time
-		b.interval = initialRetryInterval
+		b.interval = b.InitialInterval
+		if b.interval == 0 {
+			b.interval = initialRetryInterval
+		}
 		b.elapsedTime = 0
 	}
 
@@ -41,14 +50,22 @@ func (b *Backoff) Pause() (time.Duration, bool) {
 	randomizedInterval := time.Duration((rand.Float64()*(2*randomizationFactor) + (1 - randomizationFactor)) * float64(b.interval))
 	b.elapsedTime += randomizedInterval
 
-	if b.elapsedTime > maxElapsedTime {
+	elapsedCap := b.MaxElapsedTime
+	if elapsedCap == 0 {
+		elapsedCap = maxElapsedTime
+	}
+	if b.elapsedTime > elapsedCap {
 		return 0, false
 	}
 
 	// Increase interval up to the interval cap
+	intervalCap := b.MaxInterval
+	if intervalCap == 0 {
+		intervalCap = maxInterval
+	}
 	b.interval = time.Duration(float64(b.interval) * multiplier)
-	if b.interval > maxInterval {
-		b.interval = maxInterval
+	if b.interval > intervalCap {
+		b.interval = intervalCap
 	}
 
 	return randomizedInterval, true
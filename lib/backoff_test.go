@@ -45,3 +45,28 @@ func TestBackoffTimeout(t *testing.T) {
 		t.Fatalf("waited too long: %s > %s", elapsed, maxElapsedTime)
 	}
 }
+
+func TestBackoffCustomLimits(t *testing.T) {
+	b := &Backoff{
+		InitialInterval: time.Second,
+		MaxInterval:     2 * time.Second,
+		MaxElapsedTime:  10 * time.Second,
+	}
+	var elapsed time.Duration
+	for i := 0; i < 100; i++ {
+		p, ok := b.Pause()
+		if !ok {
+			break
+		}
+		if p > 3*time.Second {
+			t.Fatalf("paused longer than the randomized interval cap: %s", p)
+		}
+		elapsed += p
+	}
+	if _, ok := b.Pause(); ok {
+		t.Fatalf("did not hit the pause timeout")
+	}
+	if elapsed > 10*time.Second {
+		t.Fatalf("waited too long: %s > %s", elapsed, 10*time.Second)
+	}
+}
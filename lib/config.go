@@ -13,7 +13,9 @@ import (
 	"io/ioutil"
 	"reflect"
 	"runtime"
+	"strings"
 
+	"github.com/google/cloud-print-connector/cdd"
 	"github.com/urfave/cli"
 )
 
@@ -42,6 +44,193 @@ var (
 	FullName = ConnectorName + " for " + platformName + " version " + BuildDate + "-" + runtime.GOOS
 )
 
+// RetryPolicy describes when and how often to retry a job that failed to
+// submit to the native print system.
+type RetryPolicy struct {
+	// Maximum quantity of submission attempts, including the first one.
+	MaxAttempts uint `json:"max_attempts,omitempty"`
+
+	// Interval (eg 1s, 1m) before the first retry.
+	InitialInterval string `json:"initial_interval,omitempty"`
+
+	// Interval (eg 1s, 1m) that the backoff between retries is capped at.
+	MaxInterval string `json:"max_interval,omitempty"`
+
+	// Regular expressions matched against the submission error. Only
+	// matching errors are retried.
+	RetryableErrors []string `json:"retryable_errors,omitempty"`
+}
+
+// JobTimeouts limits how long native jobs may take. Jobs that take longer
+// are canceled, and reported ABORTED.
+type JobTimeouts struct {
+	// Time (eg 2h) from submission until the job must be finished.
+	MaxDuration string `json:"max_duration,omitempty"`
+
+	// Time (eg 30m) that the job may stay STOPPED, eg behind a paper jam.
+	MaxStopped string `json:"max_stopped,omitempty"`
+}
+
+// PrinterPool describes a virtual printer that prints each job on one of
+// several native printers.
+type PrinterPool struct {
+	// Name of the virtual printer. Must not be the name of a native printer.
+	Name string `json:"name"`
+
+	// Display name of the virtual printer. Defaults to name.
+	DisplayName string `json:"display_name,omitempty"`
+
+	// Names of the native printers that print the jobs.
+	Members []string `json:"members"`
+
+	// Do not register the members as printers of their own.
+	HideMembers bool `json:"hide_members,omitempty"`
+}
+
+// RoutingRule describes a job, and what to do with jobs that fit the
+// description. Empty conditions match every job.
+type RoutingRule struct {
+	// Name of the rule, for logging.
+	Name string `json:"name,omitempty"`
+
+	// Native printer names that the rule applies to.
+	Printers []string `json:"printers,omitempty"`
+
+	// Regular expressions matched against the job owner and job title.
+	Owner string `json:"owner,omitempty"`
+	Title string `json:"title,omitempty"`
+
+	// Document content types, eg application/pdf.
+	ContentTypes []string `json:"content_types,omitempty"`
+
+	// Page count limits, inclusive. Jobs with an unknown page count never
+	// match page count limits.
+	MinPages int `json:"min_pages,omitempty"`
+	MaxPages int `json:"max_pages,omitempty"`
+
+	// Ticket values, eg STANDARD_COLOR, LONG_EDGE.
+	Color  string `json:"color,omitempty"`
+	Duplex string `json:"duplex,omitempty"`
+
+	// What to do with matching jobs: redirect, reject or rewrite.
+	// Rules are evaluated in order; the first redirect or reject rule that
+	// matches ends the evaluation.
+	Action string `json:"action"`
+
+	// Native printer name that redirect sends the job to.
+	Printer string `json:"printer,omitempty"`
+
+	// Service action cause (eg INCONSISTENT_JOB) that reject reports.
+	// Defaults to OTHER.
+	RejectCause string `json:"reject_cause,omitempty"`
+
+	// Ticket items that rewrite replaces.
+	Ticket *cdd.PrintTicketSection `json:"ticket,omitempty"`
+}
+
+// AccountingLog describes the files that a record of each finished job is
+// appended to.
+type AccountingLog struct {
+	// File name, full path. Records are appended to this file across
+	// restarts, and full files are rolled to names with a number suffix, eg
+	// accounting.0.
+	FileName string `json:"file_name"`
+
+	// Record format: json, for one JSON object per line (the default), or
+	// csv.
+	Format string `json:"format,omitempty"`
+
+	// Max size of a file before it is rolled, in megabytes. Defaults to 10.
+	FileMaxMegabytes uint `json:"file_max_megabytes,omitempty"`
+
+	// Max quantity of rolled files to keep. Only full files are rolled, so
+	// about max_files * file_max_megabytes of records are kept, however often
+	// the connector restarts. Defaults to 12.
+	MaxFiles uint `json:"max_files,omitempty"`
+}
+
+// JobHook describes an executable or HTTP endpoint that is called with a
+// JSON description of each job at a point in the life of the job.
+type JobHook struct {
+	// Name of the hook, for logging.
+	Name string `json:"name,omitempty"`
+
+	// When to call the hook: received, when the job is received; submit,
+	// before the job is submitted to the native print system; or finished,
+	// when the job is DONE or ABORTED. Submit hooks may reject the job or
+	// replace its ticket.
+	Event string `json:"event"`
+
+	// Native printer names that the hook applies to. Empty applies to all.
+	Printers []string `json:"printers,omitempty"`
+
+	// Executable and arguments to run, with the description on standard
+	// input. Exactly one of command and url must be set.
+	Command []string `json:"command,omitempty"`
+
+	// URL that the description is POSTed to.
+	URL string `json:"url,omitempty"`
+
+	// Time (eg 5s, 1m) to wait for the hook to answer. Defaults to 10s.
+	Timeout string `json:"timeout,omitempty"`
+
+	// What to do when the hook fails or times out: ignore (the default), or
+	// reject the job. Only submit hooks may reject.
+	FailurePolicy string `json:"failure_policy,omitempty"`
+}
+
+// JobPriority gives a priority to the jobs that match it. Jobs wait for
+// their printer in priority order, highest first. Priorities range from 1
+// to 100, like the IPP job-priority attribute.
+type JobPriority struct {
+	// Name of the rule, for logging.
+	Name string `json:"name,omitempty"`
+
+	// Native printer names that the rule applies to. Empty applies to all.
+	Printers []string `json:"printers,omitempty"`
+
+	// Job owners (eg joe@example.com) whose jobs match.
+	Users []string `json:"users,omitempty"`
+
+	// Local groups whose members' jobs match. The owner joe@example.com is
+	// looked up as the local user joe.
+	Groups []string `json:"groups,omitempty"`
+
+	// Vendor ticket item whose value, when it is a number from 1 to 100, is
+	// the priority of the job. Jobs without the item don't match.
+	VendorTicketItem string `json:"vendor_ticket_item,omitempty"`
+
+	// Priority of matching jobs, from 1 to 100. Ignored when the priority
+	// is taken from a vendor ticket item.
+	Priority int `json:"priority,omitempty"`
+}
+
+// MaintenanceWindow describes a recurring time when printers are not
+// available, eg nightly maintenance or weekend shutdown.
+type MaintenanceWindow struct {
+	// Name of the window, for logging.
+	Name string `json:"name,omitempty"`
+
+	// Native printer or printer pool names that the window applies to.
+	// Empty applies to all.
+	Printers []string `json:"printers,omitempty"`
+
+	// Days (eg mon, sat) when the window starts. Empty is every day.
+	Days []string `json:"days,omitempty"`
+
+	// Local times (eg 22:00, 06:30) when the window starts and ends. A
+	// window that ends at or before its start ends the next day.
+	Start string `json:"start"`
+	End   string `json:"end"`
+
+	// Message shown as the vendor state of the printers during the window.
+	Message string `json:"message,omitempty"`
+
+	// What to do with jobs received during the window: hold (the default),
+	// to print them when the window ends, or reject.
+	JobPolicy string `json:"job_policy,omitempty"`
+}
+
 // PointerToBool converts a boolean value (constant) to a pointer-to-bool.
 func PointerToBool(b bool) *bool {
 	return &b
@@ -76,6 +265,24 @@ func GetConfig(context *cli.Context) (*Config, string, error) {
 	return b, cf, nil
 }
 
+// ChangedKeys returns the JSON keys of the fields that differ between this
+// Config and other.
+func (c *Config) ChangedKeys(other *Config) []string {
+	var keys []string
+	a, b := reflect.ValueOf(c).Elem(), reflect.ValueOf(other).Elem()
+	for i := 0; i < a.NumField(); i++ {
+		if reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			continue
+		}
+		key := strings.Split(a.Type().Field(i).Tag.Get("json"), ",")[0]
+		if key == "" {
+			key = a.Type().Field(i).Name
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
 // ToFile writes this Config object to the config file indicated by ConfigFile.
 func (c *Config) ToFile(context *cli.Context) (string, error) {
 	b, err := json.MarshalIndent(c, "", "  ")
@@ -130,6 +337,10 @@ func (c *Config) commonSparse(context *cli.Context) *Config {
 		s.GCPMaxConcurrentDownloads == DefaultConfig.GCPMaxConcurrentDownloads {
 		s.GCPMaxConcurrentDownloads = 0
 	}
+	if !context.IsSet("gcp-download-max-retries") &&
+		s.GCPDownloadMaxRetries == DefaultConfig.GCPDownloadMaxRetries {
+		s.GCPDownloadMaxRetries = 0
+	}
 	if !context.IsSet("native-job-queue-size") &&
 		s.NativeJobQueueSize == DefaultConfig.NativeJobQueueSize {
 		s.NativeJobQueueSize = 0
@@ -138,6 +349,27 @@ func (c *Config) commonSparse(context *cli.Context) *Config {
 		s.NativePrinterPollInterval == DefaultConfig.NativePrinterPollInterval {
 		s.NativePrinterPollInterval = ""
 	}
+	if s.PrinterSyncMaxConcurrency == DefaultConfig.PrinterSyncMaxConcurrency {
+		s.PrinterSyncMaxConcurrency = 0
+	}
+	if s.PrinterSyncRateLimit == DefaultConfig.PrinterSyncRateLimit {
+		s.PrinterSyncRateLimit = 0
+	}
+	if s.PrinterSyncRateBurst == DefaultConfig.PrinterSyncRateBurst {
+		s.PrinterSyncRateBurst = 0
+	}
+	if s.JobJournalFilename == DefaultConfig.JobJournalFilename {
+		s.JobJournalFilename = ""
+	}
+	if s.QuotaLedgerFilename == DefaultConfig.QuotaLedgerFilename {
+		s.QuotaLedgerFilename = ""
+	}
+	if s.ShutdownDrainTimeout == DefaultConfig.ShutdownDrainTimeout {
+		s.ShutdownDrainTimeout = ""
+	}
+	if reflect.DeepEqual(s.NativeJobRetryPolicy, DefaultConfig.NativeJobRetryPolicy) {
+		s.NativeJobRetryPolicy = nil
+	}
 	if !context.IsSet("cups-job-full-username") &&
 		reflect.DeepEqual(s.CUPSJobFullUsername, DefaultConfig.CUPSJobFullUsername) {
 		s.CUPSJobFullUsername = nil
@@ -198,12 +430,36 @@ func (c *Config) commonBackfill(configMap map[string]interface{}) *Config {
 	if _, exists := configMap["gcp_max_concurrent_downloads"]; !exists {
 		b.GCPMaxConcurrentDownloads = DefaultConfig.GCPMaxConcurrentDownloads
 	}
+	if _, exists := configMap["gcp_download_max_retries"]; !exists {
+		b.GCPDownloadMaxRetries = DefaultConfig.GCPDownloadMaxRetries
+	}
 	if _, exists := configMap["cups_job_queue_size"]; !exists {
 		b.NativeJobQueueSize = DefaultConfig.NativeJobQueueSize
 	}
 	if _, exists := configMap["cups_printer_poll_interval"]; !exists {
 		b.NativePrinterPollInterval = DefaultConfig.NativePrinterPollInterval
 	}
+	if _, exists := configMap["printer_sync_max_concurrency"]; !exists {
+		b.PrinterSyncMaxConcurrency = DefaultConfig.PrinterSyncMaxConcurrency
+	}
+	if _, exists := configMap["printer_sync_rate_limit"]; !exists {
+		b.PrinterSyncRateLimit = DefaultConfig.PrinterSyncRateLimit
+	}
+	if _, exists := configMap["printer_sync_rate_burst"]; !exists {
+		b.PrinterSyncRateBurst = DefaultConfig.PrinterSyncRateBurst
+	}
+	if _, exists := configMap["job_journal_filename"]; !exists {
+		b.JobJournalFilename = DefaultConfig.JobJournalFilename
+	}
+	if _, exists := configMap["quota_ledger_filename"]; !exists {
+		b.QuotaLedgerFilename = DefaultConfig.QuotaLedgerFilename
+	}
+	if _, exists := configMap["shutdown_drain_timeout"]; !exists {
+		b.ShutdownDrainTimeout = DefaultConfig.ShutdownDrainTimeout
+	}
+	if _, exists := configMap["native_job_retry_policy"]; !exists {
+		b.NativeJobRetryPolicy = DefaultConfig.NativeJobRetryPolicy
+	}
 	if _, exists := configMap["cups_job_full_username"]; !exists {
 		b.CUPSJobFullUsername = DefaultConfig.CUPSJobFullUsername
 	}
@@ -0,0 +1,31 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChangedKeys(t *testing.T) {
+	a := DefaultConfig
+	b := DefaultConfig
+	if keys := a.ChangedKeys(&b); len(keys) != 0 {
+		t.Logf("expected no changed keys, got %v", keys)
+		t.Fail()
+	}
+
+	b.LogLevel = "DEBUG"
+	b.PrinterBlacklist = []string{"printer"}
+	expected := []string{"printer_blacklist", "log_level"}
+	if keys := a.ChangedKeys(&b); !reflect.DeepEqual(keys, expected) {
+		t.Logf("expected %v, got %v", expected, keys)
+		t.Fail()
+	}
+}
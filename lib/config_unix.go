@@ -84,6 +84,10 @@ type Config struct {
 	// Maximum quantity of jobs (data) to download concurrently.
 	GCPMaxConcurrentDownloads uint `json:"gcp_max_concurrent_downloads,omitempty"`
 
+	// Maximum quantity of times to resume a job download that was
+	// interrupted, before giving up on the job.
+	GCPDownloadMaxRetries uint `json:"gcp_download_max_retries,omitempty"`
+
 	// CUPS job queue size, must be greater than zero.
 	// TODO: rename without cups_ prefix
 	NativeJobQueueSize uint `json:"cups_job_queue_size,omitempty"`
@@ -92,6 +96,69 @@ type Config struct {
 	// TODO: rename without cups_ prefix
 	NativePrinterPollInterval string `json:"cups_printer_poll_interval,omitempty"`
 
+	// Maximum quantity of printers to register, update or delete at once
+	// while synchronizing printers. Zero is unlimited.
+	PrinterSyncMaxConcurrency uint `json:"printer_sync_max_concurrency,omitempty"`
+
+	// Maximum rate (calls per second) of GCP calls while synchronizing
+	// printers, and the quantity of calls allowed at once after a pause.
+	// Zero rate is unlimited.
+	PrinterSyncRateLimit float64 `json:"printer_sync_rate_limit,omitempty"`
+	PrinterSyncRateBurst uint    `json:"printer_sync_rate_burst,omitempty"`
+
+	// File where jobs in flight are recorded, so that they can be followed
+	// to completion after a restart. Empty disables the journal.
+	JobJournalFilename string `json:"job_journal_filename,omitempty"`
+
+	// File where the pages printed by each user today are counted, to
+	// enforce the daily quotas of printers. Empty counts in memory only.
+	// Local (Privet) jobs are counted by the user name that their client
+	// claims, which is not authenticated.
+	QuotaLedgerFilename string `json:"quota_ledger_filename,omitempty"`
+
+	// Files that a record of each finished job is appended to, for
+	// accounting. Absent disables accounting.
+	AccountingLog *AccountingLog `json:"accounting_log,omitempty"`
+
+	// Time (eg 30s, 2m) to wait on shutdown for jobs in flight to finish.
+	// Jobs still in flight after this time are reported as QUEUED when they
+	// were not yet submitted, or left IN_PROGRESS, to be followed again
+	// after restart, when they were.
+	ShutdownDrainTimeout string `json:"shutdown_drain_timeout,omitempty"`
+
+	// Retry policy for jobs that fail to submit to CUPS.
+	NativeJobRetryPolicy *RetryPolicy `json:"native_job_retry_policy,omitempty"`
+
+	// Retry policies for specific printers, by native printer name. Empty
+	// fields are taken from native_job_retry_policy.
+	PrinterJobRetryPolicies map[string]RetryPolicy `json:"printer_job_retry_policies,omitempty"`
+
+	// Limits on how long native jobs may take. Empty is no limit.
+	NativeJobTimeouts *JobTimeouts `json:"native_job_timeouts,omitempty"`
+
+	// Limits for specific printers, by native printer name. Empty fields
+	// are taken from native_job_timeouts.
+	PrinterJobTimeouts map[string]JobTimeouts `json:"printer_job_timeouts,omitempty"`
+
+	// Virtual printers that send each job to the least busy of their
+	// member printers.
+	PrinterPools []PrinterPool `json:"printer_pools,omitempty"`
+
+	// Rules that redirect, reject or rewrite jobs as they are received.
+	RoutingRules []RoutingRule `json:"routing_rules,omitempty"`
+
+	// Executables and HTTP endpoints to call as jobs are received, submitted
+	// and finished.
+	JobHooks []JobHook `json:"job_hooks,omitempty"`
+
+	// Rules that give priorities to jobs; the first rule that matches a job
+	// sets its priority. Jobs that match no rule have priority 50.
+	JobPriorities []JobPriority `json:"job_priorities,omitempty"`
+
+	// Times when printers are not available. During a window, printers are
+	// reported STOPPED, and jobs are held or rejected.
+	MaintenanceWindows []MaintenanceWindow `json:"maintenance_windows,omitempty"`
+
 	// Use the full username (joe@example.com) in job.
 	// TODO: rename without cups_ prefix
 	CUPSJobFullUsername *bool `json:"cups_job_full_username,omitempty"`
@@ -117,6 +184,18 @@ type Config struct {
 	// Local only: HTTP API port range, high.
 	LocalPortHigh uint16 `json:"local_port_high,omitempty"`
 
+	// Address to serve Prometheus metrics at /metrics, eg localhost:9100.
+	// Empty is disabled.
+	PrometheusListenAddress string `json:"prometheus_listen_address,omitempty"`
+
+	// Address to serve health checks at /livez and /readyz, eg
+	// localhost:9101. Empty is disabled.
+	HealthListenAddress string `json:"health_listen_address,omitempty"`
+
+	// Loopback address to serve a read-only status page at /, and the same
+	// data at /status.json, eg localhost:9102. Empty is disabled.
+	StatusPageListenAddress string `json:"status_page_listen_address,omitempty"`
+
 	// CUPS only: Where to place log file.
 	LogFileName string `json:"log_file_name"`
 
@@ -153,6 +232,35 @@ type Config struct {
 	// CUPS only: copy the CUPS printer's printer-info attribute to the GCP printer's defaultDisplayName.
 	// TODO: rename with cups_ prefix
 	CUPSCopyPrinterInfoToDisplayName *bool `json:"copy_printer_info_to_display_name,omitempty"`
+
+	// CUPS only: printers whose jobs are held until released with a PIN.
+	CUPSSecureReleasePrinters []string `json:"cups_secure_release_printers,omitempty"`
+
+	// CUPS only: time (eg 1h, 30m) after which jobs that were not released are canceled.
+	CUPSSecureReleaseTimeout string `json:"cups_secure_release_timeout,omitempty"`
+
+	// CUPS and IPP only: set the job-priority of native jobs to the
+	// priority from job_priorities. Jobs wait in priority order only until
+	// they are submitted; after that, the native print system decides the
+	// order, so this keeps it in the same order. Defaults to true.
+	NativeJobPriority *bool `json:"native_job_priority,omitempty"`
+
+	// Native print system to use: cups, or ipp to talk to printers directly.
+	NativePrintSystem string `json:"native_print_system,omitempty"`
+
+	// IPP only: printer URIs (eg ipps://10.0.0.5/ipp/print), by printer name.
+	IPPPrinters map[string]string `json:"ipp_printers,omitempty"`
+
+	// IPP only: timeout for IPP requests to printers.
+	IPPRequestTimeout string `json:"ipp_request_timeout,omitempty"`
+
+	// IPP only: file of PEM certificates of CAs that ipps printers are
+	// trusted with, in addition to the system CAs.
+	IPPCAFile string `json:"ipp_ca_file,omitempty"`
+
+	// IPP only: trust ipps printers without verifying their certificates,
+	// eg self-signed certificates.
+	IPPInsecureSkipVerify bool `json:"ipp_insecure_skip_verify,omitempty"`
 }
 
 // DefaultConfig represents reasonable default values for Config fields.
@@ -174,15 +282,35 @@ var DefaultConfig = Config{
 	GCPOAuthAuthURL:           "https://accounts.google.com/o/oauth2/auth",
 	GCPOAuthTokenURL:          "https://accounts.google.com/o/oauth2/token",
 	GCPMaxConcurrentDownloads: 5,
+	GCPDownloadMaxRetries:     5,
 
 	NativeJobQueueSize:        3,
 	NativePrinterPollInterval: "1m",
+	PrinterSyncMaxConcurrency: 10,
+	PrinterSyncRateLimit:      5,
+	PrinterSyncRateBurst:      10,
+	JobJournalFilename:        "/var/lib/cloud-print-connector/jobs.json",
+	QuotaLedgerFilename:       "/var/lib/cloud-print-connector/quota.json",
+	ShutdownDrainTimeout:      "30s",
 	PrefixJobIDToJobTitle:     PointerToBool(false),
 	DisplayNamePrefix:         "",
 	PrinterBlacklist:          []string{},
 	PrinterWhitelist:          []string{},
 	LogLevel:                  "INFO",
 
+	NativeJobRetryPolicy: &RetryPolicy{
+		MaxAttempts:     5,
+		InitialInterval: "1s",
+		MaxInterval:     "30s",
+		RetryableErrors: []string{
+			// CUPS is restarting, or not running.
+			"Failed to connect to CUPS server",
+			// CUPS is too busy to take the job.
+			"server-error-service-unavailable",
+			"server-error-busy",
+		},
+	},
+
 	LocalPortLow:  26000,
 	LocalPortHigh: 26999,
 
@@ -223,6 +351,11 @@ var DefaultConfig = Config{
 	CUPSIgnoreRawPrinters:            PointerToBool(true),
 	CUPSIgnoreClassPrinters:          PointerToBool(true),
 	CUPSCopyPrinterInfoToDisplayName: PointerToBool(true),
+	CUPSSecureReleaseTimeout:         "4h",
+	NativeJobPriority:                PointerToBool(true),
+
+	NativePrintSystem: "cups",
+	IPPRequestTimeout: "30s",
 }
 
 // getConfigFilename gets the absolute filename of the config file specified by
@@ -311,6 +444,18 @@ func (c *Config) Backfill(configMap map[string]interface{}) *Config {
 	if _, exists := configMap["copy_printer_info_to_display_name"]; !exists {
 		b.CUPSCopyPrinterInfoToDisplayName = DefaultConfig.CUPSCopyPrinterInfoToDisplayName
 	}
+	if _, exists := configMap["cups_secure_release_timeout"]; !exists {
+		b.CUPSSecureReleaseTimeout = DefaultConfig.CUPSSecureReleaseTimeout
+	}
+	if _, exists := configMap["native_job_priority"]; !exists {
+		b.NativeJobPriority = DefaultConfig.NativeJobPriority
+	}
+	if _, exists := configMap["native_print_system"]; !exists {
+		b.NativePrintSystem = DefaultConfig.NativePrintSystem
+	}
+	if _, exists := configMap["ipp_request_timeout"]; !exists {
+		b.IPPRequestTimeout = DefaultConfig.IPPRequestTimeout
+	}
 
 	return &b
 }
@@ -362,6 +507,18 @@ func (c *Config) Sparse(context *cli.Context) *Config {
 		reflect.DeepEqual(s.CUPSCopyPrinterInfoToDisplayName, DefaultConfig.CUPSCopyPrinterInfoToDisplayName) {
 		s.CUPSCopyPrinterInfoToDisplayName = nil
 	}
+	if s.CUPSSecureReleaseTimeout == DefaultConfig.CUPSSecureReleaseTimeout {
+		s.CUPSSecureReleaseTimeout = ""
+	}
+	if reflect.DeepEqual(s.NativeJobPriority, DefaultConfig.NativeJobPriority) {
+		s.NativeJobPriority = nil
+	}
+	if s.NativePrintSystem == DefaultConfig.NativePrintSystem {
+		s.NativePrintSystem = ""
+	}
+	if s.IPPRequestTimeout == DefaultConfig.IPPRequestTimeout {
+		s.IPPRequestTimeout = ""
+	}
 
 	return &s
 }
@@ -82,6 +82,10 @@ type Config struct {
 	// Maximum quantity of jobs (data) to download concurrently.
 	GCPMaxConcurrentDownloads uint `json:"gcp_max_concurrent_downloads,omitempty"`
 
+	// Maximum quantity of times to resume a job download that was
+	// interrupted, before giving up on the job.
+	GCPDownloadMaxRetries uint `json:"gcp_download_max_retries,omitempty"`
+
 	// Windows Spooler job queue size, must be greater than zero.
 	// TODO: rename without cups_ prefix
 	NativeJobQueueSize uint `json:"cups_job_queue_size,omitempty"`
@@ -90,6 +94,69 @@ type Config struct {
 	// TODO: rename without cups_ prefix
 	NativePrinterPollInterval string `json:"cups_printer_poll_interval,omitempty"`
 
+	// Maximum quantity of printers to register, update or delete at once
+	// while synchronizing printers. Zero is unlimited.
+	PrinterSyncMaxConcurrency uint `json:"printer_sync_max_concurrency,omitempty"`
+
+	// Maximum rate (calls per second) of GCP calls while synchronizing
+	// printers, and the quantity of calls allowed at once after a pause.
+	// Zero rate is unlimited.
+	PrinterSyncRateLimit float64 `json:"printer_sync_rate_limit,omitempty"`
+	PrinterSyncRateBurst uint    `json:"printer_sync_rate_burst,omitempty"`
+
+	// File where jobs in flight are recorded, so that they can be followed
+	// to completion after a restart. Empty disables the journal.
+	JobJournalFilename string `json:"job_journal_filename,omitempty"`
+
+	// File where the pages printed by each user today are counted, to
+	// enforce the daily quotas of printers. Empty counts in memory only.
+	// Local (Privet) jobs are counted by the user name that their client
+	// claims, which is not authenticated.
+	QuotaLedgerFilename string `json:"quota_ledger_filename,omitempty"`
+
+	// Files that a record of each finished job is appended to, for
+	// accounting. Absent disables accounting.
+	AccountingLog *AccountingLog `json:"accounting_log,omitempty"`
+
+	// Time (eg 30s, 2m) to wait on shutdown for jobs in flight to finish.
+	// Jobs still in flight after this time are reported as QUEUED when they
+	// were not yet submitted, or left IN_PROGRESS, to be followed again
+	// after restart, when they were.
+	ShutdownDrainTimeout string `json:"shutdown_drain_timeout,omitempty"`
+
+	// Retry policy for jobs that fail to submit to Windows Spooler.
+	NativeJobRetryPolicy *RetryPolicy `json:"native_job_retry_policy,omitempty"`
+
+	// Retry policies for specific printers, by native printer name. Empty
+	// fields are taken from native_job_retry_policy.
+	PrinterJobRetryPolicies map[string]RetryPolicy `json:"printer_job_retry_policies,omitempty"`
+
+	// Limits on how long native jobs may take. Empty is no limit.
+	NativeJobTimeouts *JobTimeouts `json:"native_job_timeouts,omitempty"`
+
+	// Limits for specific printers, by native printer name. Empty fields
+	// are taken from native_job_timeouts.
+	PrinterJobTimeouts map[string]JobTimeouts `json:"printer_job_timeouts,omitempty"`
+
+	// Virtual printers that send each job to the least busy of their
+	// member printers.
+	PrinterPools []PrinterPool `json:"printer_pools,omitempty"`
+
+	// Rules that redirect, reject or rewrite jobs as they are received.
+	RoutingRules []RoutingRule `json:"routing_rules,omitempty"`
+
+	// Executables and HTTP endpoints to call as jobs are received, submitted
+	// and finished.
+	JobHooks []JobHook `json:"job_hooks,omitempty"`
+
+	// Rules that give priorities to jobs; the first rule that matches a job
+	// sets its priority. Jobs that match no rule have priority 50.
+	JobPriorities []JobPriority `json:"job_priorities,omitempty"`
+
+	// Times when printers are not available. During a window, printers are
+	// reported STOPPED, and jobs are held or rejected.
+	MaintenanceWindows []MaintenanceWindow `json:"maintenance_windows,omitempty"`
+
 	// Use the full username (joe@example.com) in job.
 	// TODO: rename without cups_ prefix
 	CUPSJobFullUsername *bool `json:"cups_job_full_username,omitempty"`
@@ -114,6 +181,18 @@ type Config struct {
 
 	// Local only: HTTP API port range, high.
 	LocalPortHigh uint16 `json:"local_port_high,omitempty"`
+
+	// Address to serve Prometheus metrics at /metrics, eg localhost:9100.
+	// Empty is disabled.
+	PrometheusListenAddress string `json:"prometheus_listen_address,omitempty"`
+
+	// Address to serve health checks at /livez and /readyz, eg
+	// localhost:9101. Empty is disabled.
+	HealthListenAddress string `json:"health_listen_address,omitempty"`
+
+	// Loopback address to serve a read-only status page at /, and the same
+	// data at /status.json, eg localhost:9102. Empty is disabled.
+	StatusPageListenAddress string `json:"status_page_listen_address,omitempty"`
 }
 
 // DefaultConfig represents reasonable default values for Config fields.
@@ -131,9 +210,16 @@ var DefaultConfig = Config{
 	GCPOAuthAuthURL:           "https://accounts.google.com/o/oauth2/auth",
 	GCPOAuthTokenURL:          "https://accounts.google.com/o/oauth2/token",
 	GCPMaxConcurrentDownloads: 5,
+	GCPDownloadMaxRetries:     5,
 
 	NativeJobQueueSize:        3,
 	NativePrinterPollInterval: "1m",
+	PrinterSyncMaxConcurrency: 10,
+	PrinterSyncRateLimit:      5,
+	PrinterSyncRateBurst:      10,
+	JobJournalFilename:        "",
+	QuotaLedgerFilename:       "",
+	ShutdownDrainTimeout:      "20s",
 	CUPSJobFullUsername:       PointerToBool(false),
 	PrefixJobIDToJobTitle:     PointerToBool(false),
 	DisplayNamePrefix:         "",
@@ -149,6 +235,17 @@ var DefaultConfig = Config{
 	FcmNotificationsEnable: false,
 	LogLevel:               "INFO",
 
+	NativeJobRetryPolicy: &RetryPolicy{
+		MaxAttempts:     5,
+		InitialInterval: "1s",
+		MaxInterval:     "30s",
+		RetryableErrors: []string{
+			// The Print Spooler service is restarting, or not running.
+			"RPC server is unavailable",
+			"RPC server is too busy",
+		},
+	},
+
 	LocalPortLow:  26000,
 	LocalPortHigh: 26999,
 }
@@ -10,12 +10,23 @@ package lib
 
 import "github.com/google/cloud-print-connector/cdd"
 
+// JobOrigin identifies the API that a job was received from.
+type JobOrigin string
+
+const (
+	JobOriginCloud  JobOrigin = "cloud"
+	JobOriginPrivet JobOrigin = "privet"
+)
+
 type Job struct {
 	NativePrinterName string
 	Filename          string
 	Title             string
 	User              string
 	JobID             string
+	Origin            JobOrigin
+	ContentType       string
+	PageCount         int // Zero when unknown.
 	Ticket            *cdd.CloudJobTicket
 	UpdateJob         func(string, *cdd.PrintJobStateDiff) error
 }
@@ -0,0 +1,118 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// MonitorProtocolVersion is the version of the JSON protocol spoken on the
+// monitor socket. A client sends one MonitorRequest, as a line of JSON, and
+// receives one MonitorResponse. A client that sends nothing receives the
+// stats in the original key=value text format.
+const MonitorProtocolVersion = 1
+
+// Monitor commands.
+const (
+	MonitorCommandStats         = "stats"
+	MonitorCommandListPrinters  = "list-printers"
+	MonitorCommandListJobs      = "list-jobs"
+	MonitorCommandSyncNow       = "sync-now"
+	MonitorCommandPausePrinter  = "pause-printer"
+	MonitorCommandResumePrinter = "resume-printer"
+	MonitorCommandCancelJob     = "cancel-job"
+	MonitorCommandSetLogLevel   = "set-log-level"
+)
+
+// MonitorRequest is a command sent to the monitor socket. Printer is the
+// native printer name, for the printer commands.
+type MonitorRequest struct {
+	Version  int    `json:"version"`
+	Command  string `json:"command"`
+	Printer  string `json:"printer,omitempty"`
+	JobID    string `json:"job_id,omitempty"`
+	LogLevel string `json:"log_level,omitempty"`
+}
+
+// MonitorResponse is the answer to a MonitorRequest. Result depends on the
+// command, and is omitted when Error is set.
+type MonitorResponse struct {
+	Version int             `json:"version"`
+	Error   string          `json:"error,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+}
+
+// MonitorStats is the result of the stats command.
+type MonitorStats struct {
+	CUPSPrinters           int  `json:"cups_printers"`
+	CUPSRawPrinters        int  `json:"cups_raw_printers"`
+	GCPPrinters            int  `json:"gcp_printers"`
+	LocalPrinters          int  `json:"local_printers"`
+	CUPSConnQty            uint `json:"cups_conn_qty"`
+	CUPSConnMaxQty         uint `json:"cups_conn_max_qty"`
+	JobsDone               uint `json:"jobs_done"`
+	JobsError              uint `json:"jobs_error"`
+	JobsInProgress         uint `json:"jobs_in_progress"`
+	JobsTimedOut           uint `json:"jobs_timed_out"`
+	PrinterSyncDiffs       uint `json:"printer_sync_diffs"`
+	PrinterSyncDiffsDone   uint `json:"printer_sync_diffs_done"`
+	PrinterSyncDiffsFailed uint `json:"printer_sync_diffs_failed"`
+}
+
+// monitorStatsFormat is the original key=value text format of the stats.
+const monitorStatsFormat = `cups-printers=%d
+cups-raw-printers=%d
+gcp-printers=%d
+local-printers=%d
+cups-conn-qty=%d
+cups-conn-max-qty=%d
+jobs-done=%d
+jobs-error=%d
+jobs-in-progress=%d
+jobs-timed-out=%d
+printer-sync-diffs=%d
+printer-sync-diffs-done=%d
+printer-sync-diffs-failed=%d
+`
+
+// String formats the stats in the original key=value text format, one stat
+// per line.
+func (s *MonitorStats) String() string {
+	return fmt.Sprintf(monitorStatsFormat,
+		s.CUPSPrinters, s.CUPSRawPrinters, s.GCPPrinters, s.LocalPrinters,
+		s.CUPSConnQty, s.CUPSConnMaxQty,
+		s.JobsDone, s.JobsError, s.JobsInProgress, s.JobsTimedOut,
+		s.PrinterSyncDiffs, s.PrinterSyncDiffsDone, s.PrinterSyncDiffsFailed)
+}
+
+// MonitorPrinter is a printer in the result of the list-printers command.
+type MonitorPrinter struct {
+	Name   string `json:"name"`
+	GCPID  string `json:"gcp_id,omitempty"`
+	State  string `json:"state,omitempty"`
+	Paused bool   `json:"paused"`
+}
+
+// MonitorJob is a job in flight in the result of the list-jobs command, or
+// a recently finished job. The native fields are empty until the job is
+// submitted to the native print system. FinishedAt and Cause are set for
+// finished jobs.
+type MonitorJob struct {
+	JobID             string     `json:"job_id"`
+	Origin            JobOrigin  `json:"origin"`
+	PrinterName       string     `json:"printer_name"`
+	NativePrinterName string     `json:"native_printer_name,omitempty"`
+	NativeJobID       uint32     `json:"native_job_id,omitempty"`
+	State             string     `json:"state,omitempty"`
+	Cause             string     `json:"cause,omitempty"`
+	SubmittedAt       *time.Time `json:"submitted_at,omitempty"`
+	FinishedAt        *time.Time `json:"finished_at,omitempty"`
+}
@@ -42,6 +42,7 @@ type Printer struct {
 	QuotaEnabled        bool
 	DailyQuota          int
 	NotificationChannel string
+	HoldJobs            bool // Hold new jobs until released with ReleaseHeldJob.
 }
 
 var rDeviceURIHostname *regexp.Regexp = regexp.MustCompile(
@@ -126,6 +127,9 @@ func DiffPrinters(nativePrinters, gcpPrinters []Printer) []PrinterDiff {
 				nativePrinter.GCPID = gcpPrinters[i].GCPID
 				// Don't lose track of this semaphore.
 				nativePrinter.NativeJobSemaphore = gcpPrinters[i].NativeJobSemaphore
+				// Quotas are set in the cloud, not by the native print system.
+				nativePrinter.QuotaEnabled = gcpPrinters[i].QuotaEnabled
+				nativePrinter.DailyQuota = gcpPrinters[i].DailyQuota
 
 				diff := diffPrinter(&nativePrinter, &gcpPrinters[i])
 				diffs = append(diffs, diff)
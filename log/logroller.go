@@ -4,8 +4,6 @@
 // license that can be found in the LICENSE file or at
 // https://developers.google.com/open-source/licenses/bsd
 
-// +build linux darwin freebsd
-
 package log
 
 import (
@@ -29,6 +27,11 @@ type LogRoller struct {
 	maxFiles     uint
 
 	rollFormat string
+	// header is written at the start of each new file.
+	header []byte
+	// appendExisting continues the file at fileName, if it is not full,
+	// rather than rolling it when the roller opens its first file.
+	appendExisting bool
 
 	m        sync.Mutex
 	file     *os.File
@@ -53,6 +56,25 @@ func NewLogRoller(fileName string, fileMaxBytes, maxFiles uint) (*LogRoller, err
 	return &lr, nil
 }
 
+// SetHeader sets bytes to write at the start of each new file, eg the
+// header row of a CSV file.
+func (lr *LogRoller) SetHeader(header []byte) {
+	lr.m.Lock()
+	defer lr.m.Unlock()
+
+	lr.header = header
+}
+
+// SetAppend makes the roller append to the file at fileName, when it is not
+// full, instead of rolling it. Files are then rolled only when they are
+// full, so that restarts do not use up the maxFiles that are kept.
+func (lr *LogRoller) SetAppend(appendExisting bool) {
+	lr.m.Lock()
+	defer lr.m.Unlock()
+
+	lr.appendExisting = appendExisting
+}
+
 func (lr *LogRoller) Write(p []byte) (int, error) {
 	lr.m.Lock()
 	defer lr.m.Unlock()
@@ -80,6 +102,18 @@ func (lr *LogRoller) Write(p []byte) (int, error) {
 
 // openFile opens a new file for logging, rolling the oldest one if needed.
 func (lr *LogRoller) openFile() error {
+	if lr.appendExisting {
+		if fi, err := os.Stat(lr.fileName); err == nil && fi.Size() > 0 && uint(fi.Size()) <= lr.fileMaxBytes {
+			f, err := os.OpenFile(lr.fileName, os.O_WRONLY|os.O_APPEND, 0666)
+			if err != nil {
+				return err
+			}
+			lr.file = f
+			lr.fileSize = uint(fi.Size())
+			return nil
+		}
+	}
+
 	if err := lr.roll(); err != nil {
 		return err
 	}
@@ -90,6 +124,14 @@ func (lr *LogRoller) openFile() error {
 		lr.file = f
 	}
 
+	if len(lr.header) > 0 {
+		written, err := lr.file.Write(lr.header)
+		if err != nil {
+			return err
+		}
+		lr.fileSize += uint(written)
+	}
+
 	return nil
 }
 
@@ -9,6 +9,9 @@ https://developers.google.com/open-source/licenses/bsd
 package log
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"testing"
@@ -56,3 +59,35 @@ func TestSortableNumberStrings(t *testing.T) {
 	s = sortableNumberStrings{"0100", "10", "11", "10"}
 	testSort(t, s)
 }
+
+func TestLogRollerAppend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloud-print-connector-logroller-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "accounting")
+
+	// Each roller is a restart; the file is appended to until it is full.
+	for _, line := range []string{"a\n", "b\n", "c\n"} {
+		lr, err := NewLogRoller(fileName, 5, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lr.SetAppend(true)
+		lr.SetHeader([]byte("h\n"))
+		if _, err = lr.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		lr.file.Close()
+	}
+
+	if b, err := ioutil.ReadFile(fileName + ".0"); err != nil || string(b) != "h\na\nb\n" {
+		t.Logf("expected full file rolled once, got %q, %v", b, err)
+		t.Fail()
+	}
+	if b, err := ioutil.ReadFile(fileName); err != nil || string(b) != "h\nc\n" {
+		t.Logf("expected new file with header, got %q, %v", b, err)
+		t.Fail()
+	}
+}
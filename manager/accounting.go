@@ -0,0 +1,181 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+)
+
+const (
+	defaultAccountingFileMaxMegabytes = 10
+	defaultAccountingMaxFiles         = 12
+)
+
+// accountingLog appends a record of each finished job to rolling files.
+type accountingLog struct {
+	format string
+	w      io.Writer
+	mutex  sync.Mutex
+}
+
+// newAccountingLog opens the accounting files described by config. A nil
+// config disables accounting, and returns a nil accountingLog.
+func newAccountingLog(config *lib.AccountingLog) (*accountingLog, error) {
+	if config == nil {
+		return nil, nil
+	}
+	if config.FileName == "" {
+		return nil, fmt.Errorf("Accounting log has no file name")
+	}
+
+	format := config.Format
+	if format == "" {
+		format = lib.AccountingFormatJSON
+	}
+	if format != lib.AccountingFormatJSON && format != lib.AccountingFormatCSV {
+		return nil, fmt.Errorf("Accounting log has unknown format %q", config.Format)
+	}
+
+	fileMaxMegabytes := config.FileMaxMegabytes
+	if fileMaxMegabytes == 0 {
+		fileMaxMegabytes = defaultAccountingFileMaxMegabytes
+	}
+	maxFiles := config.MaxFiles
+	if maxFiles == 0 {
+		maxFiles = defaultAccountingMaxFiles
+	}
+
+	roller, err := log.NewLogRoller(config.FileName, fileMaxMegabytes*1024*1024, maxFiles)
+	if err != nil {
+		return nil, err
+	}
+	// Records from before a restart are kept in the same file, so that
+	// maxFiles counts only full files.
+	roller.SetAppend(true)
+	if format == lib.AccountingFormatCSV {
+		header, err := encodeCSV(lib.AccountingCSVHeader)
+		if err != nil {
+			return nil, err
+		}
+		roller.SetHeader(header)
+	}
+
+	return &accountingLog{format: format, w: roller}, nil
+}
+
+func encodeCSV(row []string) ([]byte, error) {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Write(row)
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// write appends a record. Each record is written all at once, so that it is
+// never split across files.
+func (al *accountingLog) write(record lib.AccountingRecord) error {
+	var b []byte
+	var err error
+	if al.format == lib.AccountingFormatCSV {
+		b, err = encodeCSV(record.CSV())
+	} else {
+		if b, err = json.Marshal(record); err == nil {
+			b = append(b, '\n')
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	_, err = al.w.Write(b)
+	return err
+}
+
+// jobStateCause describes the cause of a job state, eg USER_ACTION:CANCELLED.
+func jobStateCause(state *cdd.JobState) string {
+	switch {
+	case state.UserActionCause != nil:
+		return "USER_ACTION:" + string(state.UserActionCause.ActionCode)
+	case state.DeviceStateCause != nil:
+		return "DEVICE_STATE:" + string(state.DeviceStateCause.ErrorCode)
+	case state.DeviceActionCause != nil:
+		return "DEVICE_ACTION:" + string(state.DeviceActionCause.ErrorCode)
+	case state.ServiceActionCause != nil:
+		return "SERVICE_ACTION:" + string(state.ServiceActionCause.ErrorCode)
+	}
+	return ""
+}
+
+// accountFinishedJob wraps updateJob to append an accounting record when the
+// state of a job is reported DONE or ABORTED.
+//
+// The native job is looked up in the journal, so updateJob must be called
+// before the job is deleted from the journal.
+func (pm *PrinterManager) accountFinishedJob(job *lib.Job, receivedAt time.Time, updateJob func(string, *cdd.PrintJobStateDiff) error) func(string, *cdd.PrintJobStateDiff) error {
+	if pm.accounting == nil {
+		return updateJob
+	}
+
+	return func(jobID string, state *cdd.PrintJobStateDiff) error {
+		err := updateJob(jobID, state)
+		if state.State == nil ||
+			(state.State.Type != cdd.JobStateDone && state.State.Type != cdd.JobStateAborted) {
+			return err
+		}
+
+		record := lib.AccountingRecord{
+			JobID:       jobID,
+			Origin:      job.Origin,
+			User:        job.User,
+			PrinterName: job.NativePrinterName,
+			ReceivedAt:  receivedAt,
+			FinishedAt:  time.Now(),
+			State:       string(state.State.Type),
+			Cause:       jobStateCause(state.State),
+		}
+		if entry, exists := pm.journal.get(jobID); exists {
+			record.NativePrinterName = entry.NativePrinterName
+			record.NativeJobID = entry.NativeJobID
+			submittedAt := entry.SubmittedAt
+			record.SubmittedAt = &submittedAt
+		}
+		if state.PagesPrinted != nil {
+			record.PagesPrinted = *state.PagesPrinted
+		}
+		if job.Ticket != nil {
+			if job.Ticket.Print.Color != nil {
+				record.Color = string(job.Ticket.Print.Color.Type)
+			}
+			if job.Ticket.Print.Duplex != nil {
+				record.Duplex = string(job.Ticket.Print.Duplex.Type)
+			}
+		}
+
+		if e := pm.accounting.write(record); e != nil {
+			log.WarningJobf(jobID, "Failed to write accounting record: %s", e)
+		}
+		return err
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/nativetest"
+)
+
+func TestAccountFinishedJobs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloud-print-connector-accounting-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "accounting")
+
+	native := nativetest.New()
+	native.AddPrinter(newTestPrinter("a"))
+	pm := newTestPrinterManager(t, native, nil)
+	if pm.accounting, err = newAccountingLog(&lib.AccountingLog{FileName: filename, Format: "csv"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = pm.SyncPrinters(true); err != nil {
+		t.Fatal(err)
+	}
+
+	var r jobRecorder
+	job := newTestJob(t, "printed", "a", &r)
+	job.Ticket = &cdd.CloudJobTicket{
+		Print: cdd.PrintTicketSection{Duplex: &cdd.DuplexTicketItem{Type: cdd.DuplexLongEdge}},
+	}
+	finished := make(chan struct{})
+	go func() {
+		pm.printJob(job)
+		close(finished)
+	}()
+	waitFor(t, "native job", func() bool { return len(native.Jobs()) == 1 })
+	pages := int32(3)
+	native.SetJobState(native.Jobs()[0].ID, cdd.PrintJobStateDiff{
+		State:        &cdd.JobState{Type: cdd.JobStateDone},
+		PagesPrinted: &pages,
+	})
+	<-finished
+
+	pm.printJob(newTestJob(t, "deleted", "b", &r))
+
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := lib.ReadAccountingRecords(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 accounting records, got %d", len(records))
+	}
+
+	printed := records[0]
+	if printed.JobID != "printed" || printed.PrinterName != "a" || printed.NativePrinterName != "a" ||
+		printed.NativeJobID != native.Jobs()[0].ID || printed.SubmittedAt == nil {
+		t.Logf("unexpected job or printer in accounting record %+v", printed)
+		t.Fail()
+	}
+	if printed.State != "DONE" || printed.PagesPrinted != 3 || printed.Duplex != "LONG_EDGE" ||
+		printed.User != "user@example.com" || printed.Origin != lib.JobOriginCloud {
+		t.Logf("unexpected state, pages, duplex, user or origin in accounting record %+v", printed)
+		t.Fail()
+	}
+
+	deleted := records[1]
+	if deleted.JobID != "deleted" || deleted.State != "ABORTED" || deleted.Cause != "SERVICE_ACTION:PRINTER_DELETED" ||
+		deleted.SubmittedAt != nil || deleted.NativeJobID != 0 {
+		t.Logf("unexpected accounting record for job on deleted printer %+v", deleted)
+		t.Fail()
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"os"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+)
+
+const (
+	// drainPollInterval is the time between checks for jobs in flight
+	// while draining.
+	drainPollInterval = 250 * time.Millisecond
+	// abandonTimeout is the time that jobs in flight have to hand
+	// themselves off after the drain timeout.
+	abandonTimeout = 5 * time.Second
+)
+
+// Drain stops accepting new jobs, then waits up to timeout for the jobs in
+// flight to reach a final state.
+//
+// Jobs still in flight after timeout are handed off: jobs not yet submitted
+// are reported as QUEUED, to be received again, and submitted jobs are left
+// in the job journal, to be followed again, when the connector restarts.
+func (pm *PrinterManager) Drain(timeout time.Duration) {
+	close(pm.draining)
+	if pm.privet != nil {
+		pm.privet.RefuseJobs()
+	}
+
+	if n := pm.countJobsInFlight(); n > 0 {
+		log.Infof("Waiting up to %s for %d jobs in flight to finish", timeout, n)
+	}
+	if pm.waitForJobsInFlight(timeout) {
+		return
+	}
+
+	log.Infof("Handing off %d jobs in flight", pm.countJobsInFlight())
+	close(pm.abandon)
+	if !pm.waitForJobsInFlight(abandonTimeout) {
+		log.Warningf("%d jobs in flight failed to hand off", pm.countJobsInFlight())
+	}
+}
+
+// countJobsInFlight returns the quantity of jobs in flight.
+func (pm *PrinterManager) countJobsInFlight() int {
+	pm.jobsInFlightMutex.Lock()
+	defer pm.jobsInFlightMutex.Unlock()
+
+	return len(pm.jobsInFlight)
+}
+
+// waitForJobsInFlight waits up to timeout for the jobs in flight to finish.
+//
+// Returns true if no job is left in flight.
+func (pm *PrinterManager) waitForJobsInFlight(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for pm.countJobsInFlight() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(drainPollInterval)
+	}
+	return true
+}
+
+// handOffJob gives back a job that was received while draining. Cloud jobs
+// are reported as QUEUED, so that they are fetched again after the
+// connector restarts.
+func (pm *PrinterManager) handOffJob(job *lib.Job) {
+	defer os.Remove(job.Filename)
+
+	var state cdd.PrintJobStateDiff
+	if job.Origin == lib.JobOriginCloud {
+		log.InfoJob(job.JobID, "Received while shutting down; leaving the job queued")
+		state = cdd.PrintJobStateDiff{
+			State: &cdd.JobState{Type: cdd.JobStateQueued},
+		}
+	} else {
+		log.InfoJob(job.JobID, "Received while shutting down; aborting the job")
+		state = cdd.PrintJobStateDiff{
+			State: &cdd.JobState{
+				Type:               cdd.JobStateAborted,
+				ServiceActionCause: &cdd.ServiceActionCause{ErrorCode: cdd.ServiceActionCauseOther},
+			},
+		}
+	}
+	if err := job.UpdateJob(job.JobID, &state); err != nil {
+		log.ErrorJob(job.JobID, err)
+	}
+}
+
+// abandonJob reports a job that was not yet submitted to the native print
+// system as QUEUED when the connector stops, so that the job is received
+// again after the connector restarts.
+func (pm *PrinterManager) abandonJob(jobID string, updateJob func(string, *cdd.PrintJobStateDiff) error) {
+	log.InfoJob(jobID, "Connector stopped before job was submitted; leaving the job queued")
+	state := cdd.PrintJobStateDiff{
+		State: &cdd.JobState{Type: cdd.JobStateQueued},
+	}
+	if err := updateJob(jobID, &state); err != nil {
+		log.ErrorJob(jobID, err)
+	}
+}
+
+// abandonSubmittedJob leaves a native job that is not finished when the
+// connector stops. state is the last state reported for the job.
+//
+// The job is reported as IN_PROGRESS, never QUEUED, because a QUEUED job
+// would be received and printed again by whichever connector fetches it
+// first. The journal keeps the job, so that the job is followed again, and
+// its final state reported, after the connector restarts.
+func (pm *PrinterManager) abandonSubmittedJob(jobID string, nativeJobID uint32, state cdd.PrintJobStateDiff, updateJob func(string, *cdd.PrintJobStateDiff) error) {
+	if pm.journal.enabled() {
+		log.InfoJobf(jobID, "Connector stopped before native job %d finished; following the job again after restart", nativeJobID)
+	} else {
+		log.WarningJobf(jobID, "Connector stopped before native job %d finished; the job state will not be updated", nativeJobID)
+	}
+
+	if state.State != nil &&
+		(state.State.Type == cdd.JobStateInProgress || state.State.Type == cdd.JobStateStopped) {
+		return
+	}
+	state = cdd.PrintJobStateDiff{
+		State:        &cdd.JobState{Type: cdd.JobStateInProgress},
+		PagesPrinted: state.PagesPrinted,
+	}
+	if err := updateJob(jobID, &state); err != nil {
+		log.ErrorJob(jobID, err)
+	}
+	if err := pm.journal.updateState(jobID, state); err != nil {
+		log.WarningJobf(jobID, "Failed to update job journal: %s", err)
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"testing"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+func TestHandOffJob(t *testing.T) {
+	var pm PrinterManager
+	states := make(map[string]cdd.JobStateType)
+	updateJob := func(jobID string, state *cdd.PrintJobStateDiff) error {
+		states[jobID] = state.State.Type
+		return nil
+	}
+
+	pm.handOffJob(&lib.Job{JobID: "cloud", Origin: lib.JobOriginCloud, UpdateJob: updateJob})
+	pm.handOffJob(&lib.Job{JobID: "privet", Origin: lib.JobOriginPrivet, UpdateJob: updateJob})
+
+	if states["cloud"] != cdd.JobStateQueued {
+		t.Logf("expected cloud job to be QUEUED, got %s", states["cloud"])
+		t.Fail()
+	}
+	if states["privet"] != cdd.JobStateAborted {
+		t.Logf("expected Privet job to be ABORTED, got %s", states["privet"])
+		t.Fail()
+	}
+}
+
+func TestDrainAbandonsJobsInFlight(t *testing.T) {
+	journal, err := newJobJournal("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm := PrinterManager{
+		jobsInFlight: make(map[string]*jobInFlight),
+		journal:      journal,
+		draining:     make(chan struct{}),
+		abandon:      make(chan struct{}),
+	}
+
+	if _, ok := pm.addInFlightJob("job", lib.JobOriginCloud, "printer"); !ok {
+		t.Fatal("failed to add job in flight")
+	}
+	go func() {
+		<-pm.abandon
+		pm.deleteInFlightJob("job")
+	}()
+
+	pm.Drain(0)
+
+	select {
+	case <-pm.draining:
+	default:
+		t.Log("expected Drain to stop accepting jobs")
+		t.Fail()
+	}
+	if n := pm.countJobsInFlight(); n != 0 {
+		t.Logf("expected no jobs in flight after Drain, got %d", n)
+		t.Fail()
+	}
+}
+
+func TestAbandonSubmittedJob(t *testing.T) {
+	journal, err := newJobJournal("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm := PrinterManager{journal: journal}
+	var states []cdd.JobStateType
+	updateJob := func(jobID string, state *cdd.PrintJobStateDiff) error {
+		states = append(states, state.State.Type)
+		return nil
+	}
+
+	// A submitted job must never be handed back as QUEUED, lest it print
+	// twice.
+	pm.abandonSubmittedJob("job", 1, cdd.PrintJobStateDiff{State: &cdd.JobState{Type: cdd.JobStateQueued}}, updateJob)
+	if len(states) != 1 || states[0] != cdd.JobStateInProgress {
+		t.Logf("expected QUEUED job to be reported IN_PROGRESS, got %v", states)
+		t.Fail()
+	}
+
+	states = nil
+	pm.abandonSubmittedJob("job", 1, cdd.PrintJobStateDiff{State: &cdd.JobState{Type: cdd.JobStateStopped}}, updateJob)
+	if len(states) != 0 {
+		t.Logf("expected STOPPED job to be left as it is, got %v", states)
+		t.Fail()
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"fmt"
+	"time"
+)
+
+// staleSyncIntervals is how many poll intervals may pass without a
+// successful printer sync before the sync is considered unhealthy, or
+// without progress before the sync loop is considered stuck.
+const staleSyncIntervals = 3
+
+func (pm *PrinterManager) setLastSync(t time.Time) {
+	pm.syncStatsMutex.Lock()
+	defer pm.syncStatsMutex.Unlock()
+
+	pm.lastSync = t
+}
+
+func (pm *PrinterManager) setSyncProgress() {
+	pm.syncStatsMutex.Lock()
+	defer pm.syncStatsMutex.Unlock()
+
+	pm.lastSyncProgress = time.Now()
+}
+
+func (pm *PrinterManager) setSyncInterval(interval time.Duration) {
+	pm.syncStatsMutex.Lock()
+	defer pm.syncStatsMutex.Unlock()
+
+	pm.syncInterval = interval
+}
+
+// CheckSync is a readiness check of printer sync. It fails when printers
+// have not been synchronized without error for several poll intervals, which
+// means that the native print system is failing, or that the sync loop is
+// stuck. Restarting the connector fixes neither, so it is not a liveness
+// check; see CheckSyncLoop.
+func (pm *PrinterManager) CheckSync() (string, error) {
+	pm.syncStatsMutex.Lock()
+	lastSync, interval, failed := pm.lastSync, pm.syncInterval, pm.syncDiffsFailed
+	pm.syncStatsMutex.Unlock()
+
+	if lastSync.IsZero() {
+		return "", fmt.Errorf("Printers have never been synchronized")
+	}
+	age := time.Since(lastSync)
+	if interval > 0 && age > staleSyncIntervals*interval {
+		return "", fmt.Errorf("Printers were last synchronized %s ago, at %s; the poll interval is %s",
+			age.Round(time.Second), lastSync.Format(time.RFC3339), interval)
+	}
+
+	detail := fmt.Sprintf("Printers were last synchronized at %s", lastSync.Format(time.RFC3339))
+	if failed > 0 {
+		detail += fmt.Sprintf(", with %d changes to retry", failed)
+	}
+	return detail, nil
+}
+
+// CheckSyncLoop is a liveness check of printer sync. It fails when the sync
+// loop has made no progress for several poll intervals: no sync started,
+// applied a change or ended, whether it succeeded or not. A long sync of a
+// large fleet makes progress with each change; a stuck sync does not.
+func (pm *PrinterManager) CheckSyncLoop() (string, error) {
+	pm.syncStatsMutex.Lock()
+	lastProgress, interval := pm.lastSyncProgress, pm.syncInterval
+	pm.syncStatsMutex.Unlock()
+
+	if lastProgress.IsZero() {
+		return "", fmt.Errorf("Printers have never been synchronized")
+	}
+	age := time.Since(lastProgress)
+	if interval > 0 && age > staleSyncIntervals*interval {
+		return "", fmt.Errorf("Printer sync last made progress %s ago, at %s; the poll interval is %s",
+			age.Round(time.Second), lastProgress.Format(time.RFC3339), interval)
+	}
+	return fmt.Sprintf("Printer sync last made progress at %s", lastProgress.Format(time.RFC3339)), nil
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/nativetest"
+)
+
+func TestCheckSync(t *testing.T) {
+	native := nativetest.New()
+	native.AddPrinter(newTestPrinter("a"))
+	pm := newTestPrinterManager(t, native, nil)
+	pm.setSyncInterval(time.Minute)
+
+	if _, err := pm.CheckSync(); err == nil {
+		t.Log("expected error before the first sync")
+		t.Fail()
+	}
+
+	if err := pm.SyncPrinters(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pm.CheckSync(); err != nil {
+		t.Logf("expected healthy sync, got %s", err)
+		t.Fail()
+	}
+
+	pm.setLastSync(time.Now().Add(-staleSyncIntervals*time.Minute - time.Second))
+	if _, err := pm.CheckSync(); err == nil {
+		t.Log("expected error from stale sync")
+		t.Fail()
+	}
+}
+
+func TestCheckSyncLoop(t *testing.T) {
+	native := nativetest.New()
+	pm := newTestPrinterManager(t, native, nil)
+	pm.setSyncInterval(time.Minute)
+
+	if _, err := pm.CheckSyncLoop(); err == nil {
+		t.Log("expected error before the first sync")
+		t.Fail()
+	}
+
+	// A failed sync is progress; the native print system is down, but a
+	// restart would not help.
+	pm.setLastSync(time.Now().Add(-staleSyncIntervals*time.Minute - time.Second))
+	native.FailGetPrinters(errors.New("cupsd is down"))
+	if err := pm.SyncPrinters(true); err == nil {
+		t.Fatal("expected sync to fail")
+	}
+	if _, err := pm.CheckSyncLoop(); err != nil {
+		t.Logf("expected live sync loop after failed sync, got %s", err)
+		t.Fail()
+	}
+	if _, err := pm.CheckSync(); err == nil {
+		t.Log("expected sync to be unready after failed sync")
+		t.Fail()
+	}
+
+	pm.lastSyncProgress = time.Now().Add(-staleSyncIntervals*time.Minute - time.Second)
+	if _, err := pm.CheckSyncLoop(); err == nil {
+		t.Log("expected error from stuck sync loop")
+		t.Fail()
+	}
+}
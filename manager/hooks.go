@@ -0,0 +1,395 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+)
+
+// Job hook events.
+const (
+	hookEventReceived = "received"
+	hookEventSubmit   = "submit"
+	hookEventFinished = "finished"
+)
+
+// Job hook failure policies.
+const (
+	hookFailureIgnore = "ignore"
+	hookFailureReject = "reject"
+)
+
+const (
+	// defaultHookTimeout is the time to wait for hooks without a timeout.
+	defaultHookTimeout = 10 * time.Second
+	// maxHookResponseSize is the most output read from a hook.
+	maxHookResponseSize = 1 << 20
+)
+
+// jobHook is a compiled lib.JobHook.
+type jobHook struct {
+	name            string
+	event           string
+	printers        map[string]struct{}
+	command         []string
+	url             string
+	timeout         time.Duration
+	rejectOnFailure bool
+}
+
+// hookJob describes a job to hooks.
+type hookJob struct {
+	JobID       string              `json:"job_id"`
+	Origin      lib.JobOrigin       `json:"origin"`
+	PrinterName string              `json:"printer_name"`
+	Title       string              `json:"title"`
+	User        string              `json:"user"`
+	ContentType string              `json:"content_type,omitempty"`
+	PageCount   int                 `json:"page_count,omitempty"`
+	Ticket      *cdd.CloudJobTicket `json:"ticket,omitempty"`
+}
+
+// hookPrinter describes a printer to hooks.
+type hookPrinter struct {
+	Name         string                         `json:"name"`
+	GCPID        string                         `json:"gcp_id,omitempty"`
+	DisplayName  string                         `json:"display_name"`
+	Manufacturer string                         `json:"manufacturer,omitempty"`
+	Model        string                         `json:"model,omitempty"`
+	State        *cdd.PrinterStateSection       `json:"state,omitempty"`
+	Description  *cdd.PrinterDescriptionSection `json:"description,omitempty"`
+}
+
+// hookRequest is the JSON description that hooks are called with.
+type hookRequest struct {
+	Event   string                 `json:"event"`
+	Job     hookJob                `json:"job"`
+	Printer *hookPrinter           `json:"printer,omitempty"`
+	State   *cdd.PrintJobStateDiff `json:"state,omitempty"`
+}
+
+// hookResponse is the JSON answer of submit hooks. An empty answer accepts
+// the job as it is.
+type hookResponse struct {
+	// Reject vetoes the job, which is reported ABORTED with RejectCause.
+	Reject      bool   `json:"reject,omitempty"`
+	RejectCause string `json:"reject_cause,omitempty"`
+	// Message is logged.
+	Message string `json:"message,omitempty"`
+	// Ticket replaces the job ticket.
+	Ticket *cdd.CloudJobTicket `json:"ticket,omitempty"`
+}
+
+// newJobHooks compiles job hook configs.
+func newJobHooks(hooks []lib.JobHook) ([]jobHook, error) {
+	compiled := make([]jobHook, len(hooks))
+	for i, hook := range hooks {
+		h := jobHook{
+			name:    hook.Name,
+			event:   hook.Event,
+			command: hook.Command,
+			url:     hook.URL,
+			timeout: defaultHookTimeout,
+		}
+		if h.name == "" {
+			h.name = fmt.Sprintf("#%d", i+1)
+		}
+
+		switch hook.Event {
+		case hookEventReceived, hookEventSubmit, hookEventFinished:
+		default:
+			return nil, fmt.Errorf("Job hook %s has unknown event %q", h.name, hook.Event)
+		}
+
+		if (len(hook.Command) == 0) == (hook.URL == "") {
+			return nil, fmt.Errorf("Job hook %s needs exactly one of command and url", h.name)
+		}
+
+		if hook.Timeout != "" {
+			timeout, err := time.ParseDuration(hook.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse timeout of job hook %s: %s", h.name, err)
+			}
+			if timeout <= 0 {
+				return nil, fmt.Errorf("Job hook %s has a timeout that is not positive", h.name)
+			}
+			h.timeout = timeout
+		}
+
+		switch hook.FailurePolicy {
+		case "", hookFailureIgnore:
+		case hookFailureReject:
+			if hook.Event != hookEventSubmit {
+				return nil, fmt.Errorf("Job hook %s rejects on failure, but only submit hooks may reject", h.name)
+			}
+			h.rejectOnFailure = true
+		default:
+			return nil, fmt.Errorf("Job hook %s has unknown failure policy %q", h.name, hook.FailurePolicy)
+		}
+
+		if len(hook.Printers) > 0 {
+			h.printers = make(map[string]struct{}, len(hook.Printers))
+			for _, printer := range hook.Printers {
+				h.printers[printer] = struct{}{}
+			}
+		}
+
+		compiled[i] = h
+	}
+
+	return compiled, nil
+}
+
+// appliesTo tells whether a hook is called for an event of a job on a
+// printer.
+func (h *jobHook) appliesTo(event, printerName string) bool {
+	if h.event != event {
+		return false
+	}
+	if h.printers != nil {
+		if _, exists := h.printers[printerName]; !exists {
+			return false
+		}
+	}
+	return true
+}
+
+// call calls the hook, and returns what it answered.
+func (h *jobHook) call(request []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	if h.url != "" {
+		r, err := http.NewRequest("POST", h.url, bytes.NewReader(request))
+		if err != nil {
+			return nil, err
+		}
+		r.Header.Set("Content-Type", "application/json")
+		response, err := http.DefaultClient.Do(r.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		defer response.Body.Close()
+		body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxHookResponseSize))
+		if err != nil {
+			return nil, err
+		}
+		if response.StatusCode/100 != 2 {
+			return nil, fmt.Errorf("HTTP status %s", response.Status)
+		}
+		return body, nil
+	}
+
+	stdout := limitedBuffer{max: maxHookResponseSize}
+	stderr := limitedBuffer{max: maxHookResponseSize}
+	cmd := exec.CommandContext(ctx, h.command[0], h.command[1:]...)
+	cmd.Stdin = bytes.NewReader(request)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, errors.New("timed out")
+		}
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("%s: %s", err, message)
+		}
+		return nil, err
+	}
+	if stdout.overflowed {
+		return nil, errors.New("answer is too long")
+	}
+	return stdout.Bytes(), nil
+}
+
+// limitedBuffer keeps at most max bytes written to it, and throws the rest
+// away, so that a hook can not use up memory with its output.
+type limitedBuffer struct {
+	buf        bytes.Buffer
+	max        int
+	overflowed bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); len(p) > room {
+		b.overflowed = true
+		b.buf.Write(p[:room])
+		// Keep reading, so that the hook is not blocked on a full pipe.
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
+
+// newHookRequest describes a job, and optionally its printer and state, to
+// hooks.
+func newHookRequest(event string, job *lib.Job, printer *lib.Printer, state *cdd.PrintJobStateDiff) hookRequest {
+	r := hookRequest{
+		Event: event,
+		Job: hookJob{
+			JobID:       job.JobID,
+			Origin:      job.Origin,
+			PrinterName: job.NativePrinterName,
+			Title:       job.Title,
+			User:        job.User,
+			ContentType: job.ContentType,
+			PageCount:   job.PageCount,
+			Ticket:      job.Ticket,
+		},
+		State: state,
+	}
+	if printer != nil {
+		r.Printer = &hookPrinter{
+			Name:         printer.Name,
+			GCPID:        printer.GCPID,
+			DisplayName:  printer.DefaultDisplayName,
+			Manufacturer: printer.Manufacturer,
+			Model:        printer.Model,
+			State:        printer.State,
+			Description:  printer.Description,
+		}
+	}
+	return r
+}
+
+// runHooks calls the received or finished hooks of a job, describing the
+// printer of the job when it is still present. Failures are logged, and
+// otherwise ignored.
+func (pm *PrinterManager) runHooks(event string, job *lib.Job, state *cdd.PrintJobStateDiff) {
+	var request []byte
+	for i := range pm.hooks {
+		hook := &pm.hooks[i]
+		if !hook.appliesTo(event, job.NativePrinterName) {
+			continue
+		}
+		if request == nil {
+			var printer *lib.Printer
+			if p, exists := pm.getNativePrinter(job.NativePrinterName); exists {
+				printer = &p
+			}
+			var err error
+			if request, err = json.Marshal(newHookRequest(event, job, printer, state)); err != nil {
+				log.ErrorJobf(job.JobID, "Failed to describe job to %s hooks: %s", event, err)
+				return
+			}
+		}
+		if _, err := hook.call(request); err != nil {
+			log.WarningJobf(job.JobID, "Job hook %s failed: %s", hook.name, err)
+		}
+	}
+}
+
+// runSubmitHooks calls the submit hooks of a job, in order. Each hook sees
+// the ticket that the previous hooks left.
+//
+// Returns the state to report, and false, if a hook rejected the job.
+func (pm *PrinterManager) runSubmitHooks(job *lib.Job, printer *lib.Printer) (cdd.PrintJobStateDiff, bool) {
+	for i := range pm.hooks {
+		hook := &pm.hooks[i]
+		if !hook.appliesTo(hookEventSubmit, job.NativePrinterName) {
+			continue
+		}
+
+		var response hookResponse
+		request, err := json.Marshal(newHookRequest(hookEventSubmit, job, printer, nil))
+		if err == nil {
+			var answer []byte
+			if answer, err = hook.call(request); err == nil && len(bytes.TrimSpace(answer)) > 0 {
+				if err = json.Unmarshal(answer, &response); err != nil {
+					err = fmt.Errorf("Failed to parse answer: %s", err)
+				}
+			}
+		}
+		if err == nil && response.Reject {
+			cause := cdd.ServiceActionCauseOther
+			if response.RejectCause != "" {
+				cause = cdd.ServiceActionCauseCode(response.RejectCause)
+			}
+			if _, exists := rejectCauses[cause]; !exists {
+				err = fmt.Errorf("Unknown reject cause %s", response.RejectCause)
+			} else {
+				log.InfoJobf(job.JobID, "Job hook %s rejected job with cause %s: %s", hook.name, cause, response.Message)
+				return cdd.PrintJobStateDiff{
+					State: &cdd.JobState{
+						Type:               cdd.JobStateAborted,
+						ServiceActionCause: &cdd.ServiceActionCause{ErrorCode: cause},
+					},
+				}, false
+			}
+		}
+
+		if err != nil {
+			if !hook.rejectOnFailure {
+				log.WarningJobf(job.JobID, "Job hook %s failed: %s", hook.name, err)
+				continue
+			}
+			log.ErrorJobf(job.JobID, "Job hook %s failed, rejecting job: %s", hook.name, err)
+			return cdd.PrintJobStateDiff{
+				State: &cdd.JobState{
+					Type:               cdd.JobStateAborted,
+					ServiceActionCause: &cdd.ServiceActionCause{ErrorCode: cdd.ServiceActionCauseOther},
+				},
+			}, false
+		}
+
+		if response.Message != "" {
+			log.InfoJobf(job.JobID, "Job hook %s: %s", hook.name, response.Message)
+		}
+		if response.Ticket != nil {
+			log.InfoJobf(job.JobID, "Job hook %s replaced the ticket", hook.name)
+			job.Ticket = response.Ticket
+		}
+	}
+
+	return cdd.PrintJobStateDiff{}, true
+}
+
+// hookFinishedJob wraps updateJob to call the finished hooks of a job when
+// its state is reported DONE or ABORTED.
+func (pm *PrinterManager) hookFinishedJob(job *lib.Job, updateJob func(string, *cdd.PrintJobStateDiff) error) func(string, *cdd.PrintJobStateDiff) error {
+	var hooked bool
+	for i := range pm.hooks {
+		if pm.hooks[i].event == hookEventFinished {
+			hooked = true
+			break
+		}
+	}
+	if !hooked {
+		return updateJob
+	}
+
+	return func(jobID string, state *cdd.PrintJobStateDiff) error {
+		err := updateJob(jobID, state)
+		if state.State != nil &&
+			(state.State.Type == cdd.JobStateDone || state.State.Type == cdd.JobStateAborted) {
+			pm.runHooks(hookEventFinished, job, state)
+		}
+		return err
+	}
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+)
+
+func TestNewJobHooksErrors(t *testing.T) {
+	bad := []lib.JobHook{
+		lib.JobHook{Event: "printed", URL: "http://localhost/"},
+		lib.JobHook{Event: "submit"},
+		lib.JobHook{Event: "submit", URL: "http://localhost/", Command: []string{"true"}},
+		lib.JobHook{Event: "submit", URL: "http://localhost/", Timeout: "soon"},
+		lib.JobHook{Event: "submit", URL: "http://localhost/", Timeout: "-1s"},
+		lib.JobHook{Event: "submit", URL: "http://localhost/", FailurePolicy: "retry"},
+		lib.JobHook{Event: "finished", URL: "http://localhost/", FailurePolicy: "reject"},
+	}
+	for _, hook := range bad {
+		if _, err := newJobHooks([]lib.JobHook{hook}); err == nil {
+			t.Logf("expected error for hook %+v", hook)
+			t.Fail()
+		}
+	}
+}
+
+// newHookServer answers hook requests with answer.
+func newHookServer(t *testing.T, answer func(request hookRequest) (int, *hookResponse)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request hookRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Logf("failed to decode hook request: %s", err)
+			t.Fail()
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		status, response := answer(request)
+		w.WriteHeader(status)
+		if response != nil {
+			json.NewEncoder(w).Encode(response)
+		}
+	}))
+}
+
+func TestRunSubmitHooks(t *testing.T) {
+	log.SetLevel(log.ERROR)
+
+	twoCopies := &cdd.CloudJobTicket{
+		Print: cdd.PrintTicketSection{Copies: &cdd.CopiesTicketItem{Copies: 2}},
+	}
+	s := newHookServer(t, func(request hookRequest) (int, *hookResponse) {
+		switch {
+		case request.Printer == nil || request.Printer.Name != request.Job.PrinterName:
+			return http.StatusBadRequest, nil
+		case request.Job.User == "broken@example.com":
+			return http.StatusInternalServerError, nil
+		case request.Job.User == "spam@example.com":
+			return http.StatusOK, &hookResponse{Reject: true, RejectCause: "INCONSISTENT_JOB"}
+		case request.Job.Ticket == nil:
+			return http.StatusOK, &hookResponse{Ticket: twoCopies}
+		}
+		return http.StatusOK, nil
+	})
+	defer s.Close()
+
+	hooks, err := newJobHooks([]lib.JobHook{
+		lib.JobHook{Name: "office", Event: "submit", URL: s.URL, Printers: []string{"office"}, FailurePolicy: "reject"},
+		lib.JobHook{Name: "all", Event: "submit", URL: s.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm := PrinterManager{hooks: hooks}
+
+	cases := []struct {
+		name   string
+		job    lib.Job
+		ok     bool
+		cause  cdd.ServiceActionCauseCode
+		copies int32
+	}{
+		{
+			name:   "ticket replaced",
+			job:    lib.Job{NativePrinterName: "lab", User: "joe@example.com"},
+			ok:     true,
+			copies: 2,
+		},
+		{
+			name:  "rejected",
+			job:   lib.Job{NativePrinterName: "lab", User: "spam@example.com"},
+			cause: cdd.ServiceActionCauseInconsistentJob,
+		},
+		{
+			name: "failure ignored",
+			job:  lib.Job{NativePrinterName: "lab", User: "broken@example.com"},
+			ok:   true,
+		},
+		{
+			name:  "failure rejected",
+			job:   lib.Job{NativePrinterName: "office", User: "broken@example.com"},
+			cause: cdd.ServiceActionCauseOther,
+		},
+	}
+
+	for _, c := range cases {
+		printer := lib.Printer{Name: c.job.NativePrinterName}
+		state, ok := pm.runSubmitHooks(&c.job, &printer)
+		if ok != c.ok {
+			t.Logf("%s: expected ok %t, got %t", c.name, c.ok, ok)
+			t.Fail()
+			continue
+		}
+		if !ok {
+			if state.State == nil || state.State.Type != cdd.JobStateAborted ||
+				state.State.ServiceActionCause == nil || state.State.ServiceActionCause.ErrorCode != c.cause {
+				t.Logf("%s: expected ABORTED with cause %s, got %+v", c.name, c.cause, state.State)
+				t.Fail()
+			}
+			continue
+		}
+		var copies int32
+		if c.job.Ticket != nil && c.job.Ticket.Print.Copies != nil {
+			copies = c.job.Ticket.Print.Copies.Copies
+		}
+		if copies != c.copies {
+			t.Logf("%s: expected %d copies, got %d", c.name, c.copies, copies)
+			t.Fail()
+		}
+	}
+}
+
+func TestHookFinishedJob(t *testing.T) {
+	var mutex sync.Mutex
+	var events []string
+	s := newHookServer(t, func(request hookRequest) (int, *hookResponse) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		event := request.Event + " " + string(request.State.State.Type)
+		if request.Printer != nil {
+			event += " " + request.Printer.Name
+		}
+		events = append(events, event)
+		return http.StatusOK, nil
+	})
+	defer s.Close()
+
+	hooks, err := newJobHooks([]lib.JobHook{
+		lib.JobHook{Event: "finished", URL: s.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm := PrinterManager{
+		hooks:          hooks,
+		printers:       lib.NewConcurrentPrinterMap([]lib.Printer{lib.Printer{Name: "lab"}}),
+		hiddenPrinters: lib.NewConcurrentPrinterMap(nil),
+	}
+
+	var updates int
+	job := lib.Job{JobID: "job", NativePrinterName: "lab"}
+	updateJob := pm.hookFinishedJob(&job, func(string, *cdd.PrintJobStateDiff) error {
+		updates++
+		return nil
+	})
+	for _, state := range []cdd.JobStateType{cdd.JobStateInProgress, cdd.JobStateStopped, cdd.JobStateDone} {
+		updateJob("job", &cdd.PrintJobStateDiff{State: &cdd.JobState{Type: state}})
+	}
+
+	if updates != 3 {
+		t.Logf("expected 3 updates, got %d", updates)
+		t.Fail()
+	}
+	if len(events) != 1 || events[0] != "finished DONE lab" {
+		t.Logf("expected one finished hook call with DONE and printer lab, got %v", events)
+		t.Fail()
+	}
+}
+
+func TestCommandHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+
+	hooks, err := newJobHooks([]lib.JobHook{
+		lib.JobHook{Name: "echo", Event: "submit", Command: []string{"sh", "-c", `grep -q '"user":"joe"' && echo '{"message":"ok"}'`}},
+		lib.JobHook{Name: "slow", Event: "submit", Command: []string{"sleep", "5"}, Timeout: "50ms"},
+		lib.JobHook{Name: "long", Event: "submit", Command: []string{"sh", "-c", `yes | head -c 2000000`}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	answer, err := hooks[0].call([]byte(`{"job":{"user":"joe"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response hookResponse
+	if err = json.Unmarshal(answer, &response); err != nil || response.Message != "ok" {
+		t.Logf("expected message ok, got %q, %v", answer, err)
+		t.Fail()
+	}
+
+	if _, err = hooks[0].call([]byte(`{"job":{"user":"jane"}}`)); err == nil {
+		t.Log("expected hook that exits with an error to fail")
+		t.Fail()
+	}
+	if _, err = hooks[1].call(nil); err == nil || err.Error() != "timed out" {
+		t.Logf("expected hook to time out, got %v", err)
+		t.Fail()
+	}
+	if _, err = hooks[2].call(nil); err == nil || err.Error() != "answer is too long" {
+		t.Logf("expected hook answer to be too long, got %v", err)
+		t.Fail()
+	}
+}
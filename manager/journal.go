@@ -0,0 +1,204 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+)
+
+// journalEntry describes a job that has been handed to the native print
+// system, but has not reached a final state yet.
+//
+// PrinterName is the printer that received the job, which differs from
+// NativePrinterName when the job was sent to a pool member.
+type journalEntry struct {
+	JobID             string                `json:"job_id"`
+	Origin            lib.JobOrigin         `json:"origin"`
+	PrinterName       string                `json:"printer_name,omitempty"`
+	User              string                `json:"user,omitempty"`
+	ReleasePINHash    string                `json:"release_pin_hash,omitempty"`
+	NativePrinterName string                `json:"native_printer_name"`
+	NativeJobID       uint32                `json:"native_job_id"`
+	State             cdd.PrintJobStateDiff `json:"state"`
+	SubmittedAt       time.Time             `json:"submitted_at"`
+}
+
+// jobJournal is an on-disk record of the jobs in flight, so that jobs
+// submitted to the native print system survive a connector restart.
+//
+// The whole journal is rewritten on every change. This is cheap because the
+// journal only holds jobs in flight, which are few.
+type jobJournal struct {
+	filename string
+	entries  map[string]journalEntry
+	mutex    sync.Mutex
+}
+
+// newJobJournal opens the journal at filename, reading any entries left
+// behind by the previous connector process.
+//
+// An empty filename disables the journal; all methods become no-ops.
+func newJobJournal(filename string) (*jobJournal, error) {
+	jj := jobJournal{
+		filename: filename,
+		entries:  make(map[string]journalEntry),
+	}
+	if filename == "" {
+		return &jj, nil
+	}
+	if err := makeStateDir(filename); err != nil {
+		log.Warningf("Job journal disabled; jobs in flight will not be resumed after a restart: %s", err)
+		jj.filename = ""
+		return &jj, nil
+	}
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &jj, nil
+		}
+		return nil, err
+	}
+
+	var entries []journalEntry
+	if err = json.Unmarshal(b, &entries); err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		jj.entries[entry.JobID] = entry
+	}
+
+	return &jj, nil
+}
+
+// enabled returns true if the journal is written to disk.
+func (jj *jobJournal) enabled() bool {
+	return jj.filename != ""
+}
+
+// getAll returns a copy of every entry in the journal.
+func (jj *jobJournal) getAll() []journalEntry {
+	jj.mutex.Lock()
+	defer jj.mutex.Unlock()
+
+	entries := make([]journalEntry, 0, len(jj.entries))
+	for _, entry := range jj.entries {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// get returns a copy of the entry for jobID.
+func (jj *jobJournal) get(jobID string) (journalEntry, bool) {
+	jj.mutex.Lock()
+	defer jj.mutex.Unlock()
+
+	entry, exists := jj.entries[jobID]
+	return entry, exists
+}
+
+// put adds or replaces the entry for entry.JobID.
+func (jj *jobJournal) put(entry journalEntry) error {
+	jj.mutex.Lock()
+	defer jj.mutex.Unlock()
+
+	jj.entries[entry.JobID] = entry
+	return jj.write()
+}
+
+// updateState records the last state reported for a job.
+func (jj *jobJournal) updateState(jobID string, state cdd.PrintJobStateDiff) error {
+	jj.mutex.Lock()
+	defer jj.mutex.Unlock()
+
+	entry, exists := jj.entries[jobID]
+	if !exists {
+		return nil
+	}
+	entry.State = state
+	jj.entries[jobID] = entry
+	return jj.write()
+}
+
+// delete removes the entry for jobID.
+func (jj *jobJournal) delete(jobID string) error {
+	jj.mutex.Lock()
+	defer jj.mutex.Unlock()
+
+	if _, exists := jj.entries[jobID]; !exists {
+		return nil
+	}
+	delete(jj.entries, jobID)
+	return jj.write()
+}
+
+// write replaces the journal file with the current entries.
+//
+// The caller must hold the mutex.
+func (jj *jobJournal) write() error {
+	if jj.filename == "" {
+		return nil
+	}
+
+	entries := make([]journalEntry, 0, len(jj.entries))
+	for _, entry := range jj.entries {
+		entries = append(entries, entry)
+	}
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomically(jj.filename, b)
+}
+
+// makeStateDir creates the directory that holds a state file, like the job
+// journal, when it does not exist yet, and checks that files can be written
+// there.
+func makeStateDir(filename string) error {
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, filepath.Base(filename)+".")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// writeFileAtomically replaces a file with b. A new file is renamed over the
+// old file so that a crash never leaves a partial file.
+func writeFileAtomically(filename string, b []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), filename)
+}
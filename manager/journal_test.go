@@ -0,0 +1,153 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+	"github.com/google/cloud-print-connector/nativetest"
+)
+
+// writeTestJournal journals an IN_PROGRESS job at filename, then reopens the
+// journal as after a restart, and checks that the job is still there.
+func writeTestJournal(t *testing.T, filename string, nativeJobID uint32) *jobJournal {
+	journal, err := newJobJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = journal.put(journalEntry{
+		JobID:             "job",
+		Origin:            lib.JobOriginCloud,
+		PrinterName:       "a",
+		User:              "user@example.com",
+		NativePrinterName: "a",
+		NativeJobID:       nativeJobID,
+		State:             cdd.PrintJobStateDiff{State: &cdd.JobState{Type: cdd.JobStateQueued}},
+		SubmittedAt:       time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = journal.updateState("job", cdd.PrintJobStateDiff{State: &cdd.JobState{Type: cdd.JobStateInProgress}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The journal is rewritten in place, without leftover temporary files.
+	if files, err := ioutil.ReadDir(filepath.Dir(filename)); err != nil || len(files) != 1 {
+		t.Fatalf("expected only the journal file in the state directory, got %d files, %v", len(files), err)
+	}
+
+	if journal, err = newJobJournal(filename); err != nil {
+		t.Fatal(err)
+	}
+	entry, exists := journal.get("job")
+	if !exists {
+		t.Fatal("expected job in reopened journal")
+	}
+	if entry.NativeJobID != nativeJobID || entry.User != "user@example.com" || entry.Origin != lib.JobOriginCloud ||
+		entry.State.State == nil || entry.State.State.Type != cdd.JobStateInProgress || entry.SubmittedAt.IsZero() {
+		t.Fatalf("unexpected entry in reopened journal %+v", entry)
+	}
+	return journal
+}
+
+func TestJobJournal(t *testing.T) {
+	log.SetLevel(log.ERROR)
+
+	dir, err := ioutil.TempDir("", "cloud-print-connector-journal-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// The state directory does not exist yet.
+	filename := filepath.Join(dir, "state", "jobs.json")
+
+	journal := writeTestJournal(t, filename, 1)
+	if err = journal.delete("job"); err != nil {
+		t.Fatal(err)
+	}
+	if journal, err = newJobJournal(filename); err != nil {
+		t.Fatal(err)
+	}
+	if entries := journal.getAll(); len(entries) != 0 {
+		t.Logf("expected empty journal after delete, got %+v", entries)
+		t.Fail()
+	}
+
+	// A journal that can not be written is disabled, rather than stopping
+	// the connector.
+	if journal, err = newJobJournal(filepath.Join(filename, "jobs.json")); err != nil || journal.enabled() {
+		t.Logf("expected disabled journal under a file, got enabled %t, %v", journal != nil && journal.enabled(), err)
+		t.Fail()
+	}
+}
+
+func TestJobJournalResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloud-print-connector-journal-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "jobs.json")
+
+	native := nativetest.New()
+	printer := newTestPrinter("a")
+	native.AddPrinter(printer)
+	document := filepath.Join(dir, "document")
+	if err = ioutil.WriteFile(document, []byte("%PDF-1.4"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	nativeJobID, err := native.Print(&printer, document, "title", "user", "job", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(document)
+
+	pm := newTestPrinterManager(t, native, &fakeCloudPrint{})
+	pm.journal = writeTestJournal(t, filename, nativeJobID)
+	pm.resumeJournaledJobs()
+	waitFor(t, "resumed job", func() bool { return native.Subscribed(nativeJobID) })
+	pages := int32(2)
+	native.SetJobState(nativeJobID, cdd.PrintJobStateDiff{
+		State:        &cdd.JobState{Type: cdd.JobStateDone},
+		PagesPrinted: &pages,
+	})
+	waitFor(t, "resumed job to finish", func() bool {
+		_, exists := pm.journal.get("job")
+		return !exists
+	})
+
+	recent := pm.GetRecentJobs()
+	if len(recent) != 1 || recent[0].JobID != "job" || recent[0].State != string(cdd.JobStateDone) ||
+		recent[0].NativeJobID != nativeJobID {
+		t.Logf("expected resumed job to finish DONE, got %+v", recent)
+		t.Fail()
+	}
+	if pages := pm.quotas.pagesPrinted("a", "user@example.com"); pages != 2 {
+		t.Logf("expected 2 pages counted for resumed job, got %d", pages)
+		t.Fail()
+	}
+
+	journal, err := newJobJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := journal.getAll(); len(entries) != 0 {
+		t.Logf("expected empty journal after resumed job finished, got %+v", entries)
+		t.Fail()
+	}
+}
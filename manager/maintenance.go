@@ -0,0 +1,284 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+)
+
+// What to do with jobs received during a maintenance window.
+const (
+	maintenanceJobPolicyHold   = "hold"
+	maintenanceJobPolicyReject = "reject"
+)
+
+// maintenanceCause is reported for jobs rejected during a maintenance
+// window. GCP has no cause specific to printer availability.
+const maintenanceCause = cdd.ServiceActionCauseOther
+
+var maintenanceDays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// maintenanceWindow is a compiled lib.MaintenanceWindow.
+type maintenanceWindow struct {
+	name     string
+	printers map[string]struct{}
+	// days are the days when the window starts; all false is every day.
+	days [7]bool
+	// start and end are minutes since midnight.
+	start   int
+	end     int
+	message string
+	reject  bool
+}
+
+// parseTimeOfDay parses a time like 22:00 to minutes since midnight.
+func parseTimeOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// newMaintenanceWindows compiles maintenance window configs.
+func newMaintenanceWindows(windows []lib.MaintenanceWindow) ([]maintenanceWindow, error) {
+	compiled := make([]maintenanceWindow, len(windows))
+	for i, window := range windows {
+		w := maintenanceWindow{
+			name:     window.Name,
+			printers: stringSet(window.Printers),
+			message:  window.Message,
+		}
+		if w.name == "" {
+			w.name = fmt.Sprintf("#%d", i+1)
+		}
+		if w.message == "" {
+			w.message = "Unavailable for maintenance"
+		}
+
+		for _, day := range window.Days {
+			weekday, exists := maintenanceDays[strings.ToLower(day)]
+			if !exists {
+				return nil, fmt.Errorf("Maintenance window %s has unknown day %q", w.name, day)
+			}
+			w.days[weekday] = true
+		}
+
+		var err error
+		if w.start, err = parseTimeOfDay(window.Start); err != nil {
+			return nil, fmt.Errorf("Failed to parse start of maintenance window %s: %s", w.name, err)
+		}
+		if w.end, err = parseTimeOfDay(window.End); err != nil {
+			return nil, fmt.Errorf("Failed to parse end of maintenance window %s: %s", w.name, err)
+		}
+
+		switch window.JobPolicy {
+		case "", maintenanceJobPolicyHold:
+		case maintenanceJobPolicyReject:
+			w.reject = true
+		default:
+			return nil, fmt.Errorf("Maintenance window %s has unknown job policy %q", w.name, window.JobPolicy)
+		}
+
+		compiled[i] = w
+	}
+
+	return compiled, nil
+}
+
+// startsOn checks whether the window starts on a day.
+func (w *maintenanceWindow) startsOn(day time.Weekday) bool {
+	if w.days == [7]bool{} {
+		return true
+	}
+	return w.days[day]
+}
+
+// activeAt checks whether the window is open at t, and returns when it
+// closes. A window is open for less than a day, so it opened either today or
+// yesterday.
+func (w *maintenanceWindow) activeAt(t time.Time) (time.Time, bool) {
+	for daysAgo := 0; daysAgo <= 1; daysAgo++ {
+		year, month, day := t.AddDate(0, 0, -daysAgo).Date()
+		if !w.startsOn(time.Date(year, month, day, 0, 0, 0, 0, t.Location()).Weekday()) {
+			continue
+		}
+		start := time.Date(year, month, day, 0, w.start, 0, 0, t.Location())
+		endDay := day
+		if w.end <= w.start {
+			endDay++
+		}
+		end := time.Date(year, month, endDay, 0, w.end, 0, 0, t.Location())
+		if !t.Before(start) && t.Before(end) {
+			return end, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// activeMaintenanceWindow finds the first maintenance window of a printer
+// that is open at t, and when it closes. Returns nil if there is none.
+//
+// A paused printer is in pauseWindow, which closes at the zero time.
+func (pm *PrinterManager) activeMaintenanceWindow(printerName string, t time.Time) (*maintenanceWindow, time.Time) {
+	if pm.isPaused(printerName) {
+		return &pauseWindow, time.Time{}
+	}
+	for i := range pm.maintenanceWindows {
+		w := &pm.maintenanceWindows[i]
+		if w.printers != nil {
+			if _, exists := w.printers[printerName]; !exists {
+				continue
+			}
+		}
+		if end, ok := w.activeAt(t); ok {
+			return w, end
+		}
+	}
+	return nil, time.Time{}
+}
+
+// setMaintenanceStates reports the printers that are in a maintenance window
+// at t as STOPPED, with the message of the window. Printers that already
+// show the message are left as they are.
+func (pm *PrinterManager) setMaintenanceStates(printers []lib.Printer, t time.Time) {
+	for i := range printers {
+		w, _ := pm.activeMaintenanceWindow(printers[i].Name, t)
+		if w == nil {
+			continue
+		}
+
+		var state cdd.PrinterStateSection
+		var items []cdd.VendorStateItem
+		if printers[i].State != nil {
+			state = *printers[i].State
+			if state.VendorState != nil {
+				items = state.VendorState.Item
+			}
+		}
+		item := cdd.VendorStateItem{
+			State:                cdd.VendorStateWarning,
+			DescriptionLocalized: cdd.NewLocalizedString(w.message),
+		}
+		if state.State == cdd.CloudDeviceStateStopped && hasVendorStateItem(items, item) {
+			continue
+		}
+
+		state.State = cdd.CloudDeviceStateStopped
+		state.VendorState = &cdd.VendorState{
+			Item: append(append(make([]cdd.VendorStateItem, 0, len(items)+1), items...), item),
+		}
+		printers[i].State = &state
+	}
+}
+
+func hasVendorStateItem(items []cdd.VendorStateItem, item cdd.VendorStateItem) bool {
+	for i := range items {
+		if reflect.DeepEqual(items[i], item) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForMaintenance holds a job while its printer is paused or in a
+// maintenance window, or rejects the job if the window rejects jobs. The job
+// is reported QUEUED while held.
+//
+// Returns false if the job must not be printed. In that case the final job
+// state is reported from inside this function.
+func (pm *PrinterManager) waitForMaintenance(job *lib.Job, cancel <-chan struct{}) bool {
+	jobID, updateJob := job.JobID, job.UpdateJob
+	abort := func(state cdd.PrintJobStateDiff) {
+		pm.incrementJobsProcessed(false)
+		if err := updateJob(jobID, &state); err != nil {
+			log.ErrorJob(jobID, err)
+		}
+	}
+
+	held := false
+	for {
+		// Get the resumed channel first, so that a resume between now and
+		// the wait below is not missed.
+		resumed := pm.resumedChannel()
+		w, end := pm.activeMaintenanceWindow(job.NativePrinterName, time.Now())
+		if w == nil {
+			if held {
+				log.InfoJobf(jobID, "Printer %s is available again", job.NativePrinterName)
+			}
+			return true
+		}
+
+		if w.reject {
+			log.InfoJobf(jobID, "Rejected because printer %s is in maintenance window %s", job.NativePrinterName, w.name)
+			abort(cdd.PrintJobStateDiff{
+				State: &cdd.JobState{
+					Type:               cdd.JobStateAborted,
+					ServiceActionCause: &cdd.ServiceActionCause{ErrorCode: maintenanceCause},
+				},
+			})
+			return false
+		}
+
+		// Wake up when the window closes, or, for a paused printer, when
+		// it might have been resumed.
+		var closed <-chan time.Time
+		if !end.IsZero() {
+			closed = time.After(end.Sub(time.Now()))
+		}
+
+		if !held {
+			if end.IsZero() {
+				log.InfoJobf(jobID, "Held, because printer %s is paused", job.NativePrinterName)
+			} else {
+				log.InfoJobf(jobID, "Held until %s, because printer %s is in maintenance window %s",
+					end.Format("Mon 15:04"), job.NativePrinterName, w.name)
+			}
+			state := cdd.PrintJobStateDiff{
+				State: &cdd.JobState{Type: cdd.JobStateQueued},
+			}
+			if err := updateJob(jobID, &state); err != nil {
+				log.ErrorJob(jobID, err)
+			}
+			held = true
+		}
+
+		select {
+		case <-closed:
+		case <-resumed:
+		case <-cancel:
+			log.InfoJob(jobID, "Canceled while held for maintenance")
+			abort(cdd.PrintJobStateDiff{
+				State: &cdd.JobState{
+					Type:            cdd.JobStateAborted,
+					UserActionCause: &cdd.UserActionCause{ActionCode: cdd.UserActionCauseCanceled},
+				},
+			})
+			return false
+		case <-pm.abandon:
+			pm.abandonJob(jobID, updateJob)
+			return false
+		}
+	}
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/nativetest"
+)
+
+func TestNewMaintenanceWindowsErrors(t *testing.T) {
+	cases := map[string]lib.MaintenanceWindow{
+		"bad day":        lib.MaintenanceWindow{Days: []string{"someday"}, Start: "22:00", End: "06:00"},
+		"bad start":      lib.MaintenanceWindow{Start: "10pm", End: "06:00"},
+		"no end":         lib.MaintenanceWindow{Start: "22:00"},
+		"bad job policy": lib.MaintenanceWindow{Start: "22:00", End: "06:00", JobPolicy: "drop"},
+	}
+	for name, window := range cases {
+		if _, err := newMaintenanceWindows([]lib.MaintenanceWindow{window}); err == nil {
+			t.Logf("%s: expected error", name)
+			t.Fail()
+		}
+	}
+}
+
+func TestMaintenanceWindowActiveAt(t *testing.T) {
+	windows, err := newMaintenanceWindows([]lib.MaintenanceWindow{
+		lib.MaintenanceWindow{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "22:00", End: "06:00"},
+		lib.MaintenanceWindow{Start: "12:00", End: "12:30"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nightly, lunch := &windows[0], &windows[1]
+
+	// 2016-09-05 is a Monday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2016, 9, day, hour, minute, 0, 0, time.UTC)
+	}
+	cases := []struct {
+		name   string
+		window *maintenanceWindow
+		t      time.Time
+		end    time.Time
+		active bool
+	}{
+		{"monday night", nightly, at(5, 23, 0), at(6, 6, 0), true},
+		{"tuesday morning", nightly, at(6, 5, 59), at(6, 6, 0), true},
+		{"tuesday at end", nightly, at(6, 6, 0), time.Time{}, false},
+		{"monday before start", nightly, at(5, 21, 59), time.Time{}, false},
+		{"saturday morning", nightly, at(10, 1, 0), at(10, 6, 0), true},
+		{"saturday night", nightly, at(10, 23, 0), time.Time{}, false},
+		{"monday morning", nightly, at(5, 1, 0), time.Time{}, false},
+		{"lunch", lunch, at(11, 12, 15), at(11, 12, 30), true},
+		{"after lunch", lunch, at(11, 12, 30), time.Time{}, false},
+	}
+	for _, c := range cases {
+		end, active := c.window.activeAt(c.t)
+		if active != c.active || !end.Equal(c.end) {
+			t.Logf("%s: expected %v until %s, got %v until %s", c.name, c.active, c.end, active, end)
+			t.Fail()
+		}
+	}
+}
+
+// allDay is a maintenance window that is always open.
+func allDay(printers []string, jobPolicy string) lib.MaintenanceWindow {
+	return lib.MaintenanceWindow{
+		Printers:  printers,
+		Start:     "00:00",
+		End:       "00:00",
+		Message:   "Closed for the weekend",
+		JobPolicy: jobPolicy,
+	}
+}
+
+func TestSyncPrintersMaintenance(t *testing.T) {
+	native := nativetest.New()
+	native.AddPrinter(newTestPrinter("a"))
+	native.AddPrinter(newTestPrinter("b"))
+	cloud := &fakeCloudPrint{}
+	pm := newTestPrinterManager(t, native, cloud)
+
+	var err error
+	if pm.maintenanceWindows, err = newMaintenanceWindows([]lib.MaintenanceWindow{allDay([]string{"a"}, "")}); err != nil {
+		t.Fatal(err)
+	}
+	if err = pm.SyncPrinters(true); err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := pm.printers.GetByNativeName("a")
+	expected := &cdd.PrinterStateSection{
+		State: cdd.CloudDeviceStateStopped,
+		VendorState: &cdd.VendorState{Item: []cdd.VendorStateItem{cdd.VendorStateItem{
+			State:                cdd.VendorStateWarning,
+			DescriptionLocalized: cdd.NewLocalizedString("Closed for the weekend"),
+		}}},
+	}
+	if !reflect.DeepEqual(expected, a.State) {
+		t.Logf("expected printer in maintenance to be %+v, got %+v", expected, a.State)
+		t.Fail()
+	}
+	if b, _ := pm.printers.GetByNativeName("b"); b.State.State != cdd.CloudDeviceStateIdle {
+		t.Logf("expected printer without maintenance to be IDLE, got %s", b.State.State)
+		t.Fail()
+	}
+
+	// Nothing changes while the window is open.
+	cloud.reset()
+	if err = pm.SyncPrinters(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, updated, _ := cloud.reset(); len(updated) != 0 {
+		t.Logf("expected no updates during the window, got %v", updated)
+		t.Fail()
+	}
+
+	// The printer is available again when the window closes.
+	pm.maintenanceWindows = nil
+	if err = pm.SyncPrinters(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, updated, _ := cloud.reset(); !reflect.DeepEqual([]string{"a"}, updated) {
+		t.Logf("expected printer a updated after the window, got %v", updated)
+		t.Fail()
+	}
+	if a, _ = pm.printers.GetByNativeName("a"); a.State.State != cdd.CloudDeviceStateIdle || a.State.VendorState != nil {
+		t.Logf("expected printer a to be IDLE after the window, got %+v", a.State)
+		t.Fail()
+	}
+}
+
+func TestPrintJobMaintenance(t *testing.T) {
+	native := nativetest.New()
+	native.AddPrinter(newTestPrinter("held"))
+	native.AddPrinter(newTestPrinter("rejected"))
+	pm := newTestPrinterManager(t, native, nil)
+
+	var err error
+	if pm.maintenanceWindows, err = newMaintenanceWindows([]lib.MaintenanceWindow{
+		allDay([]string{"held"}, maintenanceJobPolicyHold),
+		allDay([]string{"rejected"}, maintenanceJobPolicyReject),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err = pm.SyncPrinters(true); err != nil {
+		t.Fatal(err)
+	}
+
+	var rejected jobRecorder
+	pm.printJob(newTestJob(t, "rejected", "rejected", &rejected))
+	if states := rejected.get(); !reflect.DeepEqual([]cdd.JobStateType{cdd.JobStateAborted}, states) {
+		t.Logf("expected job to be rejected, got states %v", states)
+		t.Fail()
+	}
+
+	var held jobRecorder
+	job := newTestJob(t, "held", "held", &held)
+	finished := make(chan struct{})
+	go func() {
+		pm.printJob(job)
+		close(finished)
+	}()
+	waitFor(t, "held job", func() bool { return len(held.get()) == 1 })
+	if err = pm.CancelJob("held"); err != nil {
+		t.Fatal(err)
+	}
+	<-finished
+
+	expected := []cdd.JobStateType{cdd.JobStateQueued, cdd.JobStateAborted}
+	if states := held.get(); !reflect.DeepEqual(expected, states) {
+		t.Logf("expected held job to be %v, got %v", expected, states)
+		t.Fail()
+	}
+	if jobs := native.Jobs(); len(jobs) != 0 {
+		t.Logf("expected no native jobs during maintenance, got %d", len(jobs))
+		t.Fail()
+	}
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/metrics"
+)
+
+var (
+	jobsFinished = metrics.NewCounter("jobs_total",
+		"Jobs finished, by printer and final state.", "printer", "state")
+	jobQueueSeconds = metrics.NewHistogram("job_queue_seconds",
+		"Time from receiving jobs to submitting them to the native print system.", metrics.LatencyBuckets)
+	jobPrintSeconds = metrics.NewHistogram("job_print_seconds",
+		"Time from submitting jobs to the native print system to their final state.", metrics.LatencyBuckets)
+	printerSyncSeconds = metrics.NewHistogram("printer_sync_seconds",
+		"Time to synchronize printers with the cloud.", metrics.LatencyBuckets)
+)
+
+// countFinishedJob wraps updateJob to count the job, and how long it took to
+// print, when the state of the job is reported DONE or ABORTED.
+//
+// The native job is looked up in the journal, so updateJob must be called
+// before the job is deleted from the journal.
+func (pm *PrinterManager) countFinishedJob(job *lib.Job, updateJob func(string, *cdd.PrintJobStateDiff) error) func(string, *cdd.PrintJobStateDiff) error {
+	return func(jobID string, state *cdd.PrintJobStateDiff) error {
+		err := updateJob(jobID, state)
+		if state.State == nil ||
+			(state.State.Type != cdd.JobStateDone && state.State.Type != cdd.JobStateAborted) {
+			return err
+		}
+
+		jobsFinished.Inc(job.NativePrinterName, string(state.State.Type))
+		if entry, exists := pm.journal.get(jobID); exists {
+			jobPrintSeconds.Observe(time.Since(entry.SubmittedAt).Seconds())
+		}
+		return err
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"fmt"
+	"sort"
+)
+
+// pauseWindow is the maintenance window of paused printers. It has no end;
+// it lasts until the printer is resumed.
+var pauseWindow = maintenanceWindow{
+	name:    "pause",
+	message: "Paused by an administrator",
+}
+
+// PausePrinter pauses a printer. A paused printer is reported STOPPED, and
+// holds new jobs until it is resumed, like during a maintenance window.
+// Jobs already submitted to the native print system are not affected.
+func (pm *PrinterManager) PausePrinter(printerName string) error {
+	if _, exists := pm.printers.GetByNativeName(printerName); !exists {
+		return fmt.Errorf("Printer %s does not exist", printerName)
+	}
+
+	pm.pausedMutex.Lock()
+	if pm.paused == nil {
+		pm.paused = make(map[string]struct{})
+	}
+	pm.paused[printerName] = struct{}{}
+	pm.pausedMutex.Unlock()
+
+	return pm.SyncPrinters(false)
+}
+
+// ResumePrinter resumes a paused printer, and the jobs it held.
+func (pm *PrinterManager) ResumePrinter(printerName string) error {
+	pm.pausedMutex.Lock()
+	if _, exists := pm.paused[printerName]; !exists {
+		pm.pausedMutex.Unlock()
+		return fmt.Errorf("Printer %s is not paused", printerName)
+	}
+	delete(pm.paused, printerName)
+	if pm.resumed != nil {
+		close(pm.resumed)
+		pm.resumed = nil
+	}
+	pm.pausedMutex.Unlock()
+
+	return pm.SyncPrinters(false)
+}
+
+// isPaused checks whether a printer is paused.
+func (pm *PrinterManager) isPaused(printerName string) bool {
+	pm.pausedMutex.Lock()
+	defer pm.pausedMutex.Unlock()
+
+	_, exists := pm.paused[printerName]
+	return exists
+}
+
+// GetPausedPrinters returns the names of the paused printers, sorted.
+func (pm *PrinterManager) GetPausedPrinters() []string {
+	pm.pausedMutex.Lock()
+	defer pm.pausedMutex.Unlock()
+
+	names := make([]string, 0, len(pm.paused))
+	for name := range pm.paused {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resumedChannel returns a channel that is closed when any printer is
+// resumed.
+func (pm *PrinterManager) resumedChannel() <-chan struct{} {
+	pm.pausedMutex.Lock()
+	defer pm.pausedMutex.Unlock()
+
+	if pm.resumed == nil {
+		pm.resumed = make(chan struct{})
+	}
+	return pm.resumed
+}
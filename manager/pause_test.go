@@ -0,0 +1,82 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/nativetest"
+)
+
+func TestPausePrinter(t *testing.T) {
+	native := nativetest.New()
+	native.AddPrinter(newTestPrinter("a"))
+	pm := newTestPrinterManager(t, native, nil)
+	if err := pm.SyncPrinters(true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pm.PausePrinter("missing"); err == nil {
+		t.Log("expected error from pausing a missing printer")
+		t.Fail()
+	}
+	if err := pm.PausePrinter("a"); err != nil {
+		t.Fatal(err)
+	}
+	if a, _ := pm.printers.GetByNativeName("a"); a.State.State != cdd.CloudDeviceStateStopped {
+		t.Logf("expected paused printer to be STOPPED, got %s", a.State.State)
+		t.Fail()
+	}
+	if paused := pm.GetPausedPrinters(); !reflect.DeepEqual([]string{"a"}, paused) {
+		t.Logf("expected printer a paused, got %v", paused)
+		t.Fail()
+	}
+
+	var held jobRecorder
+	finished := make(chan struct{})
+	go func() {
+		pm.printJob(newTestJob(t, "held", "a", &held))
+		close(finished)
+	}()
+	waitFor(t, "held job", func() bool { return len(held.get()) == 1 })
+	if jobs := pm.GetJobsInFlight(); len(jobs) != 1 || jobs[0].JobID != "held" || jobs[0].NativeJobID != 0 {
+		t.Logf("expected held job in flight without a native job, got %+v", jobs)
+		t.Fail()
+	}
+	if jobs := native.Jobs(); len(jobs) != 0 {
+		t.Logf("expected no native jobs while paused, got %d", len(jobs))
+		t.Fail()
+	}
+
+	if err := pm.ResumePrinter("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.ResumePrinter("a"); err == nil {
+		t.Log("expected error from resuming a printer that is not paused")
+		t.Fail()
+	}
+	waitFor(t, "native job", func() bool { return len(native.Jobs()) == 1 })
+	if jobs := pm.GetJobsInFlight(); len(jobs) != 1 || jobs[0].NativeJobID != native.Jobs()[0].ID {
+		t.Logf("expected job in flight with native job %d, got %+v", native.Jobs()[0].ID, jobs)
+		t.Fail()
+	}
+	native.SetJobState(native.Jobs()[0].ID, cdd.PrintJobStateDiff{State: &cdd.JobState{Type: cdd.JobStateDone}})
+	<-finished
+
+	if a, _ := pm.printers.GetByNativeName("a"); a.State.State != cdd.CloudDeviceStateIdle {
+		t.Logf("expected resumed printer to be IDLE, got %s", a.State.State)
+		t.Fail()
+	}
+	if states := held.get(); states[0] != cdd.JobStateQueued || states[len(states)-1] != cdd.JobStateDone {
+		t.Logf("expected job QUEUED then DONE, got %v", states)
+		t.Fail()
+	}
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+)
+
+// poolMembersTag is the printer tag that lists the members of a pool.
+const poolMembersTag = "pool-members"
+
+// printerPool is a virtual printer that prints each job on one of its
+// member native printers.
+type printerPool struct {
+	lib.PrinterPool
+
+	// next rotates the choice between equally busy members.
+	next uint32
+}
+
+// newPrinterPools validates pool configs, and organizes them by name.
+func newPrinterPools(pools []lib.PrinterPool) (map[string]*printerPool, error) {
+	m := make(map[string]*printerPool, len(pools))
+	for _, pool := range pools {
+		if pool.Name == "" {
+			return nil, errors.New("Printer pool has no name")
+		}
+		if len(pool.Members) == 0 {
+			return nil, fmt.Errorf("Printer pool %s has no members", pool.Name)
+		}
+		if _, exists := m[pool.Name]; exists {
+			return nil, fmt.Errorf("Printer pool %s is defined more than once", pool.Name)
+		}
+		m[pool.Name] = &printerPool{PrinterPool: pool}
+	}
+	return m, nil
+}
+
+// addPoolPrinters adds a virtual printer for each pool that has at least one
+// member in nativePrinters, and removes the members of pools that hide their
+// members. The removed members are kept in pm.hiddenPrinters.
+func (pm *PrinterManager) addPoolPrinters(nativePrinters []lib.Printer) []lib.Printer {
+	if len(pm.pools) == 0 {
+		return nativePrinters
+	}
+
+	byName := make(map[string]lib.Printer, len(nativePrinters))
+	for _, printer := range nativePrinters {
+		byName[printer.Name] = printer
+	}
+
+	hidden := make(map[string]struct{})
+	poolPrinters := make([]lib.Printer, 0, len(pm.pools))
+	for _, pool := range pm.pools {
+		if _, exists := byName[pool.Name]; exists {
+			log.WarningPrinterf(pool.Name, "Printer pool has the same name as a native printer; ignoring the pool")
+			continue
+		}
+
+		members := make([]lib.Printer, 0, len(pool.Members))
+		for _, name := range pool.Members {
+			if member, exists := byName[name]; exists {
+				members = append(members, member)
+				if pool.HideMembers {
+					hidden[name] = struct{}{}
+				}
+			}
+		}
+		if len(members) == 0 {
+			log.WarningPrinterf(pool.Name, "None of the printer pool members are present")
+			continue
+		}
+
+		poolPrinters = append(poolPrinters, newPoolPrinter(pool, members))
+	}
+
+	printers := make([]lib.Printer, 0, len(nativePrinters)+len(poolPrinters))
+	hiddenPrinters := make([]lib.Printer, 0, len(hidden))
+	for _, printer := range nativePrinters {
+		if _, exists := hidden[printer.Name]; !exists {
+			printers = append(printers, printer)
+			continue
+		}
+		// Hidden printers are not synced, so keep their semaphores here.
+		if old, exists := pm.hiddenPrinters.GetByNativeName(printer.Name); exists {
+			printer.NativeJobSemaphore = old.NativeJobSemaphore
+		} else {
+			printer.NativeJobSemaphore = lib.NewSemaphore(pm.nativeJobQueueSize)
+		}
+		hiddenPrinters = append(hiddenPrinters, printer)
+	}
+	pm.hiddenPrinters.Refresh(hiddenPrinters)
+
+	return append(printers, poolPrinters...)
+}
+
+// newPoolPrinter describes the virtual printer of a pool. The pool can print
+// what every member can print, and is as available as its most available
+// member.
+func newPoolPrinter(pool *printerPool, members []lib.Printer) lib.Printer {
+	printer := members[0]
+	printer.Name = pool.Name
+	printer.DefaultDisplayName = pool.DisplayName
+	if printer.DefaultDisplayName == "" {
+		printer.DefaultDisplayName = pool.Name
+	}
+	printer.UUID = ""
+	printer.DuplexMap = nil
+	printer.NativeJobSemaphore = nil
+
+	if members[0].Description != nil {
+		description := *members[0].Description
+		for _, member := range members[1:] {
+			if member.Description == nil {
+				continue
+			}
+			description.Intersect(member.Description)
+		}
+		printer.Description = &description
+	}
+
+	state := cdd.CloudDeviceStateStopped
+	for _, member := range members {
+		if member.State == nil || member.State.State == cdd.CloudDeviceStateIdle {
+			state = cdd.CloudDeviceStateIdle
+			break
+		}
+		if member.State.State == cdd.CloudDeviceStateProcessing {
+			state = cdd.CloudDeviceStateProcessing
+		}
+	}
+	printer.State = &cdd.PrinterStateSection{State: state}
+
+	tags := make(map[string]string, len(members[0].Tags)+1)
+	for key, value := range members[0].Tags {
+		tags[key] = value
+	}
+	for _, member := range members[1:] {
+		for key, value := range tags {
+			if member.Tags[key] != value {
+				delete(tags, key)
+			}
+		}
+	}
+	memberNames := make([]string, len(members))
+	for i := range members {
+		memberNames[i] = members[i].Name
+	}
+	tags[poolMembersTag] = strings.Join(memberNames, ",")
+	printer.Tags = tags
+
+	return printer
+}
+
+// getNativePrinter gets a printer by native name, including the printers
+// hidden by pools.
+func (pm *PrinterManager) getNativePrinter(name string) (lib.Printer, bool) {
+	if printer, exists := pm.printers.GetByNativeName(name); exists {
+		return printer, true
+	}
+	return pm.hiddenPrinters.GetByNativeName(name)
+}
+
+// choosePoolMember chooses the least busy member of a pool, skipping the
+// members in tried. Members are ranked by their jobs in flight, then by the
+// jobs being submitted to them, then by whether they are printing. Members that are STOPPED are chosen only when no other
+// member is left.
+//
+// Returns false if every member has been tried, or is gone.
+func (pm *PrinterManager) choosePoolMember(pool *printerPool, tried map[string]struct{}) (lib.Printer, bool) {
+	pm.jobsInFlightMutex.Lock()
+	inFlight := make(map[string]int)
+	for _, j := range pm.jobsInFlight {
+		if j.memberPrinterName != "" {
+			inFlight[j.memberPrinterName]++
+		}
+	}
+	pm.jobsInFlightMutex.Unlock()
+
+	// The jobs in flight include those still printing, so they count
+	// first; the semaphore only counts jobs being submitted.
+	busier := func(a, b lib.Printer) bool {
+		if inFlight[a.Name] != inFlight[b.Name] {
+			return inFlight[a.Name] > inFlight[b.Name]
+		}
+		if a.NativeJobSemaphore.Count() != b.NativeJobSemaphore.Count() {
+			return a.NativeJobSemaphore.Count() > b.NativeJobSemaphore.Count()
+		}
+		aProcessing := a.State != nil && a.State.State == cdd.CloudDeviceStateProcessing
+		bProcessing := b.State != nil && b.State.State == cdd.CloudDeviceStateProcessing
+		return aProcessing && !bProcessing
+	}
+
+	var best, bestStopped lib.Printer
+	var found, foundStopped bool
+
+	// Start at a different member each time, so that ties are spread out.
+	start := int(atomic.AddUint32(&pool.next, 1))
+	for i := range pool.Members {
+		name := pool.Members[(start+i)%len(pool.Members)]
+		if _, exists := tried[name]; exists {
+			continue
+		}
+		member, exists := pm.getNativePrinter(name)
+		if !exists || member.NativeJobSemaphore == nil {
+			continue
+		}
+
+		if member.State != nil && member.State.State == cdd.CloudDeviceStateStopped {
+			if !foundStopped || busier(bestStopped, member) {
+				bestStopped, foundStopped = member, true
+			}
+		} else if !found || busier(best, member) {
+			best, found = member, true
+		}
+	}
+
+	if found {
+		return best, true
+	}
+	return bestStopped, foundStopped
+}
+
+// setInFlightJobMember records the pool member that a job in flight was
+// sent to.
+func (pm *PrinterManager) setInFlightJobMember(jobID, memberPrinterName string) {
+	pm.jobsInFlightMutex.Lock()
+	defer pm.jobsInFlightMutex.Unlock()
+
+	if j, exists := pm.jobsInFlight[jobID]; exists {
+		j.memberPrinterName = memberPrinterName
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+func TestNewPoolPrinter(t *testing.T) {
+	pool := &printerPool{PrinterPool: lib.PrinterPool{Name: "pool", Members: []string{"a", "b"}}}
+	a := lib.Printer{
+		Name:  "a",
+		State: &cdd.PrinterStateSection{State: cdd.CloudDeviceStateStopped},
+		Description: &cdd.PrinterDescriptionSection{
+			Color: &cdd.Color{Option: []cdd.ColorOption{
+				cdd.ColorOption{VendorID: "1", Type: cdd.ColorTypeStandardColor, IsDefault: true},
+				cdd.ColorOption{VendorID: "2", Type: cdd.ColorTypeStandardMonochrome},
+			}},
+			Copies: &cdd.Copies{Default: 1, Max: 100},
+		},
+		Tags: map[string]string{"location": "room 1", "printer-make-and-model": "x"},
+	}
+	b := lib.Printer{
+		Name:  "b",
+		State: &cdd.PrinterStateSection{State: cdd.CloudDeviceStateProcessing},
+		Description: &cdd.PrinterDescriptionSection{
+			Color: &cdd.Color{Option: []cdd.ColorOption{
+				cdd.ColorOption{VendorID: "2", Type: cdd.ColorTypeStandardMonochrome},
+			}},
+			Copies: &cdd.Copies{Default: 1, Max: 10},
+		},
+		Tags: map[string]string{"location": "room 2", "printer-make-and-model": "x"},
+	}
+
+	p := newPoolPrinter(pool, []lib.Printer{a, b})
+
+	if p.Name != "pool" || p.DefaultDisplayName != "pool" {
+		t.Logf("unexpected names %s %s", p.Name, p.DefaultDisplayName)
+		t.Fail()
+	}
+	if p.State.State != cdd.CloudDeviceStateProcessing {
+		t.Logf("expected state PROCESSING, got %s", p.State.State)
+		t.Fail()
+	}
+
+	expectedColor := &cdd.Color{Option: []cdd.ColorOption{
+		cdd.ColorOption{VendorID: "2", Type: cdd.ColorTypeStandardMonochrome, IsDefault: true},
+	}}
+	if !reflect.DeepEqual(p.Description.Color, expectedColor) {
+		t.Logf("expected color %+v, got %+v", expectedColor, p.Description.Color)
+		t.Fail()
+	}
+	if p.Description.Copies.Max != 10 {
+		t.Logf("expected max copies 10, got %d", p.Description.Copies.Max)
+		t.Fail()
+	}
+	if len(a.Description.Color.Option) != 2 || !a.Description.Color.Option[0].IsDefault {
+		t.Log("member description was modified")
+		t.Fail()
+	}
+
+	expectedTags := map[string]string{"printer-make-and-model": "x", poolMembersTag: "a,b"}
+	if !reflect.DeepEqual(p.Tags, expectedTags) {
+		t.Logf("expected tags %+v, got %+v", expectedTags, p.Tags)
+		t.Fail()
+	}
+}
+
+func TestChoosePoolMember(t *testing.T) {
+	pool := &printerPool{PrinterPool: lib.PrinterPool{Name: "pool", Members: []string{"a", "b", "c"}}}
+	a := lib.Printer{
+		Name:               "a",
+		State:              &cdd.PrinterStateSection{State: cdd.CloudDeviceStateStopped},
+		NativeJobSemaphore: lib.NewSemaphore(3),
+	}
+	b := lib.Printer{
+		Name:               "b",
+		State:              &cdd.PrinterStateSection{State: cdd.CloudDeviceStateIdle},
+		NativeJobSemaphore: lib.NewSemaphore(3),
+	}
+	c := lib.Printer{
+		Name:               "c",
+		State:              &cdd.PrinterStateSection{State: cdd.CloudDeviceStateIdle},
+		NativeJobSemaphore: lib.NewSemaphore(3),
+	}
+	c.NativeJobSemaphore.Acquire()
+
+	pm := PrinterManager{
+		printers:       lib.NewConcurrentPrinterMap([]lib.Printer{a, b, c}),
+		hiddenPrinters: lib.NewConcurrentPrinterMap(nil),
+		jobsInFlight:   make(map[string]*jobInFlight),
+	}
+
+	tried := make(map[string]struct{})
+	for _, expected := range []string{"b", "c", "a"} {
+		member, ok := pm.choosePoolMember(pool, tried)
+		if !ok || member.Name != expected {
+			t.Fatalf("expected member %s, got %s", expected, member.Name)
+		}
+		tried[member.Name] = struct{}{}
+	}
+	if _, ok := pm.choosePoolMember(pool, tried); ok {
+		t.Log("expected no member after all were tried")
+		t.Fail()
+	}
+
+	// Jobs that are still printing make a member busier than a job that is
+	// being submitted.
+	pm.jobsInFlight["1"] = &jobInFlight{memberPrinterName: "b"}
+	pm.jobsInFlight["2"] = &jobInFlight{memberPrinterName: "b"}
+	if member, ok := pm.choosePoolMember(pool, map[string]struct{}{}); !ok || member.Name != "c" {
+		t.Logf("expected member c, got %s", member.Name)
+		t.Fail()
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"hash/adler32"
 	"os"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -29,43 +30,300 @@ import (
 type NativePrintSystem interface {
 	GetPrinters() ([]lib.Printer, error)
 	GetJobState(printerName string, jobID uint32) (*cdd.PrintJobStateDiff, error)
+	CancelJob(printerName string, jobID uint32) error
 	Print(printer *lib.Printer, fileName, title, user, gcpJobID string, ticket *cdd.CloudJobTicket) (uint32, error)
 	ReleaseJob(printerName string, jobID uint32) error
 	RemoveCachedPPD(printerName string)
 }
 
+// cloudPrint is the part of gcp.CloudPrint that PrinterManager uses.
+type cloudPrint interface {
+	ListPrinters() ([]lib.Printer, map[string]uint, error)
+	ListQuotas() (map[string]gcp.Quota, error)
+	Register(printer *lib.Printer) error
+	Update(diff *lib.PrinterDiff) error
+	Delete(gcpID string) error
+	CanShare() bool
+	Share(gcpID, shareScope string, role gcp.Role, skipNotification bool, public bool) error
+	Jobs(gcpID string) ([]gcp.Job, error)
+	Control(jobID string, state *cdd.PrintJobStateDiff) error
+	HandleJobs(printer *lib.Printer, reportJobFailed func())
+}
+
+// jobInFlight describes a job that has been received, and is not finished
+// printing yet.
+type jobInFlight struct {
+	origin            lib.JobOrigin
+	nativePrinterName string
+	// memberPrinterName is the pool member that the job was sent to, if
+	// the job was sent to a printer pool.
+	memberPrinterName string
+	// cancel is closed to ask the job to stop printing.
+	cancel chan struct{}
+}
+
+// NativeJobSubscriber is implemented by native print systems that can tell
+// when a job changes state, so that the job state need not be polled.
+type NativeJobSubscriber interface {
+	// SubscribeJobState returns a channel that receives the job state each
+	// time it changes. The channel is closed when the subscription fails.
+	SubscribeJobState(printerName string, jobID uint32) (<-chan *cdd.PrintJobStateDiff, error)
+	UnsubscribeJobState(printerName string, jobID uint32)
+}
+
+// NativeJobHolder is implemented by native print systems that can hold jobs
+// printed to printers with lib.Printer.HoldJobs, for secure release.
+type NativeJobHolder interface {
+	// ReleaseHeldJob lets a held job print.
+	ReleaseHeldJob(printerName string, jobID uint32) error
+}
+
+const (
+	// jobStatePollInterval is the time between native job state polls.
+	jobStatePollInterval = time.Second
+	// subscribedJobStatePollInterval is the time between native job state
+	// polls when the native print system also reports state changes. This
+	// poll is just a safety net for missed changes.
+	subscribedJobStatePollInterval = time.Minute
+	// cloudJobCancelPollInterval is the time between checks for cloud jobs
+	// that were canceled by the user while printing.
+	cloudJobCancelPollInterval = 15 * time.Second
+)
+
 // Manages state and interactions between the native print system and Google Cloud Print.
 type PrinterManager struct {
 	native NativePrintSystem
-	gcp    *gcp.GoogleCloudPrint
+	gcp    cloudPrint
 	xmpp   *xmpp.XMPP
 	privet *privet.Privet
 
 	printers *lib.ConcurrentPrinterMap
 
+	// syncMutex is held for the whole of a printer sync. Syncs are started
+	// by the poll timer, SIGHUP, the monitor and pausing printers; two at
+	// once would diff against the same snapshot of printers.
+	syncMutex sync.Mutex
+
+	// Printer syncs apply at most syncMaxConcurrency diffs at once, zero
+	// being unlimited, and call GCP no faster than syncRateLimit allows.
+	syncMaxConcurrency uint
+	syncRateLimit      *tokenBucket
+
+	// Sync stats are numbers reported to monitoring, about the latest sync.
+	syncStatsMutex  sync.Mutex
+	syncDiffs       uint
+	syncDiffsDone   uint
+	syncDiffsFailed uint
+	// lastSync is when printers were last synchronized without error,
+	// lastSyncProgress is when a sync last started, applied a change or
+	// ended, and syncInterval is the current poll interval, for health
+	// checks.
+	lastSync         time.Time
+	lastSyncProgress time.Time
+	syncInterval     time.Duration
+
+	// recentJobs are the jobs that finished most recently, oldest first.
+	recentJobsMutex sync.Mutex
+	recentJobs      []lib.MonitorJob
+
 	// Job stats are numbers reported to monitoring.
 	jobStatsMutex sync.Mutex
 	jobsDone      uint
 	jobsError     uint
+	jobsTimedOut  uint
 
 	// Jobs in flight are jobs that have been received, and are not
 	// finished printing yet. Key is Job ID.
 	jobsInFlightMutex sync.Mutex
-	jobsInFlight      map[string]struct{}
+	jobsInFlight      map[string]*jobInFlight
+
+	// The journal remembers jobs in flight across connector restarts.
+	journal *jobJournal
+
+	// The quota ledger counts pages printed per user, to enforce the daily
+	// quotas of printers.
+	quotas *quotaLedger
+
+	// The accounting log records each finished job; nil when disabled.
+	accounting *accountingLog
+
+	// Retry policies for failed native job submissions. Key is native
+	// printer name; printers without their own policy use jobRetryPolicy.
+	jobRetryPolicy          *jobRetryPolicy
+	printerJobRetryPolicies map[string]*jobRetryPolicy
+
+	// Timeouts of native jobs. Key is native printer name; printers without
+	// their own timeouts use jobTimeouts.
+	jobTimeouts        *jobTimeouts
+	printerJobTimeouts map[string]*jobTimeouts
+
+	// Printer pools are virtual printers that print on member printers.
+	// Key is pool name. Members that are hidden by their pool are not in
+	// printers, but in hiddenPrinters.
+	pools          map[string]*printerPool
+	hiddenPrinters *lib.ConcurrentPrinterMap
+
+	// Routing rules redirect, reject or rewrite jobs as they are received.
+	routingRules []routingRule
+
+	// Hooks are called as jobs are received, submitted and finished.
+	hooks []jobHook
+
+	// Jobs wait for their turn to submit to a native printer in the job
+	// queue of the printer, in the order of the priorities that the rules
+	// give them. When nativeJobPriority is set, the native print system is
+	// told the priority too. Key is native printer name.
+	jobPriorityRules  []jobPriorityRule
+	nativeJobPriority bool
+	jobQueuesMutex    sync.Mutex
+	jobQueues         map[string]*jobQueue
+
+	// During maintenance windows, printers are reported STOPPED, and jobs
+	// are held or rejected.
+	maintenanceWindows []maintenanceWindow
+
+	// Paused printers are held as if in a maintenance window, until they
+	// are resumed. resumed is closed when any printer is resumed.
+	pausedMutex sync.Mutex
+	paused      map[string]struct{}
+	resumed     chan struct{}
+
+	// Secure release printers hold jobs until they are released with a PIN.
+	// Held jobs are keyed by Job ID; release throttles by printer name.
+	secureReleasePrinters map[string]struct{}
+	secureReleaseTimeout  time.Duration
+	heldJobsMutex         sync.Mutex
+	heldJobs              map[string]*heldJob
+	releaseThrottles      map[string]*releaseThrottle
 
 	nativeJobQueueSize uint
 	jobFullUsername    bool
 	shareScope         string
 
+	// pollIntervals receives new intervals between printer syncs.
+	pollIntervals chan time.Duration
+
+	// draining is closed when the connector stops accepting new jobs.
+	// abandon is closed when the jobs still in flight must be handed off.
+	draining chan struct{}
+	abandon  chan struct{}
+
 	quit   chan struct{}
 	useFcm bool
 }
 
-func NewPrinterManager(native NativePrintSystem, gcp *gcp.GoogleCloudPrint, privet *privet.Privet, printerPollInterval time.Duration, nativeJobQueueSize uint, jobFullUsername bool, shareScope string, jobs <-chan *lib.Job, notifications <-chan notification.PrinterNotification, useFcm bool) (*PrinterManager, error) {
+// PrinterManagerConfig is the configuration of a PrinterManager.
+type PrinterManagerConfig struct {
+	// PrinterPollInterval is the time between native printer syncs.
+	PrinterPollInterval time.Duration
+	// SyncMaxConcurrency is the most printers synced with the cloud at
+	// once. Zero is unlimited.
+	SyncMaxConcurrency uint
+	// SyncRateLimit is the most cloud calls per second made by syncs, with
+	// bursts of up to SyncRateBurst calls. Zero rate is unlimited.
+	SyncRateLimit float64
+	SyncRateBurst uint
+
+	NativeJobQueueSize uint
+	JobFullUsername    bool
+	ShareScope         string
+
+	// JobJournalFilename is where jobs in flight are recorded.
+	JobJournalFilename string
+	// QuotaLedgerFilename is where the pages printed today are counted.
+	QuotaLedgerFilename string
+	AccountingLog       *lib.AccountingLog
+
+	RetryPolicy          *lib.RetryPolicy
+	PrinterRetryPolicies map[string]lib.RetryPolicy
+	NativeJobTimeouts    *lib.JobTimeouts
+	PrinterJobTimeouts   map[string]lib.JobTimeouts
+
+	PrinterPools       []lib.PrinterPool
+	RoutingRules       []lib.RoutingRule
+	JobHooks           []lib.JobHook
+	JobPriorities      []lib.JobPriority
+	NativeJobPriority  bool
+	MaintenanceWindows []lib.MaintenanceWindow
+
+	// SecureReleasePrinters hold jobs until they are released with a PIN,
+	// for up to SecureReleaseTimeout.
+	SecureReleasePrinters []string
+	SecureReleaseTimeout  time.Duration
+
+	UseFcm bool
+}
+
+func NewPrinterManager(native NativePrintSystem, gcp gcp.CloudPrint, privet *privet.Privet, jobs <-chan *lib.Job, notifications <-chan notification.PrinterNotification, config PrinterManagerConfig) (*PrinterManager, error) {
 	var printers *lib.ConcurrentPrinterMap
 	var queuedJobsCount map[string]uint
 
-	var err error
+	journal, err := newJobJournal(config.JobJournalFilename)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read job journal %s: %s", config.JobJournalFilename, err)
+	}
+	quotas, err := newQuotaLedger(config.QuotaLedgerFilename)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read quota ledger %s: %s", config.QuotaLedgerFilename, err)
+	}
+	accounting, err := newAccountingLog(config.AccountingLog)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.RetryPolicy == nil {
+		config.RetryPolicy = &lib.RetryPolicy{}
+	}
+	defaultRetryPolicy, err := newJobRetryPolicy(*config.RetryPolicy, nil)
+	if err != nil {
+		return nil, err
+	}
+	retryPolicies := make(map[string]*jobRetryPolicy, len(config.PrinterRetryPolicies))
+	for printerName, policy := range config.PrinterRetryPolicies {
+		if retryPolicies[printerName], err = newJobRetryPolicy(policy, defaultRetryPolicy); err != nil {
+			return nil, fmt.Errorf("Bad retry policy for printer %s: %s", printerName, err)
+		}
+	}
+
+	if config.NativeJobTimeouts == nil {
+		config.NativeJobTimeouts = &lib.JobTimeouts{}
+	}
+	defaultTimeouts, err := newJobTimeouts(*config.NativeJobTimeouts, nil)
+	if err != nil {
+		return nil, err
+	}
+	timeouts := make(map[string]*jobTimeouts, len(config.PrinterJobTimeouts))
+	for printerName, t := range config.PrinterJobTimeouts {
+		if timeouts[printerName], err = newJobTimeouts(t, defaultTimeouts); err != nil {
+			return nil, fmt.Errorf("Bad job timeouts for printer %s: %s", printerName, err)
+		}
+	}
+
+	pools, err := newPrinterPools(config.PrinterPools)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := newRoutingRules(config.RoutingRules)
+	if err != nil {
+		return nil, err
+	}
+	hooks, err := newJobHooks(config.JobHooks)
+	if err != nil {
+		return nil, err
+	}
+	priorityRules, err := newJobPriorityRules(config.JobPriorities)
+	if err != nil {
+		return nil, err
+	}
+	windows, err := newMaintenanceWindows(config.MaintenanceWindows)
+	if err != nil {
+		return nil, err
+	}
+	secureRelease := make(map[string]struct{}, len(config.SecureReleasePrinters))
+	for _, printerName := range config.SecureReleasePrinters {
+		secureRelease[printerName] = struct{}{}
+	}
+
 	if gcp != nil {
 		// Get all GCP printers.
 		var gcpPrinters []lib.Printer
@@ -75,7 +333,7 @@ func NewPrinterManager(native NativePrintSystem, gcp *gcp.GoogleCloudPrint, priv
 		}
 		// Organize the GCP printers into a map.
 		for i := range gcpPrinters {
-			gcpPrinters[i].NativeJobSemaphore = lib.NewSemaphore(nativeJobQueueSize)
+			gcpPrinters[i].NativeJobSemaphore = lib.NewSemaphore(config.NativeJobQueueSize)
 		}
 		printers = lib.NewConcurrentPrinterMap(gcpPrinters)
 	} else {
@@ -90,21 +348,56 @@ func NewPrinterManager(native NativePrintSystem, gcp *gcp.GoogleCloudPrint, priv
 
 		printers: printers,
 
+		syncMaxConcurrency: config.SyncMaxConcurrency,
+		syncRateLimit:      newTokenBucket(config.SyncRateLimit, config.SyncRateBurst),
+
 		jobStatsMutex: sync.Mutex{},
 		jobsDone:      0,
 		jobsError:     0,
 
 		jobsInFlightMutex: sync.Mutex{},
-		jobsInFlight:      make(map[string]struct{}),
+		jobsInFlight:      make(map[string]*jobInFlight),
 
-		nativeJobQueueSize: nativeJobQueueSize,
-		jobFullUsername:    jobFullUsername,
-		shareScope:         shareScope,
+		journal:    journal,
+		quotas:     quotas,
+		accounting: accounting,
+
+		jobRetryPolicy:          defaultRetryPolicy,
+		printerJobRetryPolicies: retryPolicies,
+
+		jobTimeouts:        defaultTimeouts,
+		printerJobTimeouts: timeouts,
+
+		pools:          pools,
+		hiddenPrinters: lib.NewConcurrentPrinterMap(nil),
+
+		routingRules: rules,
+		hooks:        hooks,
+
+		jobPriorityRules:  priorityRules,
+		nativeJobPriority: config.NativeJobPriority,
+		jobQueues:         make(map[string]*jobQueue),
+
+		maintenanceWindows: windows,
+
+		secureReleasePrinters: secureRelease,
+		secureReleaseTimeout:  config.SecureReleaseTimeout,
+		heldJobsMutex:         sync.Mutex{},
+		heldJobs:              make(map[string]*heldJob),
+		releaseThrottles:      make(map[string]*releaseThrottle),
+
+		nativeJobQueueSize: config.NativeJobQueueSize,
+		jobFullUsername:    config.JobFullUsername,
+		shareScope:         config.ShareScope,
+
+		pollIntervals: make(chan time.Duration),
+
+		draining: make(chan struct{}),
+		abandon:  make(chan struct{}),
 
 		quit:   make(chan struct{}),
-		useFcm: useFcm,
+		useFcm: config.UseFcm,
 	}
-
 	// Sync once before returning, to make sure things are working.
 	// Ignore privet updates this first time because Privet always starts
 	// with zero printers.
@@ -115,7 +408,7 @@ func NewPrinterManager(native NativePrintSystem, gcp *gcp.GoogleCloudPrint, priv
 	// Initialize Privet printers.
 	if privet != nil {
 		for _, printer := range pm.printers.GetAll() {
-			err := privet.AddPrinter(printer, pm.printers.GetByNativeName)
+			err := privet.AddPrinter(printer, pm.printers.GetByNativeName, pm.CancelJob, pm.ReleaseJobs)
 			if err != nil {
 				log.WarningPrinterf(printer.Name, "Failed to register locally: %s", err)
 			} else {
@@ -124,10 +417,16 @@ func NewPrinterManager(native NativePrintSystem, gcp *gcp.GoogleCloudPrint, priv
 		}
 	}
 
-	pm.syncPrintersPeriodically(printerPollInterval)
+	// Pick up where the previous connector process left off, before
+	// accepting new jobs.
+	pm.resumeJournaledJobs()
+
+	pm.syncPrintersPeriodically(config.PrinterPollInterval)
 	pm.listenNotifications(jobs, notifications)
 
 	if gcp != nil {
+		pm.checkCloudCancellationsPeriodically()
+
 		for gcpPrinterID := range queuedJobsCount {
 			p, _ := printers.GetByGCPID(gcpPrinterID)
 			go gcp.HandleJobs(&p, func() { pm.incrementJobsProcessed(false) })
@@ -142,6 +441,7 @@ func (pm *PrinterManager) Quit() {
 }
 
 func (pm *PrinterManager) syncPrintersPeriodically(interval time.Duration) {
+	pm.setSyncInterval(interval)
 	go func() {
 		t := time.NewTimer(interval)
 		defer t.Stop()
@@ -154,6 +454,13 @@ func (pm *PrinterManager) syncPrintersPeriodically(interval time.Duration) {
 				}
 				t.Reset(interval)
 
+			case interval = <-pm.pollIntervals:
+				pm.setSyncInterval(interval)
+				if !t.Stop() {
+					<-t.C
+				}
+				t.Reset(interval)
+
 			case <-pm.quit:
 				return
 			}
@@ -161,14 +468,38 @@ func (pm *PrinterManager) syncPrintersPeriodically(interval time.Duration) {
 	}()
 }
 
+// SetPrinterPollInterval changes the interval between printer syncs. The
+// next sync is one new interval from now.
+func (pm *PrinterManager) SetPrinterPollInterval(interval time.Duration) {
+	select {
+	case pm.pollIntervals <- interval:
+	case <-pm.quit:
+	}
+}
+
 func (pm *PrinterManager) SyncPrinters(ignorePrivet bool) error {
+	pm.syncMutex.Lock()
+	defer pm.syncMutex.Unlock()
+
+	pm.setSyncProgress()
+	defer pm.setSyncProgress()
+
 	log.Debug("Synchronizing printers, stand by")
+	start := time.Now()
+	defer func() { printerSyncSeconds.Observe(time.Since(start).Seconds()) }()
 
 	// Get current snapshot of native printers.
 	nativePrinters, err := pm.native.GetPrinters()
 	if err != nil {
 		return fmt.Errorf("Sync failed while calling GetPrinters(): %s", err)
 	}
+	// Set maintenance states before adding pools, so that pools see the
+	// states of their members, then again for windows of pools.
+	now := time.Now()
+	pm.setMaintenanceStates(nativePrinters, now)
+	nativePrinters = pm.addPoolPrinters(nativePrinters)
+	pm.setMaintenanceStates(nativePrinters, now)
+	pm.addSecureReleaseCapability(nativePrinters)
 
 	// Set CapsHash on all printers.
 	for i := range nativePrinters {
@@ -187,17 +518,41 @@ func (pm *PrinterManager) SyncPrinters(ignorePrivet bool) error {
 		}
 	}
 
+	// Quotas are changed in the cloud, eg with update-gcp-printer.
+	pm.refreshQuotas()
+
 	// Compare the snapshot to what we know currently.
 	diffs := lib.DiffPrinters(nativePrinters, pm.printers.GetAll())
 	if diffs == nil {
 		log.Debugf("Printers are already in sync; there are %d", len(nativePrinters))
+		pm.setLastSync(time.Now())
 		return nil
 	}
 
-	// Update GCP.
-	ch := make(chan lib.Printer, len(diffs))
+	pm.syncStatsMutex.Lock()
+	pm.syncDiffs = uint(len(diffs))
+	pm.syncDiffsDone = 0
+	pm.syncDiffsFailed = 0
+	pm.syncStatsMutex.Unlock()
+
+	// Update GCP, with a bounded number of workers so that a large fleet
+	// doesn't open a connection per printer.
+	workers := len(diffs)
+	if pm.syncMaxConcurrency > 0 && pm.syncMaxConcurrency < uint(workers) {
+		workers = int(pm.syncMaxConcurrency)
+	}
+	work := make(chan *lib.PrinterDiff, len(diffs))
 	for i := range diffs {
-		go pm.applyDiff(&diffs[i], ch, ignorePrivet)
+		work <- &diffs[i]
+	}
+	close(work)
+	ch := make(chan lib.Printer, len(diffs))
+	for i := 0; i < workers; i++ {
+		go func() {
+			for diff := range work {
+				pm.applyDiff(diff, ch, ignorePrivet)
+			}
+		}()
 	}
 	currentPrinters := make([]lib.Printer, 0, len(diffs))
 	for _ = range diffs {
@@ -205,26 +560,59 @@ func (pm *PrinterManager) SyncPrinters(ignorePrivet bool) error {
 		if p.Name != "" {
 			currentPrinters = append(currentPrinters, p)
 		}
+		pm.syncStatsMutex.Lock()
+		pm.syncDiffsDone++
+		pm.lastSyncProgress = time.Now()
+		pm.syncStatsMutex.Unlock()
 	}
 
 	// Update what we know.
 	pm.printers.Refresh(currentPrinters)
 	log.Debugf("Finished synchronizing %d printers", len(currentPrinters))
 
+	if _, _, failed := pm.GetSyncStats(); failed > 0 {
+		log.Warningf("Failed to synchronize %d printer changes; they will be retried at the next sync", failed)
+	}
+
+	pm.setLastSync(time.Now())
 	return nil
 }
 
+// GetSyncStats returns the number of printer changes found by the latest
+// printer sync, how many of them have been applied, and how many of those
+// failed.
+func (pm *PrinterManager) GetSyncStats() (uint, uint, uint) {
+	pm.syncStatsMutex.Lock()
+	defer pm.syncStatsMutex.Unlock()
+
+	return pm.syncDiffs, pm.syncDiffsDone, pm.syncDiffsFailed
+}
+
+func (pm *PrinterManager) incrementSyncDiffsFailed() {
+	pm.syncStatsMutex.Lock()
+	defer pm.syncStatsMutex.Unlock()
+
+	pm.syncDiffsFailed++
+}
+
+// applyDiff applies one printer change, and sends the printer as it should
+// be known afterwards to ch. When a change fails in the cloud, the printer is
+// sent as it was known before, so that the next sync finds the same change
+// and tries again.
 func (pm *PrinterManager) applyDiff(diff *lib.PrinterDiff, ch chan<- lib.Printer, ignorePrivet bool) {
 	switch diff.Operation {
 	case lib.RegisterPrinter:
 		if pm.gcp != nil {
+			pm.syncRateLimit.wait()
 			if err := pm.gcp.Register(&diff.Printer); err != nil {
 				log.ErrorPrinterf(diff.Printer.Name, "Failed to register: %s", err)
+				pm.incrementSyncDiffsFailed()
 				break
 			}
 			log.InfoPrinterf(diff.Printer.Name+" "+diff.Printer.GCPID, "Registered in the cloud")
 
 			if pm.gcp.CanShare() {
+				pm.syncRateLimit.wait()
 				if err := pm.gcp.Share(diff.Printer.GCPID, pm.shareScope, gcp.User, true, false); err != nil {
 					log.ErrorPrinterf(diff.Printer.Name, "Failed to share: %s", err)
 				} else {
@@ -236,7 +624,7 @@ func (pm *PrinterManager) applyDiff(diff *lib.PrinterDiff, ch chan<- lib.Printer
 		diff.Printer.NativeJobSemaphore = lib.NewSemaphore(pm.nativeJobQueueSize)
 
 		if pm.privet != nil && !ignorePrivet {
-			err := pm.privet.AddPrinter(diff.Printer, pm.printers.GetByNativeName)
+			err := pm.privet.AddPrinter(diff.Printer, pm.printers.GetByNativeName, pm.CancelJob, pm.ReleaseJobs)
 			if err != nil {
 				log.WarningPrinterf(diff.Printer.Name, "Failed to register locally: %s", err)
 			} else {
@@ -249,11 +637,18 @@ func (pm *PrinterManager) applyDiff(diff *lib.PrinterDiff, ch chan<- lib.Printer
 
 	case lib.UpdatePrinter:
 		if pm.gcp != nil {
+			pm.syncRateLimit.wait()
 			if err := pm.gcp.Update(diff); err != nil {
 				log.ErrorPrinterf(diff.Printer.Name+" "+diff.Printer.GCPID, "Failed to update: %s", err)
-			} else {
-				log.InfoPrinterf(diff.Printer.Name+" "+diff.Printer.GCPID, "Updated in the cloud")
+				pm.incrementSyncDiffsFailed()
+				if p, exists := pm.printers.GetByNativeName(diff.Printer.Name); exists {
+					ch <- p
+				} else {
+					ch <- diff.Printer
+				}
+				return
 			}
+			log.InfoPrinterf(diff.Printer.Name+" "+diff.Printer.GCPID, "Updated in the cloud")
 		}
 
 		if pm.privet != nil && !ignorePrivet && diff.DefaultDisplayNameChanged {
@@ -272,9 +667,12 @@ func (pm *PrinterManager) applyDiff(diff *lib.PrinterDiff, ch chan<- lib.Printer
 		pm.native.RemoveCachedPPD(diff.Printer.Name)
 
 		if pm.gcp != nil {
+			pm.syncRateLimit.wait()
 			if err := pm.gcp.Delete(diff.Printer.GCPID); err != nil {
 				log.ErrorPrinterf(diff.Printer.Name+" "+diff.Printer.GCPID, "Failed to delete from the cloud: %s", err)
-				break
+				pm.incrementSyncDiffsFailed()
+				ch <- diff.Printer
+				return
 			}
 			log.InfoPrinterf(diff.Printer.Name+" "+diff.Printer.GCPID, "Deleted from the cloud")
 		}
@@ -306,10 +704,21 @@ func (pm *PrinterManager) listenNotifications(jobs <-chan *lib.Job, messages <-c
 
 			case job := <-jobs:
 				log.DebugJobf(job.JobID, "Received job: %+v", job)
-				go pm.printJob(job.NativePrinterName, job.Filename, job.Title, job.User, job.JobID, job.Ticket, job.UpdateJob)
+				select {
+				case <-pm.draining:
+					go pm.handOffJob(job)
+				default:
+					go pm.printJob(job)
+				}
 
 			case message := <-messages:
 				log.Debugf("Received message: %+v", message)
+				select {
+				case <-pm.draining:
+					// New jobs wait in the cloud for the next connector.
+					continue
+				default:
+				}
 				if message.Type == notification.PrinterNewJobs {
 					if p, exists := pm.printers.GetByGCPID(message.GCPID); exists {
 						go pm.gcp.HandleJobs(&p, func() { pm.incrementJobsProcessed(false) })
@@ -333,18 +742,24 @@ func (pm *PrinterManager) incrementJobsProcessed(success bool) {
 
 // addInFlightJob adds a job ID to the in flight set.
 //
-// Returns true if the job ID was added, false if it already exists.
-func (pm *PrinterManager) addInFlightJob(jobID string) bool {
+// Returns a channel that is closed when the job is canceled, and true if the
+// job ID was added, false if it already exists.
+func (pm *PrinterManager) addInFlightJob(jobID string, origin lib.JobOrigin, nativePrinterName string) (<-chan struct{}, bool) {
 	pm.jobsInFlightMutex.Lock()
 	defer pm.jobsInFlightMutex.Unlock()
 
 	if _, exists := pm.jobsInFlight[jobID]; exists {
-		return false
+		return nil, false
 	}
 
-	pm.jobsInFlight[jobID] = struct{}{}
+	j := jobInFlight{
+		origin:            origin,
+		nativePrinterName: nativePrinterName,
+		cancel:            make(chan struct{}),
+	}
+	pm.jobsInFlight[jobID] = &j
 
-	return true
+	return j.cancel, true
 }
 
 // deleteInFlightJob deletes a job from the in flight set.
@@ -355,32 +770,159 @@ func (pm *PrinterManager) deleteInFlightJob(jobID string) {
 	delete(pm.jobsInFlight, jobID)
 }
 
-// printJob prints a new job to a native printer, then polls the native job state
+// CancelJob asks a job in flight to stop printing. The job is canceled in
+// the native print system, then reported as ABORTED.
+func (pm *PrinterManager) CancelJob(jobID string) error {
+	pm.jobsInFlightMutex.Lock()
+	defer pm.jobsInFlightMutex.Unlock()
+
+	j, exists := pm.jobsInFlight[jobID]
+	if !exists {
+		return fmt.Errorf("Job %s is not in flight", jobID)
+	}
+
+	select {
+	case <-j.cancel:
+		// Already canceled.
+	default:
+		close(j.cancel)
+	}
+
+	return nil
+}
+
+// GetJobsInFlight returns the jobs in flight, sorted by job ID. Jobs that
+// were submitted to the native print system have native printer and job
+// IDs, and the state last reported.
+func (pm *PrinterManager) GetJobsInFlight() []lib.MonitorJob {
+	pm.jobsInFlightMutex.Lock()
+	jobs := make([]lib.MonitorJob, 0, len(pm.jobsInFlight))
+	for jobID, j := range pm.jobsInFlight {
+		jobs = append(jobs, lib.MonitorJob{
+			JobID:             jobID,
+			Origin:            j.origin,
+			PrinterName:       j.nativePrinterName,
+			NativePrinterName: j.memberPrinterName,
+		})
+	}
+	pm.jobsInFlightMutex.Unlock()
+
+	for i := range jobs {
+		entry, exists := pm.journal.get(jobs[i].JobID)
+		if !exists {
+			continue
+		}
+		jobs[i].NativePrinterName = entry.NativePrinterName
+		jobs[i].NativeJobID = entry.NativeJobID
+		if entry.State.State != nil {
+			jobs[i].State = string(entry.State.State.Type)
+		}
+		submittedAt := entry.SubmittedAt
+		jobs[i].SubmittedAt = &submittedAt
+	}
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].JobID < jobs[j].JobID })
+	return jobs
+}
+
+// GetPrinters returns the printers that are known, sorted by name.
+func (pm *PrinterManager) GetPrinters() []lib.Printer {
+	printers := pm.printers.GetAll()
+	sort.Slice(printers, func(i, j int) bool { return printers[i].Name < printers[j].Name })
+	return printers
+}
+
+// checkCloudCancellationsPeriodically cancels jobs in flight that were
+// canceled in the cloud.
+func (pm *PrinterManager) checkCloudCancellationsPeriodically() {
+	go func() {
+		t := time.NewTicker(cloudJobCancelPollInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+				pm.checkCloudCancellations()
+			case <-pm.quit:
+				return
+			}
+		}
+	}()
+}
+
+// checkCloudCancellations asks GCP for the jobs of each printer that has
+// cloud jobs in flight, and cancels the jobs that GCP says are ABORTED.
+func (pm *PrinterManager) checkCloudCancellations() {
+	pm.jobsInFlightMutex.Lock()
+	nativePrinterNames := make(map[string]struct{})
+	for _, j := range pm.jobsInFlight {
+		if j.origin == lib.JobOriginCloud {
+			nativePrinterNames[j.nativePrinterName] = struct{}{}
+		}
+	}
+	pm.jobsInFlightMutex.Unlock()
+
+	for nativePrinterName := range nativePrinterNames {
+		printer, exists := pm.printers.GetByNativeName(nativePrinterName)
+		if !exists || printer.GCPID == "" {
+			continue
+		}
+
+		jobs, err := pm.gcp.Jobs(printer.GCPID)
+		if err != nil {
+			log.WarningPrinterf(printer.Name, "Failed to check for canceled jobs: %s", err)
+			continue
+		}
+
+		for _, job := range jobs {
+			if job.SemanticState == nil || job.SemanticState.State.Type != cdd.JobStateAborted {
+				continue
+			}
+			if err := pm.CancelJob(job.GCPJobID); err == nil {
+				log.InfoJobf(job.GCPJobID, "Canceled in the cloud")
+			}
+		}
+	}
+}
+
+// printJob routes a new job, prints it to a native printer, then polls the native job state
 // and updates the GCP/Privet job state. then returns when the job state is DONE
 // or ABORTED.
 //
 // All errors are reported and logged from inside this function.
-func (pm *PrinterManager) printJob(nativePrinterName, filename, title, user, jobID string, ticket *cdd.CloudJobTicket, updateJob func(string, *cdd.PrintJobStateDiff) error) {
-	defer os.Remove(filename)
-	if !pm.addInFlightJob(jobID) {
+func (pm *PrinterManager) printJob(job *lib.Job) {
+	defer os.Remove(job.Filename)
+	cancel, ok := pm.addInFlightJob(job.JobID, job.Origin, job.NativePrinterName)
+	if !ok {
 		// This print job was already received. We probably received it
 		// again because the first instance is still QUEUED (ie not
 		// IN_PROGRESS). That's OK, just throw away the second instance.
 		return
 	}
-	defer pm.deleteInFlightJob(jobID)
+	defer pm.deleteInFlightJob(job.JobID)
 
-	if !pm.jobFullUsername {
-		user = strings.Split(user, "@")[0]
+	receivedAt := time.Now()
+	job.UpdateJob = pm.accountFinishedJob(job, receivedAt, pm.hookFinishedJob(job, pm.countFinishedJob(job, pm.rememberFinishedJob(job, job.UpdateJob))))
+	jobID, updateJob := job.JobID, job.UpdateJob
+
+	pm.runHooks(hookEventReceived, job, nil)
+
+	receivingPrinterName := job.NativePrinterName
+	if state, ok := pm.routeJob(job); !ok {
+		pm.incrementJobsProcessed(false)
+		if err := updateJob(jobID, &state); err != nil {
+			log.ErrorJob(jobID, err)
+		}
+		return
 	}
 
-	printer, exists := pm.printers.GetByNativeName(nativePrinterName)
-	if !exists {
+	if pm.overQuota(receivingPrinterName, job.User) {
+		log.InfoJobf(jobID, "Rejected because %s is over the daily quota of printer %s", job.User, receivingPrinterName)
 		pm.incrementJobsProcessed(false)
 		state := cdd.PrintJobStateDiff{
 			State: &cdd.JobState{
 				Type:               cdd.JobStateAborted,
-				ServiceActionCause: &cdd.ServiceActionCause{ErrorCode: cdd.ServiceActionCausePrinterDeleted},
+				ServiceActionCause: &cdd.ServiceActionCause{ErrorCode: quotaExceededCause},
 			},
 		}
 		if err := updateJob(jobID, &state); err != nil {
@@ -389,14 +931,22 @@ func (pm *PrinterManager) printJob(nativePrinterName, filename, title, user, job
 		return
 	}
 
-	nativeJobID, err := pm.native.Print(&printer, filename, title, user, jobID, ticket)
-	if err != nil {
+	if !pm.waitForMaintenance(job, cancel) {
+		return
+	}
+
+	user := job.User
+	if !pm.jobFullUsername {
+		user = strings.Split(user, "@")[0]
+	}
+
+	printer, exists := pm.getNativePrinter(job.NativePrinterName)
+	if !exists {
 		pm.incrementJobsProcessed(false)
-		log.ErrorJobf(jobID, "Failed to submit to native print system: %s", err)
 		state := cdd.PrintJobStateDiff{
 			State: &cdd.JobState{
-				Type:              cdd.JobStateAborted,
-				DeviceActionCause: &cdd.DeviceActionCause{ErrorCode: cdd.DeviceActionCausePrintFailure},
+				Type:               cdd.JobStateAborted,
+				ServiceActionCause: &cdd.ServiceActionCause{ErrorCode: cdd.ServiceActionCausePrinterDeleted},
 			},
 		}
 		if err := updateJob(jobID, &state); err != nil {
@@ -405,16 +955,388 @@ func (pm *PrinterManager) printJob(nativePrinterName, filename, title, user, job
 		return
 	}
 
-	log.InfoJobf(jobID, "Submitted as native job %d", nativeJobID)
+	var releasePINHash string
+	if pm.isSecureRelease(printer.Name) {
+		var pin string
+		job.Ticket, pin = takeReleasePIN(job.Ticket)
+		if !rReleasePIN.MatchString(pin) {
+			log.InfoJobf(jobID, "Rejected because printer %s needs a release PIN of 4 to 8 numbers", printer.Name)
+			pm.incrementJobsProcessed(false)
+			state := cdd.PrintJobStateDiff{
+				State: &cdd.JobState{
+					Type:               cdd.JobStateAborted,
+					ServiceActionCause: &cdd.ServiceActionCause{ErrorCode: cdd.ServiceActionCauseInconsistentJob},
+				},
+			}
+			if err := updateJob(jobID, &state); err != nil {
+				log.ErrorJob(jobID, err)
+			}
+			return
+		}
+		releasePINHash = hashReleasePIN(jobID, pin)
+	}
 
-	var state cdd.PrintJobStateDiff
+	if state, ok := pm.runSubmitHooks(job, &printer); !ok {
+		pm.incrementJobsProcessed(false)
+		if err := updateJob(jobID, &state); err != nil {
+			log.ErrorJob(jobID, err)
+		}
+		return
+	}
+
+	priority := pm.jobPriority(job, printer.Name)
+	if pm.nativeJobPriority {
+		job.Ticket = setNativeJobPriority(job.Ticket, priority)
+	}
+
+	nativeJobID, nativePrinterName, ok := pm.submitJob(job, printer, user, priority, cancel)
+	if !ok {
+		return
+	}
+	jobQueueSeconds.Observe(time.Since(receivedAt).Seconds())
+
+	if nativePrinterName == printer.Name {
+		log.InfoJobf(jobID, "Submitted as native job %d", nativeJobID)
+	} else {
+		log.InfoJobf(jobID, "Submitted as native job %d on pool member %s", nativeJobID, nativePrinterName)
+	}
+
+	entry := journalEntry{
+		JobID:             jobID,
+		Origin:            job.Origin,
+		PrinterName:       receivingPrinterName,
+		User:              job.User,
+		ReleasePINHash:    releasePINHash,
+		NativePrinterName: nativePrinterName,
+		NativeJobID:       nativeJobID,
+		SubmittedAt:       time.Now(),
+	}
+	if err := pm.journal.put(entry); err != nil {
+		log.WarningJobf(jobID, "Failed to add to job journal: %s", err)
+	}
+
+	pm.followJob(entry, updateJob, cancel)
+}
 
-	ticker := time.NewTicker(time.Second)
-	defer ticker.Stop()
-	defer pm.releaseJob(printer.Name, nativeJobID, jobID)
+// submitJob submits a job to the native print system, retrying failures as
+// allowed by the retry policy of the printer. The job is reported as QUEUED
+// while waiting to retry, and stays QUEUED if the connector stops while
+// waiting.
+//
+// When the printer is a pool, the job is submitted to the least busy member,
+// and a failed submission is tried on the other members before waiting to
+// retry.
+//
+// Each submission waits for its turn in the job queue of the native printer,
+// behind the jobs of higher priority.
+//
+// Returns the native job ID, the name of the native printer that has the
+// job, and false if the job was not submitted. In that case the final job
+// state is reported from inside this function.
+func (pm *PrinterManager) submitJob(job *lib.Job, printer lib.Printer, user string, priority int, cancel <-chan struct{}) (uint32, string, bool) {
+	jobID, updateJob := job.JobID, job.UpdateJob
+	abort := func(state cdd.PrintJobStateDiff) {
+		pm.incrementJobsProcessed(false)
+		if err := updateJob(jobID, &state); err != nil {
+			log.ErrorJob(jobID, err)
+		}
+	}
+	canceled := func() {
+		abort(cdd.PrintJobStateDiff{
+			State: &cdd.JobState{
+				Type:            cdd.JobStateAborted,
+				UserActionCause: &cdd.UserActionCause{ActionCode: cdd.UserActionCauseCanceled},
+			},
+		})
+	}
+
+	pool := pm.pools[printer.Name]
+	tried := make(map[string]struct{})
+	hold := pm.isSecureRelease(printer.Name)
+
+	policy := pm.getJobRetryPolicy(printer.Name)
+	backoff := policy.newBackoff()
+
+	for attempts := uint(1); ; attempts++ {
+		target := printer
+		if pool != nil {
+			var ok bool
+			if target, ok = pm.choosePoolMember(pool, tried); !ok {
+				log.ErrorJobf(jobID, "None of the members of printer pool %s are present", pool.Name)
+				abort(cdd.PrintJobStateDiff{
+					State: &cdd.JobState{
+						Type:               cdd.JobStateAborted,
+						ServiceActionCause: &cdd.ServiceActionCause{ErrorCode: cdd.ServiceActionCausePrinterDeleted},
+					},
+				})
+				return 0, "", false
+			}
+			tried[target.Name] = struct{}{}
+			pm.setInFlightJobMember(jobID, target.Name)
+		}
+		target.HoldJobs = hold
+
+		queue := pm.getJobQueue(&target)
+		turn := queue.enter(priority)
+		select {
+		case <-turn.ready:
+		case <-cancel:
+			queue.leave(turn)
+			log.InfoJob(jobID, "Canceled while waiting to submit")
+			canceled()
+			return 0, "", false
+		case <-pm.abandon:
+			queue.leave(turn)
+			pm.abandonJob(jobID, updateJob)
+			return 0, "", false
+		}
+		nativeJobID, err := pm.native.Print(&target, job.Filename, job.Title, user, jobID, job.Ticket)
+		// The turn ends with the submission, not with the printing, so that
+		// the native print system always has jobs to print. From here, the
+		// native print system orders the jobs, by the priority that
+		// nativeJobPriority gives them.
+		queue.leave(turn)
+		if err == nil {
+			return nativeJobID, target.Name, true
+		}
+
+		if pool != nil {
+			if _, ok := pm.choosePoolMember(pool, tried); ok {
+				log.WarningJobf(jobID, "Failed to submit to pool member %s, trying another member: %s", target.Name, err)
+				attempts--
+				continue
+			}
+			// Every member failed; start over after the retry pause.
+			tried = make(map[string]struct{})
+		}
+
+		pause, ok := backoff.Pause()
+		if !ok || !policy.shouldRetry(attempts, err) {
+			log.ErrorJobf(jobID, "Failed to submit to native print system: %s", err)
+			abort(cdd.PrintJobStateDiff{
+				State: &cdd.JobState{
+					Type:              cdd.JobStateAborted,
+					DeviceActionCause: &cdd.DeviceActionCause{ErrorCode: cdd.DeviceActionCausePrintFailure},
+				},
+			})
+			return 0, "", false
+		}
+
+		log.WarningJobf(jobID, "Failed to submit to native print system, attempt %d of %d, retrying in %s: %s",
+			attempts, policy.maxAttempts, pause, err)
+		if attempts == 1 {
+			state := cdd.PrintJobStateDiff{
+				State: &cdd.JobState{Type: cdd.JobStateQueued},
+			}
+			if err = updateJob(jobID, &state); err != nil {
+				log.ErrorJob(jobID, err)
+			}
+		}
+
+		select {
+		case <-time.After(pause):
+		case <-cancel:
+			log.InfoJob(jobID, "Canceled while waiting to retry")
+			canceled()
+			return 0, "", false
+		case <-pm.abandon:
+			pm.abandonJob(jobID, updateJob)
+			return 0, "", false
+		}
+
+		// The printer might have changed, or gone away, while waiting.
+		if printer, ok = pm.printers.GetByNativeName(printer.Name); !ok {
+			abort(cdd.PrintJobStateDiff{
+				State: &cdd.JobState{
+					Type:               cdd.JobStateAborted,
+					ServiceActionCause: &cdd.ServiceActionCause{ErrorCode: cdd.ServiceActionCausePrinterDeleted},
+				},
+			})
+			return 0, "", false
+		}
+	}
+}
+
+// getJobRetryPolicy gets the retry policy of a printer.
+func (pm *PrinterManager) getJobRetryPolicy(nativePrinterName string) *jobRetryPolicy {
+	if policy, exists := pm.printerJobRetryPolicies[nativePrinterName]; exists {
+		return policy
+	}
+	return pm.jobRetryPolicy
+}
+
+// resumeJournaledJobs follows the jobs that were in flight when the previous
+// connector process stopped, until they reach a final state.
+func (pm *PrinterManager) resumeJournaledJobs() {
+	for _, entry := range pm.journal.getAll() {
+		printerName := entry.PrinterName
+		if printerName == "" {
+			printerName = entry.NativePrinterName
+		}
+		cancel, ok := pm.addInFlightJob(entry.JobID, entry.Origin, printerName)
+		if !ok {
+			continue
+		}
+		if printerName != entry.NativePrinterName {
+			pm.setInFlightJobMember(entry.JobID, entry.NativePrinterName)
+		}
+
+		var updateJob func(string, *cdd.PrintJobStateDiff) error
+		if entry.Origin == lib.JobOriginCloud && pm.gcp != nil {
+			updateJob = pm.gcp.Control
+		} else {
+			// The Privet job cache did not survive the restart, so there is
+			// nobody left to tell about this job.
+			updateJob = func(string, *cdd.PrintJobStateDiff) error { return nil }
+		}
+		job := lib.Job{
+			NativePrinterName: printerName,
+			User:              entry.User,
+			JobID:             entry.JobID,
+			Origin:            entry.Origin,
+		}
+		updateJob = pm.accountFinishedJob(&job, entry.SubmittedAt, pm.hookFinishedJob(&job, pm.countFinishedJob(&job, pm.rememberFinishedJob(&job, updateJob))))
+
+		log.InfoJobf(entry.JobID, "Resuming native job %d on printer %s from the job journal",
+			entry.NativeJobID, entry.NativePrinterName)
+
+		go func(entry journalEntry) {
+			defer pm.deleteInFlightJob(entry.JobID)
+			pm.followJob(entry, updateJob, cancel)
+		}(entry)
+	}
+}
+
+// followJob polls the state of a native job, and reports changes with
+// updateJob, until the job reaches a final state. entry.State is the last
+// state already reported with updateJob.
+//
+// Pages printed are counted against the quota of entry.PrinterName.
+//
+// Jobs with a release PIN wait to be released before they are polled.
+//
+// When cancel is closed, the native job is canceled and reported as ABORTED.
+//
+// When the connector stops before the job is finished, the job is reported
+// as IN_PROGRESS and left in the journal, to be followed again after restart.
+//
+// When the native print system implements NativeJobSubscriber, state changes
+// are received as they happen, and polling is reduced to a safety net.
+func (pm *PrinterManager) followJob(entry journalEntry, updateJob func(string, *cdd.PrintJobStateDiff) error, cancel <-chan struct{}) {
+	jobID, printerName, nativeJobID, state := entry.JobID, entry.NativePrinterName, entry.NativeJobID, entry.State
+	// An abandoned job is still in the native print system, and stays in
+	// the journal.
+	var abandoned bool
+	// A job with a release PIN must never be released here unless it was
+	// released with its PIN; it might still be held.
+	unreleased := entry.ReleasePINHash != ""
+	defer func() {
+		if !abandoned && !unreleased {
+			pm.releaseJob(printerName, nativeJobID, jobID)
+		}
+	}()
+	defer func() {
+		if abandoned {
+			return
+		}
+		if err := pm.journal.delete(jobID); err != nil {
+			log.WarningJobf(jobID, "Failed to remove from job journal: %s", err)
+		}
+	}()
+
+	if entry.ReleasePINHash != "" {
+		var released bool
+		if state, released = pm.waitForRelease(entry, state, updateJob, cancel); !released {
+			abandoned = state.State.Type == cdd.JobStateHeld
+			return
+		}
+		unreleased = false
+	}
+
+	start := entry.SubmittedAt
+	if entry.ReleasePINHash != "" {
+		// Held jobs are timed from their release.
+		start = time.Now()
+	}
+	watchdog := newJobWatchdog(pm.getJobTimeouts(printerName), start)
+	defer watchdog.stop()
+	watchdog.setStopped(state.State != nil && state.State.Type == cdd.JobStateStopped)
+
+	var states <-chan *cdd.PrintJobStateDiff
+	pollInterval := jobStatePollInterval
+	pollNow := make(chan struct{}, 1)
+	if subscriber, ok := pm.native.(NativeJobSubscriber); ok {
+		var err error
+		states, err = subscriber.SubscribeJobState(printerName, nativeJobID)
+		if err != nil {
+			log.WarningJobf(jobID, "Failed to subscribe to native job %d, polling instead: %s", nativeJobID, err)
+		} else {
+			defer subscriber.UnsubscribeJobState(printerName, nativeJobID)
+			pollInterval = subscribedJobStatePollInterval
+			// Changes made before the subscription started are never
+			// received; get the state once now.
+			pollNow <- struct{}{}
+		}
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer func() { ticker.Stop() }()
+
+	for {
+		var nativeState *cdd.PrintJobStateDiff
+		var err error
+
+		select {
+		case s, ok := <-states:
+			if !ok {
+				log.WarningJobf(jobID, "Lost subscription to native job %d, polling instead", nativeJobID)
+				states = nil
+				ticker.Stop()
+				ticker = time.NewTicker(jobStatePollInterval)
+				continue
+			}
+			nativeState = s
+			if s.PagesPrinted == nil && s.State != nil &&
+				s.State.Type != cdd.JobStateInProgress && s.State.Type != cdd.JobStateStopped {
+				// Events might not tell the pages printed; get the final
+				// count, for quotas and accounting.
+				if polled, err := pm.native.GetJobState(printerName, nativeJobID); err == nil && polled.PagesPrinted != nil {
+					final := *s
+					final.PagesPrinted = polled.PagesPrinted
+					nativeState = &final
+				}
+			}
+		case <-pollNow:
+			nativeState, err = pm.native.GetJobState(printerName, nativeJobID)
+		case <-ticker.C:
+			nativeState, err = pm.native.GetJobState(printerName, nativeJobID)
+		case <-cancel:
+			if err = pm.native.CancelJob(printerName, nativeJobID); err != nil {
+				log.WarningJobf(jobID, "Failed to cancel native job %d: %s", nativeJobID, err)
+				// Keep following the job; it might finish on its own.
+				cancel = nil
+				continue
+			}
+			log.InfoJobf(jobID, "Canceled native job %d", nativeJobID)
+			nativeState = &cdd.PrintJobStateDiff{
+				State: &cdd.JobState{
+					Type:            cdd.JobStateAborted,
+					UserActionCause: &cdd.UserActionCause{ActionCode: cdd.UserActionCauseCanceled},
+				},
+				PagesPrinted: state.PagesPrinted,
+			}
+		case <-watchdog.durationExpired():
+			nativeState = pm.timeOutJob(jobID, printerName, nativeJobID, state,
+				fmt.Sprintf("took longer than %s", watchdog.timeouts.maxDuration))
+		case <-watchdog.stoppedExpired():
+			nativeState = pm.timeOutJob(jobID, printerName, nativeJobID, state,
+				fmt.Sprintf("was STOPPED for longer than %s", watchdog.timeouts.maxStopped))
+		case <-pm.abandon:
+			pm.abandonSubmittedJob(jobID, nativeJobID, state, updateJob)
+			abandoned = true
+			return
+		}
 
-	for _ = range ticker.C {
-		nativeState, err := pm.native.GetJobState(printer.Name, nativeJobID)
 		if err != nil {
 			log.WarningJobf(jobID, "Failed to get state of native job %d: %s", nativeJobID, err)
 
@@ -432,13 +1354,24 @@ func (pm *PrinterManager) printJob(nativePrinterName, filename, title, user, job
 			return
 		}
 
+		if nativeState.PagesPrinted == nil && state.PagesPrinted != nil {
+			// Pages printed are not always known; they never go down.
+			s := *nativeState
+			s.PagesPrinted = state.PagesPrinted
+			nativeState = &s
+		}
 		if !reflect.DeepEqual(*nativeState, state) {
+			pm.countPages(entry, state, *nativeState)
 			state = *nativeState
 			if err = updateJob(jobID, &state); err != nil {
 				log.ErrorJob(jobID, err)
 			}
 			log.InfoJobf(jobID, "State: %s", state.State.Type)
+			if err = pm.journal.updateState(jobID, state); err != nil {
+				log.WarningJobf(jobID, "Failed to update job journal: %s", err)
+			}
 		}
+		watchdog.setStopped(state.State.Type == cdd.JobStateStopped)
 
 		if state.State.Type != cdd.JobStateInProgress && state.State.Type != cdd.JobStateStopped {
 			if state.State.Type == cdd.JobStateDone {
@@ -465,6 +1398,9 @@ func (pm *PrinterManager) GetJobStats() (uint, uint, uint, error) {
 	for _, printer := range pm.printers.GetAll() {
 		processing += printer.NativeJobSemaphore.Count()
 	}
+	for _, printer := range pm.hiddenPrinters.GetAll() {
+		processing += printer.NativeJobSemaphore.Count()
+	}
 
 	pm.jobStatsMutex.Lock()
 	defer pm.jobStatsMutex.Unlock()
@@ -0,0 +1,718 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/gcp"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+	"github.com/google/cloud-print-connector/nativetest"
+)
+
+// fakeCloudPrint records the printers that PrinterManager registers, updates
+// and deletes.
+type fakeCloudPrint struct {
+	mutex        sync.Mutex
+	failRegister bool
+	failUpdate   bool
+	failDelete   bool
+	registered   []string
+	updated      []string
+	deleted      []string
+	// quotas are the quotas set in the cloud. Key is GCPID.
+	quotas map[string]gcp.Quota
+
+	// registerDelay makes Register slow, to count concurrent calls.
+	registerDelay  time.Duration
+	registering    int
+	maxRegistering int
+}
+
+func (f *fakeCloudPrint) ListPrinters() ([]lib.Printer, map[string]uint, error) {
+	return nil, nil, nil
+}
+
+func (f *fakeCloudPrint) ListQuotas() (map[string]gcp.Quota, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	quotas := make(map[string]gcp.Quota, len(f.quotas))
+	for gcpID, quota := range f.quotas {
+		quotas[gcpID] = quota
+	}
+	return quotas, nil
+}
+
+func (f *fakeCloudPrint) Register(printer *lib.Printer) error {
+	f.mutex.Lock()
+	f.registering++
+	if f.registering > f.maxRegistering {
+		f.maxRegistering = f.registering
+	}
+	f.mutex.Unlock()
+	time.Sleep(f.registerDelay)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.registering--
+	if f.failRegister {
+		return errors.New("register failed")
+	}
+	printer.GCPID = "gcp-" + printer.Name
+	f.registered = append(f.registered, printer.Name)
+	return nil
+}
+
+func (f *fakeCloudPrint) Update(diff *lib.PrinterDiff) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.failUpdate {
+		return errors.New("update failed")
+	}
+	f.updated = append(f.updated, diff.Printer.Name)
+	return nil
+}
+
+func (f *fakeCloudPrint) Delete(gcpID string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.failDelete {
+		return errors.New("delete failed")
+	}
+	f.deleted = append(f.deleted, gcpID)
+	return nil
+}
+
+func (f *fakeCloudPrint) CanShare() bool {
+	return false
+}
+
+func (f *fakeCloudPrint) Share(gcpID, shareScope string, role gcp.Role, skipNotification bool, public bool) error {
+	return nil
+}
+
+func (f *fakeCloudPrint) Jobs(gcpID string) ([]gcp.Job, error) {
+	return nil, nil
+}
+
+func (f *fakeCloudPrint) Control(jobID string, state *cdd.PrintJobStateDiff) error {
+	return nil
+}
+
+func (f *fakeCloudPrint) HandleJobs(printer *lib.Printer, reportJobFailed func()) {}
+
+// reset forgets the calls so far, and returns them sorted.
+func (f *fakeCloudPrint) reset() ([]string, []string, []string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	registered, updated, deleted := f.registered, f.updated, f.deleted
+	f.registered, f.updated, f.deleted = nil, nil, nil
+	sort.Strings(registered)
+	sort.Strings(updated)
+	sort.Strings(deleted)
+	return registered, updated, deleted
+}
+
+// newTestPrinterManager makes a PrinterManager without Privet, and without
+// GCP when cloud is nil.
+func newTestPrinterManager(t *testing.T, native NativePrintSystem, cloud *fakeCloudPrint) *PrinterManager {
+	log.SetLevel(log.ERROR)
+
+	journal, err := newJobJournal("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	quotas, err := newQuotaLedger("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := newJobRetryPolicy(lib.RetryPolicy{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pm := PrinterManager{
+		native:             native,
+		printers:           lib.NewConcurrentPrinterMap(nil),
+		jobsInFlight:       make(map[string]*jobInFlight),
+		journal:            journal,
+		quotas:             quotas,
+		jobRetryPolicy:     policy,
+		hiddenPrinters:     lib.NewConcurrentPrinterMap(nil),
+		heldJobs:           make(map[string]*heldJob),
+		releaseThrottles:   make(map[string]*releaseThrottle),
+		nativeJobQueueSize: 3,
+		draining:           make(chan struct{}),
+		abandon:            make(chan struct{}),
+		quit:               make(chan struct{}),
+	}
+	if cloud != nil {
+		pm.gcp = cloud
+	}
+	return &pm
+}
+
+func newTestPrinter(name string) lib.Printer {
+	return lib.Printer{
+		Name:               name,
+		DefaultDisplayName: name,
+		State:              &cdd.PrinterStateSection{State: cdd.CloudDeviceStateIdle},
+		Description:        &cdd.PrinterDescriptionSection{},
+		Tags:               map[string]string{"printer-name": name},
+	}
+}
+
+// jobRecorder records the job states reported by PrinterManager.
+type jobRecorder struct {
+	mutex  sync.Mutex
+	states []cdd.JobStateType
+}
+
+func (r *jobRecorder) updateJob(jobID string, state *cdd.PrintJobStateDiff) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.states = append(r.states, state.State.Type)
+	return nil
+}
+
+func (r *jobRecorder) get() []cdd.JobStateType {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]cdd.JobStateType{}, r.states...)
+}
+
+// newTestJob makes a job with its own document, which printJob removes.
+func newTestJob(t *testing.T, jobID, printerName string, r *jobRecorder) *lib.Job {
+	f, err := ioutil.TempFile("", "cloud-print-connector-job-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("%PDF-1.4")
+	f.Close()
+
+	return &lib.Job{
+		NativePrinterName: printerName,
+		Filename:          f.Name(),
+		Title:             "title",
+		User:              "user@example.com",
+		JobID:             jobID,
+		Origin:            lib.JobOriginCloud,
+		UpdateJob:         r.updateJob,
+	}
+}
+
+// waitFor waits for condition to be true, failing the test if it takes too
+// long.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func printerNames(printers []lib.Printer) []string {
+	names := make([]string, 0, len(printers))
+	for _, printer := range printers {
+		names = append(names, printer.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestSyncPrinters(t *testing.T) {
+	native := nativetest.New()
+	cloud := &fakeCloudPrint{}
+	pm := newTestPrinterManager(t, native, cloud)
+
+	color := &cdd.PrinterDescriptionSection{
+		Color: &cdd.Color{Option: []cdd.ColorOption{cdd.ColorOption{Type: cdd.ColorTypeStandardColor}}},
+	}
+
+	steps := []struct {
+		name       string
+		change     func()
+		registered []string
+		updated    []string
+		deleted    []string
+		printers   []string
+	}{
+		{
+			name: "add printers",
+			change: func() {
+				native.AddPrinter(newTestPrinter("a"))
+				native.AddPrinter(newTestPrinter("b"))
+			},
+			registered: []string{"a", "b"},
+			printers:   []string{"a", "b"},
+		},
+		{
+			name:     "no change",
+			change:   func() {},
+			printers: []string{"a", "b"},
+		},
+		{
+			name:     "change capabilities",
+			change:   func() { native.SetDescription("a", color) },
+			updated:  []string{"a"},
+			printers: []string{"a", "b"},
+		},
+		{
+			name:     "remove printer",
+			change:   func() { native.RemovePrinter("b") },
+			deleted:  []string{"gcp-b"},
+			printers: []string{"a"},
+		},
+		{
+			name:       "add printer back",
+			change:     func() { native.AddPrinter(newTestPrinter("b")) },
+			registered: []string{"b"},
+			printers:   []string{"a", "b"},
+		},
+	}
+
+	for _, step := range steps {
+		step.change()
+		if err := pm.SyncPrinters(false); err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+
+		registered, updated, deleted := cloud.reset()
+		if !reflect.DeepEqual(step.registered, registered) {
+			t.Logf("%s: expected registered %v, got %v", step.name, step.registered, registered)
+			t.Fail()
+		}
+		if !reflect.DeepEqual(step.updated, updated) {
+			t.Logf("%s: expected updated %v, got %v", step.name, step.updated, updated)
+			t.Fail()
+		}
+		if !reflect.DeepEqual(step.deleted, deleted) {
+			t.Logf("%s: expected deleted %v, got %v", step.name, step.deleted, deleted)
+			t.Fail()
+		}
+		if names := printerNames(pm.printers.GetAll()); !reflect.DeepEqual(step.printers, names) {
+			t.Logf("%s: expected printers %v, got %v", step.name, step.printers, names)
+			t.Fail()
+		}
+	}
+
+	printer, _ := pm.printers.GetByNativeName("a")
+	if printer.GCPID != "gcp-a" {
+		t.Logf("expected GCP ID gcp-a, got %q", printer.GCPID)
+		t.Fail()
+	}
+	if !reflect.DeepEqual(color, printer.Description) {
+		t.Logf("expected changed capabilities %+v, got %+v", color, printer.Description)
+		t.Fail()
+	}
+}
+
+func TestApplyDiff(t *testing.T) {
+	printer := newTestPrinter("a")
+	printer.GCPID = "gcp-a"
+
+	cases := []struct {
+		name       string
+		cloud      *fakeCloudPrint
+		operation  lib.PrinterDiffOperation
+		result     string
+		registered []string
+		updated    []string
+		deleted    []string
+	}{
+		{name: "register without GCP", operation: lib.RegisterPrinter, result: "a"},
+		{name: "register", cloud: &fakeCloudPrint{}, operation: lib.RegisterPrinter, result: "a", registered: []string{"a"}},
+		{name: "register fails", cloud: &fakeCloudPrint{failRegister: true}, operation: lib.RegisterPrinter},
+		{name: "update", cloud: &fakeCloudPrint{}, operation: lib.UpdatePrinter, result: "a", updated: []string{"a"}},
+		{name: "update fails", cloud: &fakeCloudPrint{failUpdate: true}, operation: lib.UpdatePrinter, result: "a"},
+		{name: "delete without GCP", operation: lib.DeletePrinter},
+		{name: "delete", cloud: &fakeCloudPrint{}, operation: lib.DeletePrinter, deleted: []string{"gcp-a"}},
+		{name: "delete fails", cloud: &fakeCloudPrint{failDelete: true}, operation: lib.DeletePrinter, result: "a"},
+		{name: "no change", cloud: &fakeCloudPrint{}, operation: lib.NoChangeToPrinter, result: "a"},
+	}
+
+	for _, c := range cases {
+		native := nativetest.New()
+		pm := newTestPrinterManager(t, native, c.cloud)
+
+		ch := make(chan lib.Printer, 1)
+		pm.applyDiff(&lib.PrinterDiff{Operation: c.operation, Printer: printer}, ch, false)
+		result := <-ch
+
+		if result.Name != c.result {
+			t.Logf("%s: expected printer %q, got %q", c.name, c.result, result.Name)
+			t.Fail()
+		}
+		if c.operation == lib.RegisterPrinter && result.Name != "" && result.NativeJobSemaphore == nil {
+			t.Logf("%s: expected registered printer to have a job semaphore", c.name)
+			t.Fail()
+		}
+		if c.operation == lib.DeletePrinter && !reflect.DeepEqual([]string{"a"}, native.RemovedPPDs()) {
+			t.Logf("%s: expected cached PPD to be removed, got %v", c.name, native.RemovedPPDs())
+			t.Fail()
+		}
+
+		if c.cloud == nil {
+			continue
+		}
+		registered, updated, deleted := c.cloud.reset()
+		if !reflect.DeepEqual(c.registered, registered) || !reflect.DeepEqual(c.updated, updated) ||
+			!reflect.DeepEqual(c.deleted, deleted) {
+			t.Logf("%s: expected registered %v, updated %v, deleted %v; got %v, %v, %v",
+				c.name, c.registered, c.updated, c.deleted, registered, updated, deleted)
+			t.Fail()
+		}
+	}
+}
+
+func TestSyncPrintersRetriesFailures(t *testing.T) {
+	native := nativetest.New()
+	native.AddPrinter(newTestPrinter("a"))
+	native.AddPrinter(newTestPrinter("b"))
+	cloud := &fakeCloudPrint{}
+	pm := newTestPrinterManager(t, native, cloud)
+	if err := pm.SyncPrinters(true); err != nil {
+		t.Fatal(err)
+	}
+	cloud.reset()
+
+	native.SetDescription("a", &cdd.PrinterDescriptionSection{
+		Color: &cdd.Color{Option: []cdd.ColorOption{cdd.ColorOption{Type: cdd.ColorTypeStandardColor}}},
+	})
+	native.RemovePrinter("b")
+	native.AddPrinter(newTestPrinter("c"))
+	cloud.failRegister, cloud.failUpdate, cloud.failDelete = true, true, true
+	if err := pm.SyncPrinters(true); err != nil {
+		t.Fatal(err)
+	}
+	if total, done, failed := pm.GetSyncStats(); total != 3 || done != 3 || failed != 3 {
+		t.Logf("expected 3 diffs, 3 done, 3 failed; got %d, %d, %d", total, done, failed)
+		t.Fail()
+	}
+	if names := printerNames(pm.printers.GetAll()); !reflect.DeepEqual([]string{"a", "b"}, names) {
+		t.Logf("expected failed delete to keep printer b, and failed register to drop c; got %v", names)
+		t.Fail()
+	}
+
+	cloud.failRegister, cloud.failUpdate, cloud.failDelete = false, false, false
+	if err := pm.SyncPrinters(true); err != nil {
+		t.Fatal(err)
+	}
+	registered, updated, deleted := cloud.reset()
+	if !reflect.DeepEqual([]string{"c"}, registered) || !reflect.DeepEqual([]string{"a"}, updated) ||
+		!reflect.DeepEqual([]string{"gcp-b"}, deleted) {
+		t.Logf("expected retries of register c, update a, delete gcp-b; got %v, %v, %v", registered, updated, deleted)
+		t.Fail()
+	}
+	if total, done, failed := pm.GetSyncStats(); total != 3 || done != 3 || failed != 0 {
+		t.Logf("expected 3 diffs, 3 done, 0 failed; got %d, %d, %d", total, done, failed)
+		t.Fail()
+	}
+	if names := printerNames(pm.printers.GetAll()); !reflect.DeepEqual([]string{"a", "c"}, names) {
+		t.Logf("expected printers [a c], got %v", names)
+		t.Fail()
+	}
+}
+
+func TestSyncPrintersMaxConcurrency(t *testing.T) {
+	native := nativetest.New()
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		native.AddPrinter(newTestPrinter(name))
+	}
+	cloud := &fakeCloudPrint{registerDelay: 20 * time.Millisecond}
+	pm := newTestPrinterManager(t, native, cloud)
+	pm.syncMaxConcurrency = 2
+
+	if err := pm.SyncPrinters(true); err != nil {
+		t.Fatal(err)
+	}
+	if registered, _, _ := cloud.reset(); len(registered) != 7 {
+		t.Logf("expected 7 printers registered, got %v", registered)
+		t.Fail()
+	}
+	if cloud.maxRegistering != 2 {
+		t.Logf("expected at most 2 concurrent registrations, got %d", cloud.maxRegistering)
+		t.Fail()
+	}
+}
+
+func TestSyncPrintersSerialized(t *testing.T) {
+	native := nativetest.New()
+	native.AddPrinter(newTestPrinter("a"))
+	native.AddPrinter(newTestPrinter("b"))
+	cloud := &fakeCloudPrint{registerDelay: 20 * time.Millisecond}
+	pm := newTestPrinterManager(t, native, cloud)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := pm.SyncPrinters(true); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if registered, _, _ := cloud.reset(); !reflect.DeepEqual(registered, []string{"a", "b"}) {
+		t.Logf("expected a and b registered once, got %v", registered)
+		t.Fail()
+	}
+	if names := printerNames(pm.printers.GetAll()); !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Logf("expected printers a and b, got %v", names)
+		t.Fail()
+	}
+}
+
+func TestPrintJob(t *testing.T) {
+	cases := []struct {
+		name   string
+		states []cdd.JobStateType
+		done   uint
+		errors uint
+	}{
+		{
+			name:   "done",
+			states: []cdd.JobStateType{cdd.JobStateInProgress, cdd.JobStateDone},
+			done:   1,
+		},
+		{
+			name:   "aborted",
+			states: []cdd.JobStateType{cdd.JobStateInProgress, cdd.JobStateAborted},
+			errors: 1,
+		},
+		{
+			name:   "stopped, then done",
+			states: []cdd.JobStateType{cdd.JobStateInProgress, cdd.JobStateStopped, cdd.JobStateInProgress, cdd.JobStateDone},
+			done:   1,
+		},
+	}
+
+	for _, c := range cases {
+		native := nativetest.New()
+		native.AddPrinter(newTestPrinter("a"))
+		pm := newTestPrinterManager(t, native, nil)
+		if err := pm.SyncPrinters(true); err != nil {
+			t.Fatal(err)
+		}
+
+		var r jobRecorder
+		job := newTestJob(t, "job", "a", &r)
+		finished := make(chan struct{})
+		go func() {
+			pm.printJob(job)
+			close(finished)
+		}()
+
+		waitFor(t, "native job", func() bool { return len(native.Jobs()) == 1 })
+		nativeJob := native.Jobs()[0]
+		if nativeJob.PrinterName != "a" || nativeJob.GCPJobID != "job" || nativeJob.User != "user" ||
+			string(nativeJob.Document) != "%PDF-1.4" {
+			t.Logf("%s: unexpected native job %+v", c.name, nativeJob)
+			t.Fail()
+		}
+
+		for i, state := range c.states {
+			native.SetJobState(nativeJob.ID, cdd.PrintJobStateDiff{State: &cdd.JobState{Type: state}})
+			waitFor(t, string(state), func() bool { return len(r.get()) == i+1 })
+		}
+		<-finished
+
+		if states := r.get(); !reflect.DeepEqual(c.states, states) {
+			t.Logf("%s: expected reported states %v, got %v", c.name, c.states, states)
+			t.Fail()
+		}
+		if nativeJob, _ = native.Job(nativeJob.ID); !nativeJob.Released {
+			t.Logf("%s: expected native job to be released", c.name)
+			t.Fail()
+		}
+		done, errors, processing, _ := pm.GetJobStats()
+		if done != c.done || errors != c.errors || processing != 0 {
+			t.Logf("%s: expected %d done, %d errors, 0 processing; got %d, %d, %d",
+				c.name, c.done, c.errors, done, errors, processing)
+			t.Fail()
+		}
+		if n := pm.countJobsInFlight(); n != 0 {
+			t.Logf("%s: expected no jobs in flight, got %d", c.name, n)
+			t.Fail()
+		}
+	}
+}
+
+func TestPrintJobDeduplication(t *testing.T) {
+	native := nativetest.New()
+	native.AddPrinter(newTestPrinter("a"))
+	pm := newTestPrinterManager(t, native, nil)
+	if err := pm.SyncPrinters(true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep the first instance of the job in flight.
+	native.PausePrinting()
+	var first, second jobRecorder
+	job := newTestJob(t, "job", "a", &first)
+	finished := make(chan struct{})
+	go func() {
+		pm.printJob(job)
+		close(finished)
+	}()
+	waitFor(t, "job in flight", func() bool { return pm.countJobsInFlight() == 1 })
+
+	// The second instance is thrown away.
+	pm.printJob(newTestJob(t, "job", "a", &second))
+	if states := second.get(); len(states) != 0 {
+		t.Logf("expected duplicate job to be ignored, got states %v", states)
+		t.Fail()
+	}
+
+	native.ResumePrinting()
+	waitFor(t, "native job", func() bool { return len(native.Jobs()) == 1 })
+	native.SetJobState(native.Jobs()[0].ID, cdd.PrintJobStateDiff{State: &cdd.JobState{Type: cdd.JobStateDone}})
+	<-finished
+
+	if jobs := native.Jobs(); len(jobs) != 1 {
+		t.Logf("expected 1 native job, got %d", len(jobs))
+		t.Fail()
+	}
+	if done, errors, _, _ := pm.GetJobStats(); done != 1 || errors != 0 {
+		t.Logf("expected 1 job done and none failed, got %d and %d", done, errors)
+		t.Fail()
+	}
+}
+
+func TestGetJobStats(t *testing.T) {
+	native := nativetest.New()
+	native.AddPrinter(newTestPrinter("a"))
+	native.AddPrinter(newTestPrinter("b"))
+	native.FailPrint("b", errors.New("out of paper"))
+	pm := newTestPrinterManager(t, native, nil)
+	if err := pm.SyncPrinters(true); err != nil {
+		t.Fatal(err)
+	}
+
+	native.PausePrinting()
+	var r jobRecorder
+	var wg sync.WaitGroup
+	for _, job := range []*lib.Job{
+		newTestJob(t, "1", "a", &r),
+		newTestJob(t, "2", "a", &r),
+		newTestJob(t, "3", "b", &r),
+		newTestJob(t, "4", "deleted", &r),
+	} {
+		wg.Add(1)
+		go func(job *lib.Job) {
+			defer wg.Done()
+			pm.printJob(job)
+		}(job)
+	}
+
+	// Jobs 1, 2 and 3 wait in Print; job 4 fails right away.
+	steps := []struct {
+		name                     string
+		step                     func()
+		done, errors, processing uint
+	}{
+		{
+			name:       "printing paused",
+			step:       func() {},
+			errors:     1,
+			processing: 3,
+		},
+		{
+			name:   "printing resumed",
+			step:   native.ResumePrinting,
+			errors: 2,
+		},
+		{
+			name: "jobs finished",
+			step: func() {
+				for _, job := range native.Jobs() {
+					native.SetJobState(job.ID, cdd.PrintJobStateDiff{State: &cdd.JobState{Type: cdd.JobStateDone}})
+				}
+				wg.Wait()
+			},
+			done:   2,
+			errors: 2,
+		},
+	}
+
+	for _, step := range steps {
+		step.step()
+		waitFor(t, step.name, func() bool {
+			done, errors, processing, err := pm.GetJobStats()
+			return err == nil && done == step.done && errors == step.errors && processing == step.processing
+		})
+	}
+}
+
+func TestFollowJobMissedState(t *testing.T) {
+	native := nativetest.New()
+	printer := newTestPrinter("a")
+	native.AddPrinter(printer)
+	pm := newTestPrinterManager(t, native, nil)
+	if err := pm.SyncPrinters(true); err != nil {
+		t.Fatal(err)
+	}
+
+	job := newTestJob(t, "job", "a", &jobRecorder{})
+	nativeJobID, err := native.Print(&printer, job.Filename, job.Title, "user", job.JobID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(job.Filename)
+	// The job finishes before it is followed, so no change is ever sent to
+	// the subscription.
+	native.SetJobState(nativeJobID, cdd.PrintJobStateDiff{State: &cdd.JobState{Type: cdd.JobStateDone}})
+
+	var r jobRecorder
+	finished := make(chan struct{})
+	go func() {
+		pm.followJob(journalEntry{
+			JobID:             job.JobID,
+			PrinterName:       "a",
+			NativePrinterName: "a",
+			NativeJobID:       nativeJobID,
+			State:             cdd.PrintJobStateDiff{State: &cdd.JobState{Type: cdd.JobStateQueued}},
+			SubmittedAt:       time.Now(),
+		}, r.updateJob, nil)
+		close(finished)
+	}()
+	waitFor(t, "job to finish", func() bool {
+		select {
+		case <-finished:
+			return true
+		default:
+			return false
+		}
+	})
+
+	if states := r.get(); !reflect.DeepEqual(states, []cdd.JobStateType{cdd.JobStateDone}) {
+		t.Logf("expected job reported DONE, got states %v", states)
+		t.Fail()
+	}
+}
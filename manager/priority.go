@@ -0,0 +1,317 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"container/heap"
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+)
+
+// Job priorities, like the IPP job-priority attribute.
+const (
+	minJobPriority     = 1
+	maxJobPriority     = 100
+	defaultJobPriority = 50
+
+	// nativeJobPriorityVendorID is the CUPS option, and IPP attribute, that
+	// sets the priority of a native job.
+	nativeJobPriorityVendorID = "job-priority"
+)
+
+// lookupUserGroups finds the names of the local groups of a user.
+var lookupUserGroups = func(username string) ([]string, error) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if g, err := user.LookupGroupId(id); err == nil {
+			groups = append(groups, g.Name)
+		}
+	}
+	return groups, nil
+}
+
+// jobPriorityRule is a compiled lib.JobPriority.
+type jobPriorityRule struct {
+	name             string
+	printers         map[string]struct{}
+	users            map[string]struct{}
+	groups           map[string]struct{}
+	vendorTicketItem string
+	priority         int
+}
+
+// newJobPriorityRules compiles job priority configs.
+func newJobPriorityRules(priorities []lib.JobPriority) ([]jobPriorityRule, error) {
+	compiled := make([]jobPriorityRule, len(priorities))
+	for i, priority := range priorities {
+		r := jobPriorityRule{
+			name:             priority.Name,
+			printers:         stringSet(priority.Printers),
+			users:            stringSet(priority.Users),
+			groups:           stringSet(priority.Groups),
+			vendorTicketItem: priority.VendorTicketItem,
+			priority:         priority.Priority,
+		}
+		if r.name == "" {
+			r.name = fmt.Sprintf("#%d", i+1)
+		}
+
+		if r.vendorTicketItem == "" && (r.priority < minJobPriority || r.priority > maxJobPriority) {
+			return nil, fmt.Errorf("Job priority %s has priority %d; priorities range from %d to %d",
+				r.name, r.priority, minJobPriority, maxJobPriority)
+		}
+
+		compiled[i] = r
+	}
+
+	return compiled, nil
+}
+
+// stringSet makes a set of strings, or nil when there are none.
+func stringSet(values []string) map[string]struct{} {
+	if len(values) == 0 {
+		return nil
+	}
+	set := make(map[string]struct{}, len(values))
+	for _, s := range values {
+		set[s] = struct{}{}
+	}
+	return set
+}
+
+// matchOwner checks whether the owner of a job is one of the users or groups
+// of the rule. groups looks up the groups of the owner, once.
+func (r *jobPriorityRule) matchOwner(owner string, groups func() map[string]struct{}) bool {
+	if r.users == nil && r.groups == nil {
+		return true
+	}
+	if _, exists := r.users[owner]; exists {
+		return true
+	}
+	if r.groups != nil {
+		for group := range groups() {
+			if _, exists := r.groups[group]; exists {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// vendorTicketItemPriority finds the priority that a job asks for with a
+// vendor ticket item.
+func vendorTicketItemPriority(ticket *cdd.CloudJobTicket, id string) (int, bool) {
+	if ticket == nil {
+		return 0, false
+	}
+	for _, vti := range ticket.Print.VendorTicketItem {
+		if vti.ID != id {
+			continue
+		}
+		priority, err := strconv.Atoi(vti.Value)
+		if err != nil || priority < minJobPriority || priority > maxJobPriority {
+			return 0, false
+		}
+		return priority, true
+	}
+	return 0, false
+}
+
+// jobPriority finds the priority of a job on a printer, from the first
+// priority rule that matches.
+func (pm *PrinterManager) jobPriority(job *lib.Job, printerName string) int {
+	var groups map[string]struct{}
+	lookupGroups := func() map[string]struct{} {
+		if groups == nil {
+			username := strings.Split(job.User, "@")[0]
+			names, err := lookupUserGroups(username)
+			if err != nil {
+				log.DebugJobf(job.JobID, "Failed to look up the groups of %s: %s", username, err)
+			}
+			groups = stringSet(names)
+			if groups == nil {
+				groups = map[string]struct{}{}
+			}
+		}
+		return groups
+	}
+
+	for i := range pm.jobPriorityRules {
+		r := &pm.jobPriorityRules[i]
+		if r.printers != nil {
+			if _, exists := r.printers[printerName]; !exists {
+				continue
+			}
+		}
+		if !r.matchOwner(job.User, lookupGroups) {
+			continue
+		}
+		if r.vendorTicketItem != "" {
+			priority, ok := vendorTicketItemPriority(job.Ticket, r.vendorTicketItem)
+			if !ok {
+				continue
+			}
+			log.DebugJobf(job.JobID, "Priority %d from ticket, by job priority %s", priority, r.name)
+			return priority
+		}
+		log.DebugJobf(job.JobID, "Priority %d from job priority %s", r.priority, r.name)
+		return r.priority
+	}
+	return defaultJobPriority
+}
+
+// setNativeJobPriority returns a copy of ticket that sets the priority of the
+// native job.
+func setNativeJobPriority(ticket *cdd.CloudJobTicket, priority int) *cdd.CloudJobTicket {
+	var t cdd.CloudJobTicket
+	if ticket != nil {
+		t = *ticket
+	}
+	items := make([]cdd.VendorTicketItem, 0, len(t.Print.VendorTicketItem)+1)
+	for _, vti := range t.Print.VendorTicketItem {
+		if vti.ID != nativeJobPriorityVendorID {
+			items = append(items, vti)
+		}
+	}
+	t.Print.VendorTicketItem = append(items, cdd.VendorTicketItem{
+		ID:    nativeJobPriorityVendorID,
+		Value: strconv.Itoa(priority),
+	})
+	return &t
+}
+
+// queuedJob is a job waiting for its turn in a jobQueue.
+type queuedJob struct {
+	priority int
+	seq      uint64
+	// index is the position of the job in the queue, or -1 once it is the
+	// turn of the job.
+	index int
+	ready chan struct{}
+}
+
+// queuedJobs is a heap of jobs, highest priority first, then first come
+// first served.
+type queuedJobs []*queuedJob
+
+func (q queuedJobs) Len() int { return len(q) }
+
+func (q queuedJobs) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q queuedJobs) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *queuedJobs) Push(x interface{}) {
+	j := x.(*queuedJob)
+	j.index = len(*q)
+	*q = append(*q, j)
+}
+
+func (q *queuedJobs) Pop() interface{} {
+	old := *q
+	j := old[len(old)-1]
+	*q = old[:len(old)-1]
+	j.index = -1
+	return j
+}
+
+// jobQueue lets jobs submit to a native printer in priority order, at most
+// size jobs at a time. It sits in front of the NativeJobSemaphore of the
+// printer, which has the same size, so that the semaphore never decides the
+// order.
+type jobQueue struct {
+	mutex   sync.Mutex
+	size    uint
+	running uint
+	waiting queuedJobs
+	seq     uint64
+}
+
+func newJobQueue(size uint) *jobQueue {
+	return &jobQueue{size: size}
+}
+
+// enter adds a job to the queue. The ready channel of the job is closed when
+// it is the turn of the job.
+func (q *jobQueue) enter(priority int) *queuedJob {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.seq++
+	j := &queuedJob{priority: priority, seq: q.seq, ready: make(chan struct{})}
+	if q.running < q.size && len(q.waiting) == 0 {
+		q.running++
+		j.index = -1
+		close(j.ready)
+		return j
+	}
+	heap.Push(&q.waiting, j)
+	return j
+}
+
+// leave removes a job from the queue, giving its turn to the next job if it
+// had one.
+func (q *jobQueue) leave(j *queuedJob) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if j.index >= 0 {
+		heap.Remove(&q.waiting, j.index)
+		return
+	}
+	if len(q.waiting) > 0 {
+		next := heap.Pop(&q.waiting).(*queuedJob)
+		close(next.ready)
+		return
+	}
+	q.running--
+}
+
+// getJobQueue gets the queue of a native printer.
+func (pm *PrinterManager) getJobQueue(printer *lib.Printer) *jobQueue {
+	pm.jobQueuesMutex.Lock()
+	defer pm.jobQueuesMutex.Unlock()
+
+	if pm.jobQueues == nil {
+		pm.jobQueues = make(map[string]*jobQueue)
+	}
+	if q, exists := pm.jobQueues[printer.Name]; exists {
+		return q
+	}
+	size := pm.nativeJobQueueSize
+	if printer.NativeJobSemaphore != nil {
+		size = printer.NativeJobSemaphore.Size()
+	}
+	q := newJobQueue(size)
+	pm.jobQueues[printer.Name] = q
+	return q
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/nativetest"
+)
+
+func TestNewJobPriorityRulesErrors(t *testing.T) {
+	cases := map[string]lib.JobPriority{
+		"no priority":       lib.JobPriority{Users: []string{"joe@example.com"}},
+		"priority too high": lib.JobPriority{Priority: 101},
+	}
+	for name, priority := range cases {
+		if _, err := newJobPriorityRules([]lib.JobPriority{priority}); err == nil {
+			t.Logf("%s: expected error", name)
+			t.Fail()
+		}
+	}
+
+	if _, err := newJobPriorityRules([]lib.JobPriority{lib.JobPriority{VendorTicketItem: "priority"}}); err != nil {
+		t.Logf("expected priority from vendor ticket item to need no priority, got %s", err)
+		t.Fail()
+	}
+}
+
+func TestJobPriority(t *testing.T) {
+	defer func(lookup func(string) ([]string, error)) { lookupUserGroups = lookup }(lookupUserGroups)
+	lookupUserGroups = func(username string) ([]string, error) {
+		if username == "shipping" {
+			return []string{"users", "warehouse"}, nil
+		}
+		return nil, errors.New("unknown user")
+	}
+
+	rules, err := newJobPriorityRules([]lib.JobPriority{
+		lib.JobPriority{Name: "labels", Printers: []string{"labels"}, Priority: 10},
+		lib.JobPriority{Name: "boss", Users: []string{"boss@example.com"}, Priority: 90},
+		lib.JobPriority{Name: "warehouse", Groups: []string{"warehouse"}, Priority: 80},
+		lib.JobPriority{Name: "ticket", VendorTicketItem: "priority"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm := PrinterManager{jobPriorityRules: rules}
+
+	ticket := func(priority string) *cdd.CloudJobTicket {
+		return &cdd.CloudJobTicket{
+			Print: cdd.PrintTicketSection{
+				VendorTicketItem: []cdd.VendorTicketItem{cdd.VendorTicketItem{ID: "priority", Value: priority}},
+			},
+		}
+	}
+
+	cases := []struct {
+		name     string
+		user     string
+		printer  string
+		ticket   *cdd.CloudJobTicket
+		priority int
+	}{
+		{"printer", "boss@example.com", "labels", nil, 10},
+		{"user", "boss@example.com", "a", nil, 90},
+		{"group", "shipping@example.com", "a", ticket("99"), 80},
+		{"ticket", "joe@example.com", "a", ticket("99"), 99},
+		{"bad ticket", "joe@example.com", "a", ticket("101"), defaultJobPriority},
+		{"default", "joe@example.com", "a", nil, defaultJobPriority},
+	}
+	for _, c := range cases {
+		job := lib.Job{JobID: c.name, User: c.user, Ticket: c.ticket}
+		if priority := pm.jobPriority(&job, c.printer); priority != c.priority {
+			t.Logf("%s: expected priority %d, got %d", c.name, c.priority, priority)
+			t.Fail()
+		}
+	}
+}
+
+func TestJobQueue(t *testing.T) {
+	q := newJobQueue(1)
+
+	first := q.enter(10)
+	low := q.enter(10)
+	high := q.enter(90)
+	canceled := q.enter(90)
+	sameHigh := q.enter(90)
+	q.leave(canceled)
+
+	ready := func(j *queuedJob) bool {
+		select {
+		case <-j.ready:
+			return true
+		default:
+			return false
+		}
+	}
+	expected := []*queuedJob{first, high, sameHigh, low}
+	for i, j := range expected {
+		if !ready(j) {
+			t.Fatalf("expected job %d, of priority %d, to have its turn", j.seq, j.priority)
+		}
+		for _, other := range append(expected[i+1:], canceled) {
+			if ready(other) {
+				t.Fatalf("expected job %d, of priority %d, to wait for job %d", other.seq, other.priority, j.seq)
+			}
+		}
+		q.leave(j)
+	}
+
+	if q.running != 0 || len(q.waiting) != 0 {
+		t.Logf("expected empty queue, got %d running and %d waiting", q.running, len(q.waiting))
+		t.Fail()
+	}
+}
+
+func TestPrintJobPriority(t *testing.T) {
+	native := nativetest.New()
+	native.AddPrinter(newTestPrinter("a"))
+	pm := newTestPrinterManager(t, native, nil)
+	pm.nativeJobQueueSize = 1
+	pm.nativeJobPriority = true
+	var err error
+	if pm.jobPriorityRules, err = newJobPriorityRules([]lib.JobPriority{
+		lib.JobPriority{Users: []string{"boss@example.com"}, Priority: 90},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err = pm.SyncPrinters(true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first job takes the only turn of the printer, while the others
+	// wait in the queue.
+	native.PausePrinting()
+	var r jobRecorder
+	jobs := []*lib.Job{
+		newTestJob(t, "first", "a", &r),
+		newTestJob(t, "low", "a", &r),
+		newTestJob(t, "high", "a", &r),
+	}
+	jobs[2].User = "boss@example.com"
+
+	finished := make(chan struct{}, len(jobs))
+	printJob := func(job *lib.Job) {
+		go func() {
+			pm.printJob(job)
+			finished <- struct{}{}
+		}()
+	}
+	queue := pm.getJobQueue(&lib.Printer{Name: "a"})
+	queued := func(n int) func() bool {
+		return func() bool {
+			queue.mutex.Lock()
+			defer queue.mutex.Unlock()
+			return queue.running == 1 && len(queue.waiting) == n
+		}
+	}
+	printJob(jobs[0])
+	waitFor(t, "first job", queued(0))
+	printJob(jobs[1])
+	waitFor(t, "low priority job", queued(1))
+	printJob(jobs[2])
+	waitFor(t, "high priority job", queued(2))
+	native.ResumePrinting()
+
+	waitFor(t, "native jobs", func() bool { return len(native.Jobs()) == 3 })
+	var order []string
+	for _, job := range native.Jobs() {
+		order = append(order, job.GCPJobID)
+		native.SetJobState(job.ID, cdd.PrintJobStateDiff{State: &cdd.JobState{Type: cdd.JobStateDone}})
+	}
+	for range jobs {
+		<-finished
+	}
+
+	if expected := []string{"first", "high", "low"}; !reflect.DeepEqual(expected, order) {
+		t.Logf("expected jobs submitted in order %v, got %v", expected, order)
+		t.Fail()
+	}
+	for _, job := range native.Jobs() {
+		expected := "50"
+		if job.GCPJobID == "high" {
+			expected = "90"
+		}
+		items := job.Ticket.Print.VendorTicketItem
+		if len(items) != 1 || items[0].ID != nativeJobPriorityVendorID || items[0].Value != expected {
+			t.Logf("%s: expected native job priority %s, got %+v", job.GCPJobID, expected, items)
+			t.Fail()
+		}
+	}
+}
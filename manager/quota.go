@@ -0,0 +1,200 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/log"
+)
+
+// quotaDayFormat identifies the day that a ledger counts pages for.
+const quotaDayFormat = "2006-01-02"
+
+// quotaExceededCause is reported for jobs from users over quota. GCP has no
+// cause specific to printer quotas.
+const quotaExceededCause = cdd.ServiceActionCauseOther
+
+// quotaLedger counts the pages printed today by each user on each printer,
+// so that the daily quotas of printers can be enforced. The counts start
+// over every day, at midnight local time.
+//
+// The whole ledger is rewritten on every change, so that the counts survive
+// a connector restart.
+type quotaLedger struct {
+	filename string
+	day      string
+	// Key is printer name, then user.
+	pages map[string]map[string]int
+	mutex sync.Mutex
+}
+
+// quotaLedgerFile is the on-disk format of a quotaLedger.
+type quotaLedgerFile struct {
+	Day   string                    `json:"day"`
+	Pages map[string]map[string]int `json:"pages"`
+}
+
+// newQuotaLedger opens the ledger at filename, reading the counts left
+// behind by the previous connector process.
+//
+// An empty filename keeps the counts in memory only.
+func newQuotaLedger(filename string) (*quotaLedger, error) {
+	ql := quotaLedger{
+		filename: filename,
+		day:      time.Now().Format(quotaDayFormat),
+		pages:    make(map[string]map[string]int),
+	}
+	if filename == "" {
+		return &ql, nil
+	}
+	if err := makeStateDir(filename); err != nil {
+		log.Warningf("Quota ledger kept in memory only; counts will start over after a restart: %s", err)
+		ql.filename = ""
+		return &ql, nil
+	}
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &ql, nil
+		}
+		return nil, err
+	}
+
+	var file quotaLedgerFile
+	if err = json.Unmarshal(b, &file); err != nil {
+		return nil, err
+	}
+	if file.Day == ql.day && file.Pages != nil {
+		ql.pages = file.Pages
+	}
+
+	return &ql, nil
+}
+
+// pagesPrinted returns the pages printed today by user on printerName.
+func (ql *quotaLedger) pagesPrinted(printerName, user string) int {
+	ql.mutex.Lock()
+	defer ql.mutex.Unlock()
+
+	ql.startOver(time.Now())
+	return ql.pages[printerName][user]
+}
+
+// addPages counts pages printed by user on printerName.
+func (ql *quotaLedger) addPages(printerName, user string, pages int) error {
+	ql.mutex.Lock()
+	defer ql.mutex.Unlock()
+
+	ql.startOver(time.Now())
+	if _, exists := ql.pages[printerName]; !exists {
+		ql.pages[printerName] = make(map[string]int)
+	}
+	ql.pages[printerName][user] += pages
+	return ql.write()
+}
+
+// startOver forgets the counts when now is not the day being counted.
+//
+// The caller must hold the mutex.
+func (ql *quotaLedger) startOver(now time.Time) {
+	if day := now.Format(quotaDayFormat); day != ql.day {
+		ql.day = day
+		ql.pages = make(map[string]map[string]int)
+	}
+}
+
+// write replaces the ledger file with the current counts.
+//
+// The caller must hold the mutex.
+func (ql *quotaLedger) write() error {
+	if ql.filename == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(quotaLedgerFile{ql.day, ql.pages}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomically(ql.filename, b)
+}
+
+// overQuota returns true if user has printed the daily quota of pages of
+// printerName, or more.
+//
+// Privet jobs are counted by the user name that their local client claims,
+// which is not authenticated; local users can evade quotas.
+func (pm *PrinterManager) overQuota(printerName, user string) bool {
+	printer, exists := pm.printers.GetByNativeName(printerName)
+	if !exists || !printer.QuotaEnabled {
+		return false
+	}
+	return pm.quotas.pagesPrinted(printerName, user) >= printer.DailyQuota
+}
+
+// refreshQuotas gets the quotas of the printers from the cloud, where they
+// are changed, with one call.
+func (pm *PrinterManager) refreshQuotas() {
+	if pm.gcp == nil {
+		return
+	}
+
+	pm.syncRateLimit.wait()
+	quotas, err := pm.gcp.ListQuotas()
+	if err != nil {
+		log.Warningf("Failed to get printer quotas from the cloud: %s", err)
+		return
+	}
+
+	printers := pm.printers.GetAll()
+	var changed bool
+	for i := range printers {
+		quota, exists := quotas[printers[i].GCPID]
+		if !exists || (quota.Enabled == printers[i].QuotaEnabled && quota.DailyQuota == printers[i].DailyQuota) {
+			continue
+		}
+		printers[i].QuotaEnabled = quota.Enabled
+		printers[i].DailyQuota = quota.DailyQuota
+		changed = true
+		log.InfoPrinterf(printers[i].Name, "Quota changed in the cloud: enabled %t, %d pages per day",
+			quota.Enabled, quota.DailyQuota)
+	}
+	if changed {
+		pm.printers.Refresh(printers)
+	}
+}
+
+// countPages counts the pages printed by a job since its previous state.
+func (pm *PrinterManager) countPages(entry journalEntry, previous, current cdd.PrintJobStateDiff) {
+	if current.PagesPrinted == nil {
+		return
+	}
+	pages := int(*current.PagesPrinted)
+	if previous.PagesPrinted != nil {
+		pages -= int(*previous.PagesPrinted)
+	}
+	if pages <= 0 {
+		return
+	}
+
+	printerName := entry.PrinterName
+	if printerName == "" {
+		printerName = entry.NativePrinterName
+	}
+	if err := pm.quotas.addPages(printerName, entry.User, pages); err != nil {
+		log.WarningJobf(entry.JobID, "Failed to update quota ledger: %s", err)
+	}
+}
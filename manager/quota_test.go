@@ -0,0 +1,155 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/gcp"
+	"github.com/google/cloud-print-connector/nativetest"
+)
+
+func TestQuotaLedger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quota-ledger-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "quota.json")
+
+	ql, err := newQuotaLedger(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ql.addPages("printer", "joe@example.com", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err = ql.addPages("printer", "joe@example.com", 4); err != nil {
+		t.Fatal(err)
+	}
+
+	ql, err = newQuotaLedger(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages := ql.pagesPrinted("printer", "joe@example.com"); pages != 7 {
+		t.Logf("expected 7 pages after reload, got %d", pages)
+		t.Fail()
+	}
+	if pages := ql.pagesPrinted("other", "joe@example.com"); pages != 0 {
+		t.Logf("expected 0 pages on other printer, got %d", pages)
+		t.Fail()
+	}
+
+	ql.startOver(time.Now().AddDate(0, 0, 1))
+	if pages := ql.pages["printer"]["joe@example.com"]; pages != 0 {
+		t.Logf("expected 0 pages the next day, got %d", pages)
+		t.Fail()
+	}
+}
+
+func TestCountPages(t *testing.T) {
+	pages := func(n int32) *int32 { return &n }
+	cases := []struct {
+		name   string
+		states []cdd.PrintJobStateDiff
+		pages  int
+	}{
+		{
+			name: "counted",
+			states: []cdd.PrintJobStateDiff{
+				{State: &cdd.JobState{Type: cdd.JobStateInProgress}, PagesPrinted: pages(2)},
+				{State: &cdd.JobState{Type: cdd.JobStateDone}, PagesPrinted: pages(5)},
+			},
+			pages: 5,
+		},
+		{
+			name: "pages sometimes unknown",
+			states: []cdd.PrintJobStateDiff{
+				{State: &cdd.JobState{Type: cdd.JobStateInProgress}, PagesPrinted: pages(2)},
+				{State: &cdd.JobState{Type: cdd.JobStateInProgress}},
+				{State: &cdd.JobState{Type: cdd.JobStateInProgress}, PagesPrinted: pages(4)},
+				{State: &cdd.JobState{Type: cdd.JobStateDone}},
+			},
+			pages: 4,
+		},
+	}
+
+	for _, c := range cases {
+		native := nativetest.New()
+		native.AddPrinter(newTestPrinter("a"))
+		pm := newTestPrinterManager(t, native, nil)
+		if err := pm.SyncPrinters(true); err != nil {
+			t.Fatal(err)
+		}
+
+		var r jobRecorder
+		job := newTestJob(t, "job", "a", &r)
+		finished := make(chan struct{})
+		go func() {
+			pm.printJob(job)
+			close(finished)
+		}()
+
+		waitFor(t, "native job", func() bool { return len(native.Jobs()) == 1 })
+		nativeJobID := native.Jobs()[0].ID
+		for _, state := range c.states {
+			native.SetJobState(nativeJobID, state)
+			time.Sleep(10 * time.Millisecond)
+		}
+		<-finished
+
+		if n := pm.quotas.pagesPrinted("a", job.User); n != c.pages {
+			t.Logf("%s: expected %d pages counted, got %d", c.name, c.pages, n)
+			t.Fail()
+		}
+	}
+}
+
+func TestRefreshQuotas(t *testing.T) {
+	native := nativetest.New()
+	native.AddPrinter(newTestPrinter("a"))
+	cloud := &fakeCloudPrint{}
+	pm := newTestPrinterManager(t, native, cloud)
+	if err := pm.SyncPrinters(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.quotas.addPages("a", "user@example.com", 3); err != nil {
+		t.Fatal(err)
+	}
+	if pm.overQuota("a", "user@example.com") {
+		t.Fatal("expected no quota before it is set in the cloud")
+	}
+
+	cloud.mutex.Lock()
+	cloud.quotas = map[string]gcp.Quota{"gcp-a": gcp.Quota{Enabled: true, DailyQuota: 3}}
+	cloud.mutex.Unlock()
+	if err := pm.SyncPrinters(false); err != nil {
+		t.Fatal(err)
+	}
+
+	printer, _ := pm.printers.GetByNativeName("a")
+	if !printer.QuotaEnabled || printer.DailyQuota != 3 {
+		t.Logf("expected quota of 3 pages after sync, got %t %d", printer.QuotaEnabled, printer.DailyQuota)
+		t.Fail()
+	}
+	if !pm.overQuota("a", "user@example.com") {
+		t.Log("expected user to be over the quota set in the cloud")
+		t.Fail()
+	}
+	if _, updated, _ := cloud.reset(); len(updated) != 0 {
+		t.Logf("expected quota change not to update the cloud, got updates %v", updated)
+		t.Fail()
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket limits the rate of calls to rate per second, allowing burst
+// calls at once after a pause.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket makes a full token bucket. A rate that is not positive is
+// unlimited, and returns a nil tokenBucket.
+func newTokenBucket(rate float64, burst uint) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// wait takes a token, waiting for one if the bucket is empty. Waiting calls
+// are served in order. A nil tokenBucket never waits.
+func (tb *tokenBucket) wait() {
+	if tb == nil {
+		return
+	}
+
+	tb.mutex.Lock()
+	now := time.Now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+	// Tokens go negative to reserve the tokens of the calls waiting.
+	tb.tokens--
+	var delay time.Duration
+	if tb.tokens < 0 {
+		delay = time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+	}
+	tb.mutex.Unlock()
+
+	time.Sleep(delay)
+}
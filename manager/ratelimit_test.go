@@ -0,0 +1,44 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	if tb := newTokenBucket(0, 10); tb != nil {
+		t.Logf("expected zero rate to be unlimited, got %+v", tb)
+		t.Fail()
+	}
+	// A nil tokenBucket never waits.
+	var unlimited *tokenBucket
+	unlimited.wait()
+
+	tb := newTokenBucket(50, 3)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		tb.wait()
+	}
+	if elapsed := time.Since(start); elapsed > 15*time.Millisecond {
+		t.Logf("expected burst of 3 without waiting, took %s", elapsed)
+		t.Fail()
+	}
+
+	start = time.Now()
+	for i := 0; i < 5; i++ {
+		tb.wait()
+	}
+	// 5 more tokens at 50 per second take 100ms.
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Logf("expected 5 calls past the burst to take about 100ms, took %s", elapsed)
+		t.Fail()
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+// maxRecentJobs is how many finished jobs are remembered for the status
+// page.
+const maxRecentJobs = 100
+
+// rememberFinishedJob wraps updateJob to remember the job as a recent job
+// when the state of the job is reported DONE or ABORTED.
+//
+// The native job is looked up in the journal, so updateJob must be called
+// before the job is deleted from the journal.
+func (pm *PrinterManager) rememberFinishedJob(job *lib.Job, updateJob func(string, *cdd.PrintJobStateDiff) error) func(string, *cdd.PrintJobStateDiff) error {
+	return func(jobID string, state *cdd.PrintJobStateDiff) error {
+		err := updateJob(jobID, state)
+		if state.State == nil ||
+			(state.State.Type != cdd.JobStateDone && state.State.Type != cdd.JobStateAborted) {
+			return err
+		}
+
+		finishedAt := time.Now()
+		recent := lib.MonitorJob{
+			JobID:       jobID,
+			Origin:      job.Origin,
+			PrinterName: job.NativePrinterName,
+			State:       string(state.State.Type),
+			Cause:       jobStateCause(state.State),
+			FinishedAt:  &finishedAt,
+		}
+		if entry, exists := pm.journal.get(jobID); exists {
+			recent.NativePrinterName = entry.NativePrinterName
+			recent.NativeJobID = entry.NativeJobID
+			submittedAt := entry.SubmittedAt
+			recent.SubmittedAt = &submittedAt
+		}
+
+		pm.recentJobsMutex.Lock()
+		pm.recentJobs = append(pm.recentJobs, recent)
+		if len(pm.recentJobs) > maxRecentJobs {
+			pm.recentJobs = append([]lib.MonitorJob(nil), pm.recentJobs[len(pm.recentJobs)-maxRecentJobs:]...)
+		}
+		pm.recentJobsMutex.Unlock()
+
+		return err
+	}
+}
+
+// GetRecentJobs returns the jobs that finished most recently, newest first.
+func (pm *PrinterManager) GetRecentJobs() []lib.MonitorJob {
+	pm.recentJobsMutex.Lock()
+	defer pm.recentJobsMutex.Unlock()
+
+	jobs := make([]lib.MonitorJob, len(pm.recentJobs))
+	for i, j := range pm.recentJobs {
+		jobs[len(jobs)-1-i] = j
+	}
+	return jobs
+}
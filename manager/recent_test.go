@@ -0,0 +1,62 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/nativetest"
+)
+
+func TestRecentJobs(t *testing.T) {
+	native := nativetest.New()
+	native.AddPrinter(newTestPrinter("a"))
+	pm := newTestPrinterManager(t, native, nil)
+	if err := pm.SyncPrinters(true); err != nil {
+		t.Fatal(err)
+	}
+
+	var r jobRecorder
+	job := newTestJob(t, "printed", "a", &r)
+	finished := make(chan struct{})
+	go func() {
+		pm.printJob(job)
+		close(finished)
+	}()
+	waitFor(t, "native job", func() bool { return len(native.Jobs()) == 1 })
+	native.SetJobState(native.Jobs()[0].ID, cdd.PrintJobStateDiff{State: &cdd.JobState{Type: cdd.JobStateDone}})
+	<-finished
+
+	pm.printJob(newTestJob(t, "deleted", "b", &r))
+
+	jobs := pm.GetRecentJobs()
+	if len(jobs) != 2 {
+		t.Fatalf("expected 2 recent jobs, got %+v", jobs)
+	}
+	if jobs[0].JobID != "deleted" || jobs[0].State != string(cdd.JobStateAborted) || jobs[0].Cause == "" {
+		t.Logf("expected deleted job ABORTED with a cause first, got %+v", jobs[0])
+		t.Fail()
+	}
+	if jobs[1].JobID != "printed" || jobs[1].State != string(cdd.JobStateDone) ||
+		jobs[1].NativeJobID == 0 || jobs[1].SubmittedAt == nil || jobs[1].FinishedAt == nil {
+		t.Logf("expected printed job DONE with native job, got %+v", jobs[1])
+		t.Fail()
+	}
+
+	for i := 0; i < maxRecentJobs; i++ {
+		pm.printJob(newTestJob(t, fmt.Sprintf("deleted-%d", i), "b", &r))
+	}
+	jobs = pm.GetRecentJobs()
+	if len(jobs) != maxRecentJobs || jobs[len(jobs)-1].JobID != "deleted-0" {
+		t.Logf("expected %d recent jobs, oldest deleted-0, got %d", maxRecentJobs, len(jobs))
+		t.Fail()
+	}
+}
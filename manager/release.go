@@ -0,0 +1,285 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+)
+
+// secureReleasePINVendorID is the vendor capability that users type their
+// release PIN into.
+const secureReleasePINVendorID = "secure-release-pin"
+
+var rReleasePIN = regexp.MustCompile(`^[0-9]{4,8}$`)
+
+const (
+	// releaseMaxFailures is the quantity of PINs in a row that may release
+	// nothing on a printer before release is locked on that printer.
+	releaseMaxFailures = 5
+	// releaseLockout is how long release is locked the first time. The
+	// lockout doubles each time, up to releaseMaxLockout.
+	releaseLockout    = time.Minute
+	releaseMaxLockout = time.Hour
+)
+
+// releaseThrottle counts the PINs that released nothing on a printer, to
+// stop PINs from being guessed.
+type releaseThrottle struct {
+	failures    uint
+	lockout     time.Duration
+	lockedUntil time.Time
+}
+
+// heldJob describes a job that waits in the native print system until it is
+// released with a PIN.
+type heldJob struct {
+	printerName string
+	pinHash     string
+	// release is closed to release the job.
+	release chan struct{}
+}
+
+// isSecureRelease returns true if the jobs of a printer are held until they
+// are released with a PIN.
+func (pm *PrinterManager) isSecureRelease(printerName string) bool {
+	_, exists := pm.secureReleasePrinters[printerName]
+	return exists
+}
+
+// addSecureReleaseCapability adds the release PIN capability to the printers
+// that hold their jobs until released.
+func (pm *PrinterManager) addSecureReleaseCapability(printers []lib.Printer) {
+	for i := range printers {
+		if !pm.isSecureRelease(printers[i].Name) || printers[i].Description == nil {
+			continue
+		}
+
+		// The native description may be cached, so change a copy.
+		description := *printers[i].Description
+		var vcs []cdd.VendorCapability
+		if description.VendorCapability != nil {
+			vcs = append(vcs, *description.VendorCapability...)
+		}
+		vcs = append(vcs, cdd.VendorCapability{
+			ID:   secureReleasePINVendorID,
+			Type: cdd.VendorCapabilityTypedValue,
+			TypedValueCap: &cdd.TypedValueCapability{
+				ValueType: cdd.TypedValueCapabilityTypeString,
+			},
+			DisplayNameLocalized: cdd.NewLocalizedString("Release PIN (4 to 8 numbers)"),
+		})
+		description.VendorCapability = &vcs
+		printers[i].Description = &description
+	}
+}
+
+// takeReleasePIN returns a copy of ticket without the release PIN, and the
+// release PIN.
+func takeReleasePIN(ticket *cdd.CloudJobTicket) (*cdd.CloudJobTicket, string) {
+	if ticket == nil {
+		return nil, ""
+	}
+
+	t := *ticket
+	var pin string
+	t.Print.VendorTicketItem = make([]cdd.VendorTicketItem, 0, len(ticket.Print.VendorTicketItem))
+	for _, vti := range ticket.Print.VendorTicketItem {
+		if vti.ID == secureReleasePINVendorID {
+			pin = vti.Value
+		} else {
+			t.Print.VendorTicketItem = append(t.Print.VendorTicketItem, vti)
+		}
+	}
+	return &t, pin
+}
+
+// hashReleasePIN hashes a PIN, so that the PIN is not written to the job
+// journal.
+func hashReleasePIN(jobID, pin string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(jobID+":"+pin)))
+}
+
+// ReleaseJobs releases the jobs held on a printer with a PIN.
+//
+// After releaseMaxFailures PINs in a row release nothing, PINs are not
+// checked on the printer for a while. Returns the time left to wait and false
+// if so. Whether the PIN released jobs is not returned, so that it can not be
+// guessed.
+func (pm *PrinterManager) ReleaseJobs(printerName, pin string) (time.Duration, bool) {
+	pm.heldJobsMutex.Lock()
+	defer pm.heldJobsMutex.Unlock()
+
+	throttle, exists := pm.releaseThrottles[printerName]
+	if !exists {
+		throttle = &releaseThrottle{}
+		pm.releaseThrottles[printerName] = throttle
+	}
+	if wait := throttle.lockedUntil.Sub(time.Now()); wait > 0 {
+		return wait, false
+	}
+
+	var released int
+	for jobID, j := range pm.heldJobs {
+		if j.printerName != printerName || j.pinHash != hashReleasePIN(jobID, pin) {
+			continue
+		}
+		select {
+		case <-j.release:
+			// Already released.
+		default:
+			close(j.release)
+			released++
+		}
+	}
+
+	if released > 0 {
+		throttle.failures = 0
+		return 0, true
+	}
+	throttle.failures++
+	if throttle.failures >= releaseMaxFailures {
+		throttle.failures = 0
+		if throttle.lockout == 0 {
+			throttle.lockout = releaseLockout
+		} else {
+			throttle.lockout *= 2
+		}
+		if throttle.lockout > releaseMaxLockout {
+			throttle.lockout = releaseMaxLockout
+		}
+		throttle.lockedUntil = time.Now().Add(throttle.lockout)
+		log.Warningf("%d wrong release PINs in a row on printer %s; release is locked for %s",
+			releaseMaxFailures, printerName, throttle.lockout)
+	}
+	return 0, true
+}
+
+// waitForRelease reports a job as HELD, and waits until the job is released
+// with ReleaseJobs, then releases the native job. state is the last state
+// already reported with updateJob.
+//
+// Jobs that are canceled, or not released within the secure release
+// timeout, are canceled in the native print system and reported as ABORTED.
+// Jobs still held when the connector stops stay HELD.
+//
+// Returns the last state reported, and false if the job was not released.
+func (pm *PrinterManager) waitForRelease(entry journalEntry, state cdd.PrintJobStateDiff, updateJob func(string, *cdd.PrintJobStateDiff) error, cancel <-chan struct{}) (cdd.PrintJobStateDiff, bool) {
+	jobID := entry.JobID
+	printerName := entry.PrinterName
+	if printerName == "" {
+		printerName = entry.NativePrinterName
+	}
+
+	j := heldJob{
+		printerName: printerName,
+		pinHash:     entry.ReleasePINHash,
+		release:     make(chan struct{}),
+	}
+	pm.heldJobsMutex.Lock()
+	pm.heldJobs[jobID] = &j
+	pm.heldJobsMutex.Unlock()
+	defer func() {
+		pm.heldJobsMutex.Lock()
+		delete(pm.heldJobs, jobID)
+		pm.heldJobsMutex.Unlock()
+	}()
+
+	held := cdd.PrintJobStateDiff{
+		State: &cdd.JobState{Type: cdd.JobStateHeld},
+	}
+	if !reflect.DeepEqual(held, state) {
+		state = held
+		if err := updateJob(jobID, &state); err != nil {
+			log.ErrorJob(jobID, err)
+		}
+		if err := pm.journal.updateState(jobID, state); err != nil {
+			log.WarningJobf(jobID, "Failed to update job journal: %s", err)
+		}
+	}
+	log.InfoJobf(jobID, "Held until released with a PIN on printer %s", printerName)
+
+	timeout := entry.SubmittedAt.Add(pm.secureReleaseTimeout).Sub(time.Now())
+	var aborted cdd.PrintJobStateDiff
+
+	select {
+	case <-j.release:
+		if err := pm.releaseHeldJob(entry.NativePrinterName, entry.NativeJobID); err != nil {
+			log.ErrorJobf(jobID, "Failed to release native job %d: %s", entry.NativeJobID, err)
+			aborted = cdd.PrintJobStateDiff{
+				State: &cdd.JobState{
+					Type:              cdd.JobStateAborted,
+					DeviceActionCause: &cdd.DeviceActionCause{ErrorCode: cdd.DeviceActionCauseOther},
+				},
+			}
+			break
+		}
+		log.InfoJob(jobID, "Released")
+
+		entry.ReleasePINHash = ""
+		entry.State = state
+		if err := pm.journal.put(entry); err != nil {
+			log.WarningJobf(jobID, "Failed to update job journal: %s", err)
+		}
+		return state, true
+
+	case <-time.After(timeout):
+		log.InfoJob(jobID, "Not released in time")
+		aborted = cdd.PrintJobStateDiff{
+			State: &cdd.JobState{
+				Type:               cdd.JobStateAborted,
+				ServiceActionCause: &cdd.ServiceActionCause{ErrorCode: cdd.ServiceActionCauseExpiration},
+			},
+		}
+
+	case <-cancel:
+		log.InfoJob(jobID, "Canceled while held")
+		aborted = cdd.PrintJobStateDiff{
+			State: &cdd.JobState{
+				Type:            cdd.JobStateAborted,
+				UserActionCause: &cdd.UserActionCause{ActionCode: cdd.UserActionCauseCanceled},
+			},
+		}
+
+	case <-pm.abandon:
+		if !pm.journal.enabled() {
+			log.WarningJobf(jobID, "Connector stopped; native job %d stays held, but will not be released", entry.NativeJobID)
+		} else {
+			log.InfoJob(jobID, "Connector stopped; the job stays held until restart")
+		}
+		return state, false
+	}
+
+	if err := pm.native.CancelJob(entry.NativePrinterName, entry.NativeJobID); err != nil {
+		// The native job stays held; it is never released.
+		log.ErrorJobf(jobID, "Failed to cancel held native job %d: %s", entry.NativeJobID, err)
+	}
+	if err := updateJob(jobID, &aborted); err != nil {
+		log.ErrorJob(jobID, err)
+	}
+	pm.incrementJobsProcessed(false)
+	return aborted, false
+}
+
+// releaseHeldJob lets a held native job print.
+func (pm *PrinterManager) releaseHeldJob(printerName string, nativeJobID uint32) error {
+	holder, ok := pm.native.(NativeJobHolder)
+	if !ok {
+		return errors.New("The native print system can not release held jobs")
+	}
+	return holder.ReleaseHeldJob(printerName, nativeJobID)
+}
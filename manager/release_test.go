@@ -0,0 +1,184 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/nativetest"
+)
+
+func TestTakeReleasePIN(t *testing.T) {
+	ticket := &cdd.CloudJobTicket{
+		Print: cdd.PrintTicketSection{
+			VendorTicketItem: []cdd.VendorTicketItem{
+				cdd.VendorTicketItem{ID: "Duplex", Value: "None"},
+				cdd.VendorTicketItem{ID: secureReleasePINVendorID, Value: "1234"},
+			},
+		},
+	}
+
+	t2, pin := takeReleasePIN(ticket)
+	if pin != "1234" {
+		t.Logf("expected PIN 1234, got %q", pin)
+		t.Fail()
+	}
+	if len(t2.Print.VendorTicketItem) != 1 || t2.Print.VendorTicketItem[0].ID != "Duplex" {
+		t.Logf("expected PIN to be removed from ticket, got %+v", t2.Print.VendorTicketItem)
+		t.Fail()
+	}
+	if len(ticket.Print.VendorTicketItem) != 2 {
+		t.Log("original ticket was modified")
+		t.Fail()
+	}
+
+	if _, pin = takeReleasePIN(nil); pin != "" {
+		t.Logf("expected no PIN from nil ticket, got %q", pin)
+		t.Fail()
+	}
+}
+
+func TestReleaseJobs(t *testing.T) {
+	pm := PrinterManager{
+		heldJobs: map[string]*heldJob{
+			"job1": &heldJob{printerName: "printer", pinHash: hashReleasePIN("job1", "1234"), release: make(chan struct{})},
+			"job2": &heldJob{printerName: "printer", pinHash: hashReleasePIN("job2", "1234"), release: make(chan struct{})},
+			"job3": &heldJob{printerName: "printer", pinHash: hashReleasePIN("job3", "9999"), release: make(chan struct{})},
+			"job4": &heldJob{printerName: "other", pinHash: hashReleasePIN("job4", "1234"), release: make(chan struct{})},
+		},
+		releaseThrottles: make(map[string]*releaseThrottle),
+	}
+	isReleased := func(jobID string) bool {
+		select {
+		case <-pm.heldJobs[jobID].release:
+			return true
+		default:
+			return false
+		}
+	}
+
+	if _, ok := pm.ReleaseJobs("printer", "1234"); !ok {
+		t.Fatal("expected PIN to be checked")
+	}
+	if _, ok := pm.ReleaseJobs("printer", "1234"); !ok {
+		t.Fatal("expected PIN to be checked again")
+	}
+	for jobID, released := range map[string]bool{"job1": true, "job2": true, "job3": false, "job4": false} {
+		if isReleased(jobID) != released {
+			t.Logf("expected %s released %t", jobID, released)
+			t.Fail()
+		}
+	}
+}
+
+func TestReleaseJobsThrottle(t *testing.T) {
+	pm := PrinterManager{
+		heldJobs: map[string]*heldJob{
+			"job": &heldJob{printerName: "printer", pinHash: hashReleasePIN("job", "1234"), release: make(chan struct{})},
+		},
+		releaseThrottles: make(map[string]*releaseThrottle),
+	}
+
+	for i := 0; i < releaseMaxFailures; i++ {
+		if _, ok := pm.ReleaseJobs("printer", "0000"); !ok {
+			t.Fatalf("expected wrong PIN %d to be checked", i+1)
+		}
+	}
+	wait, ok := pm.ReleaseJobs("printer", "1234")
+	if ok || wait <= 0 || wait > releaseLockout {
+		t.Fatalf("expected release to be locked for up to %s, got %s %t", releaseLockout, wait, ok)
+	}
+	select {
+	case <-pm.heldJobs["job"].release:
+		t.Log("expected job not to be released while locked")
+		t.Fail()
+	default:
+	}
+	if _, ok = pm.ReleaseJobs("other", "0000"); !ok {
+		t.Log("expected other printers not to be locked")
+		t.Fail()
+	}
+
+	// The next lockout is longer.
+	pm.releaseThrottles["printer"].lockedUntil = time.Time{}
+	for i := 0; i < releaseMaxFailures; i++ {
+		pm.ReleaseJobs("printer", "0000")
+	}
+	if wait, ok = pm.ReleaseJobs("printer", "1234"); ok || wait <= releaseLockout {
+		t.Logf("expected release to be locked for longer than %s, got %s %t", releaseLockout, wait, ok)
+		t.Fail()
+	}
+}
+
+func TestSecureRelease(t *testing.T) {
+	cases := []struct {
+		name     string
+		pin      string
+		released bool
+		states   []cdd.JobStateType
+	}{
+		{"released", "1234", true, []cdd.JobStateType{cdd.JobStateHeld, cdd.JobStateInProgress, cdd.JobStateDone}},
+		{"wrong PIN", "9999", false, []cdd.JobStateType{cdd.JobStateHeld, cdd.JobStateAborted}},
+	}
+
+	for _, c := range cases {
+		native := nativetest.New()
+		native.AddPrinter(newTestPrinter("a"))
+		pm := newTestPrinterManager(t, native, nil)
+		pm.secureReleasePrinters = map[string]struct{}{"a": struct{}{}}
+		pm.secureReleaseTimeout = 200 * time.Millisecond
+		if err := pm.SyncPrinters(true); err != nil {
+			t.Fatal(err)
+		}
+		// A held job that fails to cancel must stay held.
+		native.FailCancel("a", errors.New("cancel failed"))
+
+		var r jobRecorder
+		job := newTestJob(t, "job", "a", &r)
+		job.Ticket = &cdd.CloudJobTicket{
+			Print: cdd.PrintTicketSection{
+				VendorTicketItem: []cdd.VendorTicketItem{
+					cdd.VendorTicketItem{ID: secureReleasePINVendorID, Value: "1234"},
+				},
+			},
+		}
+		finished := make(chan struct{})
+		go func() {
+			pm.printJob(job)
+			close(finished)
+		}()
+
+		waitFor(t, "held job", func() bool { return len(r.get()) == 1 })
+		pm.ReleaseJobs("a", c.pin)
+		nativeJob := native.Jobs()[0]
+		if c.released {
+			waitFor(t, "released job", func() bool {
+				nativeJob, _ = native.Job(nativeJob.ID)
+				return nativeJob.ReleasedHeld
+			})
+			waitFor(t, "released job printing", func() bool { return len(r.get()) == 2 })
+			native.SetJobState(nativeJob.ID, cdd.PrintJobStateDiff{State: &cdd.JobState{Type: cdd.JobStateDone}})
+		}
+		<-finished
+
+		if states := r.get(); !reflect.DeepEqual(c.states, states) {
+			t.Logf("%s: expected reported states %v, got %v", c.name, c.states, states)
+			t.Fail()
+		}
+		nativeJob, _ = native.Job(nativeJob.ID)
+		if !nativeJob.Held || nativeJob.ReleasedHeld != c.released || nativeJob.Released != c.released {
+			t.Logf("%s: expected native job released %t, got %+v", c.name, c.released, nativeJob)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/google/cloud-print-connector/lib"
+)
+
+// jobRetryPolicy decides whether, and when, to retry a job that failed to
+// submit to the native print system.
+type jobRetryPolicy struct {
+	maxAttempts     uint
+	initialInterval time.Duration
+	maxInterval     time.Duration
+	retryableErrors []*regexp.Regexp
+}
+
+// newJobRetryPolicy compiles a lib.RetryPolicy. Empty fields of policy are
+// taken from defaults, which may be nil.
+func newJobRetryPolicy(policy lib.RetryPolicy, defaults *jobRetryPolicy) (*jobRetryPolicy, error) {
+	var p jobRetryPolicy
+	if defaults != nil {
+		p = *defaults
+	}
+
+	if policy.MaxAttempts > 0 {
+		p.maxAttempts = policy.MaxAttempts
+	}
+	if policy.InitialInterval != "" {
+		d, err := time.ParseDuration(policy.InitialInterval)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse initial retry interval: %s", err)
+		}
+		p.initialInterval = d
+	}
+	if policy.MaxInterval != "" {
+		d, err := time.ParseDuration(policy.MaxInterval)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse max retry interval: %s", err)
+		}
+		p.maxInterval = d
+	}
+	if len(policy.RetryableErrors) > 0 {
+		p.retryableErrors = make([]*regexp.Regexp, len(policy.RetryableErrors))
+		for i, expr := range policy.RetryableErrors {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse retryable error %q: %s", expr, err)
+			}
+			p.retryableErrors[i] = re
+		}
+	}
+
+	if p.maxAttempts == 0 {
+		// Zero attempts makes no sense; try once, never retry.
+		p.maxAttempts = 1
+	}
+
+	return &p, nil
+}
+
+// newBackoff returns a lib.Backoff that paces retries per this policy.
+func (p *jobRetryPolicy) newBackoff() *lib.Backoff {
+	return &lib.Backoff{
+		InitialInterval: p.initialInterval,
+		MaxInterval:     p.maxInterval,
+	}
+}
+
+// shouldRetry returns true if a job that failed with err, after attempts
+// attempts, should be tried again.
+func (p *jobRetryPolicy) shouldRetry(attempts uint, err error) bool {
+	if attempts >= p.maxAttempts {
+		return false
+	}
+	for _, re := range p.retryableErrors {
+		if re.MatchString(err.Error()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/lib"
+)
+
+func TestJobRetryPolicy(t *testing.T) {
+	defaults, err := newJobRetryPolicy(lib.RetryPolicy{
+		MaxAttempts:     3,
+		InitialInterval: "2s",
+		RetryableErrors: []string{"^Failed to connect"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transient := errors.New("Failed to connect to CUPS server localhost:631")
+	permanent := errors.New("Failed to print file: client-error-document-format-not-supported")
+
+	if !defaults.shouldRetry(1, transient) {
+		t.Log("expected transient error to be retried")
+		t.Fail()
+	}
+	if defaults.shouldRetry(3, transient) {
+		t.Log("did not expect retry after max attempts")
+		t.Fail()
+	}
+	if defaults.shouldRetry(1, permanent) {
+		t.Log("did not expect permanent error to be retried")
+		t.Fail()
+	}
+
+	// Empty fields come from the defaults.
+	p, err := newJobRetryPolicy(lib.RetryPolicy{MaxAttempts: 10}, defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.maxAttempts != 10 || p.initialInterval != 2*time.Second || len(p.retryableErrors) != 1 {
+		t.Logf("unexpected merged policy %+v", p)
+		t.Fail()
+	}
+
+	if _, err = newJobRetryPolicy(lib.RetryPolicy{RetryableErrors: []string{"("}}, nil); err == nil {
+		t.Log("expected bad regular expression to fail")
+		t.Fail()
+	}
+
+	// No policy means no retries.
+	p, err = newJobRetryPolicy(lib.RetryPolicy{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.shouldRetry(1, transient) {
+		t.Log("did not expect retry without a policy")
+		t.Fail()
+	}
+}
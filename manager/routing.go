@@ -0,0 +1,260 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+)
+
+// Routing rule actions.
+const (
+	routingActionRedirect = "redirect"
+	routingActionReject   = "reject"
+	routingActionRewrite  = "rewrite"
+)
+
+// rejectCauses are the service action causes that a reject rule may report.
+var rejectCauses = map[cdd.ServiceActionCauseCode]struct{}{
+	cdd.ServiceActionCauseConversionFileTooBig: struct{}{},
+	cdd.ServiceActionCauseConversionType:       struct{}{},
+	cdd.ServiceActionCauseInconsistentJob:      struct{}{},
+	cdd.ServiceActionCauseInconsistentPrinter:  struct{}{},
+	cdd.ServiceActionCauseOther:                struct{}{},
+}
+
+// routingRule is a compiled lib.RoutingRule.
+type routingRule struct {
+	name         string
+	printers     map[string]struct{}
+	owner        *regexp.Regexp
+	title        *regexp.Regexp
+	contentTypes map[string]struct{}
+	minPages     int
+	maxPages     int
+	color        cdd.ColorType
+	duplex       cdd.DuplexType
+
+	action      string
+	printer     string
+	rejectCause cdd.ServiceActionCauseCode
+	ticket      *cdd.PrintTicketSection
+}
+
+// newRoutingRules compiles routing rule configs.
+func newRoutingRules(rules []lib.RoutingRule) ([]routingRule, error) {
+	compiled := make([]routingRule, len(rules))
+	for i, rule := range rules {
+		r := routingRule{
+			name:     rule.Name,
+			minPages: rule.MinPages,
+			maxPages: rule.MaxPages,
+			color:    cdd.ColorType(rule.Color),
+			duplex:   cdd.DuplexType(rule.Duplex),
+			action:   rule.Action,
+		}
+		if r.name == "" {
+			r.name = fmt.Sprintf("#%d", i+1)
+		}
+
+		if len(rule.Printers) > 0 {
+			r.printers = make(map[string]struct{}, len(rule.Printers))
+			for _, printer := range rule.Printers {
+				r.printers[printer] = struct{}{}
+			}
+		}
+		if len(rule.ContentTypes) > 0 {
+			r.contentTypes = make(map[string]struct{}, len(rule.ContentTypes))
+			for _, contentType := range rule.ContentTypes {
+				r.contentTypes[contentType] = struct{}{}
+			}
+		}
+
+		var err error
+		if rule.Owner != "" {
+			if r.owner, err = regexp.Compile(rule.Owner); err != nil {
+				return nil, fmt.Errorf("Failed to parse owner of routing rule %s: %s", r.name, err)
+			}
+		}
+		if rule.Title != "" {
+			if r.title, err = regexp.Compile(rule.Title); err != nil {
+				return nil, fmt.Errorf("Failed to parse title of routing rule %s: %s", r.name, err)
+			}
+		}
+
+		switch rule.Action {
+		case routingActionRedirect:
+			if rule.Printer == "" {
+				return nil, fmt.Errorf("Routing rule %s redirects to no printer", r.name)
+			}
+			r.printer = rule.Printer
+		case routingActionReject:
+			r.rejectCause = cdd.ServiceActionCauseOther
+			if rule.RejectCause != "" {
+				r.rejectCause = cdd.ServiceActionCauseCode(rule.RejectCause)
+			}
+			if _, exists := rejectCauses[r.rejectCause]; !exists {
+				return nil, fmt.Errorf("Routing rule %s has unknown reject cause %s", r.name, rule.RejectCause)
+			}
+		case routingActionRewrite:
+			if rule.Ticket == nil {
+				return nil, fmt.Errorf("Routing rule %s rewrites no ticket items", r.name)
+			}
+			r.ticket = rule.Ticket
+		default:
+			return nil, fmt.Errorf("Routing rule %s has unknown action %q", r.name, rule.Action)
+		}
+
+		compiled[i] = r
+	}
+
+	return compiled, nil
+}
+
+// matches returns true if the job fits every condition of the rule.
+func (r *routingRule) matches(job *lib.Job) bool {
+	if r.printers != nil {
+		if _, exists := r.printers[job.NativePrinterName]; !exists {
+			return false
+		}
+	}
+	if r.owner != nil && !r.owner.MatchString(job.User) {
+		return false
+	}
+	if r.title != nil && !r.title.MatchString(job.Title) {
+		return false
+	}
+	if r.contentTypes != nil {
+		if _, exists := r.contentTypes[job.ContentType]; !exists {
+			return false
+		}
+	}
+	if r.minPages > 0 && (job.PageCount == 0 || job.PageCount < r.minPages) {
+		return false
+	}
+	if r.maxPages > 0 && (job.PageCount == 0 || job.PageCount > r.maxPages) {
+		return false
+	}
+	if r.color != "" {
+		if job.Ticket == nil || job.Ticket.Print.Color == nil || job.Ticket.Print.Color.Type != r.color {
+			return false
+		}
+	}
+	if r.duplex != "" {
+		if job.Ticket == nil || job.Ticket.Print.Duplex == nil || job.Ticket.Print.Duplex.Type != r.duplex {
+			return false
+		}
+	}
+	return true
+}
+
+// rewriteTicket returns a copy of ticket, with the ticket items of the rule
+// replacing the ticket items of the job. Vendor ticket items are replaced
+// by ID.
+func (r *routingRule) rewriteTicket(ticket *cdd.CloudJobTicket) *cdd.CloudJobTicket {
+	var t cdd.CloudJobTicket
+	if ticket != nil {
+		t = *ticket
+	}
+	p, rp := &t.Print, r.ticket
+
+	if len(rp.VendorTicketItem) > 0 {
+		items := make([]cdd.VendorTicketItem, 0, len(p.VendorTicketItem)+len(rp.VendorTicketItem))
+		replaced := make(map[string]struct{}, len(rp.VendorTicketItem))
+		for _, item := range rp.VendorTicketItem {
+			replaced[item.ID] = struct{}{}
+		}
+		for _, item := range p.VendorTicketItem {
+			if _, exists := replaced[item.ID]; !exists {
+				items = append(items, item)
+			}
+		}
+		p.VendorTicketItem = append(items, rp.VendorTicketItem...)
+	}
+	if rp.Color != nil {
+		p.Color = rp.Color
+	}
+	if rp.Duplex != nil {
+		p.Duplex = rp.Duplex
+	}
+	if rp.PageOrientation != nil {
+		p.PageOrientation = rp.PageOrientation
+	}
+	if rp.Copies != nil {
+		p.Copies = rp.Copies
+	}
+	if rp.Margins != nil {
+		p.Margins = rp.Margins
+	}
+	if rp.DPI != nil {
+		p.DPI = rp.DPI
+	}
+	if rp.FitToPage != nil {
+		p.FitToPage = rp.FitToPage
+	}
+	if rp.PageRange != nil {
+		p.PageRange = rp.PageRange
+	}
+	if rp.MediaSize != nil {
+		p.MediaSize = rp.MediaSize
+	}
+	if rp.Collate != nil {
+		p.Collate = rp.Collate
+	}
+	if rp.ReverseOrder != nil {
+		p.ReverseOrder = rp.ReverseOrder
+	}
+
+	return &t
+}
+
+// routeJob applies the routing rules to a job, changing the printer or the
+// ticket of the job.
+//
+// Returns the state to report, and false, if the job is rejected.
+func (pm *PrinterManager) routeJob(job *lib.Job) (cdd.PrintJobStateDiff, bool) {
+	var matched bool
+	for i := range pm.routingRules {
+		rule := &pm.routingRules[i]
+		if !rule.matches(job) {
+			continue
+		}
+		matched = true
+
+		switch rule.action {
+		case routingActionRedirect:
+			log.InfoJobf(job.JobID, "Routing rule %s redirected job from printer %s to printer %s",
+				rule.name, job.NativePrinterName, rule.printer)
+			job.NativePrinterName = rule.printer
+			return cdd.PrintJobStateDiff{}, true
+
+		case routingActionReject:
+			log.InfoJobf(job.JobID, "Routing rule %s rejected job with cause %s", rule.name, rule.rejectCause)
+			return cdd.PrintJobStateDiff{
+				State: &cdd.JobState{
+					Type:               cdd.JobStateAborted,
+					ServiceActionCause: &cdd.ServiceActionCause{ErrorCode: rule.rejectCause},
+				},
+			}, false
+
+		case routingActionRewrite:
+			log.InfoJobf(job.JobID, "Routing rule %s rewrote job ticket", rule.name)
+			job.Ticket = rule.rewriteTicket(job.Ticket)
+		}
+	}
+
+	if !matched && len(pm.routingRules) > 0 {
+		log.DebugJobf(job.JobID, "No routing rule matched; printing on printer %s", job.NativePrinterName)
+	}
+	return cdd.PrintJobStateDiff{}, true
+}
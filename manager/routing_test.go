@@ -0,0 +1,90 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"testing"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+func TestRouteJob(t *testing.T) {
+	rules, err := newRoutingRules([]lib.RoutingRule{
+		lib.RoutingRule{
+			Name:   "no-duplex",
+			Action: "rewrite",
+			Ticket: &cdd.PrintTicketSection{
+				Duplex: &cdd.DuplexTicketItem{Type: cdd.DuplexNoDuplex},
+			},
+			Printers: []string{"office"},
+		},
+		lib.RoutingRule{
+			Name:        "no-spam",
+			Owner:       "^spam@",
+			Action:      "reject",
+			RejectCause: "INCONSISTENT_JOB",
+		},
+		lib.RoutingRule{
+			Name:     "big-color",
+			MinPages: 100,
+			Color:    "STANDARD_COLOR",
+			Action:   "redirect",
+			Printer:  "production",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm := PrinterManager{routingRules: rules}
+
+	colorTicket := &cdd.CloudJobTicket{
+		Print: cdd.PrintTicketSection{
+			Color: &cdd.ColorTicketItem{Type: cdd.ColorTypeStandardColor},
+		},
+	}
+
+	job := lib.Job{NativePrinterName: "office", User: "joe@example.com", PageCount: 250, Ticket: colorTicket}
+	if _, ok := pm.routeJob(&job); !ok {
+		t.Fatal("did not expect job to be rejected")
+	}
+	if job.NativePrinterName != "production" {
+		t.Logf("expected job to be redirected to production, got %s", job.NativePrinterName)
+		t.Fail()
+	}
+	if job.Ticket.Print.Duplex == nil || job.Ticket.Print.Duplex.Type != cdd.DuplexNoDuplex {
+		t.Log("expected job ticket to be rewritten")
+		t.Fail()
+	}
+	if colorTicket.Print.Duplex != nil {
+		t.Log("original job ticket was modified")
+		t.Fail()
+	}
+
+	job = lib.Job{NativePrinterName: "office", User: "joe@example.com", Ticket: colorTicket}
+	if _, ok := pm.routeJob(&job); !ok || job.NativePrinterName != "office" {
+		t.Log("expected job with unknown page count to stay on office")
+		t.Fail()
+	}
+
+	job = lib.Job{NativePrinterName: "lab", User: "spam@example.com"}
+	state, ok := pm.routeJob(&job)
+	if ok {
+		t.Fatal("expected job to be rejected")
+	}
+	if state.State.ServiceActionCause.ErrorCode != cdd.ServiceActionCauseInconsistentJob {
+		t.Logf("unexpected reject cause %s", state.State.ServiceActionCause.ErrorCode)
+		t.Fail()
+	}
+
+	if _, err = newRoutingRules([]lib.RoutingRule{lib.RoutingRule{Action: "print"}}); err == nil {
+		t.Log("expected unknown action to fail")
+		t.Fail()
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+)
+
+// jobTimeouts limits how long native jobs may take. Zero is no limit.
+type jobTimeouts struct {
+	maxDuration time.Duration
+	maxStopped  time.Duration
+}
+
+// newJobTimeouts compiles a lib.JobTimeouts. Empty fields of timeouts are
+// taken from defaults, which may be nil.
+func newJobTimeouts(timeouts lib.JobTimeouts, defaults *jobTimeouts) (*jobTimeouts, error) {
+	var t jobTimeouts
+	if defaults != nil {
+		t = *defaults
+	}
+
+	if timeouts.MaxDuration != "" {
+		d, err := time.ParseDuration(timeouts.MaxDuration)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse max job duration: %s", err)
+		}
+		t.maxDuration = d
+	}
+	if timeouts.MaxStopped != "" {
+		d, err := time.ParseDuration(timeouts.MaxStopped)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse max job stopped time: %s", err)
+		}
+		t.maxStopped = d
+	}
+
+	return &t, nil
+}
+
+// getJobTimeouts gets the job timeouts of a printer.
+func (pm *PrinterManager) getJobTimeouts(nativePrinterName string) *jobTimeouts {
+	if timeouts, exists := pm.printerJobTimeouts[nativePrinterName]; exists {
+		return timeouts
+	}
+	if pm.jobTimeouts != nil {
+		return pm.jobTimeouts
+	}
+	return &jobTimeouts{}
+}
+
+// jobWatchdog fires when a native job exceeds its timeouts.
+type jobWatchdog struct {
+	timeouts *jobTimeouts
+	duration *time.Timer
+	stopped  *time.Timer
+}
+
+// newJobWatchdog starts watching a job that started at start.
+func newJobWatchdog(timeouts *jobTimeouts, start time.Time) *jobWatchdog {
+	w := jobWatchdog{timeouts: timeouts}
+	if timeouts.maxDuration > 0 {
+		w.duration = time.NewTimer(start.Add(timeouts.maxDuration).Sub(time.Now()))
+	}
+	return &w
+}
+
+// setStopped starts timing the job as STOPPED, or stops timing it.
+func (w *jobWatchdog) setStopped(stopped bool) {
+	if w.timeouts.maxStopped <= 0 {
+		return
+	}
+	if stopped && w.stopped == nil {
+		w.stopped = time.NewTimer(w.timeouts.maxStopped)
+	} else if !stopped && w.stopped != nil {
+		w.stopped.Stop()
+		w.stopped = nil
+	}
+}
+
+// durationExpired receives when the job has taken too long.
+func (w *jobWatchdog) durationExpired() <-chan time.Time {
+	if w.duration == nil {
+		return nil
+	}
+	return w.duration.C
+}
+
+// stoppedExpired receives when the job has been STOPPED for too long.
+func (w *jobWatchdog) stoppedExpired() <-chan time.Time {
+	if w.stopped == nil {
+		return nil
+	}
+	return w.stopped.C
+}
+
+func (w *jobWatchdog) stop() {
+	if w.duration != nil {
+		w.duration.Stop()
+	}
+	w.setStopped(false)
+}
+
+// timeOutJob cancels a native job that exceeded a timeout, and returns the
+// final state of the job.
+func (pm *PrinterManager) timeOutJob(jobID, printerName string, nativeJobID uint32, state cdd.PrintJobStateDiff, reason string) *cdd.PrintJobStateDiff {
+	log.ErrorJobf(jobID, "Native job %d %s; canceling it", nativeJobID, reason)
+	pm.incrementJobsTimedOut()
+	if err := pm.native.CancelJob(printerName, nativeJobID); err != nil {
+		log.WarningJobf(jobID, "Failed to cancel native job %d: %s", nativeJobID, err)
+	}
+
+	return &cdd.PrintJobStateDiff{
+		State: &cdd.JobState{
+			Type:              cdd.JobStateAborted,
+			DeviceActionCause: &cdd.DeviceActionCause{ErrorCode: cdd.DeviceActionCausePrintFailure},
+		},
+		PagesPrinted: state.PagesPrinted,
+	}
+}
+
+func (pm *PrinterManager) incrementJobsTimedOut() {
+	pm.jobStatsMutex.Lock()
+	defer pm.jobStatsMutex.Unlock()
+
+	pm.jobsTimedOut += 1
+}
+
+// GetJobsTimedOut returns the quantity of native jobs that were canceled
+// because they exceeded their timeouts.
+func (pm *PrinterManager) GetJobsTimedOut() uint {
+	pm.jobStatsMutex.Lock()
+	defer pm.jobStatsMutex.Unlock()
+
+	return pm.jobsTimedOut
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/nativetest"
+)
+
+func TestNewJobTimeouts(t *testing.T) {
+	defaults, err := newJobTimeouts(lib.JobTimeouts{MaxDuration: "2h", MaxStopped: "30m"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeouts, err := newJobTimeouts(lib.JobTimeouts{MaxStopped: "5m"}, defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timeouts.maxDuration != 2*time.Hour || timeouts.maxStopped != 5*time.Minute {
+		t.Logf("expected 2h and 5m, got %s and %s", timeouts.maxDuration, timeouts.maxStopped)
+		t.Fail()
+	}
+
+	if _, err = newJobTimeouts(lib.JobTimeouts{MaxDuration: "forever"}, nil); err == nil {
+		t.Log("expected error from bad max duration")
+		t.Fail()
+	}
+}
+
+func TestPrintJobTimeouts(t *testing.T) {
+	cases := []struct {
+		name     string
+		timeouts lib.JobTimeouts
+		state    cdd.JobStateType
+	}{
+		{"stopped", lib.JobTimeouts{MaxStopped: "50ms"}, cdd.JobStateStopped},
+		{"duration", lib.JobTimeouts{MaxDuration: "50ms"}, cdd.JobStateInProgress},
+	}
+
+	for _, c := range cases {
+		native := nativetest.New()
+		native.AddPrinter(newTestPrinter("a"))
+		pm := newTestPrinterManager(t, native, nil)
+		timeouts, err := newJobTimeouts(c.timeouts, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pm.printerJobTimeouts = map[string]*jobTimeouts{"a": timeouts}
+		if err = pm.SyncPrinters(true); err != nil {
+			t.Fatal(err)
+		}
+
+		var mutex sync.Mutex
+		var last cdd.PrintJobStateDiff
+		job := newTestJob(t, c.name, "a", nil)
+		job.UpdateJob = func(jobID string, state *cdd.PrintJobStateDiff) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			last = *state
+			return nil
+		}
+
+		finished := make(chan struct{})
+		go func() {
+			pm.printJob(job)
+			close(finished)
+		}()
+		waitFor(t, "native job", func() bool { return len(native.Jobs()) == 1 })
+		native.SetJobState(native.Jobs()[0].ID, cdd.PrintJobStateDiff{State: &cdd.JobState{Type: c.state}})
+
+		select {
+		case <-finished:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: timed out waiting for the watchdog", c.name)
+		}
+
+		mutex.Lock()
+		if last.State.Type != cdd.JobStateAborted || last.State.DeviceActionCause == nil ||
+			last.State.DeviceActionCause.ErrorCode != cdd.DeviceActionCausePrintFailure {
+			t.Logf("%s: expected job ABORTED by print failure, got %+v", c.name, last.State)
+			t.Fail()
+		}
+		mutex.Unlock()
+		if nativeState := native.Jobs()[0].State.State; nativeState.Type != cdd.JobStateAborted {
+			t.Logf("%s: expected native job to be canceled, got %s", c.name, nativeState.Type)
+			t.Fail()
+		}
+		if timedOut := pm.GetJobsTimedOut(); timedOut != 1 {
+			t.Logf("%s: expected 1 job timed out, got %d", c.name, timedOut)
+			t.Fail()
+		}
+	}
+}
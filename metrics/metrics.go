@@ -0,0 +1,356 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+// Package metrics counts what the connector does, and serves the counts in
+// the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/cloud-print-connector/log"
+)
+
+// namePrefix is prepended to the names of all metrics.
+const namePrefix = "cloud_print_connector_"
+
+// LatencyBuckets are histogram buckets, in seconds, for things that take
+// from milliseconds to an hour.
+var LatencyBuckets = []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 300, 900, 3600}
+
+// collector is a metric, possibly with several label values.
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+var (
+	registryMutex sync.Mutex
+	registry      = make(map[string]collector)
+)
+
+func register(name string, c collector) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("Metric %s registered twice", name))
+	}
+	registry[name] = c
+}
+
+// WriteText writes all metrics in the Prometheus text format, sorted by name.
+func WriteText(w io.Writer) error {
+	registryMutex.Lock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	collectors := make(map[string]collector, len(registry))
+	for name, c := range registry {
+		collectors[name] = c
+	}
+	registryMutex.Unlock()
+	sort.Strings(names)
+
+	b := bufio.NewWriter(w)
+	for _, name := range names {
+		collectors[name].write(b)
+	}
+	return b.Flush()
+}
+
+// Handler serves the metrics.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := WriteText(w); err != nil {
+			log.Warningf("Failed to write metrics: %s", err)
+		}
+	})
+}
+
+// Serve listens on address, eg localhost:9100, and serves the metrics at
+// /metrics until the process exits.
+func Serve(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("Failed to listen for metrics requests on %s: %s", address, err)
+	}
+
+	sm := http.NewServeMux()
+	sm.Handle("/metrics", Handler())
+	go func() {
+		if err := http.Serve(listener, sm); err != nil {
+			log.Errorf("Metrics HTTP server failed: %s", err)
+		}
+	}()
+	return nil
+}
+
+// labelSeparator joins label values into map keys. It can't be in valid
+// UTF-8 strings.
+const labelSeparator = "\xff"
+
+// family holds the values of a metric, one per combination of label values.
+type family struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+
+	mutex  sync.Mutex
+	values map[string]interface{}
+}
+
+func newFamily(name, help, kind string, labelNames []string) *family {
+	return &family{
+		name:       namePrefix + name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		values:     make(map[string]interface{}),
+	}
+}
+
+// get gets the value of labelValues, creating it with newValue if needed.
+// The family must be locked.
+func (f *family) get(labelValues []string, newValue func() interface{}) interface{} {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("Metric %s has %d labels, got %d values", f.name, len(f.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, labelSeparator)
+	v, exists := f.values[key]
+	if !exists {
+		v = newValue()
+		f.values[key] = v
+	}
+	return v
+}
+
+// sortedKeys returns the label value keys, sorted. The family must be
+// locked.
+func (f *family) sortedKeys() []string {
+	keys := make([]string, 0, len(f.values))
+	for key := range f.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *family) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+}
+
+// writeSample writes one line, eg name{label="value"} 1.
+func (f *family) writeSample(w *bufio.Writer, suffix, key string, extraName, extraValue string, value float64) {
+	w.WriteString(f.name)
+	w.WriteString(suffix)
+
+	var pairs []string
+	if len(f.labelNames) > 0 {
+		for i, v := range strings.Split(key, labelSeparator) {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, f.labelNames[i], escapeLabelValue(v)))
+		}
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraName, extraValue))
+	}
+	if len(pairs) > 0 {
+		w.WriteString("{")
+		w.WriteString(strings.Join(pairs, ","))
+		w.WriteString("}")
+	}
+
+	w.WriteString(" ")
+	w.WriteString(formatFloat(value))
+	w.WriteString("\n")
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	f *family
+}
+
+// NewCounter registers a counter, with a value for each combination of the
+// values of labelNames.
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	c := &Counter{newFamily(name, help, "counter", labelNames)}
+	register(c.f.name, c)
+	return c
+}
+
+// Inc adds one to the counter of labelValues.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the counter of labelValues.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	c.f.mutex.Lock()
+	defer c.f.mutex.Unlock()
+
+	value := c.f.get(labelValues, func() interface{} { return new(float64) }).(*float64)
+	*value += v
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.f.mutex.Lock()
+	defer c.f.mutex.Unlock()
+
+	c.f.writeHeader(w)
+	for _, key := range c.f.sortedKeys() {
+		c.f.writeSample(w, "", key, "", "", *c.f.values[key].(*float64))
+	}
+}
+
+// Gauge is a value that goes up and down.
+type Gauge struct {
+	f *family
+}
+
+// NewGauge registers a gauge, with a value for each combination of the
+// values of labelNames.
+func NewGauge(name, help string, labelNames ...string) *Gauge {
+	g := &Gauge{newFamily(name, help, "gauge", labelNames)}
+	register(g.f.name, g)
+	return g
+}
+
+// Set sets the gauge of labelValues to v.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.f.mutex.Lock()
+	defer g.f.mutex.Unlock()
+
+	value := g.f.get(labelValues, func() interface{} { return new(float64) }).(*float64)
+	*value = v
+}
+
+// SetBool sets the gauge of labelValues to 1 if b is true, and 0 otherwise.
+func (g *Gauge) SetBool(b bool, labelValues ...string) {
+	if b {
+		g.Set(1, labelValues...)
+	} else {
+		g.Set(0, labelValues...)
+	}
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.f.mutex.Lock()
+	defer g.f.mutex.Unlock()
+
+	g.f.writeHeader(w)
+	for _, key := range g.f.sortedKeys() {
+		g.f.writeSample(w, "", key, "", "", *g.f.values[key].(*float64))
+	}
+}
+
+// gaugeFunc is a gauge whose value is read when the metrics are written.
+type gaugeFunc struct {
+	f     *family
+	value func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is value().
+func NewGaugeFunc(name, help string, value func() float64) {
+	g := &gaugeFunc{newFamily(name, help, "gauge", nil), value}
+	register(g.f.name, g)
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	g.f.writeHeader(w)
+	g.f.writeSample(w, "", "", "", "", g.value())
+}
+
+// Histogram counts observations, eg latencies, in buckets.
+type Histogram struct {
+	f       *family
+	buckets []float64
+}
+
+type histogramValue struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram registers a histogram with upper bounds buckets, with a
+// histogram for each combination of the values of labelNames.
+func NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	h := &Histogram{newFamily(name, help, "histogram", labelNames), buckets}
+	register(h.f.name, h)
+	return h
+}
+
+// Observe adds v to the histogram of labelValues.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.f.mutex.Lock()
+	defer h.f.mutex.Unlock()
+
+	value := h.f.get(labelValues, func() interface{} {
+		return &histogramValue{counts: make([]uint64, len(h.buckets))}
+	}).(*histogramValue)
+	for i, upper := range h.buckets {
+		if v <= upper {
+			value.counts[i]++
+		}
+	}
+	value.count++
+	value.sum += v
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.f.mutex.Lock()
+	defer h.f.mutex.Unlock()
+
+	h.f.writeHeader(w)
+	for _, key := range h.f.sortedKeys() {
+		value := h.f.values[key].(*histogramValue)
+		for i, upper := range h.buckets {
+			h.f.writeSample(w, "_bucket", key, "le", formatFloat(upper), float64(value.counts[i]))
+		}
+		h.f.writeSample(w, "_bucket", key, "le", "+Inf", float64(value.count))
+		h.f.writeSample(w, "_sum", key, "", "", value.sum)
+		h.f.writeSample(w, "_count", key, "", "", float64(value.count))
+	}
+}
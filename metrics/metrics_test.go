@@ -0,0 +1,67 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	c := NewCounter("test_requests_total", "Test requests.", "endpoint")
+	c.Inc("info")
+	c.Add(2, `say "hi"`)
+	g := NewGauge("test_up", "Whether the test is up.")
+	g.SetBool(true)
+	NewGaugeFunc("test_answer", "The answer.", func() float64 { return 42 })
+	h := NewHistogram("test_seconds", "Test latency.", []float64{.1, 1})
+	h.Observe(.05)
+	h.Observe(.5)
+	h.Observe(5)
+
+	var b bytes.Buffer
+	if err := WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP cloud_print_connector_test_answer The answer.
+# TYPE cloud_print_connector_test_answer gauge
+cloud_print_connector_test_answer 42
+# HELP cloud_print_connector_test_requests_total Test requests.
+# TYPE cloud_print_connector_test_requests_total counter
+cloud_print_connector_test_requests_total{endpoint="info"} 1
+cloud_print_connector_test_requests_total{endpoint="say \"hi\""} 2
+# HELP cloud_print_connector_test_seconds Test latency.
+# TYPE cloud_print_connector_test_seconds histogram
+cloud_print_connector_test_seconds_bucket{le="0.1"} 1
+cloud_print_connector_test_seconds_bucket{le="1"} 2
+cloud_print_connector_test_seconds_bucket{le="+Inf"} 3
+cloud_print_connector_test_seconds_sum 5.55
+cloud_print_connector_test_seconds_count 3
+# HELP cloud_print_connector_test_up Whether the test is up.
+# TYPE cloud_print_connector_test_up gauge
+cloud_print_connector_test_up 1
+`
+	if b.String() != expected {
+		t.Logf("expected\n%s\ngot\n%s", expected, b.String())
+		t.Fail()
+	}
+}
+
+func TestLabelValuesMismatch(t *testing.T) {
+	c := NewCounter("test_mismatch_total", "Test mismatch.", "a", "b")
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(r.(string), "test_mismatch_total") {
+			t.Logf("expected panic about test_mismatch_total, got %v", r)
+			t.Fail()
+		}
+	}()
+	c.Inc("only a")
+}
@@ -9,10 +9,14 @@
 package monitor
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
+	"time"
 
-	"github.com/google/cloud-print-connector/cups"
 	"github.com/google/cloud-print-connector/gcp"
 	"github.com/google/cloud-print-connector/lib"
 	"github.com/google/cloud-print-connector/log"
@@ -20,27 +24,32 @@ import (
 	"github.com/google/cloud-print-connector/privet"
 )
 
-const monitorFormat = `cups-printers=%d
-cups-raw-printers=%d
-gcp-printers=%d
-local-printers=%d
-cups-conn-qty=%d
-cups-conn-max-qty=%d
-jobs-done=%d
-jobs-error=%d
-jobs-in-progress=%d
-`
+// legacyRequestTimeout is how long to wait for a JSON request before
+// answering with the stats in text, for clients that only read.
+const legacyRequestTimeout = time.Second
+
+// NativePrintSystem is the native print system that the monitor reports
+// on. Native print systems that keep connections open may also report them
+// with ConnQtyOpen and ConnQtyMax.
+type NativePrintSystem interface {
+	GetPrinters() ([]lib.Printer, error)
+}
+
+type connCounter interface {
+	ConnQtyOpen() uint
+	ConnQtyMax() uint
+}
 
 type Monitor struct {
-	cups         *cups.CUPS
-	gcp          *gcp.GoogleCloudPrint
+	native       NativePrintSystem
+	gcp          gcp.CloudPrint
 	p            *privet.Privet
 	pm           *manager.PrinterManager
 	listenerQuit chan bool
 }
 
-func NewMonitor(cups *cups.CUPS, gcp *gcp.GoogleCloudPrint, p *privet.Privet, pm *manager.PrinterManager, socketFilename string) (*Monitor, error) {
-	m := Monitor{cups, gcp, p, pm, make(chan bool)}
+func NewMonitor(native NativePrintSystem, gcp gcp.CloudPrint, p *privet.Privet, pm *manager.PrinterManager, socketFilename string) (*Monitor, error) {
+	m := Monitor{native, gcp, p, pm, make(chan bool)}
 
 	listener, err := net.ListenUnix("unix", &net.UnixAddr{socketFilename, "unix"})
 	if err != nil {
@@ -76,15 +85,9 @@ func (m *Monitor) listen(listener net.Listener) {
 	for {
 		select {
 		case conn := <-ch:
-			log.Info("Received monitor request")
-			stats, err := m.getStats()
-			if err != nil {
-				log.Warningf("Monitor request failed: %s", err)
-				conn.Write([]byte("error"))
-			} else {
-				conn.Write([]byte(stats))
-			}
-			conn.Close()
+			// Commands like sync-now take a while; don't make other
+			// requests wait.
+			go m.handle(conn)
 
 		case <-m.listenerQuit:
 			quitReq <- true
@@ -101,23 +104,132 @@ func (m *Monitor) Quit() {
 	<-m.listenerQuit
 }
 
-func (m *Monitor) getStats() (string, error) {
+// handle answers one request. A client that sends a line of JSON gets a
+// line of JSON back. A client that sends nothing gets the stats in the
+// text format of lib.MonitorStats.String.
+func (m *Monitor) handle(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(legacyRequestTimeout))
+	line, _ := bufio.NewReader(conn).ReadBytes('\n')
+	conn.SetReadDeadline(time.Time{})
+
+	if len(bytes.TrimSpace(line)) == 0 {
+		log.Info("Received monitor request")
+		stats, err := m.getStats()
+		if err != nil {
+			log.Warningf("Monitor request failed: %s", err)
+			conn.Write([]byte("error"))
+			return
+		}
+		conn.Write([]byte(stats.String()))
+		return
+	}
+
+	response := lib.MonitorResponse{Version: lib.MonitorProtocolVersion}
+	var request lib.MonitorRequest
+	if err := json.Unmarshal(line, &request); err != nil {
+		response.Error = fmt.Sprintf("Failed to parse monitor request: %s", err)
+	} else if request.Version != lib.MonitorProtocolVersion {
+		response.Error = fmt.Sprintf("Monitor protocol version %d is not supported; use version %d",
+			request.Version, lib.MonitorProtocolVersion)
+	} else {
+		log.Infof("Received monitor command %s", request.Command)
+		result, err := m.execute(&request)
+		if err == nil && result != nil {
+			response.Result, err = json.Marshal(result)
+		}
+		if err != nil {
+			response.Error = err.Error()
+		}
+	}
+	if response.Error != "" {
+		log.Warningf("Monitor request failed: %s", response.Error)
+	}
+
+	b, err := json.Marshal(&response)
+	if err != nil {
+		log.Warningf("Failed to write monitor response: %s", err)
+		return
+	}
+	conn.Write(append(b, '\n'))
+}
+
+// execute executes a command, and returns its result, which may be nil.
+func (m *Monitor) execute(request *lib.MonitorRequest) (interface{}, error) {
+	switch request.Command {
+	case lib.MonitorCommandStats:
+		return m.getStats()
+
+	case lib.MonitorCommandListPrinters:
+		paused := make(map[string]struct{})
+		for _, name := range m.pm.GetPausedPrinters() {
+			paused[name] = struct{}{}
+		}
+		var printers []lib.MonitorPrinter
+		for _, p := range m.pm.GetPrinters() {
+			printer := lib.MonitorPrinter{Name: p.Name, GCPID: p.GCPID}
+			if p.State != nil {
+				printer.State = string(p.State.State)
+			}
+			_, printer.Paused = paused[p.Name]
+			printers = append(printers, printer)
+		}
+		return printers, nil
+
+	case lib.MonitorCommandListJobs:
+		return m.pm.GetJobsInFlight(), nil
+
+	case lib.MonitorCommandSyncNow:
+		return nil, m.pm.SyncPrinters(false)
+
+	case lib.MonitorCommandPausePrinter, lib.MonitorCommandResumePrinter:
+		if request.Printer == "" {
+			return nil, errors.New("No printer was given")
+		}
+		if request.Command == lib.MonitorCommandPausePrinter {
+			return nil, m.pm.PausePrinter(request.Printer)
+		}
+		return nil, m.pm.ResumePrinter(request.Printer)
+
+	case lib.MonitorCommandCancelJob:
+		if request.JobID == "" {
+			return nil, errors.New("No job ID was given")
+		}
+		return nil, m.pm.CancelJob(request.JobID)
+
+	case lib.MonitorCommandSetLogLevel:
+		level, ok := log.LevelFromString(request.LogLevel)
+		if !ok {
+			return nil, fmt.Errorf("Log level %q is not valid", request.LogLevel)
+		}
+		log.SetLevel(level)
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("Monitor command %q is not known", request.Command)
+}
+
+func (m *Monitor) getStats() (*lib.MonitorStats, error) {
 	var cupsPrinterQuantity, rawPrinterQuantity, gcpPrinterQuantity, privetPrinterQuantity int
 
-	if cupsPrinters, err := m.cups.GetPrinters(); err != nil {
-		return "", err
+	if cupsPrinters, err := m.native.GetPrinters(); err != nil {
+		return nil, err
 	} else {
 		cupsPrinterQuantity = len(cupsPrinters)
 		_, rawPrinters := lib.FilterRawPrinters(cupsPrinters)
 		rawPrinterQuantity = len(rawPrinters)
 	}
 
-	cupsConnOpen := m.cups.ConnQtyOpen()
-	cupsConnMax := m.cups.ConnQtyMax()
+	var cupsConnOpen, cupsConnMax uint
+	if cc, ok := m.native.(connCounter); ok {
+		cupsConnOpen = cc.ConnQtyOpen()
+		cupsConnMax = cc.ConnQtyMax()
+	}
 
 	if m.gcp != nil {
 		if gcpPrinters, err := m.gcp.List(); err != nil {
-			return "", err
+			return nil, err
 		} else {
 			gcpPrinterQuantity = len(gcpPrinters)
 		}
@@ -129,14 +241,27 @@ func (m *Monitor) getStats() (string, error) {
 
 	jobsDone, jobsError, jobsProcessing, err := m.pm.GetJobStats()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	stats := fmt.Sprintf(
-		monitorFormat,
-		cupsPrinterQuantity, rawPrinterQuantity, gcpPrinterQuantity, privetPrinterQuantity,
-		cupsConnOpen, cupsConnMax,
-		jobsDone, jobsError, jobsProcessing)
+	jobsTimedOut := m.pm.GetJobsTimedOut()
+	syncDiffs, syncDiffsDone, syncDiffsFailed := m.pm.GetSyncStats()
+
+	stats := lib.MonitorStats{
+		CUPSPrinters:           cupsPrinterQuantity,
+		CUPSRawPrinters:        rawPrinterQuantity,
+		GCPPrinters:            gcpPrinterQuantity,
+		LocalPrinters:          privetPrinterQuantity,
+		CUPSConnQty:            cupsConnOpen,
+		CUPSConnMaxQty:         cupsConnMax,
+		JobsDone:               jobsDone,
+		JobsError:              jobsError,
+		JobsInProgress:         jobsProcessing,
+		JobsTimedOut:           jobsTimedOut,
+		PrinterSyncDiffs:       syncDiffs,
+		PrinterSyncDiffsDone:   syncDiffsDone,
+		PrinterSyncDiffsFailed: syncDiffsFailed,
+	}
 
-	return stats, nil
+	return &stats, nil
 }
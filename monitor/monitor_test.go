@@ -0,0 +1,229 @@
+// Copyright 2016 Google Inc. All rights reserved.
+
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+// +build linux darwin freebsd
+
+package monitor
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+	"github.com/google/cloud-print-connector/manager"
+	"github.com/google/cloud-print-connector/nativetest"
+)
+
+// newTestMonitor starts a monitor of a connector with one printer, a, and
+// returns the name of its socket. The returned function stops the monitor.
+func newTestMonitor(t *testing.T) (string, func()) {
+	log.SetLevel(log.ERROR)
+
+	dir, err := ioutil.TempDir("", "cloud-print-connector-monitor-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	native := nativetest.New()
+	native.AddPrinter(lib.Printer{
+		Name:               "a",
+		DefaultDisplayName: "a",
+		State:              &cdd.PrinterStateSection{State: cdd.CloudDeviceStateIdle},
+		Description:        &cdd.PrinterDescriptionSection{},
+		Tags:               map[string]string{"printer-name": "a"},
+	})
+	pm, err := manager.NewPrinterManager(native, nil, nil, nil, nil, manager.PrinterManagerConfig{
+		PrinterPollInterval: time.Hour,
+		NativeJobQueueSize:  3,
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	socketFilename := filepath.Join(dir, "monitor.sock")
+	m, err := NewMonitor(native, nil, nil, pm, socketFilename)
+	if err != nil {
+		pm.Quit()
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return socketFilename, func() {
+		m.Quit()
+		pm.Quit()
+		os.RemoveAll(dir)
+	}
+}
+
+// sendRequest sends request, which may be empty, to the monitor socket,
+// then returns the response.
+func sendRequest(t *testing.T, socketFilename string, request []byte) []byte {
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: socketFilename, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if len(request) > 0 {
+		if _, err = conn.Write(request); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.CloseWrite()
+
+	response, err := ioutil.ReadAll(bufio.NewReader(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestMonitorLegacyRequest(t *testing.T) {
+	socketFilename, stop := newTestMonitor(t)
+	defer stop()
+
+	expected := (&lib.MonitorStats{CUPSPrinters: 1, PrinterSyncDiffs: 1, PrinterSyncDiffsDone: 1}).String()
+	if response := string(sendRequest(t, socketFilename, nil)); response != expected {
+		t.Logf("expected stats\n%s\ngot\n%s", expected, response)
+		t.Fail()
+	}
+}
+
+func TestMonitorCommands(t *testing.T) {
+	socketFilename, stop := newTestMonitor(t)
+	defer stop()
+
+	cases := []struct {
+		name    string
+		request lib.MonitorRequest
+		// result is decoded into a value of the same type, and compared.
+		result interface{}
+		err    bool
+	}{
+		{
+			name:    "stats",
+			request: lib.MonitorRequest{Command: lib.MonitorCommandStats},
+			result:  &lib.MonitorStats{CUPSPrinters: 1, PrinterSyncDiffs: 1, PrinterSyncDiffsDone: 1},
+		},
+		{
+			name:    "list printers",
+			request: lib.MonitorRequest{Command: lib.MonitorCommandListPrinters},
+			result:  &[]lib.MonitorPrinter{{Name: "a", State: string(cdd.CloudDeviceStateIdle)}},
+		},
+		{
+			name:    "list jobs",
+			request: lib.MonitorRequest{Command: lib.MonitorCommandListJobs},
+			result:  &[]lib.MonitorJob{},
+		},
+		{
+			name:    "sync now",
+			request: lib.MonitorRequest{Command: lib.MonitorCommandSyncNow},
+		},
+		{
+			name:    "pause printer",
+			request: lib.MonitorRequest{Command: lib.MonitorCommandPausePrinter, Printer: "a"},
+		},
+		{
+			name:    "list paused printers",
+			request: lib.MonitorRequest{Command: lib.MonitorCommandListPrinters},
+			result:  &[]lib.MonitorPrinter{{Name: "a", State: string(cdd.CloudDeviceStateStopped), Paused: true}},
+		},
+		{
+			name:    "resume printer",
+			request: lib.MonitorRequest{Command: lib.MonitorCommandResumePrinter, Printer: "a"},
+		},
+		{
+			name:    "list resumed printers",
+			request: lib.MonitorRequest{Command: lib.MonitorCommandListPrinters},
+			result:  &[]lib.MonitorPrinter{{Name: "a", State: string(cdd.CloudDeviceStateIdle)}},
+		},
+		{
+			name:    "pause without printer",
+			request: lib.MonitorRequest{Command: lib.MonitorCommandPausePrinter},
+			err:     true,
+		},
+		{
+			name:    "cancel unknown job",
+			request: lib.MonitorRequest{Command: lib.MonitorCommandCancelJob, JobID: "job"},
+			err:     true,
+		},
+		{
+			name:    "cancel without job",
+			request: lib.MonitorRequest{Command: lib.MonitorCommandCancelJob},
+			err:     true,
+		},
+		{
+			name:    "set log level",
+			request: lib.MonitorRequest{Command: lib.MonitorCommandSetLogLevel, LogLevel: "ERROR"},
+		},
+		{
+			name:    "set bad log level",
+			request: lib.MonitorRequest{Command: lib.MonitorCommandSetLogLevel, LogLevel: "LOUD"},
+			err:     true,
+		},
+		{
+			name:    "unknown command",
+			request: lib.MonitorRequest{Command: "self-destruct"},
+			err:     true,
+		},
+		{
+			name:    "unsupported version",
+			request: lib.MonitorRequest{Version: lib.MonitorProtocolVersion + 1, Command: lib.MonitorCommandStats},
+			err:     true,
+		},
+	}
+
+	for _, c := range cases {
+		if c.request.Version == 0 {
+			c.request.Version = lib.MonitorProtocolVersion
+		}
+		b, err := json.Marshal(&c.request)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var response lib.MonitorResponse
+		if err = json.Unmarshal(sendRequest(t, socketFilename, append(b, '\n')), &response); err != nil {
+			t.Logf("%s: failed to parse response: %s", c.name, err)
+			t.Fail()
+			continue
+		}
+		if response.Version != lib.MonitorProtocolVersion || (response.Error != "") != c.err {
+			t.Logf("%s: expected error %t, got %+v", c.name, c.err, response)
+			t.Fail()
+			continue
+		}
+		if c.result == nil {
+			continue
+		}
+		result := reflect.New(reflect.TypeOf(c.result).Elem()).Interface()
+		if err = json.Unmarshal(response.Result, result); err != nil {
+			t.Logf("%s: failed to parse result %s: %s", c.name, response.Result, err)
+			t.Fail()
+			continue
+		}
+		expected, got := reflect.ValueOf(c.result).Elem(), reflect.ValueOf(result).Elem()
+		if expected.Kind() == reflect.Slice && expected.Len() == 0 && got.Len() == 0 {
+			// An empty list may be null.
+			continue
+		}
+		if !reflect.DeepEqual(c.result, result) {
+			t.Logf("%s: expected result %+v, got %+v", c.name, expected.Interface(), got.Interface())
+			t.Fail()
+		}
+	}
+}
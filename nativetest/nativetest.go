@@ -0,0 +1,379 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+// Package nativetest is an in-memory native print system, for testing code
+// that uses a native print system, like the manager package.
+//
+// Tests script the fake: they add and remove printers, change their
+// capabilities, and drive jobs through their states.
+package nativetest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+// Job is a job that was printed to the fake.
+type Job struct {
+	ID          uint32
+	PrinterName string
+	Title       string
+	User        string
+	GCPJobID    string
+	Ticket      *cdd.CloudJobTicket
+	Document    []byte
+	// Held is true when the job was printed with lib.Printer.HoldJobs.
+	Held bool
+	// ReleasedHeld is true when the held job was released with
+	// ReleaseHeldJob.
+	ReleasedHeld bool
+	Released     bool
+	Canceled     bool
+	State        cdd.PrintJobStateDiff
+}
+
+// NativePrintSystem is an in-memory native print system. The zero value is
+// not usable; use New.
+type NativePrintSystem struct {
+	mutex     sync.Mutex
+	printers  map[string]lib.Printer
+	jobs      map[uint32]*Job
+	lastJobID uint32
+	// printErrors are returned by the next Print to each printer.
+	printErrors map[string]error
+	// cancelErrors are returned by the next CancelJob on each printer.
+	cancelErrors map[string]error
+	// getPrintersError is returned by the next GetPrinters.
+	getPrintersError error
+	// resume is closed to let paused Print calls return.
+	resume chan struct{}
+	// subscribers receive job state changes. Key is job ID.
+	subscribers map[uint32]chan *cdd.PrintJobStateDiff
+	removedPPDs []string
+}
+
+// New makes a NativePrintSystem without printers.
+func New() *NativePrintSystem {
+	return &NativePrintSystem{
+		printers:     make(map[string]lib.Printer),
+		jobs:         make(map[uint32]*Job),
+		printErrors:  make(map[string]error),
+		cancelErrors: make(map[string]error),
+		subscribers:  make(map[uint32]chan *cdd.PrintJobStateDiff),
+	}
+}
+
+// AddPrinter adds a printer, or replaces the printer with the same name.
+func (n *NativePrintSystem) AddPrinter(printer lib.Printer) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.printers[printer.Name] = printer
+}
+
+// RemovePrinter removes a printer. Its jobs are kept.
+func (n *NativePrintSystem) RemovePrinter(printerName string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	delete(n.printers, printerName)
+}
+
+// SetDescription changes the capabilities of a printer.
+func (n *NativePrintSystem) SetDescription(printerName string, description *cdd.PrinterDescriptionSection) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	printer, exists := n.printers[printerName]
+	if !exists {
+		return fmt.Errorf("Printer %s does not exist", printerName)
+	}
+	printer.Description = description
+	n.printers[printerName] = printer
+	return nil
+}
+
+// FailPrint makes the next Print to a printer return err.
+func (n *NativePrintSystem) FailPrint(printerName string, err error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.printErrors[printerName] = err
+}
+
+// FailCancel makes the next CancelJob on a printer return err.
+func (n *NativePrintSystem) FailCancel(printerName string, err error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.cancelErrors[printerName] = err
+}
+
+// FailGetPrinters makes the next GetPrinters return err.
+func (n *NativePrintSystem) FailGetPrinters(err error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.getPrintersError = err
+}
+
+// PausePrinting makes Print calls wait, holding the job semaphore of their
+// printer, until ResumePrinting is called.
+func (n *NativePrintSystem) PausePrinting() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.resume == nil {
+		n.resume = make(chan struct{})
+	}
+}
+
+// ResumePrinting lets paused Print calls return.
+func (n *NativePrintSystem) ResumePrinting() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.resume != nil {
+		close(n.resume)
+		n.resume = nil
+	}
+}
+
+// SetJobState changes the state of a job, as if the printer had made
+// progress. Subscribers to the job receive the new state.
+func (n *NativePrintSystem) SetJobState(jobID uint32, state cdd.PrintJobStateDiff) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	job, exists := n.jobs[jobID]
+	if !exists {
+		return fmt.Errorf("Job %d does not exist", jobID)
+	}
+	job.State = state
+	if ch, exists := n.subscribers[jobID]; exists {
+		s := state
+		select {
+		case ch <- &s:
+		default:
+			// The subscriber is behind; it will poll eventually.
+		}
+	}
+	return nil
+}
+
+// Job gets a copy of a job.
+func (n *NativePrintSystem) Job(jobID uint32) (Job, bool) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if job, exists := n.jobs[jobID]; exists {
+		return *job, true
+	}
+	return Job{}, false
+}
+
+// Jobs gets copies of all jobs, in the order they were printed.
+func (n *NativePrintSystem) Jobs() []Job {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	jobs := make([]Job, 0, len(n.jobs))
+	for _, job := range n.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	return jobs
+}
+
+// RemovedPPDs gets the names of the printers whose cached PPDs were removed.
+func (n *NativePrintSystem) RemovedPPDs() []string {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return append([]string{}, n.removedPPDs...)
+}
+
+// GetPrinters gets copies of all printers, sorted by name.
+func (n *NativePrintSystem) GetPrinters() ([]lib.Printer, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if err := n.getPrintersError; err != nil {
+		n.getPrintersError = nil
+		return nil, err
+	}
+
+	printers := make([]lib.Printer, 0, len(n.printers))
+	for _, printer := range n.printers {
+		// The manager adds tags, so each call gets its own map.
+		tags := make(map[string]string, len(printer.Tags))
+		for k, v := range printer.Tags {
+			tags[k] = v
+		}
+		printer.Tags = tags
+		printers = append(printers, printer)
+	}
+	sort.Slice(printers, func(i, j int) bool { return printers[i].Name < printers[j].Name })
+	return printers, nil
+}
+
+// GetJobState gets the current state of a job.
+func (n *NativePrintSystem) GetJobState(printerName string, jobID uint32) (*cdd.PrintJobStateDiff, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	job, exists := n.jobs[jobID]
+	if !exists || job.PrinterName != printerName {
+		return nil, fmt.Errorf("Job %d does not exist on printer %s", jobID, printerName)
+	}
+	state := job.State
+	return &state, nil
+}
+
+// CancelJob cancels a job. The job becomes ABORTED.
+func (n *NativePrintSystem) CancelJob(printerName string, jobID uint32) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	job, exists := n.jobs[jobID]
+	if !exists || job.PrinterName != printerName {
+		return fmt.Errorf("Job %d does not exist on printer %s", jobID, printerName)
+	}
+	if err, exists := n.cancelErrors[printerName]; exists {
+		delete(n.cancelErrors, printerName)
+		return err
+	}
+	job.Canceled = true
+	job.State = cdd.PrintJobStateDiff{
+		State: &cdd.JobState{
+			Type:            cdd.JobStateAborted,
+			UserActionCause: &cdd.UserActionCause{ActionCode: cdd.UserActionCauseCanceled},
+		},
+		PagesPrinted: job.State.PagesPrinted,
+	}
+	return nil
+}
+
+// Print adds a job with the contents of fileName. The job is IN_PROGRESS
+// until changed with SetJobState.
+func (n *NativePrintSystem) Print(printer *lib.Printer, fileName, title, user, gcpJobID string, ticket *cdd.CloudJobTicket) (uint32, error) {
+	if printer.NativeJobSemaphore != nil {
+		printer.NativeJobSemaphore.Acquire()
+		defer printer.NativeJobSemaphore.Release()
+	}
+
+	n.mutex.Lock()
+	resume := n.resume
+	n.mutex.Unlock()
+	if resume != nil {
+		<-resume
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if _, exists := n.printers[printer.Name]; !exists {
+		return 0, fmt.Errorf("Printer %s does not exist", printer.Name)
+	}
+	if err, exists := n.printErrors[printer.Name]; exists {
+		delete(n.printErrors, printer.Name)
+		return 0, err
+	}
+
+	document, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return 0, err
+	}
+
+	n.lastJobID++
+	n.jobs[n.lastJobID] = &Job{
+		ID:          n.lastJobID,
+		PrinterName: printer.Name,
+		Title:       title,
+		User:        user,
+		GCPJobID:    gcpJobID,
+		Ticket:      ticket,
+		Document:    document,
+		Held:        printer.HoldJobs,
+		State: cdd.PrintJobStateDiff{
+			State: &cdd.JobState{Type: cdd.JobStateInProgress},
+		},
+	}
+	return n.lastJobID, nil
+}
+
+// ReleaseJob marks a job as released.
+func (n *NativePrintSystem) ReleaseJob(printerName string, jobID uint32) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if job, exists := n.jobs[jobID]; exists && job.PrinterName == printerName {
+		job.Released = true
+	}
+	return nil
+}
+
+// ReleaseHeldJob marks a held job as released.
+func (n *NativePrintSystem) ReleaseHeldJob(printerName string, jobID uint32) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	job, exists := n.jobs[jobID]
+	if !exists || job.PrinterName != printerName {
+		return fmt.Errorf("Job %d does not exist on printer %s", jobID, printerName)
+	}
+	if job.Held {
+		job.ReleasedHeld = true
+	}
+	return nil
+}
+
+// RemoveCachedPPD records that the cached PPD of a printer was removed.
+func (n *NativePrintSystem) RemoveCachedPPD(printerName string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.removedPPDs = append(n.removedPPDs, printerName)
+}
+
+// SubscribeJobState returns a channel that receives the states set by
+// SetJobState.
+func (n *NativePrintSystem) SubscribeJobState(printerName string, jobID uint32) (<-chan *cdd.PrintJobStateDiff, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	job, exists := n.jobs[jobID]
+	if !exists || job.PrinterName != printerName {
+		return nil, fmt.Errorf("Job %d does not exist on printer %s", jobID, printerName)
+	}
+	ch := make(chan *cdd.PrintJobStateDiff, 16)
+	n.subscribers[jobID] = ch
+	return ch, nil
+}
+
+// Subscribed returns true when states of a job are sent to a subscriber.
+func (n *NativePrintSystem) Subscribed(jobID uint32) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	_, exists := n.subscribers[jobID]
+	return exists
+}
+
+// UnsubscribeJobState stops sending states of a job.
+func (n *NativePrintSystem) UnsubscribeJobState(printerName string, jobID uint32) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	delete(n.subscribers, jobID)
+}
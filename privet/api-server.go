@@ -23,6 +23,7 @@ import (
 	"github.com/google/cloud-print-connector/cdd"
 	"github.com/google/cloud-print-connector/lib"
 	"github.com/google/cloud-print-connector/log"
+	"github.com/google/cloud-print-connector/metrics"
 )
 
 var (
@@ -33,13 +34,18 @@ var (
 		"/privet/printer/createjob",
 		"/privet/printer/submitdoc",
 		"/privet/printer/jobstate",
+		"/privet/printer/canceljob",
+		"/privet/printer/releasejob",
 	}
 	supportedAPIsOffline = []string{
 		"/privet/capabilities",
 		"/privet/printer/createjob",
 		"/privet/printer/submitdoc",
 		"/privet/printer/jobstate",
+		"/privet/printer/canceljob",
+		"/privet/printer/releasejob",
 	}
+	requests = metrics.NewCounter("privet_requests_total", "Privet API requests, by endpoint.", "endpoint")
 )
 
 type privetError struct {
@@ -76,15 +82,18 @@ type privetAPI struct {
 	online     bool
 	jc         *jobCache
 	jobs       chan<- *lib.Job
+	refusing   <-chan struct{}
 
 	getPrinter        func(string) (lib.Printer, bool)
+	cancelJob         func(string) error
+	releaseJobs       func(string, string) (time.Duration, bool)
 	getProximityToken func(string, string) ([]byte, int, error)
 
 	listener  *quittableListener
 	startTime time.Time
 }
 
-func newPrivetAPI(gcpID, name, gcpBaseURL string, xsrf xsrfSecret, online bool, jc *jobCache, jobs chan<- *lib.Job, getPrinter func(string) (lib.Printer, bool), getProximityToken func(string, string) ([]byte, int, error), listener *quittableListener) (*privetAPI, error) {
+func newPrivetAPI(gcpID, name, gcpBaseURL string, xsrf xsrfSecret, online bool, jc *jobCache, jobs chan<- *lib.Job, refusing <-chan struct{}, getPrinter func(string) (lib.Printer, bool), cancelJob func(string) error, releaseJobs func(string, string) (time.Duration, bool), getProximityToken func(string, string) ([]byte, int, error), listener *quittableListener) (*privetAPI, error) {
 	api := &privetAPI{
 		gcpID:      gcpID,
 		name:       name,
@@ -93,8 +102,11 @@ func newPrivetAPI(gcpID, name, gcpBaseURL string, xsrf xsrfSecret, online bool,
 		online:     online,
 		jc:         jc,
 		jobs:       jobs,
+		refusing:   refusing,
 
 		getPrinter:        getPrinter,
+		cancelJob:         cancelJob,
+		releaseJobs:       releaseJobs,
 		getProximityToken: getProximityToken,
 
 		listener:  listener,
@@ -113,6 +125,23 @@ func (api *privetAPI) quit() {
 	api.listener.quit()
 }
 
+// refuseJob writes a printer_busy error and returns true if new jobs are
+// refused because the connector is shutting down.
+func (api *privetAPI) refuseJob(w http.ResponseWriter) bool {
+	select {
+	case <-api.refusing:
+		pe := privetError{
+			Error:       "printer_busy",
+			Description: "Connector is shutting down",
+			Timeout:     15,
+		}.json()
+		w.Write(pe)
+		return true
+	default:
+		return false
+	}
+}
+
 func (api *privetAPI) serve() {
 	sm := http.NewServeMux()
 	sm.HandleFunc("/privet/info", api.info)
@@ -123,13 +152,28 @@ func (api *privetAPI) serve() {
 	sm.HandleFunc("/privet/printer/createjob", api.createjob)
 	sm.HandleFunc("/privet/printer/submitdoc", api.submitdoc)
 	sm.HandleFunc("/privet/printer/jobstate", api.jobstate)
+	sm.HandleFunc("/privet/printer/canceljob", api.canceljob)
+	sm.HandleFunc("/privet/printer/releasejob", api.releasejob)
 
-	err := http.Serve(api.listener, sm)
+	err := http.Serve(api.listener, countRequests(sm))
 	if err != nil && err != closed {
 		log.Errorf("Privet API HTTP server failed: %s", err)
 	}
 }
 
+// countRequests counts the requests to each endpoint of sm. Requests for
+// unknown paths are counted as endpoint other.
+func countRequests(sm *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint := "other"
+		if _, pattern := sm.Handler(r); pattern != "" {
+			endpoint = pattern
+		}
+		requests.Inc(endpoint)
+		sm.ServeHTTP(w, r)
+	})
+}
+
 type infoResponse struct {
 	Version         string               `json:"version"`
 	Name            string               `json:"name"`
@@ -333,6 +377,9 @@ func (api *privetAPI) createjob(w http.ResponseWriter, r *http.Request) {
 	if ok := api.checkRequest(w, r, "POST"); !ok {
 		return
 	}
+	if api.refuseJob(w) {
+		return
+	}
 
 	requestBody, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -358,7 +405,7 @@ func (api *privetAPI) createjob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	jobID, expiresIn := api.jc.createJob(&ticket)
+	jobID, expiresIn := api.jc.createJob(&ticket, api.cancelJob)
 	var response struct {
 		JobID     string `json:"job_id"`
 		ExpiresIn int32  `json:"expires_in"`
@@ -380,6 +427,9 @@ func (api *privetAPI) submitdoc(w http.ResponseWriter, r *http.Request) {
 	if ok := api.checkRequest(w, r, "POST"); !ok {
 		return
 	}
+	if api.refuseJob(w) {
+		return
+	}
 
 	file, err := ioutil.TempFile("", "cloud-print-connector-privet-")
 	if err != nil {
@@ -427,7 +477,7 @@ func (api *privetAPI) submitdoc(w http.ResponseWriter, r *http.Request) {
 	var expiresIn int32
 	var ticket *cdd.CloudJobTicket
 	if jobID == "" {
-		jobID, expiresIn = api.jc.createJob(nil)
+		jobID, expiresIn = api.jc.createJob(nil, api.cancelJob)
 	} else {
 		var ok bool
 		if expiresIn, ticket, ok = api.jc.getJobExpiresIn(jobID); !ok {
@@ -441,12 +491,16 @@ func (api *privetAPI) submitdoc(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	api.jc.submitJob(jobID, jobName, jobType, jobSize)
+
 	api.jobs <- &lib.Job{
 		NativePrinterName: api.name,
 		Filename:          file.Name(),
 		Title:             jobName,
 		User:              userName,
 		JobID:             jobID,
+		Origin:            lib.JobOriginPrivet,
+		ContentType:       jobType,
 		Ticket:            ticket,
 		UpdateJob:         api.jc.updateJob,
 	}
@@ -489,3 +543,75 @@ func (api *privetAPI) jobstate(w http.ResponseWriter, r *http.Request) {
 
 	w.Write(jobState)
 }
+
+// canceljob stops a job. This API is not part of the Privet spec; it lets
+// a local client take back a job it no longer wants printed.
+func (api *privetAPI) canceljob(w http.ResponseWriter, r *http.Request) {
+	log.Debugf("Received /canceljob request: %+v", r)
+	if ok := api.checkRequest(w, r, "POST"); !ok {
+		return
+	}
+
+	jobID := r.Form.Get("job_id")
+	submitted, exists := api.jc.jobSubmitted(jobID)
+	if !exists {
+		writeError(w, "invalid_print_job", "")
+		return
+	}
+
+	if submitted {
+		if err := api.cancelJob(jobID); err != nil {
+			log.WarningJobf(jobID, "Failed to cancel: %s", err)
+			writeError(w, "invalid_print_job", "Job is not printing")
+			return
+		}
+	} else {
+		// Nothing to stop yet; forget the job so that it is never printed.
+		api.jc.updateJob(jobID, &cdd.PrintJobStateDiff{
+			State: &cdd.JobState{
+				Type:            cdd.JobStateAborted,
+				UserActionCause: &cdd.UserActionCause{ActionCode: cdd.UserActionCauseCanceled},
+			},
+		})
+		defer api.jc.deleteJob(jobID)
+	}
+
+	jobState, exists := api.jc.jobState(jobID)
+	if !exists {
+		writeError(w, "invalid_print_job", "")
+		return
+	}
+
+	w.Write(jobState)
+}
+
+// releasejob prints the jobs that are held until released with a PIN. This
+// API is not part of the Privet spec; it lets a user at the printer release
+// their secure release jobs.
+//
+// The response is the same whether the PIN released jobs or not, so that
+// PINs can not be guessed; the jobs print when they are released.
+func (api *privetAPI) releasejob(w http.ResponseWriter, r *http.Request) {
+	log.Debugf("Received /releasejob request: %+v", r)
+	if ok := api.checkRequest(w, r, "POST"); !ok {
+		return
+	}
+
+	pin := r.Form.Get("pin")
+	if pin == "" {
+		writeError(w, "invalid_params", "pin is missing")
+		return
+	}
+
+	if wait, ok := api.releaseJobs(api.name, pin); !ok {
+		pe := privetError{
+			Error:       "printer_busy",
+			Description: "Too many wrong PINs",
+			Timeout:     int(wait/time.Second) + 1,
+		}.json()
+		w.Write(pe)
+		return
+	}
+
+	w.Write([]byte("{}"))
+}
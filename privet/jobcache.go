@@ -18,7 +18,7 @@ import (
 	"github.com/google/cloud-print-connector/log"
 )
 
-// Jobs expire after this much time.
+// Jobs expire after this much time without their state being polled.
 const jobLifetime = time.Hour
 
 type entry struct {
@@ -33,10 +33,15 @@ type entry struct {
 	jobType string
 	jobSize int64
 
+	// submitted is true after the job document is received.
+	submitted bool
+	// cancelJob stops a submitted job from printing.
+	cancelJob func(string) error
+
 	timer *time.Timer
 }
 
-func newEntry(jobID string, ticket *cdd.CloudJobTicket) *entry {
+func newEntry(jobID string, ticket *cdd.CloudJobTicket, cancelJob func(string) error) *entry {
 	var state cdd.JobState
 	if ticket == nil {
 		state.Type = cdd.JobStateDraft
@@ -48,6 +53,7 @@ func newEntry(jobID string, ticket *cdd.CloudJobTicket) *entry {
 		ticket:    ticket,
 		expiresAt: time.Now().Add(jobLifetime),
 		state:     state,
+		cancelJob: cancelJob,
 	}
 
 	return &entry
@@ -84,15 +90,17 @@ func (jc *jobCache) getNextJobID() string {
 }
 
 // createJob creates a new job, returns the new jobID and expires_in value.
-func (jc *jobCache) createJob(ticket *cdd.CloudJobTicket) (string, int32) {
+//
+// cancelJob is called if the job is still printing when it expires.
+func (jc *jobCache) createJob(ticket *cdd.CloudJobTicket, cancelJob func(string) error) (string, int32) {
 	jobID := jc.getNextJobID()
-	entry := newEntry(jobID, ticket)
+	entry := newEntry(jobID, ticket, cancelJob)
 
 	jc.entriesMutex.Lock()
 	defer jc.entriesMutex.Unlock()
 
 	entry.timer = time.AfterFunc(jobLifetime, func() {
-		jc.deleteJob(jobID)
+		jc.expireJob(jobID)
 	})
 	jc.entries[jobID] = *entry
 
@@ -118,6 +126,7 @@ func (jc *jobCache) submitJob(jobID, jobName, jobType string, jobSize int64) int
 		entry.jobName = jobName
 		entry.jobType = jobType
 		entry.jobSize = jobSize
+		entry.submitted = true
 		jc.entries[jobID] = entry
 		return entry.expiresIn()
 	}
@@ -125,6 +134,45 @@ func (jc *jobCache) submitJob(jobID, jobName, jobType string, jobSize int64) int
 	return 0
 }
 
+// jobSubmitted reports whether the job document has been received, and
+// whether the job exists at all.
+func (jc *jobCache) jobSubmitted(jobID string) (bool, bool) {
+	jc.entriesMutex.RLock()
+	defer jc.entriesMutex.RUnlock()
+
+	entry, exists := jc.entries[jobID]
+	return entry.submitted, exists
+}
+
+// expireJob deletes a job at the end of its lifetime. A job that is still
+// printing by then has been abandoned by its client, so it is canceled.
+//
+// A job whose state was polled since its timer was set lives on; its timer
+// was reset.
+func (jc *jobCache) expireJob(jobID string) {
+	jc.entriesMutex.Lock()
+	entry, exists := jc.entries[jobID]
+	if exists && time.Now().Before(entry.expiresAt) {
+		jc.entriesMutex.Unlock()
+		return
+	}
+	delete(jc.entries, jobID)
+	jc.entriesMutex.Unlock()
+
+	if !exists || !entry.submitted || entry.cancelJob == nil {
+		return
+	}
+	switch entry.state.Type {
+	case cdd.JobStateDone, cdd.JobStateAborted:
+		return
+	}
+
+	log.InfoJobf(jobID, "Canceling job abandoned by its Privet client")
+	if err := entry.cancelJob(jobID); err != nil {
+		log.WarningJobf(jobID, "Failed to cancel abandoned job: %s", err)
+	}
+}
+
 func (jc *jobCache) deleteJob(jobID string) {
 	jc.entriesMutex.Lock()
 	defer jc.entriesMutex.Unlock()
@@ -155,6 +203,7 @@ func (jc *jobCache) updateJob(jobID string, stateDiff *cdd.PrintJobStateDiff) er
 }
 
 // jobState gets the state of the job identified by jobID as JSON-encoded response.
+// The job lives for another jobLifetime, since its client is still polling.
 //
 // Returns an empty byte array if the job doesn't exist (because it expired).
 func (jc *jobCache) jobState(jobID string) ([]byte, bool) {
@@ -165,6 +214,9 @@ func (jc *jobCache) jobState(jobID string) ([]byte, bool) {
 	if !exists {
 		return []byte{}, false
 	}
+	entry.expiresAt = time.Now().Add(jobLifetime)
+	entry.timer.Reset(jobLifetime)
+	jc.entries[jobID] = entry
 
 	var response struct {
 		JobID         string            `json:"job_id"`
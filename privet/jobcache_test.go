@@ -0,0 +1,84 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package privet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+)
+
+func TestExpireJob(t *testing.T) {
+	jc := newJobCache()
+	canceled := map[string]bool{}
+	cancelJob := func(jobID string) error {
+		canceled[jobID] = true
+		return nil
+	}
+
+	draft, _ := jc.createJob(nil, cancelJob)
+
+	printing, _ := jc.createJob(nil, cancelJob)
+	jc.submitJob(printing, "printing", "application/pdf", 1)
+	jc.updateJob(printing, &cdd.PrintJobStateDiff{State: &cdd.JobState{Type: cdd.JobStateInProgress}})
+
+	done, _ := jc.createJob(nil, cancelJob)
+	jc.submitJob(done, "done", "application/pdf", 1)
+	jc.updateJob(done, &cdd.PrintJobStateDiff{State: &cdd.JobState{Type: cdd.JobStateDone}})
+
+	// Nobody polled the jobs for their lifetime.
+	for jobID, entry := range jc.entries {
+		entry.expiresAt = time.Now().Add(-time.Second)
+		jc.entries[jobID] = entry
+	}
+	for _, jobID := range []string{draft, printing, done} {
+		jc.expireJob(jobID)
+		if _, exists := jc.jobSubmitted(jobID); exists {
+			t.Logf("expected job %s to be deleted", jobID)
+			t.Fail()
+		}
+	}
+
+	if canceled[draft] {
+		t.Log("did not expect unsubmitted job to be canceled")
+		t.Fail()
+	}
+	if !canceled[printing] {
+		t.Log("expected abandoned job to be canceled")
+		t.Fail()
+	}
+	if canceled[done] {
+		t.Log("did not expect finished job to be canceled")
+		t.Fail()
+	}
+}
+
+func TestPolledJobDoesNotExpire(t *testing.T) {
+	jc := newJobCache()
+	canceled := false
+	cancelJob := func(string) error {
+		canceled = true
+		return nil
+	}
+
+	jobID, _ := jc.createJob(nil, cancelJob)
+	jc.submitJob(jobID, "held", "application/pdf", 1)
+	jc.updateJob(jobID, &cdd.PrintJobStateDiff{State: &cdd.JobState{Type: cdd.JobStateHeld}})
+	if _, exists := jc.jobState(jobID); !exists {
+		t.Fatal("expected job to exist")
+	}
+
+	// The timer set at createjob fires after the job was polled.
+	jc.expireJob(jobID)
+	if _, exists := jc.jobSubmitted(jobID); !exists || canceled {
+		t.Logf("expected polled job to live on, got exists %t and canceled %t", exists, canceled)
+		t.Fail()
+	}
+}
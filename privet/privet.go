@@ -11,6 +11,7 @@ package privet
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/google/cloud-print-connector/lib"
 )
@@ -26,6 +27,10 @@ type Privet struct {
 	jobs chan<- *lib.Job
 	jc   jobCache
 
+	// refusing is closed when new jobs are refused.
+	refusing   chan struct{}
+	refuseOnce sync.Once
+
 	gcpBaseURL        string
 	getProximityToken func(string, string) ([]byte, int, error)
 }
@@ -48,6 +53,8 @@ func NewPrivet(jobs chan<- *lib.Job, portLow, portHigh uint16, gcpBaseURL string
 		jobs: jobs,
 		jc:   *newJobCache(),
 
+		refusing: make(chan struct{}),
+
 		gcpBaseURL:        gcpBaseURL,
 		getProximityToken: getProximityToken,
 	}
@@ -56,7 +63,11 @@ func NewPrivet(jobs chan<- *lib.Job, portLow, portHigh uint16, gcpBaseURL string
 }
 
 // AddPrinter makes a printer available locally.
-func (p *Privet) AddPrinter(printer lib.Printer, getPrinter func(string) (lib.Printer, bool)) error {
+//
+// cancelJob should be PrinterManager.CancelJob()
+//
+// releaseJobs should be PrinterManager.ReleaseJobs()
+func (p *Privet) AddPrinter(printer lib.Printer, getPrinter func(string) (lib.Printer, bool), cancelJob func(string) error, releaseJobs func(string, string) (time.Duration, bool)) error {
 	online := false
 	if printer.GCPID != "" {
 		online = true
@@ -67,7 +78,7 @@ func (p *Privet) AddPrinter(printer lib.Printer, getPrinter func(string) (lib.Pr
 		return err
 	}
 
-	api, err := newPrivetAPI(printer.GCPID, printer.Name, p.gcpBaseURL, p.xsrf, online, &p.jc, p.jobs, getPrinter, p.getProximityToken, listener)
+	api, err := newPrivetAPI(printer.GCPID, printer.Name, p.gcpBaseURL, p.xsrf, online, &p.jc, p.jobs, p.refusing, getPrinter, cancelJob, releaseJobs, p.getProximityToken, listener)
 	if err != nil {
 		return err
 	}
@@ -118,6 +129,12 @@ func (p *Privet) DeletePrinter(cupsPrinterName string) error {
 	return err
 }
 
+// RefuseJobs makes every printer refuse new jobs, so that the connector can
+// shut down. Jobs already received are not affected.
+func (p *Privet) RefuseJobs() {
+	p.refuseOnce.Do(func() { close(p.refusing) })
+}
+
 func (p *Privet) Quit() {
 	p.apisMutex.Lock()
 	defer p.apisMutex.Unlock()
@@ -129,6 +146,19 @@ func (p *Privet) Quit() {
 	}
 }
 
+// Port returns the port of the Privet API of a printer, and false if the
+// printer is not available locally.
+func (p *Privet) Port(cupsPrinterName string) (uint16, bool) {
+	p.apisMutex.RLock()
+	defer p.apisMutex.RUnlock()
+
+	api, exists := p.apis[cupsPrinterName]
+	if !exists {
+		return 0, false
+	}
+	return api.port(), true
+}
+
 func (p *Privet) Size() int {
 	p.apisMutex.RLock()
 	defer p.apisMutex.RUnlock()
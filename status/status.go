@@ -0,0 +1,285 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+// Package status serves a read-only page, and the same data as JSON, that
+// shows the state of the printers and jobs of the connector.
+package status
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+	"github.com/google/cloud-print-connector/log"
+)
+
+// refreshSeconds is how often the page reloads itself.
+const refreshSeconds = 10
+
+// Source provides the printers and jobs to show, eg a PrinterManager.
+type Source interface {
+	GetPrinters() []lib.Printer
+	GetPausedPrinters() []string
+	GetJobsInFlight() []lib.MonitorJob
+	GetRecentJobs() []lib.MonitorJob
+}
+
+// Status is the state of all printers.
+type Status struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Printers    []Printer `json:"printers"`
+}
+
+// Printer is the state of one printer, and of its jobs. PrivetPort is zero
+// when the printer is not available locally.
+type Printer struct {
+	Name         string                   `json:"name"`
+	DisplayName  string                   `json:"display_name,omitempty"`
+	GCPID        string                   `json:"gcp_id,omitempty"`
+	PrivetPort   uint16                   `json:"privet_port,omitempty"`
+	Paused       bool                     `json:"paused"`
+	State        *cdd.PrinterStateSection `json:"state,omitempty"`
+	JobsInFlight []lib.MonitorJob         `json:"jobs_in_flight"`
+	RecentJobs   []lib.MonitorJob         `json:"recent_jobs"`
+}
+
+// Page serves the status.
+type Page struct {
+	source     Source
+	privetPort func(string) (uint16, bool)
+}
+
+// NewPage creates a page that shows the printers of source. privetPort
+// looks up the Privet port of a printer, and may be nil when local printing
+// is disabled.
+func NewPage(source Source, privetPort func(string) (uint16, bool)) *Page {
+	return &Page{source, privetPort}
+}
+
+// Status gets the current status.
+func (p *Page) Status() Status {
+	paused := make(map[string]bool)
+	for _, name := range p.source.GetPausedPrinters() {
+		paused[name] = true
+	}
+
+	printers := p.source.GetPrinters()
+	status := Status{
+		GeneratedAt: time.Now(),
+		Printers:    make([]Printer, 0, len(printers)),
+	}
+	index := make(map[string]int, len(printers))
+	for _, printer := range printers {
+		sp := Printer{
+			Name:         printer.Name,
+			DisplayName:  printer.DefaultDisplayName,
+			GCPID:        printer.GCPID,
+			Paused:       paused[printer.Name],
+			State:        printer.State,
+			JobsInFlight: []lib.MonitorJob{},
+			RecentJobs:   []lib.MonitorJob{},
+		}
+		if p.privetPort != nil {
+			if port, ok := p.privetPort(printer.Name); ok {
+				sp.PrivetPort = port
+			}
+		}
+		index[printer.Name] = len(status.Printers)
+		status.Printers = append(status.Printers, sp)
+	}
+
+	for _, job := range p.source.GetJobsInFlight() {
+		if i, exists := index[job.PrinterName]; exists {
+			status.Printers[i].JobsInFlight = append(status.Printers[i].JobsInFlight, job)
+		}
+	}
+	for _, job := range p.source.GetRecentJobs() {
+		if i, exists := index[job.PrinterName]; exists {
+			status.Printers[i].RecentJobs = append(status.Printers[i].RecentJobs, job)
+		}
+	}
+
+	return status
+}
+
+func (p *Page) serveHTML(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	status := p.Status()
+	var b bytes.Buffer
+	if err := pageTemplate.Execute(&b, &status); err != nil {
+		log.Warningf("Failed to render status page: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(b.Bytes())
+}
+
+func (p *Page) serveJSON(w http.ResponseWriter, r *http.Request) {
+	status := p.Status()
+	b, err := json.MarshalIndent(&status, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(b)
+}
+
+// Handler serves the page at / and the JSON at /status.json. Only GET and
+// HEAD are allowed; the page never changes anything.
+func (p *Page) Handler() http.Handler {
+	sm := http.NewServeMux()
+	sm.HandleFunc("/", p.serveHTML)
+	sm.HandleFunc("/status.json", p.serveJSON)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		sm.ServeHTTP(w, r)
+	})
+}
+
+// isLoopback checks whether the host of address, eg localhost:9102, is a
+// loopback address.
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Serve listens on address, which must be a loopback address, eg
+// localhost:9102, and serves the page until the process exits.
+func (p *Page) Serve(address string) error {
+	if !isLoopback(address) {
+		return fmt.Errorf("The status page must listen on a loopback address, not %s", address)
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("Failed to listen for status page requests on %s: %s", address, err)
+	}
+
+	go func() {
+		if err := http.Serve(listener, p.Handler()); err != nil {
+			log.Errorf("Status page HTTP server failed: %s", err)
+		}
+	}()
+	return nil
+}
+
+// vendorStateDescription gets the description of a vendor state item, which
+// may be only localized.
+func vendorStateDescription(item cdd.VendorStateItem) string {
+	if item.Description != "" {
+		return item.Description
+	}
+	if item.DescriptionLocalized != nil && len(*item.DescriptionLocalized) > 0 {
+		return (*item.DescriptionLocalized)[0].Value
+	}
+	return ""
+}
+
+// markerLevel formats the level of a marker, eg 50% or 200 pages.
+func markerLevel(item cdd.MarkerStateItem) string {
+	switch {
+	case item.LevelPercent != nil:
+		return fmt.Sprintf("%d%%", *item.LevelPercent)
+	case item.LevelPages != nil:
+		return fmt.Sprintf("%d pages", *item.LevelPages)
+	}
+	return ""
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+var pageTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"vendorStateDescription": vendorStateDescription,
+	"markerLevel":            markerLevel,
+	"formatTime":             formatTime,
+	"refreshSeconds":         func() int { return refreshSeconds },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{refreshSeconds}}">
+<title>Cloud Print Connector status</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 2px 8px; text-align: left; }
+h2 { margin-top: 1.5em; }
+.paused { color: #c60; }
+</style>
+</head>
+<body>
+<h1>Cloud Print Connector status</h1>
+<p>Updated {{.GeneratedAt.Local.Format "2006-01-02 15:04:05"}}; also available as <a href="status.json">JSON</a>.</p>
+{{range .Printers}}
+<h2>{{.Name}}{{if .Paused}} <span class="paused">(paused)</span>{{end}}</h2>
+<table>
+<tr><th>Display name</th><td>{{.DisplayName}}</td></tr>
+<tr><th>GCP ID</th><td>{{.GCPID}}</td></tr>
+<tr><th>Privet port</th><td>{{if .PrivetPort}}{{.PrivetPort}}{{end}}</td></tr>
+<tr><th>State</th><td>{{if .State}}{{.State.State}}{{end}}</td></tr>
+</table>
+{{if .State}}{{if .State.MarkerState}}
+<h3>Markers</h3>
+<table>
+<tr><th>Marker</th><th>State</th><th>Level</th><th>Message</th></tr>
+{{range .State.MarkerState.Item}}<tr><td>{{.VendorID}}</td><td>{{.State}}</td><td>{{markerLevel .}}</td><td>{{.VendorMessage}}</td></tr>
+{{end}}</table>
+{{end}}{{if .State.VendorState}}
+<h3>Vendor state</h3>
+<table>
+<tr><th>State</th><th>Description</th></tr>
+{{range .State.VendorState.Item}}<tr><td>{{.State}}</td><td>{{vendorStateDescription .}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}
+<h3>Jobs in flight</h3>
+{{if .JobsInFlight}}<table>
+<tr><th>Job ID</th><th>Origin</th><th>Native printer</th><th>Native job ID</th><th>State</th><th>Submitted</th></tr>
+{{range .JobsInFlight}}<tr><td>{{.JobID}}</td><td>{{.Origin}}</td><td>{{.NativePrinterName}}</td><td>{{if .NativeJobID}}{{.NativeJobID}}{{end}}</td><td>{{.State}}</td><td>{{formatTime .SubmittedAt}}</td></tr>
+{{end}}</table>{{else}}<p>None</p>{{end}}
+<h3>Recent jobs</h3>
+{{if .RecentJobs}}<table>
+<tr><th>Job ID</th><th>Origin</th><th>Native printer</th><th>Native job ID</th><th>State</th><th>Cause</th><th>Finished</th></tr>
+{{range .RecentJobs}}<tr><td>{{.JobID}}</td><td>{{.Origin}}</td><td>{{.NativePrinterName}}</td><td>{{if .NativeJobID}}{{.NativeJobID}}{{end}}</td><td>{{.State}}</td><td>{{.Cause}}</td><td>{{formatTime .FinishedAt}}</td></tr>
+{{end}}</table>{{else}}<p>None</p>{{end}}
+{{else}}
+<p>No printers.</p>
+{{end}}
+</body>
+</html>
+`))
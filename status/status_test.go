@@ -0,0 +1,137 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package status
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/cloud-print-connector/cdd"
+	"github.com/google/cloud-print-connector/lib"
+)
+
+type testSource struct{}
+
+func (testSource) GetPrinters() []lib.Printer {
+	level := int32(40)
+	return []lib.Printer{
+		{
+			Name:  "a",
+			GCPID: "gcp-a",
+			State: &cdd.PrinterStateSection{
+				State: cdd.CloudDeviceStateIdle,
+				MarkerState: &cdd.MarkerState{Item: []cdd.MarkerStateItem{
+					{VendorID: "black", State: cdd.MarkerStateOK, LevelPercent: &level},
+				}},
+				VendorState: &cdd.VendorState{Item: []cdd.VendorStateItem{
+					{State: cdd.VendorStateWarning, DescriptionLocalized: cdd.NewLocalizedString("Low <toner>")},
+				}},
+			},
+		},
+		{Name: "b"},
+	}
+}
+
+func (testSource) GetPausedPrinters() []string {
+	return []string{"b"}
+}
+
+func (testSource) GetJobsInFlight() []lib.MonitorJob {
+	return []lib.MonitorJob{{JobID: "1", PrinterName: "a"}, {JobID: "2", PrinterName: "gone"}}
+}
+
+func (testSource) GetRecentJobs() []lib.MonitorJob {
+	return []lib.MonitorJob{{JobID: "3", PrinterName: "b", State: "DONE"}}
+}
+
+func newTestPage() *Page {
+	return NewPage(testSource{}, func(name string) (uint16, bool) {
+		if name == "a" {
+			return 26000, true
+		}
+		return 0, false
+	})
+}
+
+func TestStatus(t *testing.T) {
+	status := newTestPage().Status()
+	if len(status.Printers) != 2 {
+		t.Fatalf("expected 2 printers, got %+v", status.Printers)
+	}
+	a, b := status.Printers[0], status.Printers[1]
+	if a.PrivetPort != 26000 || a.Paused || len(a.JobsInFlight) != 1 || len(a.RecentJobs) != 0 {
+		t.Logf("unexpected printer a: %+v", a)
+		t.Fail()
+	}
+	if b.PrivetPort != 0 || !b.Paused || len(b.JobsInFlight) != 0 || len(b.RecentJobs) != 1 {
+		t.Logf("unexpected printer b: %+v", b)
+		t.Fail()
+	}
+
+	status = NewPage(testSource{}, nil).Status()
+	if status.Printers[0].PrivetPort != 0 {
+		t.Logf("expected no Privet port without Privet, got %d", status.Printers[0].PrivetPort)
+		t.Fail()
+	}
+}
+
+func TestHandler(t *testing.T) {
+	handler := newTestPage().Handler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for page, got %d", w.Code)
+	}
+	page := w.Body.String()
+	for _, s := range []string{`http-equiv="refresh"`, "gcp-a", "26000", "40%", "Low &lt;toner&gt;", "(paused)"} {
+		if !strings.Contains(page, s) {
+			t.Logf("expected page to contain %q", s)
+			t.Fail()
+		}
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/status.json", nil))
+	var status Status
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Printers) != 2 || status.Printers[0].GCPID != "gcp-a" {
+		t.Logf("unexpected JSON status: %s", w.Body.String())
+		t.Fail()
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/status.json", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Logf("expected 405 for POST, got %d", w.Code)
+		t.Fail()
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	for address, expected := range map[string]bool{
+		"localhost:9102": true,
+		"127.0.0.1:9102": true,
+		"[::1]:9102":     true,
+		":9102":          false,
+		"0.0.0.0:9102":   false,
+		"10.0.0.1:9102":  false,
+		"localhost":      false,
+	} {
+		if isLoopback(address) != expected {
+			t.Logf("expected isLoopback(%q) to be %t", address, expected)
+			t.Fail()
+		}
+	}
+}
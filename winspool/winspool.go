@@ -947,6 +947,17 @@ func (ws *WinSpool) ReleaseJob(printerName string, jobID uint32) error {
 	return nil
 }
 
+// CancelJob cancels the job indicated by jobID.
+func (ws *WinSpool) CancelJob(printerName string, jobID uint32) error {
+	hPrinter, err := OpenPrinter(printerName)
+	if err != nil {
+		return err
+	}
+	defer hPrinter.ClosePrinter()
+
+	return hPrinter.SetJobCommand(int32(jobID), JOB_CONTROL_DELETE)
+}
+
 func (ws *WinSpool) StartPrinterNotifications(handle windows.Handle) error {
 	err := RegisterDeviceNotification(handle)
 	return err
@@ -9,13 +9,18 @@ https://developers.google.com/open-source/licenses/bsd
 package xmpp
 
 import (
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/google/cloud-print-connector/log"
+	"github.com/google/cloud-print-connector/metrics"
 	"github.com/google/cloud-print-connector/notification"
 )
 
+var connected = metrics.NewGauge("xmpp_connected", "Whether the XMPP conversation is up.")
+
 type PrinterNotificationType uint8
 
 type XMPP struct {
@@ -33,6 +38,11 @@ type XMPP struct {
 	quit chan struct{}
 
 	ix *internalXMPP
+
+	// connectedSince is when the current conversation started, or zero
+	// when there is none.
+	connectedMutex sync.Mutex
+	connectedSince time.Time
 }
 
 func NewXMPP(jid, proxyName, server string, port uint16, pingTimeout, pingInterval time.Duration, getAccessToken func() (string, error), notifications chan<- notification.PrinterNotification) (*XMPP, error) {
@@ -93,14 +103,39 @@ func (x *XMPP) startXMPP() error {
 	}
 
 	x.ix = ix
+	x.setConnected(true)
 	return nil
 }
 
+func (x *XMPP) setConnected(c bool) {
+	x.connectedMutex.Lock()
+	defer x.connectedMutex.Unlock()
+
+	if c {
+		x.connectedSince = time.Now()
+	} else {
+		x.connectedSince = time.Time{}
+	}
+	connected.SetBool(c)
+}
+
+// CheckConnection is a health check of the XMPP conversation.
+func (x *XMPP) CheckConnection() (string, error) {
+	x.connectedMutex.Lock()
+	defer x.connectedMutex.Unlock()
+
+	if x.connectedSince.IsZero() {
+		return "", errors.New("XMPP conversation is down")
+	}
+	return fmt.Sprintf("XMPP conversation has been up since %s", x.connectedSince.Format(time.RFC3339)), nil
+}
+
 // keepXMPPAlive restarts XMPP when it fails.
 func (x *XMPP) keepXMPPAlive() {
 	for {
 		select {
 		case <-x.dead:
+			x.setConnected(false)
 			log.Error("XMPP conversation died; restarting")
 			if err := x.startXMPP(); err != nil {
 				for err != nil {
@@ -114,6 +149,7 @@ func (x *XMPP) keepXMPPAlive() {
 		case <-x.quit:
 			// Close XMPP.
 			x.ix.Quit()
+			x.setConnected(false)
 			return
 		}
 	}